@@ -0,0 +1,243 @@
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/models"
+)
+
+const (
+	MethodEWMA   = "ewma"
+	MethodZScore = "zscore"
+
+	DefaultWindow    = 24 * time.Hour
+	DefaultWindows   = 7
+	DefaultThreshold = 3.0
+	DefaultAlpha     = 0.3
+	DefaultMinCount  = 3
+	DefaultSamples   = 5
+)
+
+// EmergingOptions configures emerging-issue detection. Each cluster's
+// incident count in the latest window is scored against its counts in the
+// Windows windows before it. Zero values take the defaults.
+type EmergingOptions struct {
+	// Method is "ewma" (the default) or "zscore".
+	Method  string
+	Window  time.Duration
+	Windows int
+	// Threshold is the score, in standard deviations above the baseline, at
+	// which a cluster is flagged.
+	Threshold float64
+	// Alpha weights the most recent window in the EWMA baseline.
+	Alpha float64
+	// MinCount is the fewest incidents in the latest window worth flagging.
+	MinCount int
+	// Samples is how many ticket numbers each cluster lists.
+	Samples int
+}
+
+func (o EmergingOptions) withDefaults() (EmergingOptions, error) {
+	switch o.Method {
+	case "":
+		o.Method = MethodEWMA
+	case MethodEWMA, MethodZScore:
+	default:
+		return o, fmt.Errorf("method must be %q or %q", MethodEWMA, MethodZScore)
+	}
+	if o.Window < 0 || o.Windows < 0 || o.Threshold < 0 || o.MinCount < 0 || o.Samples < 0 {
+		return o, fmt.Errorf("detection options must not be negative")
+	}
+	if o.Alpha < 0 || o.Alpha > 1 {
+		return o, fmt.Errorf("alpha must be between 0 and 1")
+	}
+	if o.Window == 0 {
+		o.Window = DefaultWindow
+	}
+	if o.Windows == 0 {
+		o.Windows = DefaultWindows
+	}
+	if o.Threshold == 0 {
+		o.Threshold = DefaultThreshold
+	}
+	if o.Alpha == 0 {
+		o.Alpha = DefaultAlpha
+	}
+	if o.MinCount == 0 {
+		o.MinCount = DefaultMinCount
+	}
+	if o.Samples == 0 {
+		o.Samples = DefaultSamples
+	}
+	return o, nil
+}
+
+// Horizon is how far back detection looks: the latest window and the ones
+// it is compared with.
+func (o EmergingOptions) Horizon() (time.Duration, error) {
+	o, err := o.withDefaults()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(o.Windows+1) * o.Window, nil
+}
+
+// ClusterScore is how one cluster's volume in the latest window compares
+// with its history.
+type ClusterScore struct {
+	Description string `json:"description"`
+	// Counts are the incidents opened in each window, oldest first; the last
+	// is the latest window.
+	Counts   []int   `json:"counts"`
+	Count    int     `json:"count"`
+	Baseline float64 `json:"baseline"`
+	Score    float64 `json:"score"`
+	Flagged  bool    `json:"flagged"`
+	// SampleTicketNumbers are the newest incidents of the latest window.
+	SampleTicketNumbers []string `json:"sampleTicketNumbers"`
+
+	// latest are all the incidents of the latest window, newest first.
+	latest []string
+}
+
+// EmergingReport scores every cluster, highest score first.
+type EmergingReport struct {
+	Method      string         `json:"method"`
+	WindowStart time.Time      `json:"windowStart"`
+	WindowEnd   time.Time      `json:"windowEnd"`
+	Clusters    []ClusterScore `json:"clusters"`
+}
+
+// Flagged returns the clusters growing unusually fast.
+func (r *EmergingReport) Flagged() []ClusterScore {
+	var flagged []ClusterScore
+	for _, cluster := range r.Clusters {
+		if cluster.Flagged {
+			flagged = append(flagged, cluster)
+		}
+	}
+	return flagged
+}
+
+// Emerging scores clusters of tickets, as returned by TFIDFKMeansClustering
+// over their short descriptions, by how fast they grew in the window ending
+// at now. Cluster members index tickets.
+func Emerging(now time.Time, tickets []models.Ticket, clusters []database.ClusterEntry, opts EmergingOptions) (*EmergingReport, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	now = now.UTC()
+	report := &EmergingReport{
+		Method:      opts.Method,
+		WindowStart: now.Add(-opts.Window),
+		WindowEnd:   now,
+		Clusters:    make([]ClusterScore, 0, len(clusters)),
+	}
+
+	// window returns the window an incident opened at t falls in, counting
+	// back from the latest, or -1 outside the horizon.
+	window := func(t time.Time) int {
+		if t.After(now) {
+			return -1
+		}
+		back := int(now.Sub(t) / opts.Window)
+		if now.Sub(t)%opts.Window == 0 && back > 0 {
+			back--
+		}
+		if back > opts.Windows {
+			return -1
+		}
+		return opts.Windows - back
+	}
+
+	for _, cluster := range clusters {
+		score := ClusterScore{Description: cluster.ClusterDescription, Counts: make([]int, opts.Windows+1)}
+
+		type latest struct {
+			number string
+			opened time.Time
+		}
+		var recent []latest
+		for _, index := range cluster.Members {
+			if index < 0 || index >= len(tickets) {
+				continue
+			}
+			inc, ok := parseIncident(tickets[index])
+			if !ok {
+				continue
+			}
+			w := window(inc.opened)
+			if w < 0 {
+				continue
+			}
+			score.Counts[w]++
+			if w == opts.Windows {
+				recent = append(recent, latest{tickets[index].Number, inc.opened})
+			}
+		}
+
+		sort.SliceStable(recent, func(i, j int) bool { return recent[i].opened.After(recent[j].opened) })
+		for i, incident := range recent {
+			if i < opts.Samples {
+				score.SampleTicketNumbers = append(score.SampleTicketNumbers, incident.number)
+			}
+			score.latest = append(score.latest, incident.number)
+		}
+
+		score.Count = score.Counts[opts.Windows]
+		var deviation float64
+		if opts.Method == MethodZScore {
+			score.Baseline, deviation = meanDeviation(score.Counts[:opts.Windows])
+		} else {
+			score.Baseline, deviation = ewma(score.Counts[:opts.Windows], opts.Alpha)
+		}
+		// A quiet history would make any incident look infinitely unusual, so
+		// the deviation is at least one incident.
+		score.Score = (float64(score.Count) - score.Baseline) / math.Max(deviation, 1)
+		score.Flagged = score.Count >= opts.MinCount && score.Score >= opts.Threshold
+
+		report.Clusters = append(report.Clusters, score)
+	}
+
+	sort.SliceStable(report.Clusters, func(i, j int) bool { return report.Clusters[i].Score > report.Clusters[j].Score })
+	return report, nil
+}
+
+// meanDeviation returns the mean and population standard deviation of counts.
+func meanDeviation(counts []int) (float64, float64) {
+	if len(counts) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, count := range counts {
+		sum += float64(count)
+	}
+	mean := sum / float64(len(counts))
+
+	var squares float64
+	for _, count := range counts {
+		squares += (float64(count) - mean) * (float64(count) - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(counts)))
+}
+
+// ewma returns the exponentially weighted moving average of counts and the
+// matching moving standard deviation, oldest count first.
+func ewma(counts []int, alpha float64) (float64, float64) {
+	if len(counts) == 0 {
+		return 0, 0
+	}
+	average, variance := float64(counts[0]), 0.0
+	for _, count := range counts[1:] {
+		diff := float64(count) - average
+		average += alpha * diff
+		variance = (1 - alpha) * (variance + alpha*diff*diff)
+	}
+	return average, math.Sqrt(variance)
+}
@@ -0,0 +1,229 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/database/databasetest"
+	"github.com/davidulloa/mimir/llm"
+	"github.com/davidulloa/mimir/llm/llmtest"
+	"github.com/davidulloa/mimir/models"
+	"github.com/davidulloa/mimir/notify"
+	"github.com/davidulloa/mimir/servicenow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var detectedAt = time.Date(2026, 10, 10, 12, 0, 0, 0, time.UTC)
+
+// history returns tickets described as description, perDay[i] of them
+// opened on each day, oldest first, the last day ending at detectedAt.
+func history(prefix string, description string, perDay ...int) []models.Ticket {
+	var tickets []models.Ticket
+	for day, count := range perDay {
+		daysBack := len(perDay) - 1 - day
+		for i := 0; i < count; i++ {
+			opened := detectedAt.Add(-time.Duration(daysBack)*24*time.Hour - time.Duration(i+1)*time.Hour)
+			tickets = append(tickets, models.Ticket{
+				Number:           fmt.Sprintf("%s%d%02d", prefix, day, i),
+				ShortDescription: description,
+				OpenedAt:         opened.Format(servicenow.DateTimeLayout),
+			})
+		}
+	}
+	return tickets
+}
+
+// clustersOf puts consecutive runs of tickets with the same description in a
+// cluster.
+func clustersOf(tickets []models.Ticket) []database.ClusterEntry {
+	var clusters []database.ClusterEntry
+	for i, ticket := range tickets {
+		if i == 0 || ticket.ShortDescription != tickets[i-1].ShortDescription {
+			clusters = append(clusters, database.ClusterEntry{ClusterDescription: ticket.ShortDescription})
+		}
+		last := &clusters[len(clusters)-1]
+		last.Members = append(last.Members, i)
+	}
+	return clusters
+}
+
+func TestEmergingFlagsFastGrowingClusters(t *testing.T) {
+	var tickets []models.Ticket
+	tickets = append(tickets, history("MAIL", "Email outage", 1, 1, 1, 1, 1, 1, 1, 6)...)
+	tickets = append(tickets, history("VPN", "VPN drops", 2, 3, 2, 3, 2, 3, 2, 3)...)
+	tickets = append(tickets, history("PRN", "Printer jammed", 0, 0, 0, 0, 0, 0, 0, 2)...)
+
+	for _, method := range []string{MethodEWMA, MethodZScore} {
+		report, err := Emerging(detectedAt, tickets, clustersOf(tickets), EmergingOptions{Method: method})
+		require.NoError(t, err)
+		require.Len(t, report.Clusters, 3)
+
+		mail := report.Clusters[0]
+		assert.Equal(t, "Email outage", mail.Description, method)
+		assert.Equal(t, []int{1, 1, 1, 1, 1, 1, 1, 6}, mail.Counts)
+		assert.InDelta(t, 1, mail.Baseline, 1e-9)
+		assert.InDelta(t, 5, mail.Score, 1e-9)
+		assert.True(t, mail.Flagged)
+		assert.Equal(t, []string{"MAIL700", "MAIL701", "MAIL702", "MAIL703", "MAIL704"}, mail.SampleTicketNumbers)
+
+		flagged := report.Flagged()
+		require.Len(t, flagged, 1, "%s: steady clusters and ones below MinCount aren't flagged", method)
+	}
+
+	report, err := Emerging(detectedAt, tickets, clustersOf(tickets), EmergingOptions{Window: 48 * time.Hour, Windows: 3})
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 2, 2, 7}, report.Clusters[0].Counts)
+
+	_, err = Emerging(detectedAt, tickets, nil, EmergingOptions{Method: "arima"})
+	assert.Error(t, err)
+}
+
+type recordingNotifier struct {
+	alerts []notify.Alert
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, alert notify.Alert) error {
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+// watchIncidents stores a week of steady printer incidents and a spike of
+// mailbox ones on dev000001.
+func watchIncidents(t *testing.T) {
+	databasetest.UseBoltStore(t)
+
+	var tickets []models.Ticket
+	tickets = append(tickets, history("MAIL", "Email outage mailbox unavailable", 1, 1, 1, 1, 1, 1, 1, 6)...)
+	tickets = append(tickets, history("PRN", "Printer jammed paper tray", 1, 1, 1, 1, 1, 1, 1, 1)...)
+	for i := range tickets {
+		tickets[i].InstanceID = "dev000001"
+		tickets[i].SysID = tickets[i].Number
+		tickets[i].ID = database.TicketID("dev000001", tickets[i].SysID)
+		tickets[i].SysCreatedOn = tickets[i].OpenedAt
+	}
+	require.NoError(t, database.StoreTickets(tickets))
+}
+
+// labelClusters makes the default provider label each cluster with label
+// applied to the text of its first incident.
+func labelClusters(t *testing.T, label func(text string) string) {
+	fake := llmtest.UseFake(t)
+	fake.Responder = func(req llm.Request) (string, error) {
+		content := req.Messages[len(req.Messages)-1].Content
+		var clusters [][]string
+		if err := json.Unmarshal([]byte(content[strings.Index(content, "["):]), &clusters); err != nil {
+			return "", err
+		}
+		var labels []map[string]string
+		for _, texts := range clusters {
+			labels = append(labels, map[string]string{"cluster_description": label(texts[0])})
+		}
+		response, err := json.Marshal(map[string]interface{}{"clusters": labels})
+		return string(response), err
+	}
+}
+
+func TestWatcherNotifiesOncePerWindow(t *testing.T) {
+	watchIncidents(t)
+	labelClusters(t, func(text string) string { return text })
+
+	notifier := &recordingNotifier{}
+	notify.SetDefault(notifier)
+	t.Cleanup(func() { notify.SetDefault(nil) })
+
+	now := detectedAt
+	watcher := NewWatcher()
+	watcher.now = func() time.Time { return now }
+
+	sent, err := watcher.Check(context.Background(), "dev000001")
+	require.NoError(t, err)
+	require.Len(t, sent, 1)
+	assert.Equal(t, "Email outage mailbox unavailable", sent[0].Cluster)
+	assert.Equal(t, notify.KindEmergingIssue, sent[0].Kind)
+	assert.Len(t, sent[0].TicketNumbers, DefaultSamples)
+	assert.Contains(t, sent[0].Text, "6 incidents")
+
+	now = now.Add(time.Hour)
+	sent, err = watcher.Check(context.Background(), "dev000001")
+	require.NoError(t, err)
+	assert.Empty(t, sent, "already reported this window")
+	assert.Len(t, notifier.alerts, 1)
+}
+
+func TestWatcherIgnoresChangingLabels(t *testing.T) {
+	watchIncidents(t)
+	round := 0
+	labelClusters(t, func(text string) string { return fmt.Sprintf("%s (take %d)", text, round) })
+
+	notifier := &recordingNotifier{}
+	notify.SetDefault(notifier)
+	t.Cleanup(func() { notify.SetDefault(nil) })
+
+	now := detectedAt
+	watcher := NewWatcher()
+	watcher.now = func() time.Time { return now }
+
+	sent, err := watcher.Check(context.Background(), "dev000001")
+	require.NoError(t, err)
+	require.Len(t, sent, 1)
+	assert.Equal(t, "Email outage mailbox unavailable (take 0)", sent[0].Cluster)
+
+	round++
+	now = now.Add(time.Hour)
+	sent, err = watcher.Check(context.Background(), "dev000001")
+	require.NoError(t, err)
+	assert.Empty(t, sent, "same incidents under a new label")
+	assert.Len(t, notifier.alerts, 1)
+
+	now = now.Add(DefaultWindow)
+	watcher.prune(now, DefaultWindow)
+	assert.Empty(t, watcher.notified, "reports older than the window are forgotten")
+}
+
+func TestSampleIncidents(t *testing.T) {
+	tickets := history("INC", "Email outage", 40, 40, 40)
+	sampled := sampleIncidents(tickets, 30)
+	require.Len(t, sampled, 30)
+	assert.Equal(t, sampled, sampleIncidents(tickets, 30))
+	position := make(map[string]int)
+	for i, ticket := range tickets {
+		position[ticket.Number] = i
+	}
+	for i := 1; i < len(sampled); i++ {
+		assert.Less(t, position[sampled[i-1].Number], position[sampled[i].Number], "the sample keeps the tickets' order")
+	}
+
+	// A few more incidents change the sample little.
+	more := sampleIncidents(append(history("NEW", "Email outage", 5), tickets...), 30)
+	kept := 0
+	for _, ticket := range more {
+		for _, before := range sampled {
+			if ticket.Number == before.Number {
+				kept++
+			}
+		}
+	}
+	assert.GreaterOrEqual(t, kept, 25)
+
+	assert.Len(t, sampleIncidents(tickets[:10], 30), 10)
+}
+
+func TestWatcherReportsLabellingFailures(t *testing.T) {
+	watchIncidents(t)
+	fake := llmtest.UseFake(t)
+	fake.Responder = func(req llm.Request) (string, error) { return "", errors.New("model unavailable") }
+	llm.SetDefault(fake)
+	t.Cleanup(func() { llm.SetDefault(nil) })
+
+	watcher := NewWatcher()
+	watcher.now = func() time.Time { return detectedAt }
+	_, err := watcher.Check(context.Background(), "dev000001")
+	assert.ErrorIs(t, err, ErrClustering)
+}
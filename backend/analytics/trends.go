@@ -0,0 +1,336 @@
+// Package analytics summarises how stored incidents evolve over time: counts,
+// time to resolve, reopens and SLA breaches, bucketed by day, week or month
+// and broken down by cluster, category and priority.
+package analytics
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/davidulloa/mimir/models"
+	"github.com/davidulloa/mimir/servicenow"
+)
+
+const (
+	GranularityDay   = "day"
+	GranularityWeek  = "week"
+	GranularityMonth = "month"
+
+	// Unclustered is the cluster of incidents no cluster includes.
+	Unclustered = "Unclustered"
+	// Uncategorized keys incidents without a category or priority.
+	Uncategorized = "(none)"
+
+	// MaxBuckets caps how many buckets a report spans.
+	MaxBuckets = 400
+)
+
+// Options selects the incidents a report covers and how they are bucketed.
+type Options struct {
+	// Granularity is day, week (starting Monday) or month. Empty means day,
+	// or the finest of them that spans the report in MaxBuckets.
+	Granularity string
+	// From and To bound when incidents were opened: From inclusive, To
+	// exclusive. Zero values leave that side open.
+	From time.Time
+	To   time.Time
+	// Cluster names the cluster of an incident number. Incidents it returns
+	// "" for are Unclustered; a nil Cluster leaves the breakdown empty.
+	Cluster func(number string) string
+}
+
+// Metrics summarise a set of incidents.
+type Metrics struct {
+	Count    int `json:"count"`
+	Resolved int `json:"resolved"`
+	// MeanTimeToResolveHours averages opened to resolved over resolved
+	// incidents.
+	MeanTimeToResolveHours float64 `json:"meanTimeToResolveHours"`
+	Reopened               int     `json:"reopened"`
+	// ReopenRate is the share of incidents reopened at least once.
+	ReopenRate float64 `json:"reopenRate"`
+	// SLAMeasured counts incidents that report made_sla at all.
+	SLAMeasured   int     `json:"slaMeasured"`
+	SLABreached   int     `json:"slaBreached"`
+	SLABreachRate float64 `json:"slaBreachRate"`
+
+	resolveHours float64
+}
+
+// Bucket holds the incidents opened in [Start, End).
+type Bucket struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Metrics
+}
+
+// Series is one cluster, category or priority over every bucket of a report.
+type Series struct {
+	Key     string   `json:"key"`
+	Total   Metrics  `json:"total"`
+	Buckets []Bucket `json:"buckets"`
+}
+
+// Report is the trend of a set of incidents. Buckets cover From to To without
+// gaps, and every series has the same buckets.
+type Report struct {
+	Granularity string    `json:"granularity"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	Total       Metrics   `json:"total"`
+	Buckets     []Bucket  `json:"buckets"`
+	Clusters    []Series  `json:"clusters"`
+	Categories  []Series  `json:"categories"`
+	Priorities  []Series  `json:"priorities"`
+}
+
+func validGranularity(granularity string) bool {
+	switch granularity {
+	case "", GranularityDay, GranularityWeek, GranularityMonth:
+		return true
+	}
+	return false
+}
+
+// bucketStart returns the start of the bucket t falls in.
+func bucketStart(t time.Time, granularity string) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch granularity {
+	case GranularityWeek:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case GranularityMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return day
+	}
+}
+
+func nextBucket(start time.Time, granularity string) time.Time {
+	switch granularity {
+	case GranularityWeek:
+		return start.AddDate(0, 0, 7)
+	case GranularityMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// bucketStarts returns the starts of the buckets spanning from until to, or
+// false if there are more than MaxBuckets.
+func bucketStarts(from time.Time, to time.Time, granularity string) ([]time.Time, bool) {
+	if from.IsZero() || to.IsZero() {
+		return nil, true
+	}
+	var starts []time.Time
+	for start := bucketStart(from, granularity); start.Before(to); start = nextBucket(start, granularity) {
+		if len(starts) == MaxBuckets {
+			return nil, false
+		}
+		starts = append(starts, start)
+	}
+	return starts, true
+}
+
+func parseTime(value string) (time.Time, bool) {
+	t, err := time.ParseInLocation(servicenow.DateTimeLayout, value, time.UTC)
+	return t, err == nil
+}
+
+// incident is a ticket with the fields trends read parsed.
+type incident struct {
+	opened   time.Time
+	resolved time.Time
+	reopened bool
+	// made is nil when the incident has no made_sla value.
+	made *bool
+}
+
+func parseIncident(ticket models.Ticket) (incident, bool) {
+	var result incident
+	opened, ok := parseTime(ticket.OpenedAt)
+	if !ok {
+		// Incidents synced before opened_at was stored.
+		if opened, ok = parseTime(ticket.SysCreatedOn); !ok {
+			return result, false
+		}
+	}
+	result.opened = opened
+	if resolved, ok := parseTime(ticket.ResolvedAt); ok && !resolved.Before(opened) {
+		result.resolved = resolved
+	}
+	if count, err := strconv.Atoi(ticket.ReopenCount); err == nil && count > 0 {
+		result.reopened = true
+	}
+	if made, err := strconv.ParseBool(ticket.MadeSLA); err == nil {
+		result.made = &made
+	}
+	return result, true
+}
+
+func (m *Metrics) add(inc incident) {
+	m.Count++
+	if !inc.resolved.IsZero() {
+		m.Resolved++
+		m.resolveHours += inc.resolved.Sub(inc.opened).Hours()
+	}
+	if inc.reopened {
+		m.Reopened++
+	}
+	if inc.made != nil {
+		m.SLAMeasured++
+		if !*inc.made {
+			m.SLABreached++
+		}
+	}
+}
+
+// finish computes the averages and rates from the counts.
+func (m *Metrics) finish() {
+	if m.Resolved > 0 {
+		m.MeanTimeToResolveHours = m.resolveHours / float64(m.Resolved)
+	}
+	if m.Count > 0 {
+		m.ReopenRate = float64(m.Reopened) / float64(m.Count)
+	}
+	if m.SLAMeasured > 0 {
+		m.SLABreachRate = float64(m.SLABreached) / float64(m.SLAMeasured)
+	}
+}
+
+// series accumulates one Series.
+type series struct {
+	total   Metrics
+	buckets []Metrics
+}
+
+// Trends reports on the incidents among tickets opened between opts.From and
+// opts.To. Tickets without a parseable opened_at or sys_created_on are left
+// out.
+func Trends(tickets []models.Ticket, opts Options) (*Report, error) {
+	if !validGranularity(opts.Granularity) {
+		return nil, fmt.Errorf("granularity must be %q, %q or %q", GranularityDay, GranularityWeek, GranularityMonth)
+	}
+	if !opts.From.IsZero() && !opts.To.IsZero() && !opts.From.Before(opts.To) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+
+	type entry struct {
+		ticket models.Ticket
+		incident
+	}
+	var entries []entry
+	for _, ticket := range tickets {
+		inc, ok := parseIncident(ticket)
+		if !ok {
+			continue
+		}
+		if (!opts.From.IsZero() && inc.opened.Before(opts.From)) || (!opts.To.IsZero() && !inc.opened.Before(opts.To)) {
+			continue
+		}
+		entries = append(entries, entry{ticket, inc})
+	}
+
+	// The report spans the requested range, or the incidents where it's open.
+	from, to := opts.From, opts.To
+	for _, e := range entries {
+		if opts.From.IsZero() && (from.IsZero() || e.opened.Before(from)) {
+			from = e.opened
+		}
+		if opts.To.IsZero() && (to.IsZero() || !e.opened.Before(to)) {
+			to = e.opened.Add(time.Second)
+		}
+	}
+
+	granularities := []string{opts.Granularity}
+	if opts.Granularity == "" {
+		granularities = []string{GranularityDay, GranularityWeek, GranularityMonth}
+	}
+	var granularity string
+	var starts []time.Time
+	var ok bool
+	for _, granularity = range granularities {
+		if starts, ok = bucketStarts(from, to, granularity); ok {
+			break
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("the report would span more than %d %s buckets: narrow the range or use a coarser granularity", MaxBuckets, granularity)
+	}
+
+	report := &Report{Granularity: granularity, From: from.UTC(), To: to.UTC()}
+	index := func(t time.Time) int {
+		return sort.Search(len(starts), func(i int) bool { return starts[i].After(t) }) - 1
+	}
+
+	overall := &series{buckets: make([]Metrics, len(starts))}
+	clusters := make(map[string]*series)
+	categories := make(map[string]*series)
+	priorities := make(map[string]*series)
+	add := func(all map[string]*series, key string, i int, inc incident) {
+		s, ok := all[key]
+		if !ok {
+			s = &series{buckets: make([]Metrics, len(starts))}
+			all[key] = s
+		}
+		s.total.add(inc)
+		s.buckets[i].add(inc)
+	}
+
+	for _, e := range entries {
+		i := index(e.opened)
+		overall.total.add(e.incident)
+		overall.buckets[i].add(e.incident)
+
+		if opts.Cluster != nil {
+			cluster := opts.Cluster(e.ticket.Number)
+			if cluster == "" {
+				cluster = Unclustered
+			}
+			add(clusters, cluster, i, e.incident)
+		}
+		add(categories, keyOrNone(e.ticket.Category), i, e.incident)
+		add(priorities, keyOrNone(e.ticket.Priority), i, e.incident)
+	}
+
+	toBuckets := func(s *series) []Bucket {
+		buckets := make([]Bucket, len(starts))
+		for i, start := range starts {
+			s.buckets[i].finish()
+			buckets[i] = Bucket{Start: start, End: nextBucket(start, granularity), Metrics: s.buckets[i]}
+		}
+		return buckets
+	}
+	toSeries := func(all map[string]*series) []Series {
+		result := make([]Series, 0, len(all))
+		for key, s := range all {
+			s.total.finish()
+			result = append(result, Series{Key: key, Total: s.total, Buckets: toBuckets(s)})
+		}
+		// Largest first, so the busiest clusters lead.
+		sort.Slice(result, func(i, j int) bool {
+			if result[i].Total.Count != result[j].Total.Count {
+				return result[i].Total.Count > result[j].Total.Count
+			}
+			return result[i].Key < result[j].Key
+		})
+		return result
+	}
+
+	overall.total.finish()
+	report.Total = overall.total
+	report.Buckets = toBuckets(overall)
+	report.Clusters = toSeries(clusters)
+	report.Categories = toSeries(categories)
+	report.Priorities = toSeries(priorities)
+	return report, nil
+}
+
+func keyOrNone(key string) string {
+	if key == "" {
+		return Uncategorized
+	}
+	return key
+}
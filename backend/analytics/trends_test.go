@@ -0,0 +1,119 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davidulloa/mimir/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ticket(number, opened, resolved, category, priority, reopens, madeSLA string) models.Ticket {
+	return models.Ticket{
+		Number:      number,
+		OpenedAt:    opened,
+		ResolvedAt:  resolved,
+		Category:    category,
+		Priority:    priority,
+		ReopenCount: reopens,
+		MadeSLA:     madeSLA,
+	}
+}
+
+var tickets = []models.Ticket{
+	// Thursday 1 October, week of 28 September.
+	ticket("INC001", "2026-10-01 09:00:00", "2026-10-01 13:00:00", "network", "2", "0", "true"),
+	ticket("INC002", "2026-10-01 10:00:00", "2026-10-02 10:00:00", "network", "1", "1", "false"),
+	// Monday 5 October.
+	ticket("INC003", "2026-10-05 08:00:00", "", "email", "3", "0", ""),
+	// Synced before opened_at was stored.
+	{Number: "INC004", SysCreatedOn: "2026-10-20 08:00:00", Priority: "3", ReopenCount: "2", MadeSLA: "false"},
+	{Number: "INC005", ShortDescription: "No dates"},
+}
+
+func cluster(number string) string {
+	switch number {
+	case "INC001", "INC002":
+		return "VPN drops"
+	case "INC003":
+		return "Mail delays"
+	}
+	return ""
+}
+
+func TestTrendsByWeek(t *testing.T) {
+	report, err := Trends(tickets, Options{Granularity: GranularityWeek, Cluster: cluster})
+	require.NoError(t, err)
+
+	assert.Equal(t, 4, report.Total.Count)
+	assert.Equal(t, 2, report.Total.Resolved)
+	assert.InDelta(t, 14, report.Total.MeanTimeToResolveHours, 1e-9, "(4h + 24h) / 2")
+	assert.InDelta(t, 0.5, report.Total.ReopenRate, 1e-9)
+	assert.Equal(t, 3, report.Total.SLAMeasured)
+	assert.InDelta(t, 2.0/3, report.Total.SLABreachRate, 1e-9)
+
+	// Weeks start on Monday and empty weeks are kept.
+	require.Len(t, report.Buckets, 4)
+	assert.Equal(t, time.Date(2026, 9, 28, 0, 0, 0, 0, time.UTC), report.Buckets[0].Start)
+	assert.Equal(t, time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC), report.Buckets[0].End)
+	var counts []int
+	for _, bucket := range report.Buckets {
+		counts = append(counts, bucket.Count)
+	}
+	assert.Equal(t, []int{2, 1, 0, 1}, counts)
+
+	require.Len(t, report.Clusters, 3)
+	assert.Equal(t, "VPN drops", report.Clusters[0].Key)
+	assert.Equal(t, 2, report.Clusters[0].Total.Count)
+	assert.InDelta(t, 0.5, report.Clusters[0].Total.SLABreachRate, 1e-9)
+	assert.Len(t, report.Clusters[0].Buckets, 4)
+	assert.ElementsMatch(t, []string{"Mail delays", Unclustered}, []string{report.Clusters[1].Key, report.Clusters[2].Key})
+
+	keys := func(series []Series) []string {
+		var result []string
+		for _, s := range series {
+			result = append(result, s.Key)
+		}
+		return result
+	}
+	assert.Equal(t, []string{"network", Uncategorized, "email"}, keys(report.Categories))
+	assert.Equal(t, []string{"3", "1", "2"}, keys(report.Priorities))
+}
+
+func TestTrendsRangeAndGranularity(t *testing.T) {
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 10, 6, 0, 0, 0, 0, time.UTC)
+	report, err := Trends(tickets, Options{From: from, To: to})
+	require.NoError(t, err)
+
+	assert.Equal(t, GranularityDay, report.Granularity)
+	assert.Equal(t, 3, report.Total.Count)
+	require.Len(t, report.Buckets, 5)
+	assert.Equal(t, 2, report.Buckets[0].Count)
+	assert.Equal(t, 1, report.Buckets[4].Count)
+	assert.Empty(t, report.Clusters, "clusters need a Cluster func")
+
+	report, err = Trends(tickets, Options{Granularity: GranularityMonth})
+	require.NoError(t, err)
+	require.Len(t, report.Buckets, 1)
+	assert.Equal(t, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), report.Buckets[0].End)
+
+	report, err = Trends(nil, Options{})
+	require.NoError(t, err)
+	assert.Empty(t, report.Buckets)
+
+	years := Options{From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)}
+	report, err = Trends(tickets, years)
+	require.NoError(t, err)
+	assert.Equal(t, GranularityWeek, report.Granularity, "too many days coarsen to weeks")
+	assert.LessOrEqual(t, len(report.Buckets), MaxBuckets)
+	years.Granularity = GranularityDay
+	_, err = Trends(tickets, years)
+	assert.Error(t, err, "too many buckets at the requested granularity")
+
+	_, err = Trends(tickets, Options{Granularity: "hour"})
+	assert.Error(t, err)
+	_, err = Trends(tickets, Options{From: to, To: from})
+	assert.Error(t, err)
+}
@@ -0,0 +1,290 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/models"
+	"github.com/davidulloa/mimir/notify"
+	"github.com/davidulloa/mimir/servicenow"
+	"github.com/davidulloa/mimir/ticketsync"
+)
+
+// DefaultWatchInterval is how often a Watcher checks for emerging issues.
+const DefaultWatchInterval = time.Hour
+
+// MaxEmergingIncidents caps how many incidents DetectEmerging clusters, as
+// /tickets caps its clustering.
+const MaxEmergingIncidents = 1000
+
+// ErrClustering wraps failures to cluster the incidents or have the model
+// label the clusters.
+var ErrClustering = errors.New("error clustering incidents")
+
+// DetectEmerging clusters the tickets opened within the horizon of opts
+// before now with TFIDFKMeansClustering and scores each cluster. Fewer of
+// them than MinCount can't make a cluster worth flagging, so they aren't
+// clustered. Beyond MaxEmergingIncidents, a sample is clustered and scored,
+// and its counts are scaled up to estimate the clusters'.
+func DetectEmerging(now time.Time, tickets []models.Ticket, opts EmergingOptions) (*EmergingReport, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	horizon, err := opts.Horizon()
+	if err != nil {
+		return nil, err
+	}
+
+	since := now.UTC().Add(-horizon).Format(servicenow.DateTimeLayout)
+	var recent []models.Ticket
+	for _, ticket := range tickets {
+		opened := ticket.OpenedAt
+		if opened == "" {
+			opened = ticket.SysCreatedOn
+		}
+		if opened > since {
+			recent = append(recent, ticket)
+		}
+	}
+	if len(recent) < opts.MinCount {
+		return Emerging(now, nil, nil, opts)
+	}
+
+	sampled := sampleIncidents(recent, MaxEmergingIncidents)
+	descriptions := make([]string, len(sampled))
+	for i, ticket := range sampled {
+		descriptions[i] = ticket.ShortDescription
+	}
+
+	clusters, err := database.TFIDFKMeansClustering(descriptions)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrClustering, err)
+	}
+	report, err := Emerging(now, sampled, clusters.Clusters, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(sampled) < len(recent) {
+		scaleCounts(report, float64(len(recent))/float64(len(sampled)))
+	}
+	return report, nil
+}
+
+// sampleIncidents returns max of tickets, in their order, or all of them if
+// there are no more. The sample is those whose numbers hash lowest, so
+// later checks over mostly the same incidents sample mostly the same ones.
+func sampleIncidents(tickets []models.Ticket, max int) []models.Ticket {
+	if len(tickets) <= max {
+		return tickets
+	}
+
+	hashes := make([]uint32, len(tickets))
+	order := make([]int, len(tickets))
+	for i, ticket := range tickets {
+		h := fnv.New32a()
+		h.Write([]byte(ticket.Number))
+		hashes[i] = h.Sum32()
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return hashes[order[i]] < hashes[order[j]] })
+	order = order[:max]
+	sort.Ints(order)
+
+	sampled := make([]models.Ticket, len(order))
+	for i, index := range order {
+		sampled[i] = tickets[index]
+	}
+	return sampled
+}
+
+// scaleCounts scales the counts and baselines of a report over a sample by
+// factor, to estimate those of all the incidents. Scores are unchanged.
+func scaleCounts(report *EmergingReport, factor float64) {
+	for i := range report.Clusters {
+		cluster := &report.Clusters[i]
+		for j, count := range cluster.Counts {
+			cluster.Counts[j] = int(math.Round(float64(count) * factor))
+		}
+		cluster.Count = cluster.Counts[len(cluster.Counts)-1]
+		cluster.Baseline *= factor
+	}
+}
+
+// Alerts returns a notification for each flagged cluster of report.
+func Alerts(instanceID string, report *EmergingReport) []notify.Alert {
+	window := report.WindowEnd.Sub(report.WindowStart)
+
+	var alerts []notify.Alert
+	for _, cluster := range report.Flagged() {
+		alerts = append(alerts, notify.Alert{
+			Kind:       notify.KindEmergingIssue,
+			InstanceID: instanceID,
+			CreatedAt:  report.WindowEnd,
+			Text: fmt.Sprintf("Emerging issue on %s: %q has %d incidents in the last %s, against a baseline of %.1f. Examples: %s",
+				instanceID, cluster.Description, cluster.Count, window, cluster.Baseline, strings.Join(cluster.SampleTicketNumbers, ", ")),
+			Cluster:       cluster.Description,
+			TicketNumbers: cluster.SampleTicketNumbers,
+			Count:         cluster.Count,
+			Baseline:      cluster.Baseline,
+			Score:         cluster.Score,
+		})
+	}
+	return alerts
+}
+
+// Watcher periodically looks for emerging issues in every registered
+// instance's stored incidents and notifies the default notifier about each
+// flagged cluster, once per window.
+type Watcher struct {
+	Options  EmergingOptions
+	Interval time.Duration
+
+	now func() time.Time
+	mu  sync.Mutex
+	// notified maps instance and ticket number to when an alert last
+	// covered the ticket.
+	notified map[string]time.Time
+}
+
+func NewWatcher() *Watcher {
+	return &Watcher{
+		Interval: DefaultWatchInterval,
+		now:      time.Now,
+		notified: make(map[string]time.Time),
+	}
+}
+
+// Run checks every Interval until ctx is done.
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.CheckAll(ctx); err != nil {
+			log.Printf("Error detecting emerging issues: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll checks every instance with stored credentials. A failing instance
+// doesn't stop the others; their errors are joined.
+func (w *Watcher) CheckAll(ctx context.Context) error {
+	store, err := database.GetStore()
+	if err != nil {
+		return err
+	}
+
+	records, err := store.ListCredentials()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, record := range records {
+		if _, err := w.Check(ctx, record.InstanceID); err != nil {
+			errs = append(errs, fmt.Errorf("instance %s: %w", record.InstanceID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Check scores the instance's stored incidents and notifies about clusters
+// that weren't already reported within the last window. Cluster labels come
+// from the model and vary between checks, so a cluster counts as reported
+// when an alert sent within the window covered any of its incidents in the
+// latest window. It returns the alerts it sent.
+func (w *Watcher) Check(ctx context.Context, instanceID string) ([]notify.Alert, error) {
+	opts, err := w.Options.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	now := w.now()
+	w.prune(now, opts.Window)
+
+	tickets, err := ticketsync.Tickets(instanceID, servicenow.IncidentTable, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := DetectEmerging(now, tickets, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	flagged := report.Flagged()
+	if len(flagged) == 0 {
+		return nil, nil
+	}
+	// Alerts follows the order of Flagged.
+	alerts := Alerts(instanceID, report)
+
+	notifier, err := notify.Default()
+	if err != nil {
+		return nil, err
+	}
+
+	var sent []notify.Alert
+	var errs []error
+	for i, alert := range alerts {
+		keys := make([]string, len(flagged[i].latest))
+		for j, number := range flagged[i].latest {
+			keys[j] = instanceID + "\x00" + number
+		}
+		if w.reported(keys) {
+			continue
+		}
+
+		if err := notifier.Notify(ctx, alert); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		w.mu.Lock()
+		for _, key := range keys {
+			w.notified[key] = now
+		}
+		w.mu.Unlock()
+		sent = append(sent, alert)
+	}
+	return sent, errors.Join(errs...)
+}
+
+// reported reports whether an alert still within its window covered any of
+// the keys.
+func (w *Watcher) reported(keys []string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, key := range keys {
+		if _, ok := w.notified[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// prune forgets tickets last covered a window or more before now.
+func (w *Watcher) prune(now time.Time, window time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for key, at := range w.notified {
+		if now.Sub(at) >= window {
+			delete(w.notified, key)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/llm"
+	"github.com/davidulloa/mimir/models"
+)
+
+// embed returns the vector search matches an accelerator against.
+func embed(ctx context.Context, text string) ([]float32, error) {
+	provider, err := llm.Default()
+	if err != nil {
+		return nil, err
+	}
+
+	vectors, err := provider.Embed(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("expected 1 embedding, got %d", len(vectors))
+	}
+	return vectors[0], nil
+}
+
+// Save embeds the accelerator's title and description and stores it. If its
+// documentation URL changed, the indexed documentation is dropped so it is
+// fetched again on next use.
+func Save(ctx context.Context, accelerator models.Accelerator) error {
+	vector, err := embed(ctx, strings.TrimSpace(accelerator.Title+"\n"+accelerator.Description))
+	if err != nil {
+		return fmt.Errorf("error embedding accelerator: %v", err)
+	}
+
+	existing, err := database.GetAcceleratorByID(accelerator.ID)
+	if err != nil && err != database.ErrAcceleratorNotFound {
+		return err
+	}
+
+	if err := database.SaveAccelerator(accelerator, vector); err != nil {
+		return err
+	}
+
+	if existing != nil && existing.Url != accelerator.Url {
+		return database.ReplaceDocumentChunks(accelerator.ID, nil)
+	}
+	return nil
+}
+
+// Delete removes the accelerator and its indexed documentation.
+func Delete(acceleratorID string) error {
+	if err := database.DeleteAccelerator(acceleratorID); err != nil {
+		return err
+	}
+	return database.ReplaceDocumentChunks(acceleratorID, nil)
+}
+
+// Search finds the accelerators whose title and description best match query.
+// When the query can't be embedded, it falls back to keyword matching alone.
+func Search(ctx context.Context, query string, category string, limit int) ([]models.AcceleratorMatch, error) {
+	vector, err := embed(ctx, query)
+	if err != nil {
+		log.Printf("Error embedding accelerator search query, searching by keyword only: %v", err)
+		vector = nil
+	}
+	return database.SearchAccelerators(query, vector, category, limit)
+}
@@ -0,0 +1,304 @@
+// Package catalog loads the accelerator catalog from a JSON or CSV export and
+// upserts it into the store. Accelerators are keyed by URL: each gets a UUID
+// derived from its URL, so loading the same file twice changes nothing.
+package catalog
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/llm"
+	"github.com/davidulloa/mimir/models"
+	"github.com/google/uuid"
+)
+
+// Namespace is the UUID namespace accelerator IDs are derived in.
+var Namespace = uuid.MustParse("6f0e4c1a-3b7d-5e2f-9a8c-1d4b6e0f2a37")
+
+// Entry is one accelerator as it appears in the catalog file.
+type Entry struct {
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	Category    string `json:"category"`
+	Description string `json:"description,omitempty"`
+}
+
+// ID returns the deterministic ID for the accelerator documented at url.
+func ID(url string) string {
+	return uuid.NewSHA1(Namespace, []byte(normalizeURL(url))).String()
+}
+
+// normalizeURL makes trivially different spellings of a URL key the same.
+func normalizeURL(url string) string {
+	return strings.TrimRight(strings.TrimSpace(url), "/")
+}
+
+// Load reads a catalog file, choosing the format from its extension.
+func Load(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		return ReadJSON(f)
+	case ".csv":
+		return ReadCSV(f)
+	default:
+		return nil, fmt.Errorf("unsupported catalog format %q", ext)
+	}
+}
+
+// ReadJSON reads either the {"accelerators": [...]} document used by
+// scripts/accelerators.json or a bare array of entries.
+func ReadJSON(r io.Reader) ([]Entry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var document struct {
+		Accelerators []Entry `json:"accelerators"`
+	}
+	if err := json.Unmarshal(data, &document); err == nil {
+		return document.Accelerators, nil
+	}
+
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("error decoding catalog: %v", err)
+	}
+	return entries, nil
+}
+
+// ReadCSV reads a CSV file with a header row naming the name (or title), url,
+// category and optional description columns.
+func ReadCSV(r io.Reader) ([]Entry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading catalog header: %v", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		if i, ok := columns["title"]; ok {
+			columns["name"] = i
+		}
+	}
+	for _, required := range []string{"name", "url"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("catalog is missing the %q column", required)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var entries []Entry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading catalog: %v", err)
+		}
+		entries = append(entries, Entry{
+			Name:        field(record, "name"),
+			URL:         field(record, "url"),
+			Category:    field(record, "category"),
+			Description: field(record, "description"),
+		})
+	}
+	return entries, nil
+}
+
+type Outcome string
+
+const (
+	Created   Outcome = "created"
+	Updated   Outcome = "updated"
+	Unchanged Outcome = "unchanged"
+	Failed    Outcome = "failed"
+)
+
+// Result is what happened to one entry.
+type Result struct {
+	Entry   Entry
+	ID      string
+	Outcome Outcome
+	Err     error
+}
+
+type Report struct {
+	Results []Result
+}
+
+// Count returns how many entries had the given outcome.
+func (r *Report) Count(outcome Outcome) int {
+	n := 0
+	for _, result := range r.Results {
+		if result.Outcome == outcome {
+			n++
+		}
+	}
+	return n
+}
+
+type Options struct {
+	// DryRun reports what would change without writing or generating anything.
+	DryRun bool
+}
+
+// Ingest upserts entries by URL. An accelerator already stored under another
+// ID (e.g. by the old Python loader) is updated in place so existing chat
+// threads keep pointing at it; new ones get ID(url). Descriptions are only
+// generated when neither the entry nor the stored accelerator has one.
+func Ingest(ctx context.Context, entries []Entry, opts Options) (*Report, error) {
+	stored, err := database.GetAllAccelerators()
+	if err != nil {
+		return nil, err
+	}
+
+	byURL := make(map[string]models.Accelerator)
+	for _, accelerator := range stored {
+		key := normalizeURL(accelerator.Url)
+		// Prefer the record under the deterministic ID if the URL is duplicated.
+		if _, seen := byURL[key]; !seen || accelerator.ID == ID(key) {
+			byURL[key] = accelerator
+		}
+	}
+
+	report := &Report{}
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		result := ingest(ctx, entry, byURL, seen, opts)
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+func ingest(ctx context.Context, entry Entry, byURL map[string]models.Accelerator, seen map[string]bool, opts Options) Result {
+	entry.Name = strings.TrimSpace(entry.Name)
+	entry.URL = strings.TrimSpace(entry.URL)
+	result := Result{Entry: entry}
+
+	key := normalizeURL(entry.URL)
+	if key == "" || entry.Name == "" {
+		result.Outcome = Failed
+		result.Err = fmt.Errorf("name and url are required")
+		return result
+	}
+	if seen[key] {
+		result.Outcome = Failed
+		result.Err = fmt.Errorf("duplicate url in catalog")
+		return result
+	}
+	seen[key] = true
+
+	accelerator := models.Accelerator{
+		ID:          ID(key),
+		Url:         entry.URL,
+		Title:       entry.Name,
+		Category:    entry.Category,
+		Description: entry.Description,
+	}
+
+	existing, exists := byURL[key]
+	if exists {
+		accelerator.ID = existing.ID
+		if accelerator.Description == "" {
+			accelerator.Description = existing.Description
+		}
+	}
+	result.ID = accelerator.ID
+
+	// An accelerator stored without a description is never unchanged: it
+	// still needs one generated.
+	if exists && accelerator == existing && accelerator.Description != "" {
+		result.Outcome = Unchanged
+		return result
+	}
+	if exists {
+		result.Outcome = Updated
+	} else {
+		result.Outcome = Created
+	}
+	if opts.DryRun {
+		return result
+	}
+
+	if accelerator.Description == "" {
+		description, err := Describe(ctx, accelerator)
+		if err != nil {
+			result.Outcome = Failed
+			result.Err = fmt.Errorf("error generating description: %v", err)
+			return result
+		}
+		accelerator.Description = description
+	}
+
+	if err := Save(ctx, accelerator); err != nil {
+		result.Outcome = Failed
+		result.Err = err
+		return result
+	}
+	byURL[key] = accelerator
+	return result
+}
+
+// Describe asks the configured LLM provider for a markdown description of the
+// accelerator.
+func Describe(ctx context.Context, accelerator models.Accelerator) (string, error) {
+	provider, err := llm.Default()
+	if err != nil {
+		return "", err
+	}
+
+	prompt := fmt.Sprintf(`Please provide a comprehensive description for the accelerator below:
+
+**Name:** %s
+**URL:** %s
+**Category:** %s
+
+The description should include key features, benefits, and any relevant information to help someone understand what this accelerator offers.
+
+Please format the description in markdown.`, accelerator.Title, accelerator.Url, accelerator.Category)
+
+	description, err := provider.Complete(ctx, llm.Request{
+		Messages: []llm.Message{
+			llm.System("You are a helpful assistant that generates detailed markdown descriptions for accelerators."),
+			llm.User(prompt),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	description = strings.TrimSpace(description)
+	if description == "" {
+		return "", fmt.Errorf("empty description")
+	}
+	return description, nil
+}
@@ -0,0 +1,141 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/database/databasetest"
+	"github.com/davidulloa/mimir/llm/llmtest"
+	"github.com/davidulloa/mimir/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadJSONAndCSV(t *testing.T) {
+	entries, err := ReadJSON(strings.NewReader(`{"accelerators": [
+		{"name": "Incident Health Check", "url": "https://example.com/ihc", "category": "ITSM"}
+	]}`))
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{{Name: "Incident Health Check", URL: "https://example.com/ihc", Category: "ITSM"}}, entries)
+
+	entries, err = ReadJSON(strings.NewReader(`[{"name": "CMDB", "url": "https://example.com/cmdb"}]`))
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{{Name: "CMDB", URL: "https://example.com/cmdb"}}, entries)
+
+	entries, err = ReadCSV(strings.NewReader("Title,URL,Category,Description\n" +
+		"Incident Health Check,https://example.com/ihc,ITSM,\"Reviews incidents, end to end\"\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{{
+		Name:        "Incident Health Check",
+		URL:         "https://example.com/ihc",
+		Category:    "ITSM",
+		Description: "Reviews incidents, end to end",
+	}}, entries)
+
+	_, err = ReadCSV(strings.NewReader("name,category\nx,y\n"))
+	assert.Error(t, err)
+}
+
+func TestIngestIsIdempotent(t *testing.T) {
+	databasetest.UseBoltStore(t)
+	fake := llmtest.UseFake(t, "Generated description.")
+	ctx := context.Background()
+
+	entries := []Entry{
+		{Name: "Incident Health Check", URL: "https://example.com/ihc", Category: "ITSM"},
+		{Name: "CMDB Jumpstart", URL: "https://example.com/cmdb", Category: "ITOM", Description: "Given."},
+		{Name: "No URL"},
+		{Name: "Duplicate", URL: "https://example.com/ihc/"},
+	}
+
+	report, err := Ingest(ctx, entries, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, []Outcome{Created, Created, Failed, Failed}, outcomes(report))
+	assert.Len(t, fake.Requests, 1, "only the missing description is generated")
+
+	stored, err := database.GetAcceleratorByID(ID("https://example.com/ihc"))
+	require.NoError(t, err)
+	assert.Equal(t, "Generated description.", stored.Description)
+
+	report, err = Ingest(ctx, entries[:2], Options{})
+	require.NoError(t, err)
+	assert.Equal(t, []Outcome{Unchanged, Unchanged}, outcomes(report))
+	assert.Len(t, fake.Requests, 1)
+
+	entries[1].Category = "ITAM"
+	report, err = Ingest(ctx, entries[:2], Options{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, []Outcome{Unchanged, Updated}, outcomes(report))
+
+	all, err := database.GetAllAccelerators()
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+	for _, accelerator := range all {
+		assert.NotEqual(t, "ITAM", accelerator.Category, "dry run must not write")
+	}
+}
+
+func TestIngestUpdatesLegacyRecordInPlace(t *testing.T) {
+	databasetest.UseBoltStore(t)
+	llmtest.UseFake(t)
+
+	legacy := models.Accelerator{ID: "legacy-id", Url: "https://example.com/ihc", Title: "Old title", Description: "Kept."}
+	require.NoError(t, database.SaveAccelerator(legacy, nil))
+
+	report, err := Ingest(context.Background(), []Entry{{Name: "Incident Health Check", URL: "https://example.com/ihc"}}, Options{})
+	require.NoError(t, err)
+	require.Len(t, report.Results, 1)
+	assert.Equal(t, Updated, report.Results[0].Outcome)
+	assert.Equal(t, "legacy-id", report.Results[0].ID)
+
+	stored, err := database.GetAcceleratorByID("legacy-id")
+	require.NoError(t, err)
+	assert.Equal(t, "Incident Health Check", stored.Title)
+	assert.Equal(t, "Kept.", stored.Description)
+}
+
+func TestIngestDescribesStoredRecordWithoutDescription(t *testing.T) {
+	databasetest.UseBoltStore(t)
+	fake := llmtest.UseFake(t, "Generated description.")
+
+	entry := Entry{Name: "Incident Health Check", URL: "https://example.com/ihc"}
+	bare := models.Accelerator{ID: ID(normalizeURL(entry.URL)), Url: entry.URL, Title: entry.Name}
+	require.NoError(t, database.SaveAccelerator(bare, nil))
+
+	report, err := Ingest(context.Background(), []Entry{entry}, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, []Outcome{Updated}, outcomes(report))
+	assert.Len(t, fake.Requests, 1)
+
+	stored, err := database.GetAcceleratorByID(bare.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Generated description.", stored.Description)
+}
+
+func outcomes(report *Report) []Outcome {
+	var result []Outcome
+	for _, r := range report.Results {
+		result = append(result, r.Outcome)
+	}
+	return result
+}
+
+func TestSearchFallsBackToKeywords(t *testing.T) {
+	databasetest.UseBoltStore(t)
+	fake := llmtest.UseFake(t)
+
+	ctx := context.Background()
+	require.NoError(t, Save(ctx, models.Accelerator{ID: ID("https://example.com/a"), Title: "Incident Health Check", Description: "Reviews incident processes"}))
+	require.NoError(t, Save(ctx, models.Accelerator{ID: ID("https://example.com/b"), Title: "CMDB Audit", Description: "Finds stale CIs"}))
+
+	fake.EmbedErr = errors.New("embedding service unavailable")
+	matches, err := Search(ctx, "incident processes", "", 10)
+	require.NoError(t, err)
+	if assert.Len(t, matches, 1) {
+		assert.Equal(t, "Incident Health Check", matches[0].Accelerator.Title)
+		assert.Greater(t, matches[0].Score, 0.0)
+	}
+}
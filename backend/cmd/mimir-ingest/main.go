@@ -0,0 +1,72 @@
+// Command mimir-ingest loads the accelerator catalog into the configured store.
+//
+//	mimir-ingest [-dry-run] [-v] accelerators.json|accelerators.csv
+//
+// The store and LLM provider are configured from the same environment
+// variables as the server. Entries are upserted by URL, so the command can be
+// re-run safely; it exits non-zero if any entry failed.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/davidulloa/mimir/catalog"
+	"github.com/davidulloa/mimir/database"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report what would change without writing")
+	verbose := flag.Bool("v", false, "also list unchanged accelerators")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <catalog.json|catalog.csv>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	entries, err := catalog.Load(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Error loading catalog: %v", err)
+	}
+
+	store, err := database.OpenStore()
+	if err != nil {
+		log.Fatalf("Error opening store: %v", err)
+	}
+	defer store.Close()
+	database.SetStore(store)
+
+	report, err := catalog.Ingest(context.Background(), entries, catalog.Options{DryRun: *dryRun})
+	if err != nil {
+		log.Fatalf("Error ingesting catalog: %v", err)
+	}
+
+	for _, result := range report.Results {
+		switch {
+		case result.Err != nil:
+			fmt.Printf("%-9s %s (%s): %v\n", result.Outcome, result.Entry.Name, result.Entry.URL, result.Err)
+		case result.Outcome != catalog.Unchanged || *verbose:
+			fmt.Printf("%-9s %s %s\n", result.Outcome, result.ID, result.Entry.Name)
+		}
+	}
+
+	fmt.Printf("\n%d created, %d updated, %d unchanged, %d failed\n",
+		report.Count(catalog.Created), report.Count(catalog.Updated),
+		report.Count(catalog.Unchanged), report.Count(catalog.Failed))
+	if *dryRun {
+		fmt.Println("(dry run: nothing was written)")
+	}
+
+	if report.Count(catalog.Failed) > 0 {
+		store.Close()
+		os.Exit(1)
+	}
+}
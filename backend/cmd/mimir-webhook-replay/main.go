@@ -0,0 +1,180 @@
+// Command mimir-webhook-replay posts recorded ServiceNow webhook events to a
+// running server, signing each one as the instance would.
+//
+//	mimir-webhook-replay -instance dev000001 [-url http://localhost:8080] [-delay 1s] events.jsonl...
+//
+// Each file holds one event per line, or a JSON event or array of events;
+// directories are read for *.json and *.jsonl files. The secret comes from
+// -secret or MIMIR_WEBHOOK_SECRET. It exits non-zero if any event was refused.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/davidulloa/mimir/webhook"
+)
+
+func main() {
+	server := flag.String("url", "http://localhost:8080", "base URL of the mimir server")
+	instance := flag.String("instance", "", "ServiceNow instance the events come from")
+	secret := flag.String("secret", os.Getenv("MIMIR_WEBHOOK_SECRET"), "the instance's webhook secret")
+	delay := flag.Duration("delay", 0, "pause between events")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s -instance <id> [flags] <events.jsonl|events.json|dir>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 || *instance == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *secret == "" {
+		log.Fatal("No webhook secret; pass -secret or set MIMIR_WEBHOOK_SECRET")
+	}
+
+	var events [][]byte
+	for _, path := range flag.Args() {
+		loaded, err := load(path)
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", path, err)
+		}
+		events = append(events, loaded...)
+	}
+
+	target := strings.TrimRight(*server, "/") + "/webhooks/servicenow/" + url.PathEscape(*instance)
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	failed := 0
+	for i, body := range events {
+		if i > 0 && *delay > 0 {
+			time.Sleep(*delay)
+		}
+		status, reply, err := post(client, target, *secret, body)
+		if err != nil || status != http.StatusOK {
+			failed++
+		}
+		if err != nil {
+			fmt.Printf("%3d error %v\n", i+1, err)
+			continue
+		}
+		fmt.Printf("%3d %d %s %s\n", i+1, status, describe(body), strings.TrimSpace(reply))
+	}
+
+	fmt.Printf("\n%d sent, %d failed\n", len(events), failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func post(client *http.Client, target string, secret string, body []byte) (int, string, error) {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(secret, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	reply, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(reply), err
+}
+
+// describe summarises an event as "<event> <number>".
+func describe(body []byte) string {
+	var event webhook.Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		return "(unparsed)"
+	}
+	number := event.Record.Number
+	if number == "" {
+		number = event.Record.SysID
+	}
+	return event.Event + " " + number
+}
+
+// load returns the events in path, each as the exact bytes to send.
+func load(path string) ([][]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return loadFile(path)
+	}
+
+	var files []string
+	for _, pattern := range []string{"*.json", "*.jsonl"} {
+		matches, err := filepath.Glob(filepath.Join(path, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	var events [][]byte
+	for _, file := range files {
+		loaded, err := loadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		events = append(events, loaded...)
+	}
+	return events, nil
+}
+
+func loadFile(path string) ([][]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		var events []json.RawMessage
+		if err := json.Unmarshal(trimmed, &events); err != nil {
+			return nil, err
+		}
+		result := make([][]byte, len(events))
+		for i, event := range events {
+			result[i] = event
+		}
+		return result, nil
+	}
+	if json.Valid(trimmed) {
+		return [][]byte{trimmed}, nil
+	}
+
+	var events [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		event := bytes.TrimSpace(scanner.Bytes())
+		if len(event) == 0 {
+			continue
+		}
+		if !json.Valid(event) {
+			return nil, fmt.Errorf("line %d is not valid JSON", line)
+		}
+		events = append(events, append([]byte(nil), event...))
+	}
+	return events, scanner.Err()
+}
@@ -2,21 +2,31 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/davidulloa/mimir/models"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate/fault"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
 )
 
-func GetAcceleratorByID(acceleratorID string) (*models.Accelerator, error) {
+var ErrAcceleratorNotFound = errors.New("accelerator not found")
 
-    client, err := GetWeaviateClient()
-    if err != nil {
-        log.Printf("Error getting Weaviate client: %v", err)
-        return nil, err
-    }
+// HybridAlpha weighs vector similarity against keyword matching in accelerator
+// search: 1 is pure vector search, 0 pure keyword search.
+const HybridAlpha = 0.5
+
+// acceleratorQueryLimit lifts Weaviate's default page size so the whole
+// catalog comes back in one query.
+const acceleratorQueryLimit = 10000
+
+func (s *WeaviateStore) GetAcceleratorByID(acceleratorID string) (*models.Accelerator, error) {
+    client := s.client
 
     result, err := client.Data().ObjectsGetter().
         WithClassName("Accelerator").
@@ -27,7 +37,7 @@ func GetAcceleratorByID(acceleratorID string) (*models.Accelerator, error) {
         if clientErr, ok := err.(*fault.WeaviateClientError); ok {
             if clientErr.StatusCode == 404 {
                 log.Printf("Accelerator not found with ID: %s", acceleratorID)
-                return nil, fmt.Errorf("accelerator not found")
+                return nil, ErrAcceleratorNotFound
             }
         }
         log.Printf("Error retrieving accelerator with ID %s: %v", acceleratorID, err)
@@ -36,10 +46,10 @@ func GetAcceleratorByID(acceleratorID string) (*models.Accelerator, error) {
 
     if len(result) == 0 {
         log.Printf("Accelerator not found with ID: %s", acceleratorID)
-        return nil, fmt.Errorf("accelerator not found")
+        return nil, ErrAcceleratorNotFound
     }
 
-    accelerator := &models.Accelerator{}
+    accelerator := &models.Accelerator{ID: acceleratorID}
     properties, ok := result[0].Properties.(map[string]interface{})
     if !ok {
         log.Printf("Expected properties to be a map but got: %T %+v", result[0].Properties, result[0].Properties)
@@ -73,12 +83,8 @@ func GetAcceleratorByID(acceleratorID string) (*models.Accelerator, error) {
     return accelerator, nil
 }
 
-func GetAllAccelerators() ([]models.Accelerator, error) {
-    client, err := GetWeaviateClient()
-    if err != nil {
-        log.Printf("Error getting Weaviate client: %v", err)
-        return nil, err
-    }
+func (s *WeaviateStore) GetAllAccelerators() ([]models.Accelerator, error) {
+    client := s.client
 
     fields := []graphql.Field{
         {Name: "url"},
@@ -91,6 +97,7 @@ func GetAllAccelerators() ([]models.Accelerator, error) {
     result, err := client.GraphQL().Get().
         WithClassName("Accelerator").
         WithFields(fields...).
+        WithLimit(acceleratorQueryLimit).
         Do(context.Background())
 
     if err != nil {
@@ -150,15 +157,207 @@ func GetAllAccelerators() ([]models.Accelerator, error) {
             accelerator.Category = category
         }
 
-        // if additional, ok := accMap["_additional"].(map[string]interface{}); ok {
-        //     if id, ok := additional["id"].(string); ok {
-        //         // Optionally, you can add an ID field to your Accelerator struct to store this value
-        //         accelerator.Id = id
-        //     }
-        // }
+        if additional, ok := accMap["_additional"].(map[string]interface{}); ok {
+            if id, ok := additional["id"].(string); ok {
+                accelerator.ID = id
+            }
+        }
 
         acceleratorsList = append(acceleratorsList, accelerator)
     }
 
     return acceleratorsList, nil
 }
+
+// SaveAccelerator creates the accelerator under its ID or replaces the object
+// already stored there. The vector is used for search; it may be nil.
+func (s *WeaviateStore) SaveAccelerator(accelerator models.Accelerator, vector []float32) error {
+	client := s.client
+
+	if accelerator.ID == "" {
+		return fmt.Errorf("accelerator ID is required")
+	}
+
+	properties := map[string]interface{}{
+		"url":         accelerator.Url,
+		"title":       accelerator.Title,
+		"description": accelerator.Description,
+		"category":    accelerator.Category,
+	}
+
+	exists, err := client.Data().Checker().
+		WithClassName("Accelerator").
+		WithID(accelerator.ID).
+		Do(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return client.Data().Updater().
+			WithID(accelerator.ID).
+			WithClassName("Accelerator").
+			WithProperties(properties).
+			WithVector(vector).
+			Do(context.Background())
+	}
+
+	_, err = client.Data().Creator().
+		WithClassName("Accelerator").
+		WithID(accelerator.ID).
+		WithProperties(properties).
+		WithVector(vector).
+		Do(context.Background())
+	return err
+}
+
+func (s *WeaviateStore) DeleteAccelerator(acceleratorID string) error {
+	client := s.client
+
+	exists, err := client.Data().Checker().
+		WithClassName("Accelerator").
+		WithID(acceleratorID).
+		Do(context.Background())
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrAcceleratorNotFound
+	}
+
+	return client.Data().Deleter().
+		WithClassName("Accelerator").
+		WithID(acceleratorID).
+		Do(context.Background())
+}
+
+// SearchAccelerators runs a hybrid query over title and description, blending
+// BM25 with similarity to vector, or BM25 alone when vector is empty. An empty
+// category matches every accelerator.
+func (s *WeaviateStore) SearchAccelerators(query string, vector []float32, category string, limit int) ([]models.AcceleratorMatch, error) {
+	client := s.client
+
+	fields := []graphql.Field{
+		{Name: "url"},
+		{Name: "title"},
+		{Name: "description"},
+		{Name: "category"},
+		{Name: "_additional { id score }"},
+	}
+
+	hybrid := client.GraphQL().HybridArgumentBuilder().
+		WithQuery(query).
+		WithProperties([]string{"title", "description"})
+	if len(vector) > 0 {
+		hybrid = hybrid.WithAlpha(HybridAlpha).WithVector(vector)
+	} else {
+		// Without a vector, rank by BM25 alone.
+		hybrid = hybrid.WithAlpha(0)
+	}
+
+	get := client.GraphQL().Get().
+		WithClassName("Accelerator").
+		WithFields(fields...).
+		WithHybrid(hybrid).
+		WithLimit(limit)
+	if category != "" {
+		get = get.WithWhere(filters.Where().
+			WithPath([]string{"category"}).
+			WithOperator(filters.Equal).
+			WithValueString(category))
+	}
+
+	response, err := get.Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if response.Errors != nil {
+		return nil, fmt.Errorf("graphQL errors: %v", response.Errors)
+	}
+
+	getObject, ok := response.Data["Get"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("unable to parse 'Get' from response data")
+	}
+
+	classObjects, ok := getObject["Accelerator"].([]interface{})
+	if !ok {
+		return nil, errors.New("unable to parse 'Accelerator' class from class object")
+	}
+
+	matches := make([]models.AcceleratorMatch, 0, len(classObjects))
+	for _, classObject := range classObjects {
+		obj, ok := classObject.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		var match models.AcceleratorMatch
+		match.Accelerator.Url, _ = obj["url"].(string)
+		match.Accelerator.Title, _ = obj["title"].(string)
+		match.Accelerator.Description, _ = obj["description"].(string)
+		match.Accelerator.Category, _ = obj["category"].(string)
+		if additional, ok := obj["_additional"].(map[string]interface{}); ok {
+			match.Accelerator.ID, _ = additional["id"].(string)
+			// Hybrid scores come back as strings.
+			if score, ok := additional["score"].(string); ok {
+				match.Score, _ = strconv.ParseFloat(score, 64)
+			}
+		}
+		matches = append(matches, match)
+	}
+
+	return matches, nil
+}
+
+// keywordScore is the fraction of the query's terms found in text.
+func keywordScore(terms []string, text string) float64 {
+	if len(terms) == 0 {
+		return 0
+	}
+
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), isNotWordRune) {
+		words[word] = true
+	}
+
+	matched := 0
+	for _, term := range terms {
+		if words[term] {
+			matched++
+		}
+	}
+	return float64(matched) / float64(len(terms))
+}
+
+func isNotWordRune(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r > 127)
+}
+
+// rankAccelerators scores accelerators the way Weaviate's hybrid search does,
+// blending keyword and vector scores by HybridAlpha. Accelerators that match
+// neither way are dropped.
+func rankAccelerators(accelerators []models.Accelerator, vectors map[string][]float32, query string, vector []float32, limit int) []models.AcceleratorMatch {
+	terms := strings.FieldsFunc(strings.ToLower(query), isNotWordRune)
+
+	var matches []models.AcceleratorMatch
+	for _, accelerator := range accelerators {
+		keyword := keywordScore(terms, accelerator.Title+" "+accelerator.Description)
+		similarity := cosineSimilarity(vectors[accelerator.ID], vector)
+		if keyword == 0 && similarity <= 0 {
+			continue
+		}
+		matches = append(matches, models.AcceleratorMatch{
+			Accelerator: accelerator,
+			Score:       HybridAlpha*similarity + (1-HybridAlpha)*keyword,
+		})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"log"
 	"os"
 
 	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
@@ -15,6 +16,9 @@ const (
 	AuthorizationClass = "Authorization"
 )
 
+// CreateHash is the legacy credential hash: one round of SHA-256 over the
+// username, password and AUTHORIZATION_SALT. It is only used to find records
+// that have not been upgraded to argon2id yet.
 func CreateHash(username string, password string) string {
 	combined := username + password + os.Getenv("AUTHORIZATION_SALT")
 	h := sha256.New()
@@ -23,15 +27,137 @@ func CreateHash(username string, password string) string {
     return hex.EncodeToString(hashed)
 }
 
+// userDigest identifies a username within an instance for record lookup.
+func userDigest(instanceID string, username string) string {
+	sum := sha256.Sum256([]byte(instanceID + "\x00" + username))
+	return hex.EncodeToString(sum[:])
+}
+
+// setPassword hashes the credentials onto record with a fresh salt, the current
+// cost settings and the current pepper.
+func setPassword(record *AuthRecord, username string, password string) error {
+	p := currentPepper()
+	hash, err := hashPassword(p.apply(username, password), CurrentPasswordParams())
+	if err != nil {
+		return err
+	}
+
+	record.UserDigest = userDigest(record.InstanceID, username)
+	record.AuthHash = hash
+	record.Algorithm = AlgorithmArgon2id
+	record.PepperID = p.ID
+	return nil
+}
+
+// checkPassword reports whether the credentials match record and whether the
+// record should be rehashed because its cost settings or pepper are stale.
+func checkPassword(record AuthRecord, username string, password string) (bool, bool) {
+	p, ok := findPepper(record.PepperID)
+	if !ok {
+		log.Printf("Auth record %s uses an unknown pepper %s", record.ID, record.PepperID)
+		return false, false
+	}
+
+	valid, params, err := verifyPassword(p.apply(username, password), record.AuthHash)
+	if err != nil {
+		log.Printf("Auth record %s has an invalid hash: %v", record.ID, err)
+		return false, false
+	}
+
+	stale := p.ID != currentPepper().ID || params != CurrentPasswordParams()
+	return valid, stale
+}
+
 func ValidateAuthentication(instanceID string, username string, password string) (bool, error) {
-	hash := CreateHash(username, password)
-	client, err := GetWeaviateClient()
+	store, err := GetStore()
+
+	if err != nil {
+		return false, err
+	}
+
+	records, err := store.FindAuthRecordsByUser(instanceID, userDigest(instanceID, username))
+	if err != nil {
+		return false, err
+	}
+
+	for _, record := range records {
+		valid, stale := checkPassword(record, username, password)
+		if !valid {
+			continue
+		}
 
+		if stale {
+			if err := setPassword(&record, username, password); err == nil {
+				if err := store.UpdateAuthRecord(record); err != nil {
+					log.Printf("Error rehashing auth record %s: %v", record.ID, err)
+				}
+			}
+		}
+		return true, nil
+	}
+
+	// Fall back to the legacy SHA-256 hash and upgrade the record in place.
+	legacy, err := store.FindAuthRecord(instanceID, CreateHash(username, password))
 	if err != nil {
 		return false, err
 	}
+	if legacy == nil {
+		return false, nil
+	}
+
+	if err := setPassword(legacy, username, password); err != nil {
+		return false, err
+	}
+	if err := store.UpdateAuthRecord(*legacy); err != nil {
+		log.Printf("Error upgrading legacy auth record %s: %v", legacy.ID, err)
+	}
+
+	return true, nil
+}
+
+// RegisterAuthentication stores verified credentials, replacing the hash of any
+// existing record for the same user, including a legacy record for the same
+// credentials.
+func RegisterAuthentication(instanceID string, username string, password string) error {
+	store, err := GetStore()
+
+	if err != nil {
+		return err
+	}
+
+	record := AuthRecord{InstanceID: instanceID}
+	if err := setPassword(&record, username, password); err != nil {
+		return err
+	}
+
+	existing, err := store.FindAuthRecordsByUser(instanceID, record.UserDigest)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		record.ID = existing[0].ID
+		return store.UpdateAuthRecord(record)
+	}
+
+	// Records from before user digests can only be found by their legacy
+	// hash. Upgrade one in place rather than leaving it behind.
+	legacy, err := store.FindAuthRecord(instanceID, CreateHash(username, password))
+	if err != nil {
+		return err
+	}
+	if legacy != nil {
+		record.ID = legacy.ID
+		return store.UpdateAuthRecord(record)
+	}
+
+	_, err = store.CreateAuthRecord(record)
+	return err
+}
 
-	fields := []string{"instanceID"}
+func (s *WeaviateStore) FindAuthRecord(instanceID string, authHash string) (*AuthRecord, error) {
+	client := s.client
+
+	fields := []string{"instanceID", "authHash", "_additional { id }"}
 	graphqlFields := make([]graphql.Field, len(fields))
 	for i, field := range fields {
 		graphqlFields[i] = graphql.Field{Name: field}
@@ -42,49 +168,124 @@ func ValidateAuthentication(instanceID string, username string, password string)
 		WithFields(graphqlFields...).
 		WithWhere(filters.Where().WithOperator(filters.And).WithOperands([]*filters.WhereBuilder{
 			filters.Where().WithPath([]string{"instanceID"}).WithOperator(filters.Equal).WithValueString(instanceID),
-			filters.Where().WithPath([]string{"authHash"}).WithOperator(filters.Equal).WithValueString(hash),
+			filters.Where().WithPath([]string{"authHash"}).WithOperator(filters.Equal).WithValueString(authHash),
 		})).
         WithLimit(1).
         Do(context.Background())
 	
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	
 	getObject, ok := response.Data["Get"].(map[string]interface{})
 	if !ok {
-		return false, errors.New("unable to parse 'Get' from response data")
+		return nil, errors.New("unable to parse 'Get' from response data")
 	}
 
 	classObjects, ok := getObject[AuthorizationClass].([]interface{})
 	if !ok {
-		return false, errors.New("unable to parse 'Authorization' class from class object")
+		return nil, errors.New("unable to parse 'Authorization' class from class object")
 	}
 
-	// Returns true if object is found
-	return len(classObjects) > 0, nil
+	if len(classObjects) == 0 {
+		return nil, nil
+	}
 
-}
+	record := &AuthRecord{InstanceID: instanceID, AuthHash: authHash}
+	if obj, ok := classObjects[0].(map[string]interface{}); ok {
+		if additional, ok := obj["_additional"].(map[string]interface{}); ok {
+			record.ID, _ = additional["id"].(string)
+		}
+	}
 
-func RegisterAuthentication(instanceID string, username string, password string) error {
-	hash := CreateHash(username, password)
-	client, err := GetWeaviateClient()
+	return record, nil
+}
 
-	if err != nil {
-		return err
-	}
+func (s *WeaviateStore) CreateAuthRecord(record AuthRecord) (string, error) {
+	client := s.client
 
-	_, err = client.Data().Creator().
+	response, err := client.Data().Creator().
 		WithClassName(AuthorizationClass).
 		WithProperties(map[string]interface{}{
-			"instanceID":  instanceID,
-			"authHash": hash,
+			"instanceID":  record.InstanceID,
+			"authHash": record.AuthHash,
+			"userDigest": record.UserDigest,
+			"algorithm":  record.Algorithm,
+			"pepperID":   record.PepperID,
 		}).
 		Do(context.Background())
 	
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	return nil
+	return string(response.Object.ID), nil
+}
+
+func (s *WeaviateStore) FindAuthRecordsByUser(instanceID string, userDigest string) ([]AuthRecord, error) {
+	client := s.client
+
+	fields := []string{"instanceID", "authHash", "userDigest", "algorithm", "pepperID", "_additional { id }"}
+	graphqlFields := make([]graphql.Field, len(fields))
+	for i, field := range fields {
+		graphqlFields[i] = graphql.Field{Name: field}
+	}
+
+	response, err := client.GraphQL().Get().
+		WithClassName(AuthorizationClass).
+		WithFields(graphqlFields...).
+		WithWhere(filters.Where().WithOperator(filters.And).WithOperands([]*filters.WhereBuilder{
+			filters.Where().WithPath([]string{"instanceID"}).WithOperator(filters.Equal).WithValueString(instanceID),
+			filters.Where().WithPath([]string{"userDigest"}).WithOperator(filters.Equal).WithValueString(userDigest),
+		})).
+		Do(context.Background())
+
+	if err != nil {
+		return nil, err
+	}
+
+	getObject, ok := response.Data["Get"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("unable to parse 'Get' from response data")
+	}
+
+	classObjects, ok := getObject[AuthorizationClass].([]interface{})
+	if !ok {
+		return nil, errors.New("unable to parse 'Authorization' class from class object")
+	}
+
+	records := make([]AuthRecord, 0, len(classObjects))
+	for _, classObject := range classObjects {
+		obj, ok := classObject.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		record := AuthRecord{InstanceID: instanceID, UserDigest: userDigest}
+		record.AuthHash, _ = obj["authHash"].(string)
+		record.Algorithm, _ = obj["algorithm"].(string)
+		record.PepperID, _ = obj["pepperID"].(string)
+		if additional, ok := obj["_additional"].(map[string]interface{}); ok {
+			record.ID, _ = additional["id"].(string)
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func (s *WeaviateStore) UpdateAuthRecord(record AuthRecord) error {
+	client := s.client
+
+	return client.Data().Updater().
+		WithID(record.ID).
+		WithClassName(AuthorizationClass).
+		WithProperties(map[string]interface{}{
+			"instanceID": record.InstanceID,
+			"authHash":   record.AuthHash,
+			"userDigest": record.UserDigest,
+			"algorithm":  record.Algorithm,
+			"pepperID":   record.PepperID,
+		}).
+		Do(context.Background())
 }
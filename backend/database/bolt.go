@@ -0,0 +1,936 @@
+package database
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/davidulloa/mimir/models"
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	threadsBucket        = []byte("threads")
+	messagesBucket       = []byte("messages")
+	acceleratorsBucket   = []byte("accelerators")
+	acceleratorVectors   = []byte("accelerator_vectors")
+	ticketsBucket        = []byte("tickets")
+	authorizationsBucket = []byte("authorizations")
+	sessionsBucket       = []byte("sessions")
+	credentialsBucket    = []byte("credentials")
+	chunksBucket         = []byte("chunks")
+	suggestionRunsBucket = []byte("suggestion_runs")
+	syncStatesBucket     = []byte("sync_states")
+	generationJobsBucket = []byte("generation_jobs")
+	messageVectorsBucket = []byte("message_vectors")
+	revokedTokensBucket  = []byte("revoked_tokens")
+)
+
+// BoltStore is an embedded Store kept in a single bbolt file on local disk.
+// Records are stored as JSON; chat messages live in a sub-bucket per thread
+// keyed by insertion sequence so they come back in the order they were added,
+// and generation jobs and message embeddings in sub-buckets per thread keyed
+// by ID.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening bolt store at %s: %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{threadsBucket, messagesBucket, acceleratorsBucket, acceleratorVectors, ticketsBucket, authorizationsBucket, sessionsBucket, credentialsBucket, chunksBucket, suggestionRunsBucket, syncStatesBucket, generationJobsBucket, messageVectorsBucket, revokedTokensBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating bolt buckets: %v", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func putJSON(b *bolt.Bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), data)
+}
+
+// getJSON decodes the value stored under key into v and reports whether it existed.
+func getJSON(b *bolt.Bucket, key string, v interface{}) (bool, error) {
+	data := b.Get([]byte(key))
+	if data == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+func sequenceKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+func (s *BoltStore) CreateChatThread(thread models.ChatThread) (string, error) {
+	thread.ID = uuid.NewString()
+	thread.CreatedAt = time.Now()
+	thread.UpdatedAt = time.Now()
+	thread.Messages = nil
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.Bucket(messagesBucket).CreateBucket([]byte(thread.ID)); err != nil {
+			return err
+		}
+		return putJSON(tx.Bucket(threadsBucket), thread.ID, thread)
+	})
+	if err != nil {
+		log.Printf("Error creating chat thread: %v", err)
+		return "", err
+	}
+
+	log.Printf("Chat thread created successfully with ID: %s", thread.ID)
+	return thread.ID, nil
+}
+
+func (s *BoltStore) GetChatThread(threadID string) (*models.ChatThread, error) {
+	thread := &models.ChatThread{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		found, err := getJSON(tx.Bucket(threadsBucket), threadID, thread)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("chat thread not found")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := s.GetChatMessages(threadID)
+	if err != nil {
+		return nil, err
+	}
+	thread.Messages = NewChatTree(threadID, messages).Branch(thread.ActiveMessageID)
+
+	return thread, nil
+}
+
+func (s *BoltStore) UpdateChatThread(thread models.ChatThread) error {
+	thread.UpdatedAt = time.Now()
+	thread.Messages = nil
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(threadsBucket)
+		var stored models.ChatThread
+		found, err := getJSON(b, thread.ID, &stored)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("chat thread not found")
+		}
+		thread.ActiveMessageID = stored.ActiveMessageID
+		thread.Summary = stored.Summary
+		thread.SummaryMessageID = stored.SummaryMessageID
+		thread.MessageSequence = stored.MessageSequence
+		return putJSON(b, thread.ID, thread)
+	})
+}
+
+// setActiveMessage points the stored thread at messageID, if the thread
+// exists. A non-zero sequence records that the message was just added.
+func setActiveMessage(tx *bolt.Tx, threadID string, messageID string, sequence int64) error {
+	b := tx.Bucket(threadsBucket)
+	var thread models.ChatThread
+	found, err := getJSON(b, threadID, &thread)
+	if err != nil || !found {
+		return err
+	}
+	thread.ActiveMessageID = messageID
+	if sequence != 0 {
+		thread.MessageSequence = sequence
+	}
+	return putJSON(b, threadID, thread)
+}
+
+func (s *BoltStore) SetActiveChatMessage(threadID string, messageID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(threadsBucket).Get([]byte(threadID)) == nil {
+			return fmt.Errorf("chat thread not found")
+		}
+		return setActiveMessage(tx, threadID, messageID, 0)
+	})
+}
+
+func (s *BoltStore) SetChatSummary(threadID string, summary string, messageID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(threadsBucket)
+		var thread models.ChatThread
+		found, err := getJSON(b, threadID, &thread)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("chat thread not found")
+		}
+		thread.Summary = summary
+		thread.SummaryMessageID = messageID
+		return putJSON(b, threadID, thread)
+	})
+}
+
+func (s *BoltStore) DeleteChatThread(threadID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(threadsBucket)
+		if b.Get([]byte(threadID)) == nil {
+			return fmt.Errorf("chat thread not found")
+		}
+		if err := b.Delete([]byte(threadID)); err != nil {
+			return err
+		}
+
+		for _, bucket := range [][]byte{messagesBucket, generationJobsBucket, messageVectorsBucket} {
+			err := tx.Bucket(bucket).DeleteBucket([]byte(threadID))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) GetChatThreadsByInstanceID(instanceID string) ([]models.ChatThread, error) {
+	var threads []models.ChatThread
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(threadsBucket).ForEach(func(k, v []byte) error {
+			var thread models.ChatThread
+			if err := json.Unmarshal(v, &thread); err != nil {
+				return err
+			}
+			// userID = instanceID
+			if thread.UserID == instanceID {
+				threads = append(threads, thread)
+			}
+			return nil
+		})
+	})
+	return threads, err
+}
+
+func (s *BoltStore) AddChatMessage(threadID string, message models.ChatMessage) (string, error) {
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+	message.ID = uuid.NewString()
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(messagesBucket).CreateBucketIfNotExists([]byte(threadID))
+		if err != nil {
+			return err
+		}
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		message.Sequence = int64(seq)
+
+		data, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(sequenceKey(seq), data); err != nil {
+			return err
+		}
+		return setActiveMessage(tx, threadID, message.ID, message.Sequence)
+	})
+	if err != nil {
+		return "", err
+	}
+	return message.ID, nil
+}
+
+func (s *BoltStore) GetChatMessages(threadID string) ([]models.ChatMessage, error) {
+	var messages []models.ChatMessage
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(messagesBucket).Bucket([]byte(threadID))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var message models.ChatMessage
+			if err := json.Unmarshal(v, &message); err != nil {
+				return err
+			}
+			// Messages are keyed by the sequence, including those stored
+			// before it was recorded on them.
+			message.Sequence = int64(binary.BigEndian.Uint64(k))
+			messages = append(messages, message)
+			return nil
+		})
+	})
+	return messages, err
+}
+
+func (s *BoltStore) SaveChatMessageVectors(threadID string, vectors map[string][]float32) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(messageVectorsBucket).CreateBucketIfNotExists([]byte(threadID))
+		if err != nil {
+			return err
+		}
+		for messageID, vector := range vectors {
+			if err := putJSON(b, messageID, vector); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) GetChatMessageVectors(threadID string) (map[string][]float32, error) {
+	vectors := make(map[string][]float32)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(messageVectorsBucket).Bucket([]byte(threadID))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var vector []float32
+			if err := json.Unmarshal(v, &vector); err != nil {
+				return err
+			}
+			vectors[string(k)] = vector
+			return nil
+		})
+	})
+	return vectors, err
+}
+
+func (s *BoltStore) GetUnembeddedChatMessages(threadIDs []string) (map[string][]models.ChatMessage, error) {
+	unembedded := make(map[string][]models.ChatMessage)
+	for _, threadID := range threadIDs {
+		messages, err := s.GetChatMessages(threadID)
+		if err != nil {
+			return nil, err
+		}
+		vectors, err := s.GetChatMessageVectors(threadID)
+		if err != nil {
+			return nil, err
+		}
+		for _, message := range messages {
+			if _, ok := vectors[message.ID]; !ok {
+				unembedded[threadID] = append(unembedded[threadID], message)
+			}
+		}
+	}
+	return unembedded, nil
+}
+
+// SearchChatMessages scores every message of the threads; the embedded
+// store holds one process's chats, so a linear scan is fine.
+func (s *BoltStore) SearchChatMessages(query ChatMessageQuery) ([]ChatMessageMatch, error) {
+	var matches []ChatMessageMatch
+	for _, threadID := range query.ThreadIDs {
+		messages, err := s.GetChatMessages(threadID)
+		if err != nil {
+			return nil, err
+		}
+		vectors, err := s.GetChatMessageVectors(threadID)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, rankChatMessages(threadID, messages, vectors, query)...)
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if query.Limit > 0 && len(matches) > query.Limit {
+		matches = matches[:query.Limit]
+	}
+	return matches, nil
+}
+
+func (s *BoltStore) GetAcceleratorByID(acceleratorID string) (*models.Accelerator, error) {
+	accelerator := &models.Accelerator{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		found, err := getJSON(tx.Bucket(acceleratorsBucket), acceleratorID, accelerator)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrAcceleratorNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	accelerator.ID = acceleratorID
+	return accelerator, nil
+}
+
+func (s *BoltStore) GetAllAccelerators() ([]models.Accelerator, error) {
+	var accelerators []models.Accelerator
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(acceleratorsBucket).ForEach(func(k, v []byte) error {
+			var accelerator models.Accelerator
+			if err := json.Unmarshal(v, &accelerator); err != nil {
+				return err
+			}
+			accelerator.ID = string(k)
+			accelerators = append(accelerators, accelerator)
+			return nil
+		})
+	})
+	return accelerators, err
+}
+
+func (s *BoltStore) SaveAccelerator(accelerator models.Accelerator, vector []float32) error {
+	if accelerator.ID == "" {
+		return fmt.Errorf("accelerator ID is required")
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := putJSON(tx.Bucket(acceleratorsBucket), accelerator.ID, accelerator); err != nil {
+			return err
+		}
+		if vector == nil {
+			return tx.Bucket(acceleratorVectors).Delete([]byte(accelerator.ID))
+		}
+		return putJSON(tx.Bucket(acceleratorVectors), accelerator.ID, vector)
+	})
+}
+
+func (s *BoltStore) DeleteAccelerator(acceleratorID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(acceleratorsBucket)
+		if b.Get([]byte(acceleratorID)) == nil {
+			return ErrAcceleratorNotFound
+		}
+		if err := b.Delete([]byte(acceleratorID)); err != nil {
+			return err
+		}
+		return tx.Bucket(acceleratorVectors).Delete([]byte(acceleratorID))
+	})
+}
+
+// SearchAccelerators scores every accelerator in the category; the catalog
+// is small enough that a linear scan is fine.
+func (s *BoltStore) SearchAccelerators(query string, vector []float32, category string, limit int) ([]models.AcceleratorMatch, error) {
+	all, err := s.GetAllAccelerators()
+	if err != nil {
+		return nil, err
+	}
+
+	var accelerators []models.Accelerator
+	vectors := make(map[string][]float32)
+	err = s.db.View(func(tx *bolt.Tx) error {
+		for _, accelerator := range all {
+			if category != "" && !strings.EqualFold(accelerator.Category, category) {
+				continue
+			}
+			accelerators = append(accelerators, accelerator)
+
+			var v []float32
+			if _, err := getJSON(tx.Bucket(acceleratorVectors), accelerator.ID, &v); err != nil {
+				return err
+			}
+			vectors[accelerator.ID] = v
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rankAccelerators(accelerators, vectors, query, vector, limit), nil
+}
+
+func (s *BoltStore) RetrieveTickets(ids []string) ([]models.Ticket, error) {
+	tickets := []models.Ticket{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ticketsBucket)
+		for _, id := range ids {
+			var ticket models.Ticket
+			found, err := getJSON(b, id, &ticket)
+			if err != nil {
+				return err
+			}
+			if found {
+				tickets = append(tickets, ticket)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return []models.Ticket{}, err
+	}
+	return tickets, nil
+}
+
+func (s *BoltStore) StoreTickets(tickets []models.Ticket) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ticketsBucket)
+		for _, ticket := range tickets {
+			if ticket.ID == "" {
+				ticket.ID = uuid.NewString()
+			}
+			if err := putJSON(b, ticket.ID, ticket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) GetTicketsByInstanceID(instanceID string) ([]models.Ticket, error) {
+	tickets := []models.Ticket{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(ticketsBucket).ForEach(func(k, v []byte) error {
+			var ticket models.Ticket
+			if err := json.Unmarshal(v, &ticket); err != nil {
+				return err
+			}
+			if ticket.InstanceID == instanceID {
+				tickets = append(tickets, ticket)
+			}
+			return nil
+		})
+	})
+	return tickets, err
+}
+
+func (s *BoltStore) GetSyncState(instanceID string, table string) (*SyncState, error) {
+	var state *SyncState
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var candidate SyncState
+		found, err := getJSON(tx.Bucket(syncStatesBucket), syncStateID(instanceID, table), &candidate)
+		if found {
+			state = &candidate
+		}
+		return err
+	})
+	return state, err
+}
+
+func (s *BoltStore) SaveSyncState(state SyncState) error {
+	state.ID = syncStateID(state.InstanceID, state.Table)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(syncStatesBucket), state.ID, state)
+	})
+}
+
+func (s *BoltStore) FindAuthRecord(instanceID string, authHash string) (*AuthRecord, error) {
+	var record *AuthRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(authorizationsBucket).ForEach(func(k, v []byte) error {
+			var candidate AuthRecord
+			if err := json.Unmarshal(v, &candidate); err != nil {
+				return err
+			}
+			if record == nil && candidate.InstanceID == instanceID && candidate.AuthHash == authHash {
+				record = &candidate
+			}
+			return nil
+		})
+	})
+	return record, err
+}
+
+func (s *BoltStore) CreateAuthRecord(record AuthRecord) (string, error) {
+	record.ID = uuid.NewString()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(authorizationsBucket), record.ID, record)
+	})
+	if err != nil {
+		return "", err
+	}
+	return record.ID, nil
+}
+
+func (s *BoltStore) FindAuthRecordsByUser(instanceID string, userDigest string) ([]AuthRecord, error) {
+	var records []AuthRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(authorizationsBucket).ForEach(func(k, v []byte) error {
+			var candidate AuthRecord
+			if err := json.Unmarshal(v, &candidate); err != nil {
+				return err
+			}
+			if candidate.InstanceID == instanceID && candidate.UserDigest == userDigest {
+				records = append(records, candidate)
+			}
+			return nil
+		})
+	})
+	return records, err
+}
+
+func (s *BoltStore) UpdateAuthRecord(record AuthRecord) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(authorizationsBucket)
+		if b.Get([]byte(record.ID)) == nil {
+			return fmt.Errorf("auth record not found")
+		}
+		return putJSON(b, record.ID, record)
+	})
+}
+
+func (s *BoltStore) CreateSession(session SessionRecord) (string, error) {
+	session.ID = uuid.NewString()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(sessionsBucket), session.ID, session)
+	})
+	if err != nil {
+		return "", err
+	}
+	return session.ID, nil
+}
+
+func (s *BoltStore) FindSession(tokenHash string) (*SessionRecord, error) {
+	var session *SessionRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).ForEach(func(k, v []byte) error {
+			var candidate SessionRecord
+			if err := json.Unmarshal(v, &candidate); err != nil {
+				return err
+			}
+			if session == nil && candidate.TokenHash == tokenHash {
+				session = &candidate
+			}
+			return nil
+		})
+	})
+	return session, err
+}
+
+func (s *BoltStore) DeleteSession(sessionID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Delete([]byte(sessionID))
+	})
+}
+
+// Revoked access tokens are keyed by token ID, holding their expiry.
+func (s *BoltStore) RevokeAccessToken(tokenID string, expiresAt time.Time) error {
+	now := time.Now()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(revokedTokensBucket)
+
+		var expired [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var at time.Time
+			if err := json.Unmarshal(v, &at); err != nil {
+				return err
+			}
+			if at.Before(now) {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return putJSON(b, tokenID, expiresAt)
+	})
+}
+
+func (s *BoltStore) ListRevokedAccessTokens() (map[string]time.Time, error) {
+	now := time.Now()
+	revoked := make(map[string]time.Time)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(revokedTokensBucket).ForEach(func(k, v []byte) error {
+			var at time.Time
+			if err := json.Unmarshal(v, &at); err != nil {
+				return err
+			}
+			if at.After(now) {
+				revoked[string(k)] = at
+			}
+			return nil
+		})
+	})
+	return revoked, err
+}
+
+// Credentials are keyed by instance ID, so saving replaces the previous record.
+func (s *BoltStore) SaveCredential(record CredentialRecord) error {
+	record.ID = record.InstanceID
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(credentialsBucket), record.InstanceID, record)
+	})
+}
+
+func (s *BoltStore) FindCredential(instanceID string) (*CredentialRecord, error) {
+	var record *CredentialRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var candidate CredentialRecord
+		found, err := getJSON(tx.Bucket(credentialsBucket), instanceID, &candidate)
+		if found {
+			record = &candidate
+		}
+		return err
+	})
+	return record, err
+}
+
+func (s *BoltStore) ListCredentials() ([]CredentialRecord, error) {
+	var records []CredentialRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(credentialsBucket).ForEach(func(k, v []byte) error {
+			var record CredentialRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	return records, err
+}
+
+// Document chunks live in a sub-bucket per accelerator; search is a linear
+// scan, which is fine for the size of one documentation page.
+func (s *BoltStore) ReplaceDocumentChunks(acceleratorID string, chunks []models.DocumentChunk) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		parent := tx.Bucket(chunksBucket)
+		if err := parent.DeleteBucket([]byte(acceleratorID)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+
+		b, err := parent.CreateBucket([]byte(acceleratorID))
+		if err != nil {
+			return err
+		}
+
+		for _, chunk := range chunks {
+			chunk.ID = uuid.NewString()
+			chunk.AcceleratorID = acceleratorID
+
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			data, err := json.Marshal(chunk)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(sequenceKey(seq), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) documentChunks(acceleratorID string) ([]models.DocumentChunk, error) {
+	var chunks []models.DocumentChunk
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(chunksBucket).Bucket([]byte(acceleratorID))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var chunk models.DocumentChunk
+			if err := json.Unmarshal(v, &chunk); err != nil {
+				return err
+			}
+			chunks = append(chunks, chunk)
+			return nil
+		})
+	})
+	return chunks, err
+}
+
+func (s *BoltStore) SearchDocumentChunks(acceleratorID string, vector []float32, k int) ([]models.DocumentChunk, error) {
+	chunks, err := s.documentChunks(acceleratorID)
+	if err != nil {
+		return nil, err
+	}
+	nearest := nearestChunks(chunks, vector, k)
+	for i := range nearest {
+		nearest[i].Vector = nil
+	}
+	return nearest, nil
+}
+
+func (s *BoltStore) CountDocumentChunks(acceleratorID string) (int, error) {
+	chunks, err := s.documentChunks(acceleratorID)
+	return len(chunks), err
+}
+
+func (s *BoltStore) CreateSuggestionRun(run models.SuggestionRun) (string, error) {
+	run.ID = uuid.NewString()
+	if run.CreatedAt.IsZero() {
+		run.CreatedAt = time.Now()
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(suggestionRunsBucket), run.ID, run)
+	})
+	if err != nil {
+		return "", err
+	}
+	return run.ID, nil
+}
+
+func (s *BoltStore) GetSuggestionRun(runID string) (*models.SuggestionRun, error) {
+	run := &models.SuggestionRun{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		found, err := getJSON(tx.Bucket(suggestionRunsBucket), runID, run)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("suggestion run not found")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+func (s *BoltStore) GetSuggestionRunsByInstanceID(instanceID string, offset int, limit int) ([]models.SuggestionRun, error) {
+	runs := []models.SuggestionRun{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(suggestionRunsBucket).ForEach(func(k, v []byte) error {
+			var run models.SuggestionRun
+			if err := json.Unmarshal(v, &run); err != nil {
+				return err
+			}
+			if run.InstanceID == instanceID {
+				runs = append(runs, run)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortRunsNewestFirst(runs)
+	runs = runs[min(offset, len(runs)):]
+	if limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs, nil
+}
+
+func (s *BoltStore) SaveGenerationJob(job *models.GenerationJob) error {
+	now := time.Now()
+	saved := *job
+	if saved.ID == "" {
+		saved.ID = uuid.NewString()
+		saved.CreatedAt = now
+	}
+	saved.UpdatedAt = now
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(generationJobsBucket).CreateBucketIfNotExists([]byte(saved.ThreadID))
+		if err != nil {
+			return err
+		}
+		return putJSON(b, saved.ID, saved)
+	})
+	if err != nil {
+		return err
+	}
+	*job = saved
+	return nil
+}
+
+// ClaimGenerationJob reads and updates the thread's jobs in one transaction.
+func (s *BoltStore) ClaimGenerationJob(threadID string, jobID string, owner string, lease time.Duration) (*models.GenerationJob, error) {
+	var claimed *models.GenerationJob
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(generationJobsBucket).Bucket([]byte(threadID))
+		if b == nil {
+			return nil
+		}
+
+		var jobs []models.GenerationJob
+		err := b.ForEach(func(k, v []byte) error {
+			var job models.GenerationJob
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		sortJobsOldestFirst(jobs)
+
+		now := time.Now()
+		next := nextGenerationJob(jobs, jobID, owner, lease, now)
+		if next < 0 {
+			return nil
+		}
+		job := jobs[next]
+		startJob(&job, owner)
+		job.UpdatedAt = now
+		if err := putJSON(b, job.ID, job); err != nil {
+			return err
+		}
+		claimed = &job
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+func (s *BoltStore) GetGenerationJobs(threadID string, statuses ...string) ([]models.GenerationJob, error) {
+	jobs := []models.GenerationJob{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(generationJobsBucket).Bucket([]byte(threadID))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var job models.GenerationJob
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			if !hasStatus(job, statuses) {
+				return nil
+			}
+			jobs = append(jobs, job)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortJobsOldestFirst(jobs)
+	return jobs, nil
+}
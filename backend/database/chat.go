@@ -2,14 +2,14 @@ package database
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
-	"os"
 	"time"
 
+	"github.com/davidulloa/mimir/llm"
 	"github.com/davidulloa/mimir/models"
-	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/option"
+	"github.com/google/uuid"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate/fault"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
@@ -20,12 +20,8 @@ const (
 	ChatMessageClass = "ChatMessage"
 )
 
-func CreateChatThread(thread models.ChatThread) (string, error) {
-	client, err := GetWeaviateClient()
-	if err != nil {
-		log.Printf("Error getting Weaviate client: %v", err)
-		return "", err
-	}
+func (s *WeaviateStore) CreateChatThread(thread models.ChatThread) (string, error) {
+	client := s.client
 
 	thread.CreatedAt = time.Now()
 	thread.UpdatedAt = time.Now()
@@ -55,12 +51,8 @@ func CreateChatThread(thread models.ChatThread) (string, error) {
 	return string(threadID), nil
 }
 
-func GetChatThread(threadID string) (*models.ChatThread, error) {
-	client, err := GetWeaviateClient()
-	if err != nil {
-		log.Printf("Error getting Weaviate client: %v", err)
-		return nil, err
-	}
+func (s *WeaviateStore) GetChatThread(threadID string) (*models.ChatThread, error) {
+	client := s.client
 
 	result, err := client.Data().ObjectsGetter().
 		WithClassName(ChatThreadClass).
@@ -104,29 +96,34 @@ func GetChatThread(threadID string) (*models.ChatThread, error) {
 	thread.IsActive = properties["isActive"].(bool)
 	thread.Metadata = properties["metadata"].(string)
 	thread.AcceleratorId = properties["acceleratorID"].(string)
+	thread.ActiveMessageID, _ = properties["activeMessageID"].(string)
+	thread.Summary, _ = properties["summary"].(string)
+	thread.SummaryMessageID, _ = properties["summaryMessageID"].(string)
+	if sequence, ok := properties["messageSequence"].(float64); ok {
+		thread.MessageSequence = int64(sequence)
+	}
 
-	thread.Messages, err = GetChatMessages(threadID)
+	messages, err := s.GetChatMessages(threadID)
 	if err != nil {
 		log.Printf("Error retrieving messages for chat thread ID %s: %v", threadID, err)
 		return nil, err
 	}
+	thread.Messages = NewChatTree(threadID, messages).Branch(thread.ActiveMessageID)
 
 	log.Printf("Retrieved chat thread with ID: %s", threadID)
 	return thread, nil
 }
 
-func UpdateChatThread(thread models.ChatThread) error {
-	client, err := GetWeaviateClient()
-	if err != nil {
-		log.Printf("Error getting Weaviate client: %v", err)
-		return err
-	}
+func (s *WeaviateStore) UpdateChatThread(thread models.ChatThread) error {
+	client := s.client
 
 	thread.UpdatedAt = time.Now()
 
 	log.Printf("Updating chat thread with ID: %s", thread.ID)
 
-	err = client.Data().Updater().
+	// Merging leaves activeMessageID and the summary alone.
+	err := client.Data().Updater().
+		WithMerge().
 		WithClassName(ChatThreadClass).
 		WithID(thread.ID).
 		WithProperties(map[string]interface{}{
@@ -168,11 +165,11 @@ func EditChatThreadTitle(threadID string) error {
 }
 
 func GenerateTitle(messages []models.ChatMessage) string {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-
-	client := openai.NewClient(
-		option.WithAPIKey(apiKey),
-	)
+	provider, err := llm.Default()
+	if err != nil {
+		log.Printf("Error getting LLM provider: %v", err)
+		return "Unnamed Chat"
+	}
 
 	prompt := "Based on the following chat messages, generate a short title (5 words maximum) that summarizes the conversation:"
 
@@ -183,34 +180,25 @@ func GenerateTitle(messages []models.ChatMessage) string {
 
 	content := fmt.Sprintf("%s\n%s", prompt, conversationContent)
 
-	chat, err := client.Chat.Completions.New(context.TODO(), openai.ChatCompletionNewParams{
-		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
-			openai.SystemMessage("You are a helpful assistant."),
-			openai.UserMessage(content),
-		}),
-		Model: openai.F(openai.ChatModelGPT4o2024_08_06),
+	title, err := provider.Complete(context.TODO(), llm.Request{
+		Messages: []llm.Message{
+			llm.System("You are a helpful assistant."),
+			llm.User(content),
+		},
 	})
 
 	if err != nil {
 		return "Unnamed Chat"
 	}
 
-	if len(chat.Choices) == 0 {
-		return "Unnamed Chat"
-	}
-
-	return chat.Choices[0].Message.Content
+	return title
 }
 
-func DeleteChatThread(threadID string) error {
-	client, err := GetWeaviateClient()
-	if err != nil {
-		log.Printf("Error getting Weaviate client: %v", err)
-		return err
-	}
+func (s *WeaviateStore) DeleteChatThread(threadID string) error {
+	client := s.client
 
 	log.Printf("Deleting chat thread with ID: %s", threadID)
-	err = client.Data().Deleter().
+	err := client.Data().Deleter().
 		WithClassName(ChatThreadClass).
 		WithID(threadID).
 		Do(context.Background())
@@ -230,52 +218,147 @@ func DeleteChatThread(threadID string) error {
 	return nil
 }
 
-func AddChatMessage(threadID string, message models.ChatMessage) error {
-	client, err := GetWeaviateClient()
+// chatMessageID is the object storing the thread's message with sequence.
+func chatMessageID(threadID string, sequence int64) string {
+	return uuid.NewSHA1(ticketNamespace, []byte(fmt.Sprintf("message/%s/%d", threadID, sequence))).String()
+}
+
+// lastMessageSequence returns the thread's MessageSequence. Threads from
+// before it was kept continue from their latest message.
+func (s *WeaviateStore) lastMessageSequence(threadID string) (int64, error) {
+	result, err := s.client.Data().ObjectsGetter().
+		WithClassName(ChatThreadClass).
+		WithID(threadID).
+		Do(context.Background())
 	if err != nil {
-		log.Printf("Error getting Weaviate client: %v", err)
-		return err
+		return 0, err
 	}
+	if len(result) > 0 {
+		properties, _ := result[0].Properties.(map[string]interface{})
+		if sequence, ok := properties["messageSequence"].(float64); ok && sequence > 0 {
+			return int64(sequence), nil
+		}
+	}
+
+	messages, err := s.GetChatMessages(threadID)
+	if err != nil {
+		return 0, err
+	}
+	var last int64
+	for _, message := range messages {
+		if message.Sequence > last {
+			last = message.Sequence
+		}
+	}
+	return last, nil
+}
+
+// AddChatMessage numbers the message after the thread's MessageSequence.
+// Weaviate can't increment it atomically, so the message is stored under an
+// ID made from the thread and sequence: creating it fails if a concurrent
+// message took the sequence, and the next one is tried. MessageSequence only
+// saves looking for a free sequence from the start.
+func (s *WeaviateStore) AddChatMessage(threadID string, message models.ChatMessage) (string, error) {
+	client := s.client
+	ctx := context.Background()
 
 	if message.Timestamp.IsZero() {
 		message.Timestamp = time.Now()
 		log.Printf("Setting current timestamp for message: %s", message.Content)
 	}
 
-	response, err := client.Data().Creator().
-		WithClassName(ChatMessageClass).
-		WithProperties(map[string]interface{}{
-			"threadID":  threadID,
-			"role":      message.Role,
-			"content":   message.Content,
-			"timestamp": message.Timestamp,
-		}).
-		Do(context.Background())
+	citations, err := json.Marshal(message.Citations)
+	if err != nil {
+		return "", err
+	}
 
+	sequence, err := s.lastMessageSequence(threadID)
 	if err != nil {
-		log.Printf("Error adding chat message to thread ID %s: %v", threadID, err)
-		return err
+		log.Printf("Error reading message sequence of thread ID %s: %v", threadID, err)
+		return "", err
 	}
 
-	messageID := response.Object.ID
+	var messageID string
+	for {
+		sequence++
+		messageID = chatMessageID(threadID, sequence)
+		_, err = client.Data().Creator().
+			WithClassName(ChatMessageClass).
+			WithID(messageID).
+			WithProperties(map[string]interface{}{
+				"threadID":  threadID,
+				"role":      message.Role,
+				"content":   message.Content,
+				"timestamp": message.Timestamp,
+				"citations": string(citations),
+				"parentID":  message.ParentID,
+				"sequence":  sequence,
+			}).
+			Do(ctx)
+		if err == nil {
+			break
+		}
+
+		taken, checkErr := client.Data().Checker().WithClassName(ChatMessageClass).WithID(messageID).Do(ctx)
+		if checkErr != nil || !taken {
+			log.Printf("Error adding chat message to thread ID %s: %v", threadID, err)
+			return "", err
+		}
+	}
+
+	err = client.Data().Updater().
+		WithMerge().
+		WithClassName(ChatThreadClass).
+		WithID(threadID).
+		WithProperties(map[string]interface{}{
+			"activeMessageID": messageID,
+			"messageSequence": sequence,
+		}).
+		Do(ctx)
+	if err != nil {
+		log.Printf("Error making message %s active in thread ID %s: %v", messageID, threadID, err)
+	}
 
 	log.Printf("Chat message added successfully to thread ID: %s with message ID: %s", threadID, messageID)
 
-	return nil
+	return messageID, nil
 }
 
-func GetChatMessages(threadID string) ([]models.ChatMessage, error) {
-	client, err := GetWeaviateClient()
-	if err != nil {
-		log.Printf("Error getting Weaviate client: %v", err)
-		return nil, err
+func (s *WeaviateStore) SetActiveChatMessage(threadID string, messageID string) error {
+	err := s.client.Data().Updater().
+		WithMerge().
+		WithClassName(ChatThreadClass).
+		WithID(threadID).
+		WithProperties(map[string]interface{}{
+			"activeMessageID": messageID,
+		}).
+		Do(context.Background())
+
+	if clientErr, ok := err.(*fault.WeaviateClientError); ok && clientErr.StatusCode == 404 {
+		return fmt.Errorf("chat thread not found")
 	}
+	return err
+}
 
-	fields := []string{"role", "content", "timestamp", "_additional{id}"}
-	graphqlFields := make([]graphql.Field, len(fields))
-	for i, field := range fields {
-		graphqlFields[i] = graphql.Field{Name: field}
+func (s *WeaviateStore) SetChatSummary(threadID string, summary string, messageID string) error {
+	err := s.client.Data().Updater().
+		WithMerge().
+		WithClassName(ChatThreadClass).
+		WithID(threadID).
+		WithProperties(map[string]interface{}{
+			"summary":          summary,
+			"summaryMessageID": messageID,
+		}).
+		Do(context.Background())
+
+	if clientErr, ok := err.(*fault.WeaviateClientError); ok && clientErr.StatusCode == 404 {
+		return fmt.Errorf("chat thread not found")
 	}
+	return err
+}
+
+func (s *WeaviateStore) GetChatMessages(threadID string) ([]models.ChatMessage, error) {
+	fields := []string{"role", "content", "timestamp", "citations", "parentID", "sequence", "_additional{id}"}
 
 	whereFilter := filters.Where().
 		WithPath([]string{"threadID"}).
@@ -283,44 +366,16 @@ func GetChatMessages(threadID string) ([]models.ChatMessage, error) {
 		WithValueString(threadID)
 
 	log.Printf("Fetching chat messages for thread ID: %s", threadID)
-	result, err := client.GraphQL().Get().
-		WithClassName(ChatMessageClass).
-		WithFields(graphqlFields...).
-		WithWhere(whereFilter).
-		Do(context.Background())
-
+	chatMessages, err := s.getAll(ChatMessageClass, fields, whereFilter,
+		graphql.Sort{Path: []string{"sequence"}, Order: graphql.Asc},
+		graphql.Sort{Path: []string{"_id"}, Order: graphql.Asc})
 	if err != nil {
 		log.Printf("Error retrieving chat messages for thread ID %s: %v", threadID, err)
 		return nil, err
 	}
 
-	if result.Errors != nil {
-		for _, err := range result.Errors {
-			log.Printf("GraphQL error: %v", err)
-		}
-		return nil, fmt.Errorf("graphQL errors: %v", result.Errors)
-	}
-
 	var messages []models.ChatMessage
-	chatMessages, ok := result.Data["Get"].(map[string]interface{})[ChatMessageClass]
-	if !ok {
-		log.Printf("Unexpected data structure: %v", result.Data)
-		return nil, fmt.Errorf("unexpected data structure: %v", result.Data)
-	}
-
-	chatMessagesSlice, ok := chatMessages.([]interface{})
-	if !ok {
-		log.Printf("Expected slice of messages but got: %v", chatMessages)
-		return nil, fmt.Errorf("expected slice of messages but got: %v", chatMessages)
-	}
-
-	for _, obj := range chatMessagesSlice {
-		msg, ok := obj.(map[string]interface{})
-		if !ok {
-			log.Printf("Expected message object but got: %v", obj)
-			return nil, fmt.Errorf("expected message object but got: %v", obj)
-		}
-
+	for _, msg := range chatMessages {
 		timestampStr, exists := msg["timestamp"].(string)
 		if !exists || timestampStr == "" {
 			log.Printf("Timestamp field is missing or empty for message ID: %v", msg["id"])
@@ -333,24 +388,34 @@ func GetChatMessages(threadID string) ([]models.ChatMessage, error) {
 			return nil, fmt.Errorf("error parsing timestamp for message ID %v: %v", msg["id"], err)
 		}
 
+		var citations []models.Citation
+		if raw, ok := msg["citations"].(string); ok && raw != "" {
+			if err := json.Unmarshal([]byte(raw), &citations); err != nil {
+				log.Printf("Error parsing citations for message ID %v: %v", msg["id"], err)
+			}
+		}
+
+		parentID, _ := msg["parentID"].(string)
+		sequence, _ := msg["sequence"].(float64)
+
 		messages = append(messages, models.ChatMessage{
 			ID:        msg["_additional"].(map[string]interface{})["id"].(string),
 			Role:      msg["role"].(string),
 			Content:   msg["content"].(string),
 			Timestamp: timestamp,
+			Citations: citations,
+			ParentID:  parentID,
+			Sequence:  int64(sequence),
 		})
 	}
+	SortChatMessages(messages)
 
 	log.Printf("Retrieved %d chat messages for thread ID: %s", len(messages), threadID)
 	return messages, nil
 }
 
-func GetChatThreadsByInstanceID(instanceID string) ([]models.ChatThread, error) {
-	client, err := GetWeaviateClient()
-	if err != nil {
-		log.Printf("Error getting Weaviate client: %v", err)
-		return nil, err
-	}
+func (s *WeaviateStore) GetChatThreadsByInstanceID(instanceID string) ([]models.ChatThread, error) {
+	client := s.client
 
 	fields := []string{"userID", "title", "createdAt", "updatedAt", "isActive", "metadata", "acceleratorID", "_additional{id}"}
 	graphqlFields := make([]graphql.Field, len(fields))
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"sort"
+
+	"github.com/davidulloa/mimir/models"
+)
+
+// SortChatMessages puts messages in the order they were added, by Sequence
+// and then Timestamp.
+func SortChatMessages(messages []models.ChatMessage) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		if messages[i].Sequence != messages[j].Sequence {
+			return messages[i].Sequence < messages[j].Sequence
+		}
+		return messages[i].Timestamp.Before(messages[j].Timestamp)
+	})
+}
+
+// ChatTree indexes a thread's messages by their parent links. Editing or
+// regenerating a message adds a sibling, so a thread is a tree and what it
+// shows is one branch of it, from the first message down to a leaf.
+type ChatTree struct {
+	threadID string
+	messages []models.ChatMessage
+	index    map[string]int
+	children map[string][]int
+}
+
+// NewChatTree builds the tree of a thread's messages, in any order. Messages
+// without a ParentID get the one they implicitly had: the message added
+// before them, or the thread for the first.
+func NewChatTree(threadID string, messages []models.ChatMessage) *ChatTree {
+	sorted := append([]models.ChatMessage(nil), messages...)
+	SortChatMessages(sorted)
+
+	t := &ChatTree{
+		threadID: threadID,
+		messages: sorted,
+		index:    make(map[string]int, len(sorted)),
+		children: make(map[string][]int),
+	}
+	for i := range sorted {
+		if sorted[i].ParentID == "" {
+			sorted[i].ParentID = threadID
+			if i > 0 {
+				sorted[i].ParentID = sorted[i-1].ID
+			}
+		}
+		t.index[sorted[i].ID] = i
+		t.children[sorted[i].ParentID] = append(t.children[sorted[i].ParentID], i)
+	}
+	return t
+}
+
+// Message returns the message with id.
+func (t *ChatTree) Message(id string) (models.ChatMessage, bool) {
+	i, ok := t.index[id]
+	if !ok {
+		return models.ChatMessage{}, false
+	}
+	return t.messages[i], true
+}
+
+// Leaf returns the most recently added message, or "" for an empty thread.
+func (t *ChatTree) Leaf() string {
+	if len(t.messages) == 0 {
+		return ""
+	}
+	return t.messages[len(t.messages)-1].ID
+}
+
+// Branch returns the messages from the first down to leafID. An unknown or
+// empty leafID means Leaf, and the thread's ID an empty branch.
+func (t *ChatTree) Branch(leafID string) []models.ChatMessage {
+	if leafID == t.threadID {
+		return nil
+	}
+	if _, ok := t.index[leafID]; !ok {
+		leafID = t.Leaf()
+	}
+
+	var branch []models.ChatMessage
+	for id := leafID; id != t.threadID; {
+		i, ok := t.index[id]
+		if !ok || len(branch) > len(t.messages) {
+			// A dangling or cyclic parent link ends the branch.
+			break
+		}
+		branch = append(branch, t.messages[i])
+		id = t.messages[i].ParentID
+	}
+
+	for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+		branch[i], branch[j] = branch[j], branch[i]
+	}
+	return branch
+}
+
+// Siblings returns the messages sharing id's parent, id included, oldest
+// first.
+func (t *ChatTree) Siblings(id string) []models.ChatMessage {
+	i, ok := t.index[id]
+	if !ok {
+		return nil
+	}
+
+	var siblings []models.ChatMessage
+	for _, j := range t.children[t.messages[i].ParentID] {
+		siblings = append(siblings, t.messages[j])
+	}
+	return siblings
+}
+
+// LatestLeaf follows the newest child down from id and returns the message
+// it ends at, which is where switching to id's branch lands.
+func (t *ChatTree) LatestLeaf(id string) string {
+	for depth := 0; depth <= len(t.messages); depth++ {
+		children := t.children[id]
+		if len(children) == 0 {
+			break
+		}
+		id = t.messages[children[len(children)-1]].ID
+	}
+	return id
+}
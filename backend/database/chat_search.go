@@ -0,0 +1,559 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/davidulloa/mimir/llm"
+	"github.com/davidulloa/mimir/models"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
+)
+
+// Chat history search modes.
+const (
+	SearchKeyword  = "keyword"
+	SearchSemantic = "semantic"
+	SearchHybrid   = "hybrid"
+)
+
+const (
+	// embedBatchSize caps the messages embedded per request.
+	embedBatchSize = 256
+	// maxEmbedRunes keeps long replies within the embedding model's input
+	// limit; their opening is enough to match on.
+	maxEmbedRunes = 8000
+
+	// maxChatSearchResults caps the results of a chat history search.
+	maxChatSearchResults = 100
+
+	// snippetRunes is the length of a snippet, and snippetLead how much of
+	// it comes before the first match.
+	snippetRunes = 200
+	snippetLead  = 60
+)
+
+// ChatSearch is a search over an instance's chat history.
+type ChatSearch struct {
+	Query string
+	// Mode is SearchKeyword, SearchSemantic or SearchHybrid. Empty means
+	// SearchHybrid.
+	Mode string
+	// AcceleratorID restricts the search to one accelerator's threads.
+	AcceleratorID string
+	// From and To bound when messages were sent, To exclusive. Zero times
+	// leave that side open.
+	From time.Time
+	To   time.Time
+	// Limit caps the results, at most maxChatSearchResults; 0 means that
+	// many.
+	Limit int
+}
+
+// ChatMessageQuery is what SearchChatMessages ranks messages against.
+type ChatMessageQuery struct {
+	ThreadIDs []string
+	Query     string
+	// Vector is the query's embedding. It is only needed when Alpha > 0.
+	Vector []float32
+	// Alpha weighs similarity to Vector against keyword matches on Query:
+	// 0 ranks by keyword alone, 1 by similarity alone.
+	Alpha float64
+	// From and To bound when messages were sent, To exclusive. Zero times
+	// leave that side open.
+	From  time.Time
+	To    time.Time
+	Limit int
+}
+
+// ChatMessageMatch is a message SearchChatMessages found, and its score.
+type ChatMessageMatch struct {
+	ThreadID string
+	Message  models.ChatMessage
+	Score    float64
+}
+
+// SearchChatHistory ranks the instance's messages, on every branch, and
+// thread titles against the search, best first. Keyword mode scores how well
+// a text matches the query's terms, semantic mode the similarity of its
+// embedding to the query's, and hybrid mode blends both by HybridAlpha like
+// accelerator search. The store ranks the messages; titles are too short to
+// embed usefully, so they are matched by keyword only and left out of
+// semantic searches.
+//
+// Messages are embedded the first time a search needs them, and the
+// embeddings are kept for later searches.
+func SearchChatHistory(ctx context.Context, instanceID string, search ChatSearch) ([]models.ChatSearchResult, error) {
+	var alpha float64
+	switch search.Mode {
+	case SearchKeyword:
+		alpha = 0
+	case SearchSemantic:
+		alpha = 1
+	case "", SearchHybrid:
+		alpha = HybridAlpha
+	default:
+		return nil, fmt.Errorf("unknown search mode %q", search.Mode)
+	}
+	limit := search.Limit
+	if limit <= 0 || limit > maxChatSearchResults {
+		limit = maxChatSearchResults
+	}
+
+	s, err := GetStore()
+	if err != nil {
+		return nil, err
+	}
+
+	threads, err := s.GetChatThreadsByInstanceID(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := strings.FieldsFunc(strings.ToLower(search.Query), isNotWordRune)
+	inRange := func(t time.Time) bool {
+		return (search.From.IsZero() || !t.Before(search.From)) && (search.To.IsZero() || t.Before(search.To))
+	}
+
+	var results []models.ChatSearchResult
+	var threadIDs []string
+	searched := make(map[string]models.ChatThread)
+	for _, thread := range threads {
+		if search.AcceleratorID != "" && thread.AcceleratorId != search.AcceleratorID {
+			continue
+		}
+		threadIDs = append(threadIDs, thread.ID)
+		searched[thread.ID] = thread
+
+		if keyword := keywordScore(terms, thread.Title); alpha < 1 && keyword > 0 && inRange(thread.UpdatedAt) {
+			results = append(results, models.ChatSearchResult{
+				ThreadID:      thread.ID,
+				ThreadTitle:   thread.Title,
+				AcceleratorID: thread.AcceleratorId,
+				Timestamp:     thread.UpdatedAt,
+				Snippet:       highlight(thread.Title, terms),
+				Score:         (1 - alpha) * keyword,
+			})
+		}
+	}
+	if len(threadIDs) == 0 {
+		return nil, nil
+	}
+
+	query := ChatMessageQuery{
+		ThreadIDs: threadIDs,
+		Query:     search.Query,
+		Alpha:     alpha,
+		From:      search.From,
+		To:        search.To,
+		Limit:     limit,
+	}
+	if alpha > 0 {
+		provider, err := llm.Default()
+		if err != nil {
+			return nil, err
+		}
+		if err := indexChatMessages(ctx, s, provider, threadIDs); err != nil {
+			return nil, err
+		}
+		vectors, err := provider.Embed(ctx, []string{search.Query})
+		if err != nil {
+			return nil, fmt.Errorf("error embedding query: %v", err)
+		}
+		if len(vectors) != 1 {
+			return nil, fmt.Errorf("expected 1 embedding, got %d", len(vectors))
+		}
+		query.Vector = vectors[0]
+	}
+
+	matches, err := s.SearchChatMessages(query)
+	if err != nil {
+		return nil, err
+	}
+	for _, match := range matches {
+		thread := searched[match.ThreadID]
+		results = append(results, models.ChatSearchResult{
+			ThreadID:      thread.ID,
+			ThreadTitle:   thread.Title,
+			AcceleratorID: thread.AcceleratorId,
+			MessageID:     match.Message.ID,
+			Role:          match.Message.Role,
+			Timestamp:     match.Message.Timestamp,
+			Snippet:       highlight(match.Message.Content, terms),
+			Score:         match.Score,
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Timestamp.After(results[j].Timestamp)
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// indexChatMessages embeds and stores the embeddings of the threads'
+// messages that have none yet.
+func indexChatMessages(ctx context.Context, s Store, provider llm.Provider, threadIDs []string) error {
+	unembedded, err := s.GetUnembeddedChatMessages(threadIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, threadID := range threadIDs {
+		var missing []models.ChatMessage
+		for _, message := range unembedded[threadID] {
+			if strings.TrimSpace(message.Content) != "" {
+				missing = append(missing, message)
+			}
+		}
+
+		for len(missing) > 0 {
+			batch := missing
+			if len(batch) > embedBatchSize {
+				batch = batch[:embedBatchSize]
+			}
+			missing = missing[len(batch):]
+
+			texts := make([]string, len(batch))
+			for i, message := range batch {
+				texts[i] = message.Content
+				if runes := []rune(message.Content); len(runes) > maxEmbedRunes {
+					texts[i] = string(runes[:maxEmbedRunes])
+				}
+			}
+			embedded, err := provider.Embed(ctx, texts)
+			if err != nil {
+				return fmt.Errorf("error embedding chat messages: %v", err)
+			}
+			if len(embedded) != len(batch) {
+				return fmt.Errorf("expected %d embeddings, got %d", len(batch), len(embedded))
+			}
+
+			added := make(map[string][]float32, len(batch))
+			for i, message := range batch {
+				added[message.ID] = embedded[i]
+			}
+			if err := s.SaveChatMessageVectors(threadID, added); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rankChatMessages scores messages the way Weaviate's hybrid search does,
+// blending keyword and vector scores by query.Alpha. Messages that match
+// neither way, or only the way Alpha leaves out, are dropped.
+func rankChatMessages(threadID string, messages []models.ChatMessage, vectors map[string][]float32, query ChatMessageQuery) []ChatMessageMatch {
+	terms := strings.FieldsFunc(strings.ToLower(query.Query), isNotWordRune)
+
+	var matches []ChatMessageMatch
+	for _, message := range messages {
+		if !query.From.IsZero() && message.Timestamp.Before(query.From) ||
+			!query.To.IsZero() && !message.Timestamp.Before(query.To) {
+			continue
+		}
+
+		keyword := keywordScore(terms, message.Content)
+		var similarity float64
+		if query.Alpha > 0 {
+			similarity = cosineSimilarity(vectors[message.ID], query.Vector)
+		}
+		if !(query.Alpha < 1 && keyword > 0 || query.Alpha > 0 && similarity > 0) {
+			continue
+		}
+		matches = append(matches, ChatMessageMatch{
+			ThreadID: threadID,
+			Message:  message,
+			Score:    query.Alpha*similarity + (1-query.Alpha)*keyword,
+		})
+	}
+	return matches
+}
+
+// highlight returns an HTML-escaped excerpt of text around the first of
+// terms it contains, with every term wrapped in <mark> tags. Text without
+// them is excerpted from the start.
+func highlight(text string, terms []string) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+
+	wanted := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		wanted[term] = true
+	}
+
+	// Find the words matching a term, as [start, end) rune offsets.
+	var matches [][2]int
+	for i := 0; i < len(runes); {
+		if isNotWordRune(unicode.ToLower(runes[i])) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(runes) && !isNotWordRune(unicode.ToLower(runes[i])) {
+			i++
+		}
+		if wanted[strings.ToLower(string(runes[start:i]))] {
+			matches = append(matches, [2]int{start, i})
+		}
+	}
+
+	start := 0
+	if len(matches) > 0 && matches[0][0] > snippetLead {
+		start = matches[0][0] - snippetLead
+		// Start at a word.
+		for i := start; i < matches[0][0]; i++ {
+			if runes[i] == ' ' {
+				start = i + 1
+				break
+			}
+		}
+	}
+	end := len(runes)
+	if end-start > snippetRunes {
+		end = start + snippetRunes
+		// End at a word.
+		for i := end; i > start; i-- {
+			if runes[i] == ' ' {
+				end = i
+				break
+			}
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	at := start
+	for _, match := range matches {
+		if match[0] < start || match[1] > end {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[at:match[0]])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(runes[match[0]:match[1]])))
+		b.WriteString("</mark>")
+		at = match[1]
+	}
+	b.WriteString(html.EscapeString(string(runes[at:end])))
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+func SaveChatMessageVectors(threadID string, vectors map[string][]float32) error {
+	s, err := GetStore()
+	if err != nil {
+		return err
+	}
+	return s.SaveChatMessageVectors(threadID, vectors)
+}
+
+func GetChatMessageVectors(threadID string) (map[string][]float32, error) {
+	s, err := GetStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetChatMessageVectors(threadID)
+}
+
+// SaveChatMessageVectors sets the vectors of the thread's message objects,
+// marking them embedded.
+func (s *WeaviateStore) SaveChatMessageVectors(threadID string, vectors map[string][]float32) error {
+	for messageID, vector := range vectors {
+		err := s.client.Data().Updater().
+			WithMerge().
+			WithClassName(ChatMessageClass).
+			WithID(messageID).
+			WithProperties(map[string]interface{}{
+				"threadID": threadID,
+				"embedded": true,
+			}).
+			WithVector(vector).
+			Do(context.Background())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *WeaviateStore) GetChatMessageVectors(threadID string) (map[string][]float32, error) {
+	where := filters.Where().WithPath([]string{"threadID"}).WithOperator(filters.Equal).WithValueString(threadID)
+	objects, err := s.getAll(ChatMessageClass, []string{"_additional { id vector }"}, where,
+		graphql.Sort{Path: []string{"_id"}, Order: graphql.Asc})
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := make(map[string][]float32)
+	for _, obj := range objects {
+		additional, ok := obj["_additional"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, _ := additional["id"].(string)
+		values, _ := additional["vector"].([]interface{})
+		// Messages not embedded yet have no vector.
+		if id == "" || len(values) == 0 {
+			continue
+		}
+
+		vector := make([]float32, len(values))
+		for i, value := range values {
+			f, _ := value.(float64)
+			vector[i] = float32(f)
+		}
+		vectors[id] = vector
+	}
+	return vectors, nil
+}
+
+// chatMessageSearchFields are read for every message a search returns.
+var chatMessageSearchFields = []string{"threadID", "role", "content", "timestamp"}
+
+// chatMessageMatch parses a message object read with chatMessageSearchFields.
+func chatMessageMatch(obj map[string]interface{}) ChatMessageMatch {
+	var match ChatMessageMatch
+	match.ThreadID, _ = obj["threadID"].(string)
+	match.Message.Role, _ = obj["role"].(string)
+	match.Message.Content, _ = obj["content"].(string)
+	if timestamp, ok := obj["timestamp"].(string); ok {
+		match.Message.Timestamp, _ = time.Parse(time.RFC3339, timestamp)
+	}
+	if additional, ok := obj["_additional"].(map[string]interface{}); ok {
+		match.Message.ID, _ = additional["id"].(string)
+	}
+	return match
+}
+
+// inThreads matches the messages of threadIDs.
+func inThreads(threadIDs []string) *filters.WhereBuilder {
+	return filters.Where().WithPath([]string{"threadID"}).WithOperator(filters.ContainsAny).WithValueString(threadIDs...)
+}
+
+// GetUnembeddedChatMessages finds the messages SaveChatMessageVectors hasn't
+// marked embedded.
+func (s *WeaviateStore) GetUnembeddedChatMessages(threadIDs []string) (map[string][]models.ChatMessage, error) {
+	if len(threadIDs) == 0 {
+		return nil, nil
+	}
+
+	where := filters.Where().WithOperator(filters.And).WithOperands([]*filters.WhereBuilder{
+		inThreads(threadIDs),
+		filters.Where().WithPath([]string{"embedded"}).WithOperator(filters.NotEqual).WithValueBoolean(true),
+	})
+	objects, err := s.getAll(ChatMessageClass, append(chatMessageSearchFields, "_additional { id }"), where,
+		graphql.Sort{Path: []string{"_id"}, Order: graphql.Asc})
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make(map[string][]models.ChatMessage)
+	for _, obj := range objects {
+		match := chatMessageMatch(obj)
+		messages[match.ThreadID] = append(messages[match.ThreadID], match.Message)
+	}
+	return messages, nil
+}
+
+// SearchChatMessages runs a nearVector query when the ranking is by
+// similarity alone, and otherwise a hybrid query over the content, fusing
+// BM25 and vector scores by relative score so they fall between 0 and 1.
+func (s *WeaviateStore) SearchChatMessages(query ChatMessageQuery) ([]ChatMessageMatch, error) {
+	if len(query.ThreadIDs) == 0 {
+		return nil, nil
+	}
+
+	operands := []*filters.WhereBuilder{inThreads(query.ThreadIDs)}
+	if !query.From.IsZero() {
+		operands = append(operands, filters.Where().
+			WithPath([]string{"timestamp"}).
+			WithOperator(filters.GreaterThanEqual).
+			WithValueDate(query.From))
+	}
+	if !query.To.IsZero() {
+		operands = append(operands, filters.Where().
+			WithPath([]string{"timestamp"}).
+			WithOperator(filters.LessThan).
+			WithValueDate(query.To))
+	}
+	where := operands[0]
+	if len(operands) > 1 {
+		where = filters.Where().WithOperator(filters.And).WithOperands(operands)
+	}
+
+	fields := make([]graphql.Field, 0, len(chatMessageSearchFields)+1)
+	for _, field := range chatMessageSearchFields {
+		fields = append(fields, graphql.Field{Name: field})
+	}
+
+	get := s.client.GraphQL().Get().
+		WithClassName(ChatMessageClass).
+		WithWhere(where).
+		WithLimit(query.Limit)
+	if query.Alpha >= 1 {
+		fields = append(fields, graphql.Field{Name: "_additional { id distance }"})
+		get = get.WithNearVector(s.client.GraphQL().NearVectorArgBuilder().WithVector(query.Vector))
+	} else {
+		fields = append(fields, graphql.Field{Name: "_additional { id score }"})
+		hybrid := s.client.GraphQL().HybridArgumentBuilder().
+			WithQuery(query.Query).
+			WithProperties([]string{"content"}).
+			WithFusionType(graphql.RelativeScore).
+			WithAlpha(float32(query.Alpha))
+		if len(query.Vector) > 0 {
+			hybrid = hybrid.WithVector(query.Vector)
+		}
+		get = get.WithHybrid(hybrid)
+	}
+
+	response, err := get.WithFields(fields...).Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if response.Errors != nil {
+		return nil, fmt.Errorf("graphQL errors: %v", response.Errors)
+	}
+
+	getObject, ok := response.Data["Get"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("unable to parse 'Get' from response data")
+	}
+	classObjects, _ := getObject[ChatMessageClass].([]interface{})
+
+	matches := make([]ChatMessageMatch, 0, len(classObjects))
+	for _, classObject := range classObjects {
+		obj, ok := classObject.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		match := chatMessageMatch(obj)
+		if additional, ok := obj["_additional"].(map[string]interface{}); ok {
+			if distance, ok := additional["distance"].(float64); ok {
+				// Cosine distance, so this is the similarity.
+				match.Score = 1 - distance
+			}
+			// Hybrid scores come back as strings.
+			if score, ok := additional["score"].(string); ok {
+				match.Score, _ = strconv.ParseFloat(score, 64)
+			}
+		}
+		matches = append(matches, match)
+	}
+	return matches, nil
+}
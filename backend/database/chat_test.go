@@ -1,14 +1,24 @@
 package database
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/davidulloa/mimir/llm/llmtest"
 	"github.com/davidulloa/mimir/models"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestWeaviateOperations(t *testing.T) {
+	if os.Getenv("WEAVIATE_URL") == "" {
+		t.Skip("WEAVIATE_URL is not set; chat operations are covered by the embedded store")
+	}
+
 	client, err := InitWeaviateClient()
 	if err != nil {
 		t.Fatalf("Failed to initialize Weaviate client: %v", err)
@@ -55,14 +65,14 @@ func TestWeaviateOperations(t *testing.T) {
 		Role:    "user",
 		Content: "Hello, GPT!",
 	}
-	err = AddChatMessage(threadID, userMessage)
+	_, err = AddChatMessage(threadID, userMessage)
 	assert.NoError(t, err)
 
 	gptMessage := models.ChatMessage{
 		Role:    "assistant",
 		Content: "Hello! How can I assist you today?",
 	}
-	err = AddChatMessage(threadID, gptMessage)
+	_, err = AddChatMessage(threadID, gptMessage)
 	assert.NoError(t, err)
 
 	messages, err := GetChatMessages(threadID)
@@ -147,7 +157,7 @@ func TestChatMessageOperations(t *testing.T) {
 	}
 
 	for _, msg := range messages {
-		err = AddChatMessage(threadID, msg)
+		_, err = AddChatMessage(threadID, msg)
 		assert.NoError(t, err)
 	}
 
@@ -165,4 +175,343 @@ func TestChatMessageOperations(t *testing.T) {
 	// Clean up
 	err = DeleteChatThread(threadID)
 	assert.NoError(t, err)
-}
\ No newline at end of file
+}
+func TestChatBranching(t *testing.T) {
+	threadID, err := CreateChatThread(models.ChatThread{UserID: "user789", Title: "Branching Thread"})
+	assert.NoError(t, err)
+	defer DeleteChatThread(threadID)
+
+	add := func(parentID, role, content string) string {
+		id, err := AddChatMessage(threadID, models.ChatMessage{ParentID: parentID, Role: role, Content: content})
+		assert.NoError(t, err)
+		return id
+	}
+	contents := func(messages []models.ChatMessage) []string {
+		var out []string
+		for _, message := range messages {
+			out = append(out, message.Content)
+		}
+		return out
+	}
+
+	question := add(threadID, "user", "Q1")
+	add(question, "assistant", "A1")
+	edited := add(threadID, "user", "Q1 edited")
+	add(edited, "assistant", "A1 edited")
+
+	thread, err := GetChatThread(threadID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Q1 edited", "A1 edited"}, contents(thread.Messages), "the newest message is active")
+
+	messages, err := GetChatMessages(threadID)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 4)
+
+	tree := NewChatTree(threadID, messages)
+	assert.Equal(t, []string{"Q1", "Q1 edited"}, contents(tree.Siblings(edited)))
+
+	assert.NoError(t, SetActiveChatMessage(threadID, tree.LatestLeaf(question)))
+	thread, err = GetChatThread(threadID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Q1", "A1"}, contents(thread.Messages))
+
+	// Renaming the thread keeps the branch shown.
+	thread.Title = "Renamed"
+	assert.NoError(t, UpdateChatThread(*thread))
+	thread, err = GetChatThread(threadID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Q1", "A1"}, contents(thread.Messages))
+
+	assert.Error(t, SetActiveChatMessage("missing", question))
+}
+
+func TestChatTreeInfersLegacyParents(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tree := NewChatTree("thread", []models.ChatMessage{
+		{ID: "b", Role: "assistant", Timestamp: start.Add(time.Minute)},
+		{ID: "a", Role: "user", Timestamp: start},
+		{ID: "c", Role: "user", ParentID: "thread", Timestamp: start.Add(2 * time.Minute)},
+	})
+
+	assert.Equal(t, "c", tree.Leaf())
+	assert.Len(t, tree.Branch(""), 1)
+	assert.Equal(t, []string{"a", "b"}, []string{tree.Branch("b")[0].ID, tree.Branch("b")[1].ID})
+	assert.Len(t, tree.Siblings("a"), 2)
+	assert.Equal(t, "b", tree.LatestLeaf("a"))
+	assert.Empty(t, tree.Branch("thread"))
+	assert.Nil(t, tree.Siblings("missing"))
+}
+
+func TestChatSummary(t *testing.T) {
+	threadID, err := CreateChatThread(models.ChatThread{UserID: "user789", Title: "Summary Thread"})
+	assert.NoError(t, err)
+	defer DeleteChatThread(threadID)
+
+	messageID, err := AddChatMessage(threadID, models.ChatMessage{Role: "user", Content: "Hello"})
+	assert.NoError(t, err)
+	assert.NoError(t, SetChatSummary(threadID, "The user said hello.", messageID))
+
+	thread, err := GetChatThread(threadID)
+	assert.NoError(t, err)
+	thread.Title = "Renamed"
+	thread.Summary = ""
+	assert.NoError(t, UpdateChatThread(*thread))
+
+	thread, err = GetChatThread(threadID)
+	assert.NoError(t, err)
+	assert.Equal(t, "The user said hello.", thread.Summary, "updates leave the summary alone")
+	assert.Equal(t, messageID, thread.SummaryMessageID)
+
+	assert.Error(t, SetChatSummary("missing", "", messageID))
+}
+
+func TestGenerationJobs(t *testing.T) {
+	threadID, err := CreateChatThread(models.ChatThread{UserID: "user789", Title: "Job Thread"})
+	assert.NoError(t, err)
+
+	job := models.GenerationJob{ThreadID: threadID, MessageID: "m1", Status: models.JobQueued}
+	assert.NoError(t, SaveGenerationJob(&job))
+	assert.NotEmpty(t, job.ID)
+	assert.False(t, job.CreatedAt.IsZero())
+
+	job.Status = models.JobFailed
+	job.Error = "rate limited"
+	job.Attempts = 1
+	assert.NoError(t, SaveGenerationJob(&job))
+
+	second := models.GenerationJob{ThreadID: threadID, MessageID: "m2", Status: models.JobRunning}
+	assert.NoError(t, SaveGenerationJob(&second))
+
+	jobs, err := GetGenerationJobs(threadID)
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 2) {
+		assert.Equal(t, job.ID, jobs[0].ID)
+		assert.Equal(t, models.JobFailed, jobs[0].Status)
+		assert.Equal(t, "rate limited", jobs[0].Error)
+		assert.Equal(t, 1, jobs[0].Attempts)
+		assert.Equal(t, second.ID, jobs[1].ID)
+	}
+
+	jobs, err = GetGenerationJobs(threadID, models.JobRunning, models.JobQueued)
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 1) {
+		assert.Equal(t, second.ID, jobs[0].ID)
+	}
+
+	assert.NoError(t, DeleteChatThread(threadID))
+	if os.Getenv("WEAVIATE_URL") == "" {
+		jobs, err = GetGenerationJobs(threadID)
+		assert.NoError(t, err)
+		assert.Empty(t, jobs, "deleting the thread deletes its jobs")
+	}
+}
+
+func TestClaimGenerationJob(t *testing.T) {
+	threadID, err := CreateChatThread(models.ChatThread{UserID: "user789", Title: "Claim Thread"})
+	assert.NoError(t, err)
+	defer DeleteChatThread(threadID)
+
+	first := models.GenerationJob{ThreadID: threadID, MessageID: "m1", Status: models.JobQueued}
+	assert.NoError(t, SaveGenerationJob(&first))
+	second := models.GenerationJob{ThreadID: threadID, MessageID: "m2", Status: models.JobQueued, Owner: "request"}
+	assert.NoError(t, SaveGenerationJob(&second))
+
+	// Jobs are claimed oldest first.
+	claimed, err := ClaimGenerationJob(threadID, second.ID, "request", time.Minute)
+	assert.NoError(t, err)
+	assert.Nil(t, claimed, "an older job is queued")
+
+	// Of concurrent claims, one wins.
+	claims := make(chan *models.GenerationJob, 8)
+	for i := 0; i < cap(claims); i++ {
+		go func(owner string) {
+			claimed, err := ClaimGenerationJob(threadID, first.ID, owner, time.Minute)
+			assert.NoError(t, err)
+			claims <- claimed
+		}(fmt.Sprintf("replica%d", i))
+	}
+	var winners []*models.GenerationJob
+	for i := 0; i < cap(claims); i++ {
+		if claimed := <-claims; claimed != nil {
+			winners = append(winners, claimed)
+		}
+	}
+	if assert.Len(t, winners, 1) {
+		assert.Equal(t, models.JobRunning, winners[0].Status)
+		assert.Equal(t, 1, winners[0].Attempts)
+		assert.NotEmpty(t, winners[0].Owner)
+	}
+
+	// The thread is busy while the job runs, and the next job is reserved.
+	claimed, err = ClaimGenerationJob(threadID, second.ID, "request", time.Minute)
+	assert.NoError(t, err)
+	assert.Nil(t, claimed, "another job is running")
+
+	done := *winners[0]
+	done.Status = models.JobSucceeded
+	assert.NoError(t, SaveGenerationJob(&done))
+
+	claimed, err = ClaimGenerationJob(threadID, second.ID, "replica0", time.Minute)
+	assert.NoError(t, err)
+	assert.Nil(t, claimed, "the job is reserved")
+	claimed, err = ClaimGenerationJob(threadID, second.ID, "request", time.Minute)
+	assert.NoError(t, err)
+	if assert.NotNil(t, claimed) {
+		assert.Equal(t, "request", claimed.Owner)
+	}
+
+	// A job that stopped making progress no longer holds up the thread.
+	third := models.GenerationJob{ThreadID: threadID, MessageID: "m3", Status: models.JobQueued}
+	assert.NoError(t, SaveGenerationJob(&third))
+	claimed, err = ClaimGenerationJob(threadID, third.ID, "replica0", time.Minute)
+	assert.NoError(t, err)
+	assert.Nil(t, claimed)
+	time.Sleep(10 * time.Millisecond)
+	claimed, err = ClaimGenerationJob(threadID, third.ID, "replica0", time.Millisecond)
+	assert.NoError(t, err)
+	assert.NotNil(t, claimed)
+}
+
+func TestChatMessageSequence(t *testing.T) {
+	threadID, err := CreateChatThread(models.ChatThread{UserID: "user789", Title: "Sequence Thread"})
+	assert.NoError(t, err)
+	defer DeleteChatThread(threadID)
+
+	// Messages added within the same instant still keep their order.
+	at := time.Now()
+	for _, content := range []string{"first", "second", "third"} {
+		_, err := AddChatMessage(threadID, models.ChatMessage{Role: "user", Content: content, Timestamp: at})
+		assert.NoError(t, err)
+	}
+
+	messages, err := GetChatMessages(threadID)
+	assert.NoError(t, err)
+	if assert.Len(t, messages, 3) {
+		for i, content := range []string{"first", "second", "third"} {
+			assert.Equal(t, content, messages[i].Content)
+		}
+		assert.Less(t, messages[0].Sequence, messages[1].Sequence)
+		assert.Less(t, messages[1].Sequence, messages[2].Sequence)
+
+		thread, err := GetChatThread(threadID)
+		assert.NoError(t, err)
+		assert.Equal(t, messages[2].Sequence, thread.MessageSequence, "the thread counts its messages")
+	}
+
+	legacy := []models.ChatMessage{
+		{ID: "new", Sequence: 1, Timestamp: at.Add(-time.Hour)},
+		{ID: "old", Timestamp: at.Add(-2 * time.Hour)},
+		{ID: "older", Timestamp: at.Add(-3 * time.Hour)},
+	}
+	SortChatMessages(legacy)
+	assert.Equal(t, []string{"older", "old", "new"}, []string{legacy[0].ID, legacy[1].ID, legacy[2].ID})
+}
+
+func TestSearchChatHistory(t *testing.T) {
+	llmtest.UseFake(t)
+
+	add := func(instanceID, acceleratorID, title string, at time.Time, contents ...string) (string, []string) {
+		threadID, err := CreateChatThread(models.ChatThread{UserID: instanceID, Title: title, AcceleratorId: acceleratorID})
+		assert.NoError(t, err)
+		t.Cleanup(func() { DeleteChatThread(threadID) })
+
+		var ids []string
+		for i, content := range contents {
+			role := "user"
+			if i%2 == 1 {
+				role = "assistant"
+			}
+			id, err := AddChatMessage(threadID, models.ChatMessage{Role: role, Content: content, Timestamp: at})
+			assert.NoError(t, err)
+			ids = append(ids, id)
+		}
+		return threadID, ids
+	}
+
+	routing, routingMessages := add("search-instance", "acc-routing", "Incident routing", time.Date(2025, 1, 10, 9, 0, 0, 0, time.UTC),
+		"How do I route incidents by category?",
+		"Add a rule per category to the assignment lookup rules table.")
+	cmdb, cmdbMessages := add("search-instance", "acc-cmdb", "CMDB health", time.Date(2025, 2, 10, 9, 0, 0, 0, time.UTC),
+		"What should I check weekly?",
+		"Review the CMDB health dashboard for duplicate and stale CIs.")
+	add("other-instance", "acc-routing", "Assignment rules", time.Date(2025, 1, 10, 9, 0, 0, 0, time.UTC),
+		"Where are assignment rules?")
+
+	ids := func(results []models.ChatSearchResult) []string {
+		var ids []string
+		for _, result := range results {
+			ids = append(ids, result.MessageID)
+		}
+		return ids
+	}
+
+	results, err := SearchChatHistory(context.Background(), "search-instance", ChatSearch{Query: "assignment rules", Mode: SearchKeyword})
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1, "other instances' threads are not searched") {
+		assert.Equal(t, routing, results[0].ThreadID)
+		assert.Equal(t, "Incident routing", results[0].ThreadTitle)
+		assert.Equal(t, "acc-routing", results[0].AcceleratorID)
+		assert.Equal(t, routingMessages[1], results[0].MessageID)
+		assert.Equal(t, "assistant", results[0].Role)
+		assert.Equal(t, "Add a rule per category to the <mark>assignment</mark> lookup <mark>rules</mark> table.", results[0].Snippet)
+		assert.Equal(t, 1.0, results[0].Score)
+	}
+
+	results, err = SearchChatHistory(context.Background(), "search-instance", ChatSearch{Query: "health", Mode: SearchKeyword, AcceleratorID: "acc-cmdb"})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"", cmdbMessages[1]}, ids(results), "the title matches as well as the reply")
+	for _, result := range results {
+		assert.Equal(t, cmdb, result.ThreadID)
+	}
+
+	results, err = SearchChatHistory(context.Background(), "search-instance", ChatSearch{Query: "category", Mode: SearchKeyword, AcceleratorID: "acc-cmdb"})
+	assert.NoError(t, err)
+	assert.Empty(t, results, "other accelerators' threads are filtered out")
+
+	results, err = SearchChatHistory(context.Background(), "search-instance", ChatSearch{
+		Query: "category health",
+		Mode:  SearchKeyword,
+		From:  time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:    time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC),
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, routingMessages, ids(results), "messages and titles outside the dates are filtered out")
+
+	results, err = SearchChatHistory(context.Background(), "search-instance", ChatSearch{Query: "stale duplicate CIs", Mode: SearchSemantic, Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{cmdbMessages[1]}, ids(results))
+
+	vectors, err := GetChatMessageVectors(cmdb)
+	assert.NoError(t, err)
+	assert.Len(t, vectors, 2, "searching embeds the messages and keeps their vectors")
+	store, err := GetStore()
+	if assert.NoError(t, err) {
+		missing, err := store.GetUnembeddedChatMessages([]string{routing, cmdb})
+		assert.NoError(t, err)
+		assert.Empty(t, missing, "embedded messages aren't embedded again")
+	}
+
+	results, err = SearchChatHistory(context.Background(), "search-instance", ChatSearch{Query: "routing incidents"})
+	assert.NoError(t, err)
+	if assert.NotEmpty(t, results) {
+		assert.Equal(t, routing, results[0].ThreadID, "hybrid is the default mode")
+	}
+
+	_, err = SearchChatHistory(context.Background(), "search-instance", ChatSearch{Query: "rules", Mode: "fuzzy"})
+	assert.Error(t, err)
+}
+
+func TestHighlight(t *testing.T) {
+	terms := []string{"sla"}
+	assert.Equal(t, "Breached <mark>SLA</mark> &lt;b&gt;tasks&lt;/b&gt;", highlight("Breached  SLA\n<b>tasks</b>", terms))
+
+	long := strings.Repeat("word ", 30) + "the SLA definition " + strings.Repeat("more ", 60)
+	snippet := highlight(long, terms)
+	assert.True(t, strings.HasPrefix(snippet, "…word "), snippet)
+	assert.True(t, strings.HasSuffix(snippet, " more…"), snippet)
+	assert.Contains(t, snippet, "the <mark>SLA</mark> definition")
+	text := strings.NewReplacer("<mark>", "", "</mark>", "").Replace(snippet)
+	assert.LessOrEqual(t, len([]rune(text)), snippetRunes+2)
+
+	assert.Equal(t, "No match here", highlight("No match here", terms))
+}
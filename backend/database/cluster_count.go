@@ -0,0 +1,216 @@
+package database
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	DefaultMinClusters = 2
+	DefaultMaxClusters = 8
+	// ClusterLimit caps every cluster count a request can ask for. Each k in
+	// the range is clustered several times over, and each cluster is
+	// labelled by the model.
+	ClusterLimit = 2 * DefaultMaxClusters
+
+	// kmeansRestarts is how many randomly seeded k-means runs are tried per k,
+	// since a single run can settle in a poor local optimum.
+	kmeansRestarts = 5
+)
+
+// ClusteringOptions controls how many clusters TFIDFKMeansClusteringWithOptions
+// produces. With NumClusters set, that k is used as-is; otherwise every k in
+// [MinClusters, MaxClusters] is tried and the one with the best silhouette
+// score wins. Mode picks the vectors clustered; see ClusterDocuments.
+// Algorithm picks the Clusterer; DBSCAN ignores the cluster counts.
+type ClusteringOptions struct {
+	Mode        string `json:"mode,omitempty"`
+	NumClusters int    `json:"numClusters,omitempty"`
+	MinClusters int    `json:"minClusters,omitempty"`
+	MaxClusters int    `json:"maxClusters,omitempty"`
+
+	// Algorithm is "kmeans" (the default), "dbscan" or "agglomerative".
+	Algorithm string `json:"algorithm,omitempty"`
+	// Epsilon and MinPoints tune DBSCAN; Epsilon is estimated when zero.
+	Epsilon   float64 `json:"epsilon,omitempty"`
+	MinPoints int     `json:"minPoints,omitempty"`
+	// Linkage is "average" (the default), "complete" or "single". With a
+	// DistanceThreshold, agglomerative clusters are cut where merges exceed
+	// it rather than at a k.
+	Linkage           string  `json:"linkage,omitempty"`
+	DistanceThreshold float64 `json:"distanceThreshold,omitempty"`
+}
+
+// Validate checks the cluster counts are between 0 and ClusterLimit.
+func (o ClusteringOptions) Validate() error {
+	if o.NumClusters < 0 || o.MinClusters < 0 || o.MaxClusters < 0 {
+		return fmt.Errorf("cluster counts must not be negative")
+	}
+	if o.NumClusters > ClusterLimit || o.MinClusters > ClusterLimit || o.MaxClusters > ClusterLimit {
+		return fmt.Errorf("cluster counts must be at most %d", ClusterLimit)
+	}
+	return nil
+}
+
+// clusterRange resolves the k range to search for n documents. Silhouette is
+// only defined for 2 <= k <= n-1, so tiny inputs collapse to a single cluster.
+func (o ClusteringOptions) clusterRange(n int) (int, int, error) {
+	if err := o.Validate(); err != nil {
+		return 0, 0, err
+	}
+
+	if o.NumClusters > 0 {
+		if o.NumClusters > n {
+			return 0, 0, fmt.Errorf("cannot build %d clusters from %d documents", o.NumClusters, n)
+		}
+		return o.NumClusters, o.NumClusters, nil
+	}
+
+	minK, maxK := o.MinClusters, o.MaxClusters
+	if minK == 0 {
+		minK = DefaultMinClusters
+	}
+	if maxK == 0 {
+		maxK = DefaultMaxClusters
+	}
+	if minK > maxK {
+		return 0, 0, fmt.Errorf("minClusters (%d) is greater than maxClusters (%d)", minK, maxK)
+	}
+
+	if maxK > n-1 {
+		maxK = n - 1
+	}
+	if minK > maxK {
+		if n == 0 {
+			return 0, 0, nil
+		}
+		return 1, 1, nil
+	}
+	return minK, maxK, nil
+}
+
+func euclideanDistance(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return math.Sqrt(sum)
+}
+
+// cosineDistance is 1 minus the cosine similarity of a and b, in [0, 2].
+func cosineDistance(a, b []float64) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 1
+	}
+	return 1 - dot/(math.Sqrt(normA)*math.Sqrt(normB))
+}
+
+// silhouetteScore is the mean silhouette coefficient over all points, in
+// [-1, 1]. Higher means tighter, better separated clusters. Points in
+// singleton clusters contribute 0, as do inputs with fewer than two clusters.
+func silhouetteScore(matrix [][]float64, clustered map[int][]int) float64 {
+	return silhouetteScoreWith(matrix, clustered, euclideanDistance)
+}
+
+// silhouetteScoreWith is silhouetteScore under the given distance.
+func silhouetteScoreWith(matrix [][]float64, clustered map[int][]int, distance func(a, b []float64) float64) float64 {
+	var groups [][]int
+	for _, indices := range clustered {
+		if len(indices) > 0 {
+			groups = append(groups, indices)
+		}
+	}
+	if len(groups) < 2 {
+		return 0
+	}
+
+	var total float64
+	var count int
+	for g, members := range groups {
+		for _, i := range members {
+			count++
+			if len(members) == 1 {
+				continue
+			}
+
+			var a float64
+			for _, j := range members {
+				if j != i {
+					a += distance(matrix[i], matrix[j])
+				}
+			}
+			a /= float64(len(members) - 1)
+
+			b := math.Inf(1)
+			for h, other := range groups {
+				if h == g {
+					continue
+				}
+				var d float64
+				for _, j := range other {
+					d += distance(matrix[i], matrix[j])
+				}
+				b = math.Min(b, d/float64(len(other)))
+			}
+
+			if m := math.Max(a, b); m > 0 {
+				total += (b - a) / m
+			}
+		}
+	}
+
+	return total / float64(count)
+}
+
+// metric pairs a distance with the k-means variant that clusters under it.
+type metric struct {
+	distance  func(a, b []float64) float64
+	partition func(matrix [][]float64, k int) (map[int][]int, error)
+}
+
+var (
+	euclideanMetric = metric{distance: euclideanDistance, partition: clusterTexts}
+	cosineMetric    = metric{distance: cosineDistance, partition: sphericalKMeans}
+)
+
+// selectClusters runs k-means for each k in the configured range and keeps the
+// partition with the highest silhouette score across all restarts.
+func selectClusters(matrix [][]float64, opts ClusteringOptions) (int, map[int][]int, float64, error) {
+	return selectClustersWith(matrix, opts, euclideanMetric)
+}
+
+// selectClustersWith is selectClusters under the given metric.
+func selectClustersWith(matrix [][]float64, opts ClusteringOptions, m metric) (int, map[int][]int, float64, error) {
+	minK, maxK, err := opts.clusterRange(len(matrix))
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	if maxK == 0 {
+		return 0, map[int][]int{}, 0, nil
+	}
+
+	bestK := 0
+	var best map[int][]int
+	bestScore := math.Inf(-1)
+	for k := minK; k <= maxK; k++ {
+		for run := 0; run < kmeansRestarts; run++ {
+			clustered, err := m.partition(matrix, k)
+			if err != nil {
+				return 0, nil, 0, err
+			}
+			score := silhouetteScoreWith(matrix, clustered, m.distance)
+			if score > bestScore {
+				bestK, best, bestScore = k, clustered, score
+			}
+		}
+	}
+
+	return bestK, best, bestScore, nil
+}
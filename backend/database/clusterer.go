@@ -0,0 +1,396 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	AlgorithmKMeans        = "kmeans"
+	AlgorithmDBSCAN        = "dbscan"
+	AlgorithmAgglomerative = "agglomerative"
+
+	LinkageAverage  = "average"
+	LinkageComplete = "complete"
+	LinkageSingle   = "single"
+
+	// DefaultMinPoints is the neighbourhood size, counting the point itself,
+	// that makes a point a DBSCAN core point.
+	DefaultMinPoints = 3
+)
+
+// Clustering is the result of running a Clusterer over the rows of a matrix.
+type Clustering struct {
+	// Clusters holds the row indices of each cluster.
+	Clusters [][]int
+	// Noise holds rows that fit no cluster; only DBSCAN reports any.
+	Noise []int
+	// Dendrogram is the full merge tree of an agglomerative run.
+	Dendrogram *DendrogramNode
+	// SilhouetteScore measures the clusters, leaving noise out.
+	SilhouetteScore float64
+}
+
+// DendrogramNode is a node of an agglomerative merge tree. Leaves are single
+// rows; every other node merges its two children at distance Height.
+type DendrogramNode struct {
+	// Index is the row of a leaf and -1 for merges.
+	Index    int               `json:"index"`
+	Height   float64           `json:"height"`
+	Size     int               `json:"size"`
+	Children []*DendrogramNode `json:"children,omitempty"`
+}
+
+// leaves appends the rows under n to rows in left-to-right order.
+func (n *DendrogramNode) leaves(rows []int) []int {
+	if len(n.Children) == 0 {
+		return append(rows, n.Index)
+	}
+	for _, child := range n.Children {
+		rows = child.leaves(rows)
+	}
+	return rows
+}
+
+// Clusterer partitions the rows of a matrix.
+type Clusterer interface {
+	Cluster(matrix [][]float64) (*Clustering, error)
+}
+
+// newClusterer builds the Clusterer opts.Algorithm names, measuring distance
+// with m.
+func newClusterer(opts ClusteringOptions, m metric) (Clusterer, error) {
+	switch opts.Algorithm {
+	case "", AlgorithmKMeans:
+		return &KMeans{Options: opts, metric: m}, nil
+	case AlgorithmDBSCAN:
+		if opts.Epsilon < 0 || opts.MinPoints < 0 {
+			return nil, fmt.Errorf("epsilon and minPoints must not be negative")
+		}
+		return &DBSCAN{Epsilon: opts.Epsilon, MinPoints: opts.MinPoints, Distance: m.distance}, nil
+	case AlgorithmAgglomerative:
+		switch opts.Linkage {
+		case "", LinkageAverage, LinkageComplete, LinkageSingle:
+		default:
+			return nil, fmt.Errorf("unknown linkage %q", opts.Linkage)
+		}
+		if opts.DistanceThreshold < 0 {
+			return nil, fmt.Errorf("distanceThreshold must not be negative")
+		}
+		return &Agglomerative{Options: opts, Distance: m.distance}, nil
+	default:
+		return nil, fmt.Errorf("unknown clustering algorithm %q", opts.Algorithm)
+	}
+}
+
+// KMeans searches the configured k range with restarted k-means runs and keeps
+// the partition with the best silhouette score.
+type KMeans struct {
+	Options ClusteringOptions
+	metric  metric
+}
+
+func (c *KMeans) Cluster(matrix [][]float64) (*Clustering, error) {
+	m := c.metric
+	if m.partition == nil {
+		m = euclideanMetric
+	}
+
+	numClusters, clustered, score, err := selectClustersWith(matrix, c.Options, m)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Clustering{SilhouetteScore: score}
+	for k := 0; k < numClusters; k++ {
+		if len(clustered[k]) > 0 {
+			result.Clusters = append(result.Clusters, clustered[k])
+		}
+	}
+	return result, nil
+}
+
+// DBSCAN groups points that lie in dense regions and reports the rest as
+// noise instead of forcing them into a cluster. A zero Epsilon is estimated
+// from the data as the median distance to each point's MinPoints-th neighbour.
+type DBSCAN struct {
+	Epsilon   float64
+	MinPoints int
+	Distance  func(a, b []float64) float64
+}
+
+func (c *DBSCAN) Cluster(matrix [][]float64) (*Clustering, error) {
+	n := len(matrix)
+	if n == 0 {
+		return &Clustering{}, nil
+	}
+
+	distance := c.Distance
+	if distance == nil {
+		distance = euclideanDistance
+	}
+	minPoints := c.MinPoints
+	if minPoints == 0 {
+		minPoints = DefaultMinPoints
+	}
+
+	distances := pairwiseDistances(matrix, distance)
+	epsilon := c.Epsilon
+	if epsilon == 0 {
+		epsilon = estimateEpsilon(distances, minPoints)
+	}
+
+	neighbours := func(i int) []int {
+		var result []int
+		for j, d := range distances[i] {
+			if d <= epsilon {
+				result = append(result, j)
+			}
+		}
+		return result
+	}
+
+	const unvisited, noise = -2, -1
+	labels := make([]int, n)
+	for i := range labels {
+		labels[i] = unvisited
+	}
+
+	cluster := 0
+	for i := range matrix {
+		if labels[i] != unvisited {
+			continue
+		}
+
+		seeds := neighbours(i)
+		if len(seeds) < minPoints {
+			labels[i] = noise
+			continue
+		}
+
+		labels[i] = cluster
+		for len(seeds) > 0 {
+			j := seeds[0]
+			seeds = seeds[1:]
+
+			if labels[j] == noise {
+				// A border point: reachable, but not dense enough to expand from.
+				labels[j] = cluster
+			}
+			if labels[j] != unvisited {
+				continue
+			}
+
+			labels[j] = cluster
+			if expanded := neighbours(j); len(expanded) >= minPoints {
+				seeds = append(seeds, expanded...)
+			}
+		}
+		cluster++
+	}
+
+	result := &Clustering{Clusters: make([][]int, cluster)}
+	clustered := make(map[int][]int)
+	for i, label := range labels {
+		if label == noise {
+			result.Noise = append(result.Noise, i)
+			continue
+		}
+		result.Clusters[label] = append(result.Clusters[label], i)
+		clustered[label] = result.Clusters[label]
+	}
+	result.SilhouetteScore = silhouetteScoreWith(matrix, clustered, distance)
+	return result, nil
+}
+
+// estimateEpsilon returns the median distance from each point to its
+// minPoints-th nearest neighbour, counting the point itself.
+func estimateEpsilon(distances [][]float64, minPoints int) float64 {
+	kth := make([]float64, len(distances))
+	for i, row := range distances {
+		sorted := append([]float64(nil), row...)
+		sort.Float64s(sorted)
+		k := minPoints - 1
+		if k >= len(sorted) {
+			k = len(sorted) - 1
+		}
+		kth[i] = sorted[k]
+	}
+	sort.Float64s(kth)
+	return kth[len(kth)/2]
+}
+
+// Agglomerative builds a merge tree bottom-up, repeatedly joining the two
+// closest clusters under the configured linkage, then cuts it into clusters:
+// at Options.DistanceThreshold if set, otherwise at Options.NumClusters or
+// the k in the configured range with the best silhouette score.
+type Agglomerative struct {
+	Options  ClusteringOptions
+	Distance func(a, b []float64) float64
+}
+
+func (c *Agglomerative) Cluster(matrix [][]float64) (*Clustering, error) {
+	n := len(matrix)
+	if n == 0 {
+		return &Clustering{}, nil
+	}
+
+	distance := c.Distance
+	if distance == nil {
+		distance = euclideanDistance
+	}
+
+	root := buildDendrogram(pairwiseDistances(matrix, distance), c.Options.Linkage)
+	result := &Clustering{Dendrogram: root}
+
+	score := func(clusters [][]int) float64 {
+		clustered := make(map[int][]int, len(clusters))
+		for i, rows := range clusters {
+			clustered[i] = rows
+		}
+		return silhouetteScoreWith(matrix, clustered, distance)
+	}
+
+	if c.Options.DistanceThreshold > 0 {
+		result.Clusters = cutAtHeight(root, c.Options.DistanceThreshold)
+		result.SilhouetteScore = score(result.Clusters)
+		return result, nil
+	}
+
+	minK, maxK, err := c.Options.clusterRange(n)
+	if err != nil {
+		return nil, err
+	}
+
+	bestScore := math.Inf(-1)
+	for k := minK; k <= maxK; k++ {
+		clusters := cutIntoK(root, k)
+		if s := score(clusters); s > bestScore {
+			result.Clusters, result.SilhouetteScore, bestScore = clusters, s, s
+		}
+	}
+	return result, nil
+}
+
+func pairwiseDistances(matrix [][]float64, distance func(a, b []float64) float64) [][]float64 {
+	distances := make([][]float64, len(matrix))
+	for i := range distances {
+		distances[i] = make([]float64, len(matrix))
+	}
+	for i := range matrix {
+		for j := i + 1; j < len(matrix); j++ {
+			d := distance(matrix[i], matrix[j])
+			distances[i][j], distances[j][i] = d, d
+		}
+	}
+	return distances
+}
+
+// buildDendrogram merges clusters pairwise, updating distances with the
+// Lance-Williams formula for the linkage. It consumes distances.
+func buildDendrogram(distances [][]float64, linkage string) *DendrogramNode {
+	n := len(distances)
+	nodes := make([]*DendrogramNode, n)
+	active := make([]bool, n)
+	for i := range nodes {
+		nodes[i] = &DendrogramNode{Index: i, Size: 1}
+		active[i] = true
+	}
+
+	for merges := 0; merges < n-1; merges++ {
+		a, b, closest := -1, -1, math.Inf(1)
+		for i := 0; i < n; i++ {
+			if !active[i] {
+				continue
+			}
+			for j := i + 1; j < n; j++ {
+				if active[j] && distances[i][j] < closest {
+					a, b, closest = i, j, distances[i][j]
+				}
+			}
+		}
+
+		sizeA, sizeB := float64(nodes[a].Size), float64(nodes[b].Size)
+		for k := 0; k < n; k++ {
+			if !active[k] || k == a || k == b {
+				continue
+			}
+			var d float64
+			switch linkage {
+			case LinkageSingle:
+				d = math.Min(distances[a][k], distances[b][k])
+			case LinkageComplete:
+				d = math.Max(distances[a][k], distances[b][k])
+			default:
+				d = (sizeA*distances[a][k] + sizeB*distances[b][k]) / (sizeA + sizeB)
+			}
+			distances[a][k], distances[k][a] = d, d
+		}
+
+		nodes[a] = &DendrogramNode{
+			Index:    -1,
+			Height:   closest,
+			Size:     nodes[a].Size + nodes[b].Size,
+			Children: []*DendrogramNode{nodes[a], nodes[b]},
+		}
+		active[b] = false
+	}
+
+	for i, node := range nodes {
+		if active[i] {
+			return node
+		}
+	}
+	return nil
+}
+
+// cutIntoK undoes the k-1 highest merges. The linkages used here never merge
+// below an earlier merge, so this is the state after n-k merges.
+func cutIntoK(root *DendrogramNode, k int) [][]int {
+	frontier := []*DendrogramNode{root}
+	for len(frontier) < k {
+		highest := -1
+		for i, node := range frontier {
+			if len(node.Children) > 0 && (highest < 0 || node.Height > frontier[highest].Height) {
+				highest = i
+			}
+		}
+		if highest < 0 {
+			break
+		}
+		node := frontier[highest]
+		frontier = append(frontier[:highest], frontier[highest+1:]...)
+		frontier = append(frontier, node.Children...)
+	}
+	return frontierRows(frontier)
+}
+
+// cutAtHeight undoes every merge above threshold.
+func cutAtHeight(root *DendrogramNode, threshold float64) [][]int {
+	var frontier []*DendrogramNode
+	var walk func(node *DendrogramNode)
+	walk = func(node *DendrogramNode) {
+		if len(node.Children) > 0 && node.Height > threshold {
+			for _, child := range node.Children {
+				walk(child)
+			}
+			return
+		}
+		frontier = append(frontier, node)
+	}
+	walk(root)
+	return frontierRows(frontier)
+}
+
+// frontierRows lists the rows under each node, ordered by their first row so
+// cluster order doesn't depend on the order of merges.
+func frontierRows(frontier []*DendrogramNode) [][]int {
+	clusters := make([][]int, len(frontier))
+	for i, node := range frontier {
+		clusters[i] = node.leaves(nil)
+		sort.Ints(clusters[i])
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i][0] < clusters[j][0] })
+	return clusters
+}
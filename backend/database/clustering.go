@@ -4,15 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"os"
+	"log"
 
 	"github.com/invopop/jsonschema"
 
 	"math"
 	"strings"
 
-	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/option"
+	"github.com/davidulloa/mimir/llm"
 
 	"github.com/muesli/clusters"
 	"github.com/muesli/kmeans"
@@ -33,10 +32,35 @@ func NewTFIDFVectorizer() *TFIDFVectorizer {
 type ClusterEntry struct {
 	ClusterDescription string   `json:"cluster_description"`
 	TextEntries        []string `json:"text_entries"`
+	// Members are the indices of the clustered documents, in the same order
+	// as TextEntries. Unlike the text, they identify duplicates uniquely.
+	Members []int `json:"members"`
 }
 
 type TicketResponse struct {
 	Clusters []ClusterEntry `json:"clusters"`
+	// NumClusters is the k that was used and SilhouetteScore how well those
+	// clusters separate, from -1 (poor) to 1 (well separated).
+	NumClusters     int     `json:"num_clusters"`
+	SilhouetteScore float64 `json:"silhouette_score"`
+	// Mode is the clustering mode that produced the clusters.
+	Mode string `json:"mode"`
+	// Algorithm is the Clusterer used. Noise lists the indices of documents
+	// DBSCAN left out of every cluster, and Dendrogram is the merge tree of
+	// agglomerative runs; its leaves index the documents too.
+	Algorithm  string          `json:"algorithm"`
+	Noise      []int           `json:"noise,omitempty"`
+	Dendrogram *DendrogramNode `json:"dendrogram,omitempty"`
+}
+
+// clusterLabel is what the model returns for each cluster, in order. It only
+// labels clusters; membership never round-trips through the model.
+type clusterLabel struct {
+	ClusterDescription string `json:"cluster_description"`
+}
+
+type clusterLabels struct {
+	Clusters []clusterLabel `json:"clusters"`
 }
 
 
@@ -65,9 +89,13 @@ func (v *TFIDFVectorizer) FitTransform(descriptions []string) [][]float64 {
     for i, desc := range descriptions {
         vector := make([]float64, len(v.Vocabulary))
         tokens := strings.Fields(strings.ToLower(desc))
+        counts := make(map[string]int, len(tokens))
         for _, token := range tokens {
+            counts[token]++
+        }
+        for token, count := range counts {
             if idx, exists := v.Vocabulary[token]; exists {
-                tf := float64(strings.Count(desc, token)) / float64(len(tokens))
+                tf := float64(count) / float64(len(tokens))
                 vector[idx] = tf * v.IDF[token]
             }
         }
@@ -77,47 +105,49 @@ func (v *TFIDFVectorizer) FitTransform(descriptions []string) [][]float64 {
     return vectors
 }
 
-func coordinatesEqual(a, b clusters.Coordinates) bool {
-    if len(a) != len(b) {
-        return false
-    }
-    for i := range a {
-        if a[i] != b[i] {
-            return false
-        }
-    }
-    return true
+// indexedObservation remembers which row of the matrix an observation is, so
+// k-means results map back to rows even when two rows are identical.
+type indexedObservation struct {
+    coordinates clusters.Coordinates
+    index       int
 }
 
-func clusterTexts(tfidfMatrix [][]float64, numClusters int) map[int][]int {
+func (o indexedObservation) Coordinates() clusters.Coordinates {
+    return o.coordinates
+}
+
+func (o indexedObservation) Distance(point clusters.Coordinates) float64 {
+    return o.coordinates.Distance(point)
+}
+
+func clusterTexts(tfidfMatrix [][]float64, numClusters int) (map[int][]int, error) {
     var observations clusters.Observations
-    for _, vector := range tfidfMatrix {
-        observations = append(observations, clusters.Coordinates(vector))
+    for i, vector := range tfidfMatrix {
+        observations = append(observations, indexedObservation{coordinates: vector, index: i})
     }
 
     km, err := kmeans.NewWithOptions(0.01, nil)
     if err != nil {
-        panic(err)
+        return nil, fmt.Errorf("error creating k-means: %v", err)
     }
 
     clusters, err := km.Partition(observations, numClusters)
     if err != nil {
-        panic(err)
+        return nil, fmt.Errorf("error partitioning into %d clusters: %v", numClusters, err)
     }
 
     clusteredTexts := make(map[int][]int)
     for clusterIndex, cluster := range clusters {
         for _, observation := range cluster.Observations {
-            for i, orig := range observations {
-                if coordinatesEqual(orig.Coordinates(), observation.Coordinates()) {
-                    clusteredTexts[clusterIndex] = append(clusteredTexts[clusterIndex], i)
-                    break
-                }
+            indexed, ok := observation.(indexedObservation)
+            if !ok {
+                return nil, fmt.Errorf("unexpected observation type %T", observation)
             }
+            clusteredTexts[clusterIndex] = append(clusteredTexts[clusterIndex], indexed.index)
         }
     }
 
-    return clusteredTexts
+    return clusteredTexts, nil
 }
 
 
@@ -131,13 +161,12 @@ func GenerateSchema[T any]() interface{} {
 	return schema
 }
 
-var TicketResponseSchema = GenerateSchema[TicketResponse]()
+var TicketResponseSchema = GenerateSchema[clusterLabels]()
 func generateTicketDescriptions(clusters [][]string) (*TicketResponse, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-
-	client := openai.NewClient(
-		option.WithAPIKey(apiKey),
-	)
+	provider, err := llm.Default()
+	if err != nil {
+		return nil, err
+	}
 
 	promptEngineering := "You are a helpful assistant. Can you provide a short summary of the main features of these products? Write a very short (5 words maximum title that summarizes all of them collectively). Usually, you would include the product name that most correlates to those incident reports."
 
@@ -146,56 +175,88 @@ func generateTicketDescriptions(clusters [][]string) (*TicketResponse, error) {
 		return nil, fmt.Errorf("error marshaling clusters: %v", err)
 	}
 
-	content := fmt.Sprintf("Classify the following clusters:\n%s", string(clustersJSON))
-
-	schemaParam := openai.ResponseFormatJSONSchemaJSONSchemaParam{
-		Name:        openai.F("ticket_response"),
-		Description: openai.F("Clustered ticket descriptions"),
-		Schema:      openai.F(TicketResponseSchema),
-		Strict:      openai.Bool(true),
-	}
+	content := fmt.Sprintf("Classify the following clusters, returning one description per cluster in the same order:\n%s", string(clustersJSON))
 
-	chat, err := client.Chat.Completions.New(context.TODO(), openai.ChatCompletionNewParams{
-		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
-			openai.SystemMessage(promptEngineering),
-			openai.UserMessage(content),
-		}),
-		ResponseFormat: openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
-			openai.ResponseFormatJSONSchemaParam{
-				Type:       openai.F(openai.ResponseFormatJSONSchemaTypeJSONSchema),
-				JSONSchema: openai.F(schemaParam),
-			},
-		),
-		Model: openai.F(openai.ChatModelGPT4o2024_08_06),
-	})
+	var response clusterLabels
+	err = provider.CompleteJSON(context.TODO(), llm.Request{
+		Messages: []llm.Message{
+			llm.System(promptEngineering),
+			llm.User(content),
+		},
+	}, llm.Schema{
+		Name:        "ticket_response",
+		Description: "Clustered ticket descriptions",
+		Schema:      TicketResponseSchema,
+	}, &response)
 
 	if err != nil {
-		return nil, fmt.Errorf("error getting chat completion: %v", err)
+		return nil, err
 	}
 
-	if len(chat.Choices) == 0 {
-		return nil, fmt.Errorf("no response from the model")
+	if len(response.Clusters) != len(clusters) {
+		log.Printf("Expected %d cluster descriptions, got %d", len(clusters), len(response.Clusters))
 	}
 
-	var response TicketResponse
-	err = json.Unmarshal([]byte(chat.Choices[0].Message.Content), &response)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing JSON response: %v", err)
+	// Every cluster is kept whatever the model returned; unlabeled ones get a
+	// placeholder.
+	entries := make([]ClusterEntry, len(clusters))
+	for i, texts := range clusters {
+		entries[i] = ClusterEntry{
+			ClusterDescription: fmt.Sprintf("Cluster %d", i+1),
+			TextEntries:        texts,
+		}
+		if i < len(response.Clusters) && strings.TrimSpace(response.Clusters[i].ClusterDescription) != "" {
+			entries[i].ClusterDescription = response.Clusters[i].ClusterDescription
+		}
 	}
 
-	return &response, nil
+	return &TicketResponse{Clusters: entries}, nil
 }
 
 
 func TFIDFKMeansClustering(documents []string) (TicketResponse, error) {
+    return TFIDFKMeansClusteringWithOptions(documents, ClusteringOptions{})
+}
+
+func TFIDFKMeansClusteringWithOptions(documents []string, opts ClusteringOptions) (TicketResponse, error) {
     vectorizer := NewTFIDFVectorizer()
     tfidfMatrix := vectorizer.FitTransform(documents)
 
-    numClusters := 3
-    clusteredTexts := clusterTexts(tfidfMatrix, numClusters)
+    response, err := clusterMatrix(documents, tfidfMatrix, opts, euclideanMetric)
+    if err != nil {
+        return TicketResponse{}, err
+    }
+    response.Mode = ClusteringTFIDF
+    return response, nil
+}
+
+// clusterMatrix clusters the documents by their rows in matrix and has the
+// model describe each cluster.
+func clusterMatrix(documents []string, matrix [][]float64, opts ClusteringOptions, m metric) (TicketResponse, error) {
+    clusterer, err := newClusterer(opts, m)
+    if err != nil {
+        return TicketResponse{}, err
+    }
+
+    result, err := clusterer.Cluster(matrix)
+    if err != nil {
+        return TicketResponse{}, err
+    }
+
+    algorithm := opts.Algorithm
+    if algorithm == "" {
+        algorithm = AlgorithmKMeans
+    }
+
+    noise := result.Noise
+
+    numClusters := len(result.Clusters)
+    if numClusters == 0 {
+        return TicketResponse{Clusters: []ClusterEntry{}, Algorithm: algorithm, Noise: noise, Dendrogram: result.Dendrogram}, nil
+    }
 
     clusters := make([][]string, numClusters)
-    for clusterIndex, textIndices := range clusteredTexts {
+    for clusterIndex, textIndices := range result.Clusters {
         for _, textIndex := range textIndices {
             clusters[clusterIndex] = append(clusters[clusterIndex], documents[textIndex])
         }
@@ -206,9 +267,14 @@ func TFIDFKMeansClustering(documents []string) (TicketResponse, error) {
         return TicketResponse{}, fmt.Errorf("Error generating ticket descriptions: %v", err) 
     }
 
-    if len(response.Clusters) != numClusters {
-        fmt.Printf("Expected %d clusters, got %d", numClusters, len(response.Clusters))
+    for clusterIndex, textIndices := range result.Clusters {
+        response.Clusters[clusterIndex].Members = textIndices
     }
 
+    response.NumClusters = numClusters
+    response.SilhouetteScore = result.SilhouetteScore
+    response.Algorithm = algorithm
+    response.Noise = noise
+    response.Dendrogram = result.Dendrogram
     return *response, nil 
 }
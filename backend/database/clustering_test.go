@@ -1,19 +1,45 @@
 package database
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"math"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 
-	"github.com/muesli/clusters"
+	"github.com/davidulloa/mimir/llm"
+	"github.com/davidulloa/mimir/llm/llmtest"
 )
 
-func TestGenerateTicketDescriptions(t *testing.T) {
+// useClusterLabeller installs a fake model that labels every cluster it is
+// asked to classify.
+func useClusterLabeller(t *testing.T) *llm.Fake {
+	fake := llmtest.UseFake(t)
+	fake.Responder = func(req llm.Request) (string, error) {
+		content := req.Messages[len(req.Messages)-1].Content
+		var clusters [][]string
+		if err := json.Unmarshal([]byte(content[strings.Index(content, "\n")+1:]), &clusters); err != nil {
+			return "", err
+		}
 
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		t.Fatal("OPENAI_API_KEY is not set in the environment")
+		response := clusterLabels{}
+		for i := range clusters {
+			response.Clusters = append(response.Clusters, clusterLabel{
+				ClusterDescription: fmt.Sprintf("Cluster %d", i+1),
+			})
+		}
+		data, err := json.Marshal(response)
+		return string(data), err
 	}
+	return fake
+}
+
+func TestGenerateTicketDescriptions(t *testing.T) {
+	useClusterLabeller(t)
 
 	clusters := [][]string{
 		{"apple", "banana", "orange"},
@@ -107,22 +133,18 @@ func TestTFIDFVectorizerFitTransform(t *testing.T) {
 	fmt.Println("Vocabulary:", vectorizer.Vocabulary)
 }
 
-func TestCoordinatesEqual(t *testing.T) {
-	tests := []struct {
-		a, b     clusters.Coordinates
-		expected bool
-	}{
-		{clusters.Coordinates{1, 2, 3}, clusters.Coordinates{1, 2, 3}, true},
-		{clusters.Coordinates{1, 2, 3}, clusters.Coordinates{1, 2, 4}, false},
-		{clusters.Coordinates{1, 2, 3}, clusters.Coordinates{1, 2}, false},
-		{clusters.Coordinates{}, clusters.Coordinates{}, true},
-	}
+func TestTFIDFVectorizerCountsWholeTokens(t *testing.T) {
+	vectorizer := NewTFIDFVectorizer()
+	vectors := vectorizer.FitTransform([]string{"VPN vpn down", "a password reset"})
 
-	for _, test := range tests {
-		result := coordinatesEqual(test.a, test.b)
-		if result != test.expected {
-			t.Errorf("coordinatesEqual(%v, %v) = %v; want %v", test.a, test.b, result, test.expected)
-		}
+	// "a" is also inside "password", and "VPN" differs from "vpn" in case;
+	// only whole lowercased tokens count.
+	idf := math.Log(2)
+	if got, want := vectors[1][vectorizer.Vocabulary["a"]], idf/3; math.Abs(got-want) > 1e-9 {
+		t.Errorf("tf-idf of \"a\" = %v; want %v", got, want)
+	}
+	if got, want := vectors[0][vectorizer.Vocabulary["vpn"]], 2*idf/3; math.Abs(got-want) > 1e-9 {
+		t.Errorf("tf-idf of \"vpn\" = %v; want %v", got, want)
 	}
 }
 
@@ -136,7 +158,10 @@ func TestClusterTexts(t *testing.T) {
 	}
 	numClusters := 3
 
-	result := clusterTexts(tfidfMatrix, numClusters)
+	result, err := clusterTexts(tfidfMatrix, numClusters)
+	if err != nil {
+		t.Fatalf("clusterTexts returned error: %v", err)
+	}
 
 	if len(result) != numClusters {
 		t.Errorf("Expected %d clusters, got %d", numClusters, len(result))
@@ -166,6 +191,45 @@ func TestClusterTexts(t *testing.T) {
 	}
 }
 
+func TestClusterTextsKeepsDuplicateRows(t *testing.T) {
+	matrix := [][]float64{{1, 0}, {1, 0}, {0, 1}, {0, 1}}
+
+	result, err := clusterTexts(matrix, 2)
+	if err != nil {
+		t.Fatalf("clusterTexts returned error: %v", err)
+	}
+
+	var rows []int
+	for _, indices := range result {
+		rows = append(rows, indices...)
+	}
+	sort.Ints(rows)
+	if !reflect.DeepEqual(rows, []int{0, 1, 2, 3}) {
+		t.Errorf("Expected every row exactly once, got %v", rows)
+	}
+}
+
+func TestGenerateTicketDescriptionsKeepsUnlabeledClusters(t *testing.T) {
+	// The model rewrites entries and labels only one of two clusters.
+	llmtest.UseFake(t, `{"clusters":[{"cluster_description":"Passwords","text_entries":["rewritten"]}]}`)
+
+	clusters := [][]string{{"reset password", "reset password"}, {"printer jammed"}}
+	response, err := generateTicketDescriptions(clusters)
+	if err != nil {
+		t.Fatalf("Error generating ticket descriptions: %v", err)
+	}
+
+	if len(response.Clusters) != 2 {
+		t.Fatalf("Expected 2 clusters, got %d", len(response.Clusters))
+	}
+	if response.Clusters[0].ClusterDescription != "Passwords" || response.Clusters[1].ClusterDescription != "Cluster 2" {
+		t.Errorf("Unexpected descriptions: %q, %q", response.Clusters[0].ClusterDescription, response.Clusters[1].ClusterDescription)
+	}
+	if !reflect.DeepEqual(response.Clusters[0].TextEntries, clusters[0]) {
+		t.Errorf("Expected the original entries, got %v", response.Clusters[0].TextEntries)
+	}
+}
+
 func TestClusterEntry(t *testing.T) {
 	entry := ClusterEntry{
 		ClusterDescription: "Test Cluster",
@@ -214,6 +278,8 @@ func TestTicketResponse(t *testing.T) {
 }
 
 func TestTFIDFKMeansClustering(t *testing.T) {
+	useClusterLabeller(t)
+
 	documents := []string{
 		"Jira wasn't working for me this morning, couldn't log in after several tries.",
 		"Confluence kept crashing every time I tried to save a page, super frustrating.",
@@ -243,7 +309,10 @@ func TestTFIDFKMeansClustering(t *testing.T) {
 	tfidfMatrix := vectorizer.FitTransform(documents)
 
 	numClusters := 3
-	clusteredTexts := clusterTexts(tfidfMatrix, numClusters)
+	clusteredTexts, err := clusterTexts(tfidfMatrix, numClusters)
+	if err != nil {
+		t.Fatalf("clusterTexts returned error: %v", err)
+	}
 
 	clusters := make([][]string, numClusters)
 	for clusterIndex, textIndices := range clusteredTexts {
@@ -281,3 +350,248 @@ func TestTFIDFKMeansClustering(t *testing.T) {
 		fmt.Println()
 	}
 }
+
+func TestSilhouetteScore(t *testing.T) {
+	matrix := [][]float64{
+		{0, 0}, {0, 1},
+		{10, 10}, {10, 11},
+	}
+
+	separated := silhouetteScore(matrix, map[int][]int{0: {0, 1}, 1: {2, 3}})
+	if separated < 0.8 {
+		t.Errorf("Expected well separated clusters to score above 0.8, got %f", separated)
+	}
+
+	mixed := silhouetteScore(matrix, map[int][]int{0: {0, 2}, 1: {1, 3}})
+	if mixed >= 0 {
+		t.Errorf("Expected mixed clusters to score below 0, got %f", mixed)
+	}
+
+	if score := silhouetteScore(matrix, map[int][]int{0: {0, 1, 2, 3}}); score != 0 {
+		t.Errorf("Expected a single cluster to score 0, got %f", score)
+	}
+}
+
+func TestClusterRange(t *testing.T) {
+	tests := []struct {
+		opts       ClusteringOptions
+		n          int
+		minK, maxK int
+		wantErr    bool
+	}{
+		{ClusteringOptions{}, 20, DefaultMinClusters, DefaultMaxClusters, false},
+		{ClusteringOptions{}, 5, 2, 4, false},
+		{ClusteringOptions{}, 2, 1, 1, false},
+		{ClusteringOptions{}, 0, 0, 0, false},
+		{ClusteringOptions{NumClusters: 4}, 20, 4, 4, false},
+		{ClusteringOptions{MinClusters: 3, MaxClusters: 5}, 20, 3, 5, false},
+		{ClusteringOptions{NumClusters: 30}, 20, 0, 0, true},
+		{ClusteringOptions{MinClusters: 6, MaxClusters: 3}, 20, 0, 0, true},
+		{ClusteringOptions{MaxClusters: ClusterLimit}, 100, DefaultMinClusters, ClusterLimit, false},
+		{ClusteringOptions{MaxClusters: ClusterLimit + 1}, 100, 0, 0, true},
+		{ClusteringOptions{MinClusters: 1000, MaxClusters: 1000}, 5000, 0, 0, true},
+	}
+
+	for _, test := range tests {
+		minK, maxK, err := test.opts.clusterRange(test.n)
+		if (err != nil) != test.wantErr {
+			t.Errorf("clusterRange(%+v, %d) error = %v; wantErr %v", test.opts, test.n, err, test.wantErr)
+			continue
+		}
+		if minK != test.minK || maxK != test.maxK {
+			t.Errorf("clusterRange(%+v, %d) = [%d, %d]; want [%d, %d]", test.opts, test.n, minK, maxK, test.minK, test.maxK)
+		}
+	}
+}
+
+func TestSelectClusters(t *testing.T) {
+	// k-means seeds its centers in the unit cube, like TF-IDF weights.
+	matrix := [][]float64{
+		{0, 0}, {0, 0.05}, {0.05, 0},
+		{0.5, 0.5}, {0.5, 0.55}, {0.55, 0.5},
+		{0, 0.95}, {0.05, 0.95}, {0, 1},
+	}
+
+	k, clustered, score, err := selectClusters(matrix, ClusteringOptions{MinClusters: 2, MaxClusters: 5})
+	if err != nil {
+		t.Fatalf("selectClusters returned error: %v", err)
+	}
+
+	if k != 3 {
+		t.Errorf("Expected 3 clusters to be selected, got %d", k)
+	}
+	if score < 0.8 {
+		t.Errorf("Expected silhouette score above 0.8, got %f", score)
+	}
+
+	total := 0
+	for _, indices := range clustered {
+		total += len(indices)
+	}
+	if total != len(matrix) {
+		t.Errorf("Expected %d clustered points, got %d", len(matrix), total)
+	}
+}
+
+// offlineEmbedder is a fake whose embedding server is unreachable.
+type offlineEmbedder struct {
+	*llm.Fake
+}
+
+func (offlineEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestClusterDocumentsWithEmbeddings(t *testing.T) {
+	useClusterLabeller(t)
+
+	documents := []string{
+		"reset my password",
+		"password reset please",
+		"printer jammed again",
+		"the printer is jammed",
+	}
+
+	response, err := ClusterDocuments(context.Background(), documents, ClusteringOptions{Mode: ClusteringEmbedding, NumClusters: 2})
+	if err != nil {
+		t.Fatalf("ClusterDocuments returned error: %v", err)
+	}
+
+	if response.Mode != ClusteringEmbedding {
+		t.Errorf("Expected mode %q, got %q", ClusteringEmbedding, response.Mode)
+	}
+	if len(response.Clusters) != 2 {
+		t.Fatalf("Expected 2 clusters, got %d", len(response.Clusters))
+	}
+	for i, cluster := range response.Clusters {
+		if len(cluster.TextEntries) != 2 {
+			t.Errorf("Cluster %d: expected 2 entries, got %v", i, cluster.TextEntries)
+			continue
+		}
+		// Both entries share the cluster's topic word.
+		topic := "password"
+		if strings.Contains(cluster.TextEntries[0], "printer") {
+			topic = "printer"
+		}
+		if !strings.Contains(cluster.TextEntries[1], topic) {
+			t.Errorf("Cluster %d mixes topics: %v", i, cluster.TextEntries)
+		}
+	}
+	if response.SilhouetteScore <= 0 {
+		t.Errorf("Expected a positive silhouette score, got %f", response.SilhouetteScore)
+	}
+}
+
+func TestClusterDocumentsFallsBackToTFIDF(t *testing.T) {
+	fake := useClusterLabeller(t)
+	llm.SetDefault(offlineEmbedder{fake})
+
+	documents := []string{"reset my password", "password reset", "printer jammed", "printer jammed again"}
+	response, err := ClusterDocuments(context.Background(), documents, ClusteringOptions{Mode: ClusteringEmbedding, NumClusters: 2})
+	if err != nil {
+		t.Fatalf("ClusterDocuments returned error: %v", err)
+	}
+	if response.Mode != ClusteringTFIDF {
+		t.Errorf("Expected fallback to %q, got %q", ClusteringTFIDF, response.Mode)
+	}
+
+	if _, err := ClusterDocuments(context.Background(), documents, ClusteringOptions{Mode: "bogus"}); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
+
+func TestCosineDistance(t *testing.T) {
+	if d := cosineDistance([]float64{1, 0}, []float64{2, 0}); d > 1e-9 {
+		t.Errorf("Expected parallel vectors to have distance 0, got %f", d)
+	}
+	if d := cosineDistance([]float64{1, 0}, []float64{0, 3}); d != 1 {
+		t.Errorf("Expected orthogonal vectors to have distance 1, got %f", d)
+	}
+}
+
+// threeGroups is three tight groups of three points plus, optionally, an outlier.
+func threeGroups(outlier bool) [][]float64 {
+	matrix := [][]float64{
+		{0, 0}, {0, 0.05}, {0.05, 0},
+		{0.5, 0.5}, {0.5, 0.55}, {0.55, 0.5},
+		{0, 0.95}, {0.05, 0.95}, {0, 1},
+	}
+	if outlier {
+		matrix = append(matrix, []float64{1, 0})
+	}
+	return matrix
+}
+
+func TestDBSCANReportsNoise(t *testing.T) {
+	for _, epsilon := range []float64{0.1, 0} {
+		result, err := (&DBSCAN{Epsilon: epsilon, MinPoints: 3}).Cluster(threeGroups(true))
+		if err != nil {
+			t.Fatalf("DBSCAN returned error: %v", err)
+		}
+
+		if len(result.Clusters) != 3 {
+			t.Errorf("epsilon %v: expected 3 clusters, got %v", epsilon, result.Clusters)
+		}
+		if !reflect.DeepEqual(result.Noise, []int{9}) {
+			t.Errorf("epsilon %v: expected the outlier as noise, got %v", epsilon, result.Noise)
+		}
+		if result.SilhouetteScore < 0.8 {
+			t.Errorf("epsilon %v: expected silhouette score above 0.8, got %f", epsilon, result.SilhouetteScore)
+		}
+	}
+}
+
+func TestAgglomerativeDendrogram(t *testing.T) {
+	for _, linkage := range []string{LinkageAverage, LinkageComplete, LinkageSingle} {
+		result, err := (&Agglomerative{Options: ClusteringOptions{Linkage: linkage, MinClusters: 2, MaxClusters: 5}}).Cluster(threeGroups(false))
+		if err != nil {
+			t.Fatalf("%s: Agglomerative returned error: %v", linkage, err)
+		}
+
+		expected := [][]int{{0, 1, 2}, {3, 4, 5}, {6, 7, 8}}
+		if !reflect.DeepEqual(result.Clusters, expected) {
+			t.Errorf("%s: expected clusters %v, got %v", linkage, expected, result.Clusters)
+		}
+
+		root := result.Dendrogram
+		if root == nil || root.Size != 9 || len(root.leaves(nil)) != 9 {
+			t.Fatalf("%s: expected a dendrogram over 9 rows, got %+v", linkage, root)
+		}
+		if root.Index != -1 || len(root.Children) != 2 {
+			t.Errorf("%s: expected the root to merge two children", linkage)
+		}
+	}
+
+	// Cutting below the merges between groups gives the groups back.
+	result, err := (&Agglomerative{Options: ClusteringOptions{DistanceThreshold: 0.2}}).Cluster(threeGroups(false))
+	if err != nil {
+		t.Fatalf("Agglomerative returned error: %v", err)
+	}
+	if len(result.Clusters) != 3 {
+		t.Errorf("Expected 3 clusters below the threshold, got %v", result.Clusters)
+	}
+}
+
+func TestClusterMatrixReportsNoise(t *testing.T) {
+	useClusterLabeller(t)
+
+	documents := []string{"a1", "a2", "a3", "b1", "b2", "b3", "c1", "c2", "c3", "outlier"}
+	response, err := clusterMatrix(documents, threeGroups(true), ClusteringOptions{Algorithm: AlgorithmDBSCAN, Epsilon: 0.1}, euclideanMetric)
+	if err != nil {
+		t.Fatalf("clusterMatrix returned error: %v", err)
+	}
+
+	if response.Algorithm != AlgorithmDBSCAN {
+		t.Errorf("Expected algorithm %q, got %q", AlgorithmDBSCAN, response.Algorithm)
+	}
+	if response.NumClusters != 3 || len(response.Clusters) != 3 {
+		t.Errorf("Expected 3 described clusters, got %d", len(response.Clusters))
+	}
+	if !reflect.DeepEqual(response.Noise, []int{9}) {
+		t.Errorf("Expected the outlier as noise, got %v", response.Noise)
+	}
+
+	if _, err := clusterMatrix(documents, threeGroups(true), ClusteringOptions{Algorithm: "spectral"}, euclideanMetric); err == nil {
+		t.Error("Expected an error for an unknown algorithm")
+	}
+}
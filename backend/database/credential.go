@@ -0,0 +1,124 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
+)
+
+const (
+	CredentialClass = "Credential"
+)
+
+// CredentialRecord holds an instance's encrypted ServiceNow credentials. The
+// store never sees them in the clear; see the vault package.
+type CredentialRecord struct {
+	ID         string    `json:"id"`
+	InstanceID string    `json:"instanceID"`
+	KeyID      string    `json:"keyID"`
+	Ciphertext string    `json:"ciphertext"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+var credentialFields = []string{"instanceID", "keyID", "ciphertext", "updatedAt", "_additional { id }"}
+
+func (s *WeaviateStore) findCredentials(where *filters.WhereBuilder) ([]CredentialRecord, error) {
+	client := s.client
+
+	graphqlFields := make([]graphql.Field, len(credentialFields))
+	for i, field := range credentialFields {
+		graphqlFields[i] = graphql.Field{Name: field}
+	}
+
+	query := client.GraphQL().Get().
+		WithClassName(CredentialClass).
+		WithFields(graphqlFields...)
+	if where != nil {
+		query = query.WithWhere(where)
+	}
+
+	response, err := query.Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	getObject, ok := response.Data["Get"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("unable to parse 'Get' from response data")
+	}
+
+	classObjects, ok := getObject[CredentialClass].([]interface{})
+	if !ok {
+		return nil, errors.New("unable to parse 'Credential' class from class object")
+	}
+
+	records := make([]CredentialRecord, 0, len(classObjects))
+	for _, classObject := range classObjects {
+		obj, ok := classObject.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		var record CredentialRecord
+		record.InstanceID, _ = obj["instanceID"].(string)
+		record.KeyID, _ = obj["keyID"].(string)
+		record.Ciphertext, _ = obj["ciphertext"].(string)
+		if updatedAt, ok := obj["updatedAt"].(string); ok {
+			record.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+		}
+		if additional, ok := obj["_additional"].(map[string]interface{}); ok {
+			record.ID, _ = additional["id"].(string)
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func (s *WeaviateStore) FindCredential(instanceID string) (*CredentialRecord, error) {
+	records, err := s.findCredentials(filters.Where().WithPath([]string{"instanceID"}).WithOperator(filters.Equal).WithValueString(instanceID))
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	return &records[0], nil
+}
+
+func (s *WeaviateStore) ListCredentials() ([]CredentialRecord, error) {
+	return s.findCredentials(nil)
+}
+
+func (s *WeaviateStore) SaveCredential(record CredentialRecord) error {
+	client := s.client
+
+	existing, err := s.FindCredential(record.InstanceID)
+	if err != nil {
+		return err
+	}
+
+	properties := map[string]interface{}{
+		"instanceID": record.InstanceID,
+		"keyID":      record.KeyID,
+		"ciphertext": record.Ciphertext,
+		"updatedAt":  record.UpdatedAt,
+	}
+
+	if existing != nil {
+		return client.Data().Updater().
+			WithID(existing.ID).
+			WithClassName(CredentialClass).
+			WithProperties(properties).
+			Do(context.Background())
+	}
+
+	_, err = client.Data().Creator().
+		WithClassName(CredentialClass).
+		WithProperties(properties).
+		Do(context.Background())
+	return err
+}
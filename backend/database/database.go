@@ -50,3 +50,23 @@ func GetWeaviateClient() (*weaviate.Client, error) {
     }
     return weaviateClient, nil
 }
+
+// WeaviateStore is the Store backed by the hosted Weaviate cluster.
+type WeaviateStore struct {
+    client *weaviate.Client
+}
+
+func NewWeaviateStore() (*WeaviateStore, error) {
+    client, err := GetWeaviateClient()
+    if err != nil {
+        return nil, err
+    }
+    store := &WeaviateStore{client: client}
+    store.ensureProperties()
+    return store, nil
+}
+
+// Close is a no-op; the Weaviate client holds no resources that need releasing.
+func (s *WeaviateStore) Close() error {
+    return nil
+}
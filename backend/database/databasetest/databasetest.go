@@ -0,0 +1,26 @@
+// Package databasetest sets up stores for the tests of packages that use the
+// database package.
+package databasetest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/davidulloa/mimir/database"
+)
+
+// UseBoltStore opens an empty bolt store in a temporary directory and makes it
+// the package store until the test ends.
+func UseBoltStore(t testing.TB) *database.BoltStore {
+	t.Helper()
+	s, err := database.OpenBoltStore(filepath.Join(t.TempDir(), "mimir.db"))
+	if err != nil {
+		t.Fatalf("opening bolt store: %v", err)
+	}
+	database.SetStore(s)
+	t.Cleanup(func() {
+		database.SetStore(nil)
+		s.Close()
+	})
+	return s
+}
@@ -0,0 +1,214 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/davidulloa/mimir/models"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
+)
+
+const (
+	DocumentChunkClass = "DocumentChunk"
+)
+
+func ReplaceDocumentChunks(acceleratorID string, chunks []models.DocumentChunk) error {
+	s, err := GetStore()
+	if err != nil {
+		return err
+	}
+	return s.ReplaceDocumentChunks(acceleratorID, chunks)
+}
+
+func SearchDocumentChunks(acceleratorID string, vector []float32, k int) ([]models.DocumentChunk, error) {
+	s, err := GetStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.SearchDocumentChunks(acceleratorID, vector, k)
+}
+
+func CountDocumentChunks(acceleratorID string) (int, error) {
+	s, err := GetStore()
+	if err != nil {
+		return 0, err
+	}
+	return s.CountDocumentChunks(acceleratorID)
+}
+
+// cosineSimilarity returns the cosine of the angle between a and b, or 0 if
+// either is empty or zero or their lengths differ.
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// nearestChunks ranks chunks by similarity to vector and returns the top k.
+func nearestChunks(chunks []models.DocumentChunk, vector []float32, k int) []models.DocumentChunk {
+	scores := make([]float64, len(chunks))
+	order := make([]int, len(chunks))
+	for i, chunk := range chunks {
+		scores[i] = cosineSimilarity(chunk.Vector, vector)
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+
+	if k > len(order) {
+		k = len(order)
+	}
+	nearest := make([]models.DocumentChunk, k)
+	for i := range nearest {
+		nearest[i] = chunks[order[i]]
+	}
+	return nearest
+}
+
+func acceleratorFilter(acceleratorID string) *filters.WhereBuilder {
+	return filters.Where().
+		WithPath([]string{"acceleratorID"}).
+		WithOperator(filters.Equal).
+		WithValueString(acceleratorID)
+}
+
+func (s *WeaviateStore) ReplaceDocumentChunks(acceleratorID string, chunks []models.DocumentChunk) error {
+	client := s.client
+	ctx := context.Background()
+
+	exists, err := client.Schema().ClassExistenceChecker().WithClassName(DocumentChunkClass).Do(ctx)
+	if err != nil {
+		return err
+	}
+	if exists {
+		_, err = client.Batch().ObjectsBatchDeleter().
+			WithClassName(DocumentChunkClass).
+			WithWhere(acceleratorFilter(acceleratorID)).
+			Do(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, chunk := range chunks {
+		_, err := client.Data().Creator().
+			WithClassName(DocumentChunkClass).
+			WithProperties(map[string]interface{}{
+				"acceleratorID": acceleratorID,
+				"url":           chunk.URL,
+				"title":         chunk.Title,
+				"position":      chunk.Position,
+				"content":       chunk.Content,
+			}).
+			WithVector(chunk.Vector).
+			Do(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *WeaviateStore) SearchDocumentChunks(acceleratorID string, vector []float32, k int) ([]models.DocumentChunk, error) {
+	client := s.client
+
+	fields := []string{"acceleratorID", "url", "title", "position", "content", "_additional { id }"}
+	graphqlFields := make([]graphql.Field, len(fields))
+	for i, field := range fields {
+		graphqlFields[i] = graphql.Field{Name: field}
+	}
+
+	response, err := client.GraphQL().Get().
+		WithClassName(DocumentChunkClass).
+		WithFields(graphqlFields...).
+		WithWhere(acceleratorFilter(acceleratorID)).
+		WithNearVector(client.GraphQL().NearVectorArgBuilder().WithVector(vector)).
+		WithLimit(k).
+		Do(context.Background())
+
+	if err != nil {
+		return nil, err
+	}
+
+	getObject, ok := response.Data["Get"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("unable to parse 'Get' from response data")
+	}
+
+	classObjects, ok := getObject[DocumentChunkClass].([]interface{})
+	if !ok {
+		return nil, errors.New("unable to parse 'DocumentChunk' class from class object")
+	}
+
+	chunks := make([]models.DocumentChunk, 0, len(classObjects))
+	for _, classObject := range classObjects {
+		obj, ok := classObject.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		chunk := models.DocumentChunk{AcceleratorID: acceleratorID}
+		chunk.URL, _ = obj["url"].(string)
+		chunk.Title, _ = obj["title"].(string)
+		chunk.Content, _ = obj["content"].(string)
+		if position, ok := obj["position"].(float64); ok {
+			chunk.Position = int(position)
+		}
+		if additional, ok := obj["_additional"].(map[string]interface{}); ok {
+			chunk.ID, _ = additional["id"].(string)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, nil
+}
+
+func (s *WeaviateStore) CountDocumentChunks(acceleratorID string) (int, error) {
+	client := s.client
+
+	exists, err := client.Schema().ClassExistenceChecker().WithClassName(DocumentChunkClass).Do(context.Background())
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	response, err := client.GraphQL().Aggregate().
+		WithClassName(DocumentChunkClass).
+		WithWhere(acceleratorFilter(acceleratorID)).
+		WithFields(graphql.Field{Name: "meta", Fields: []graphql.Field{{Name: "count"}}}).
+		Do(context.Background())
+
+	if err != nil {
+		return 0, err
+	}
+
+	aggregate, ok := response.Data["Aggregate"].(map[string]interface{})
+	if !ok {
+		return 0, errors.New("unable to parse 'Aggregate' from response data")
+	}
+
+	results, ok := aggregate[DocumentChunkClass].([]interface{})
+	if !ok || len(results) == 0 {
+		return 0, nil
+	}
+
+	result, _ := results[0].(map[string]interface{})
+	meta, _ := result["meta"].(map[string]interface{})
+	count, _ := meta["count"].(float64)
+	return int(count), nil
+}
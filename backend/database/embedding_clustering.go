@@ -0,0 +1,172 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+
+	"github.com/davidulloa/mimir/llm"
+)
+
+const (
+	// ClusteringTFIDF clusters TF-IDF vectors of the raw text. It needs no
+	// model and is the default.
+	ClusteringTFIDF = "tfidf"
+	// ClusteringEmbedding clusters sentence embeddings from the LLM provider
+	// by cosine distance, so tickets worded differently can still group.
+	ClusteringEmbedding = "embedding"
+)
+
+// ClusterDocuments clusters documents using the vectors opts.Mode selects and
+// has the model describe each cluster. If the documents can't be embedded,
+// e.g. because the embedding server is offline, it falls back to TF-IDF; the
+// response's Mode says which was used.
+func ClusterDocuments(ctx context.Context, documents []string, opts ClusteringOptions) (TicketResponse, error) {
+	switch opts.Mode {
+	case "", ClusteringTFIDF:
+		return TFIDFKMeansClusteringWithOptions(documents, opts)
+	case ClusteringEmbedding:
+	default:
+		return TicketResponse{}, fmt.Errorf("unknown clustering mode %q", opts.Mode)
+	}
+
+	matrix, err := embedDocuments(ctx, documents)
+	if err != nil {
+		log.Printf("Error embedding tickets, falling back to TF-IDF: %v", err)
+		return TFIDFKMeansClusteringWithOptions(documents, opts)
+	}
+
+	response, err := clusterMatrix(documents, matrix, opts, cosineMetric)
+	if err != nil {
+		return TicketResponse{}, err
+	}
+	response.Mode = ClusteringEmbedding
+	return response, nil
+}
+
+// embedDocuments embeds each document and scales the vectors to unit length.
+func embedDocuments(ctx context.Context, documents []string) ([][]float64, error) {
+	if len(documents) == 0 {
+		return nil, nil
+	}
+
+	provider, err := llm.Default()
+	if err != nil {
+		return nil, err
+	}
+
+	vectors, err := provider.Embed(ctx, documents)
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != len(documents) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(documents), len(vectors))
+	}
+
+	matrix := make([][]float64, len(vectors))
+	for i, vector := range vectors {
+		var norm float64
+		for _, x := range vector {
+			norm += float64(x) * float64(x)
+		}
+		norm = math.Sqrt(norm)
+
+		row := make([]float64, len(vector))
+		for j, x := range vector {
+			if norm > 0 {
+				row[j] = float64(x) / norm
+			}
+		}
+		matrix[i] = row
+	}
+	return matrix, nil
+}
+
+// sphericalKMeansIterations bounds the assignment/update rounds of one run.
+const sphericalKMeansIterations = 100
+
+// sphericalKMeans partitions unit vectors into k clusters by cosine distance.
+// Centers are seeded with k-means++ from the points themselves: the random
+// seeds in the unit cube that clusterTexts uses all sit far from sparse,
+// high-dimensional embeddings.
+func sphericalKMeans(matrix [][]float64, k int) (map[int][]int, error) {
+	n := len(matrix)
+	if k <= 0 || n == 0 {
+		return map[int][]int{}, nil
+	}
+	if k > n {
+		k = n
+	}
+
+	centers := [][]float64{matrix[rand.Intn(n)]}
+	nearest := make([]float64, n)
+	for len(centers) < k {
+		var total float64
+		for i, point := range matrix {
+			nearest[i] = math.Inf(1)
+			for _, center := range centers {
+				nearest[i] = math.Min(nearest[i], cosineDistance(point, center))
+			}
+			nearest[i] *= nearest[i]
+			total += nearest[i]
+		}
+
+		next := rand.Intn(n)
+		if total > 0 {
+			target := rand.Float64() * total
+			for i, d := range nearest {
+				target -= d
+				if target <= 0 {
+					next = i
+					break
+				}
+			}
+		}
+		centers = append(centers, matrix[next])
+	}
+
+	assignment := make([]int, n)
+	for i := range assignment {
+		assignment[i] = -1
+	}
+
+	for iteration := 0; iteration < sphericalKMeansIterations; iteration++ {
+		changed := false
+		for i, point := range matrix {
+			best, bestDistance := 0, math.Inf(1)
+			for c, center := range centers {
+				if d := cosineDistance(point, center); d < bestDistance {
+					best, bestDistance = c, d
+				}
+			}
+			if assignment[i] != best {
+				assignment[i] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+
+		for c := range centers {
+			center := make([]float64, len(matrix[0]))
+			for i, point := range matrix {
+				if assignment[i] != c {
+					continue
+				}
+				for j, x := range point {
+					center[j] += x
+				}
+			}
+			centers[c] = center
+		}
+	}
+
+	clustered := make(map[int][]int)
+	for i, c := range assignment {
+		clustered[c] = append(clustered[c], i)
+	}
+	return clustered, nil
+}
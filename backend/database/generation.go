@@ -0,0 +1,243 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/davidulloa/mimir/models"
+	"github.com/google/uuid"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
+)
+
+const (
+	GenerationJobClass   = "GenerationJob"
+	GenerationClaimClass = "GenerationClaim"
+)
+
+func SaveGenerationJob(job *models.GenerationJob) error {
+	s, err := GetStore()
+	if err != nil {
+		return err
+	}
+	return s.SaveGenerationJob(job)
+}
+
+func GetGenerationJobs(threadID string, statuses ...string) ([]models.GenerationJob, error) {
+	s, err := GetStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetGenerationJobs(threadID, statuses...)
+}
+
+// hasStatus reports whether the job has one of statuses, or statuses is empty.
+func hasStatus(job models.GenerationJob, statuses []string) bool {
+	if len(statuses) == 0 {
+		return true
+	}
+	for _, status := range statuses {
+		if job.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+func ClaimGenerationJob(threadID string, jobID string, owner string, lease time.Duration) (*models.GenerationJob, error) {
+	s, err := GetStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.ClaimGenerationJob(threadID, jobID, owner, lease)
+}
+
+// nextGenerationJob returns the index in a thread's jobs, oldest first, of
+// jobID if owner may start it now, or -1. A thread's jobs run one at a time,
+// oldest first: jobID must be the oldest queued job and no other job may be
+// running. A queued job with an owner is reserved for that owner. Jobs that
+// went lease without progress are taken to have died with their owner, so
+// they neither hold up the thread nor stay reserved.
+func nextGenerationJob(jobs []models.GenerationJob, jobID string, owner string, lease time.Duration, now time.Time) int {
+	next := -1
+	for i, job := range jobs {
+		live := now.Sub(job.UpdatedAt) < lease
+		switch {
+		case job.Status == models.JobRunning && live:
+			return -1
+		case job.Status == models.JobQueued && next < 0:
+			next = i
+		}
+	}
+	if next < 0 || jobs[next].ID != jobID {
+		return -1
+	}
+	if job := jobs[next]; job.Owner != "" && job.Owner != owner && now.Sub(job.UpdatedAt) < lease {
+		return -1
+	}
+	return next
+}
+
+// startJob marks a claimed job as running for owner.
+func startJob(job *models.GenerationJob, owner string) {
+	job.Status = models.JobRunning
+	job.Error = ""
+	job.Attempts++
+	job.Owner = owner
+}
+
+func sortJobsOldestFirst(jobs []models.GenerationJob) {
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+}
+
+var generationJobFields = []string{"threadID", "messageID", "status", "error", "attempts", "owner", "replyID", "createdAt", "updatedAt", "_additional { id }"}
+
+func (s *WeaviateStore) SaveGenerationJob(job *models.GenerationJob) error {
+	client := s.client
+
+	now := time.Now()
+	saved := *job
+	create := saved.ID == ""
+	if create {
+		saved.ID = uuid.NewString()
+		saved.CreatedAt = now
+	}
+	saved.UpdatedAt = now
+
+	properties := map[string]interface{}{
+		"threadID":  saved.ThreadID,
+		"messageID": saved.MessageID,
+		"status":    saved.Status,
+		"error":     saved.Error,
+		"attempts":  saved.Attempts,
+		"owner":     saved.Owner,
+		"replyID":   saved.ReplyID,
+		"createdAt": saved.CreatedAt,
+		"updatedAt": saved.UpdatedAt,
+	}
+
+	var err error
+	if create {
+		_, err = client.Data().Creator().
+			WithClassName(GenerationJobClass).
+			WithID(saved.ID).
+			WithProperties(properties).
+			Do(context.Background())
+	} else {
+		err = client.Data().Updater().
+			WithClassName(GenerationJobClass).
+			WithID(saved.ID).
+			WithProperties(properties).
+			Do(context.Background())
+	}
+	if err != nil {
+		return err
+	}
+
+	*job = saved
+	return nil
+}
+
+func (s *WeaviateStore) GetGenerationJobs(threadID string, statuses ...string) ([]models.GenerationJob, error) {
+	where := filters.Where().WithPath([]string{"threadID"}).WithOperator(filters.Equal).WithValueString(threadID)
+	if len(statuses) > 0 {
+		matches := make([]*filters.WhereBuilder, len(statuses))
+		for i, status := range statuses {
+			matches[i] = filters.Where().WithPath([]string{"status"}).WithOperator(filters.Equal).WithValueString(status)
+		}
+		where = filters.Where().WithOperator(filters.And).WithOperands([]*filters.WhereBuilder{
+			where,
+			filters.Where().WithOperator(filters.Or).WithOperands(matches),
+		})
+	}
+
+	classObjects, err := s.getAll(GenerationJobClass, generationJobFields, where,
+		graphql.Sort{Path: []string{"createdAt"}, Order: graphql.Asc},
+		graphql.Sort{Path: []string{"_id"}, Order: graphql.Asc})
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]models.GenerationJob, 0, len(classObjects))
+	for _, obj := range classObjects {
+		job := models.GenerationJob{ThreadID: threadID}
+		if additional, ok := obj["_additional"].(map[string]interface{}); ok {
+			job.ID, _ = additional["id"].(string)
+		}
+		job.MessageID, _ = obj["messageID"].(string)
+		job.Status, _ = obj["status"].(string)
+		job.Error, _ = obj["error"].(string)
+		job.Owner, _ = obj["owner"].(string)
+		job.ReplyID, _ = obj["replyID"].(string)
+		if attempts, ok := obj["attempts"].(float64); ok {
+			job.Attempts = int(attempts)
+		}
+		for field, target := range map[string]*time.Time{
+			"createdAt": &job.CreatedAt,
+			"updatedAt": &job.UpdatedAt,
+		} {
+			if value, ok := obj[field].(string); ok {
+				*target, _ = time.Parse(time.RFC3339, value)
+			}
+		}
+		jobs = append(jobs, job)
+	}
+
+	sortJobsOldestFirst(jobs)
+	return jobs, nil
+}
+
+// generationClaimID is the object claiming a job for its next attempt.
+func generationClaimID(job models.GenerationJob) string {
+	return uuid.NewSHA1(ticketNamespace, []byte(fmt.Sprintf("claim/%s/%d", job.ID, job.Attempts+1))).String()
+}
+
+// ClaimGenerationJob can't update the job conditionally, as Weaviate has no
+// transactions. Creating an object under an ID that is taken fails, though,
+// so each attempt at a job is claimed by creating its claim object first.
+// Concurrent claims all read the job as queued and pick the same attempt,
+// and only one of them creates the claim.
+func (s *WeaviateStore) ClaimGenerationJob(threadID string, jobID string, owner string, lease time.Duration) (*models.GenerationJob, error) {
+	client := s.client
+	ctx := context.Background()
+
+	jobs, err := s.GetGenerationJobs(threadID, models.JobQueued, models.JobRunning)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	next := nextGenerationJob(jobs, jobID, owner, lease, now)
+	if next < 0 {
+		return nil, nil
+	}
+	job := jobs[next]
+
+	claimID := generationClaimID(job)
+	_, err = client.Data().Creator().
+		WithClassName(GenerationClaimClass).
+		WithID(claimID).
+		WithProperties(map[string]interface{}{
+			"threadID":  threadID,
+			"jobID":     job.ID,
+			"owner":     owner,
+			"createdAt": now,
+		}).
+		Do(ctx)
+	if err != nil {
+		taken, checkErr := client.Data().Checker().WithClassName(GenerationClaimClass).WithID(claimID).Do(ctx)
+		if checkErr == nil && taken {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	startJob(&job, owner)
+	if err := s.SaveGenerationJob(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
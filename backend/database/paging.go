@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
+)
+
+// weaviatePageSize is how many objects getAll reads per query. Weaviate
+// returns 10 when a query sets no limit.
+const weaviatePageSize = 100
+
+// getAll reads every object of the class matching where, a page at a time,
+// in the order of sort. Sort must order the objects fully, or pages may skip
+// or repeat some. Objects of a class that doesn't exist yet are none.
+func (s *WeaviateStore) getAll(className string, fields []string, where *filters.WhereBuilder, sort ...graphql.Sort) ([]map[string]interface{}, error) {
+	var objects []map[string]interface{}
+	for offset := 0; ; offset += weaviatePageSize {
+		page, err := s.getPage(className, fields, where, offset, weaviatePageSize, sort...)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, page...)
+		if len(page) < weaviatePageSize {
+			return objects, nil
+		}
+	}
+}
+
+// getPage reads up to limit objects of the class matching where, skipping
+// the first offset of them in the order of sort.
+func (s *WeaviateStore) getPage(className string, fields []string, where *filters.WhereBuilder, offset int, limit int, sort ...graphql.Sort) ([]map[string]interface{}, error) {
+	graphqlFields := make([]graphql.Field, len(fields))
+	for i, field := range fields {
+		graphqlFields[i] = graphql.Field{Name: field}
+	}
+
+	ctx := context.Background()
+	query := s.client.GraphQL().Get().
+		WithClassName(className).
+		WithFields(graphqlFields...).
+		WithLimit(limit).
+		WithOffset(offset)
+	if where != nil {
+		query = query.WithWhere(where)
+	}
+	if len(sort) > 0 {
+		query = query.WithSort(sort...)
+	}
+
+	response, err := query.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if response.Errors != nil {
+		exists, err := s.client.Schema().ClassExistenceChecker().WithClassName(className).Do(ctx)
+		if err == nil && !exists {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("graphQL errors: %v", response.Errors)
+	}
+
+	getObject, ok := response.Data["Get"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("unable to parse 'Get' from response data")
+	}
+	classObjects, _ := getObject[className].([]interface{})
+
+	objects := make([]map[string]interface{}, 0, len(classObjects))
+	for _, classObject := range classObjects {
+		if obj, ok := classObject.(map[string]interface{}); ok {
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
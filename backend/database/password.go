@@ -0,0 +1,143 @@
+package database
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	AlgorithmLegacySHA256 = "sha256"
+	AlgorithmArgon2id     = "argon2id"
+)
+
+var errInvalidPasswordHash = errors.New("invalid argon2id hash")
+
+// PasswordParams are the argon2id cost settings. They can be tuned with
+// AUTH_ARGON2_MEMORY (KiB), AUTH_ARGON2_ITERATIONS and AUTH_ARGON2_PARALLELISM;
+// records hashed with different settings are rehashed on their next login.
+type PasswordParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+func envUint(name string, fallback uint64, bits int) uint64 {
+	value, err := strconv.ParseUint(os.Getenv(name), 10, bits)
+	if err != nil || value == 0 {
+		return fallback
+	}
+	return value
+}
+
+func CurrentPasswordParams() PasswordParams {
+	return PasswordParams{
+		Memory:      uint32(envUint("AUTH_ARGON2_MEMORY", 64*1024, 32)),
+		Iterations:  uint32(envUint("AUTH_ARGON2_ITERATIONS", 3, 32)),
+		Parallelism: uint8(envUint("AUTH_ARGON2_PARALLELISM", 2, 8)),
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// pepper is a server-side secret mixed into every hash so a leaked store alone
+// can't be brute forced. Its ID is recorded on each hash so the pepper can be
+// rotated: set AUTHORIZATION_PEPPER to the new value and move the old one into
+// AUTHORIZATION_PEPPER_PREVIOUS (comma separated); records are re-peppered on
+// their next successful login.
+type pepper struct {
+	ID    string
+	Value string
+}
+
+func pepperID(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:4])
+}
+
+func currentPepper() pepper {
+	value := os.Getenv("AUTHORIZATION_PEPPER")
+	return pepper{ID: pepperID(value), Value: value}
+}
+
+func findPepper(id string) (pepper, bool) {
+	if current := currentPepper(); current.ID == id {
+		return current, true
+	}
+	for _, value := range strings.Split(os.Getenv("AUTHORIZATION_PEPPER_PREVIOUS"), ",") {
+		if value = strings.TrimSpace(value); value != "" && pepperID(value) == id {
+			return pepper{ID: id, Value: value}, true
+		}
+	}
+	return pepper{}, false
+}
+
+func (p pepper) apply(username string, password string) []byte {
+	mac := hmac.New(sha256.New, []byte(p.Value))
+	mac.Write([]byte(username))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+// hashPassword returns an encoded hash in the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func hashPassword(secret []byte, params PasswordParams) (string, error) {
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey(secret, salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// verifyPassword checks secret against an encoded hash and returns the
+// parameters it was hashed with.
+func verifyPassword(secret []byte, encoded string) (bool, PasswordParams, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return false, PasswordParams{}, errInvalidPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, PasswordParams{}, errInvalidPasswordHash
+	}
+
+	var params PasswordParams
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return false, PasswordParams{}, errInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, PasswordParams{}, errInvalidPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, PasswordParams{}, errInvalidPasswordHash
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	candidate := argon2.IDKey(secret, salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return subtle.ConstantTimeCompare(key, candidate) == 1, params, nil
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"log"
+
+	weaviatemodels "github.com/weaviate/weaviate/entities/models"
+)
+
+// weaviateProperties lists properties added to existing classes after they
+// were first created. Auto-schema only adds a property once an object carries
+// it, and until then queries that select it fail.
+var weaviateProperties = map[string][]*weaviatemodels.Property{
+	SuggestionRunClass: {
+		{Name: "table", DataType: []string{"text"}},
+	},
+	ChatMessageClass: {
+		{Name: "citations", DataType: []string{"text"}},
+		{Name: "parentID", DataType: []string{"text"}},
+		{Name: "sequence", DataType: []string{"int"}},
+		{Name: "embedded", DataType: []string{"boolean"}},
+	},
+	ChatThreadClass: {
+		{Name: "activeMessageID", DataType: []string{"text"}},
+		{Name: "summary", DataType: []string{"text"}},
+		{Name: "summaryMessageID", DataType: []string{"text"}},
+		{Name: "messageSequence", DataType: []string{"int"}},
+	},
+	GenerationJobClass: {
+		{Name: "owner", DataType: []string{"text"}},
+	},
+	TicketClass: {
+		{Name: "instanceID", DataType: []string{"text"}},
+		{Name: "table", DataType: []string{"text"}},
+		{Name: "sysID", DataType: []string{"text"}},
+		{Name: "sysCreatedOn", DataType: []string{"text"}},
+		{Name: "sysUpdatedOn", DataType: []string{"text"}},
+		{Name: "problemID", DataType: []string{"text"}},
+		{Name: "changeID", DataType: []string{"text"}},
+		{Name: "causedByID", DataType: []string{"text"}},
+		{Name: "deleted", DataType: []string{"boolean"}},
+		{Name: "openedAt", DataType: []string{"text"}},
+		{Name: "resolvedAt", DataType: []string{"text"}},
+		{Name: "category", DataType: []string{"text"}},
+		{Name: "assignmentGroup", DataType: []string{"text"}},
+		{Name: "reopenCount", DataType: []string{"text"}},
+		{Name: "madeSLA", DataType: []string{"text"}},
+	},
+}
+
+// ensureProperties adds any missing weaviateProperties to classes that already
+// exist. Classes that don't exist yet get them from auto-schema on first write.
+func (s *WeaviateStore) ensureProperties() {
+	ctx := context.Background()
+
+	for className, properties := range weaviateProperties {
+		class, err := s.client.Schema().ClassGetter().WithClassName(className).Do(ctx)
+		if err != nil || class == nil {
+			continue
+		}
+
+		existing := make(map[string]bool, len(class.Properties))
+		for _, property := range class.Properties {
+			existing[property.Name] = true
+		}
+
+		for _, property := range properties {
+			if existing[property.Name] {
+				continue
+			}
+			err := s.client.Schema().PropertyCreator().
+				WithClassName(className).
+				WithProperty(property).
+				Do(ctx)
+			if err != nil {
+				log.Printf("Error adding property %s to %s: %v", property.Name, className, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
+)
+
+const (
+	SessionClass      = "Session"
+	RevokedTokenClass = "RevokedToken"
+)
+
+// SessionRecord is the server-side half of a refresh token. Only a digest of
+// the token is stored, so a leaked store can't be used to mint sessions.
+type SessionRecord struct {
+	ID         string    `json:"id"`
+	TokenHash  string    `json:"tokenHash"`
+	InstanceID string    `json:"instanceID"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+func CreateSession(session SessionRecord) (string, error) {
+	s, err := GetStore()
+	if err != nil {
+		return "", err
+	}
+	return s.CreateSession(session)
+}
+
+func FindSession(tokenHash string) (*SessionRecord, error) {
+	s, err := GetStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.FindSession(tokenHash)
+}
+
+func DeleteSession(sessionID string) error {
+	s, err := GetStore()
+	if err != nil {
+		return err
+	}
+	return s.DeleteSession(sessionID)
+}
+
+func RevokeAccessToken(tokenID string, expiresAt time.Time) error {
+	s, err := GetStore()
+	if err != nil {
+		return err
+	}
+	return s.RevokeAccessToken(tokenID, expiresAt)
+}
+
+func ListRevokedAccessTokens() (map[string]time.Time, error) {
+	s, err := GetStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.ListRevokedAccessTokens()
+}
+
+func (s *WeaviateStore) CreateSession(session SessionRecord) (string, error) {
+	client := s.client
+
+	response, err := client.Data().Creator().
+		WithClassName(SessionClass).
+		WithProperties(map[string]interface{}{
+			"tokenHash":  session.TokenHash,
+			"instanceID": session.InstanceID,
+			"createdAt":  session.CreatedAt,
+			"expiresAt":  session.ExpiresAt,
+		}).
+		Do(context.Background())
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(response.Object.ID), nil
+}
+
+func (s *WeaviateStore) FindSession(tokenHash string) (*SessionRecord, error) {
+	client := s.client
+
+	fields := []string{"tokenHash", "instanceID", "createdAt", "expiresAt", "_additional { id }"}
+	graphqlFields := make([]graphql.Field, len(fields))
+	for i, field := range fields {
+		graphqlFields[i] = graphql.Field{Name: field}
+	}
+
+	response, err := client.GraphQL().Get().
+		WithClassName(SessionClass).
+		WithFields(graphqlFields...).
+		WithWhere(filters.Where().WithPath([]string{"tokenHash"}).WithOperator(filters.Equal).WithValueString(tokenHash)).
+		WithLimit(1).
+		Do(context.Background())
+
+	if err != nil {
+		return nil, err
+	}
+
+	getObject, ok := response.Data["Get"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("unable to parse 'Get' from response data")
+	}
+
+	classObjects, ok := getObject[SessionClass].([]interface{})
+	if !ok {
+		return nil, errors.New("unable to parse 'Session' class from class object")
+	}
+
+	if len(classObjects) == 0 {
+		return nil, nil
+	}
+
+	obj, ok := classObjects[0].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("unable to parse session object")
+	}
+
+	session := &SessionRecord{TokenHash: tokenHash}
+	session.InstanceID, _ = obj["instanceID"].(string)
+	if createdAt, ok := obj["createdAt"].(string); ok {
+		session.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	}
+	if expiresAt, ok := obj["expiresAt"].(string); ok {
+		session.ExpiresAt, _ = time.Parse(time.RFC3339, expiresAt)
+	}
+	if additional, ok := obj["_additional"].(map[string]interface{}); ok {
+		session.ID, _ = additional["id"].(string)
+	}
+
+	return session, nil
+}
+
+func (s *WeaviateStore) DeleteSession(sessionID string) error {
+	client := s.client
+
+	return client.Data().Deleter().
+		WithClassName(SessionClass).
+		WithID(sessionID).
+		Do(context.Background())
+}
+
+// revokedTokenObjectID is the object a revoked token is stored under, so
+// checking it is a lookup by ID.
+func revokedTokenObjectID(tokenID string) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(tokenID)).String()
+}
+
+func (s *WeaviateStore) RevokeAccessToken(tokenID string, expiresAt time.Time) error {
+	client := s.client
+	ctx := context.Background()
+
+	exists, err := client.Schema().ClassExistenceChecker().WithClassName(RevokedTokenClass).Do(ctx)
+	if err != nil {
+		return err
+	}
+	if exists {
+		_, err = client.Batch().ObjectsBatchDeleter().
+			WithClassName(RevokedTokenClass).
+			WithWhere(filters.Where().
+				WithPath([]string{"expiresAt"}).
+				WithOperator(filters.LessThan).
+				WithValueDate(time.Now())).
+			Do(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	revoked, err := client.Data().Checker().
+		WithClassName(RevokedTokenClass).
+		WithID(revokedTokenObjectID(tokenID)).
+		Do(ctx)
+	if err != nil || revoked {
+		return err
+	}
+
+	_, err = client.Data().Creator().
+		WithClassName(RevokedTokenClass).
+		WithID(revokedTokenObjectID(tokenID)).
+		WithProperties(map[string]interface{}{
+			"tokenID":   tokenID,
+			"expiresAt": expiresAt,
+		}).
+		Do(ctx)
+	return err
+}
+
+func (s *WeaviateStore) ListRevokedAccessTokens() (map[string]time.Time, error) {
+	where := filters.Where().
+		WithPath([]string{"expiresAt"}).
+		WithOperator(filters.GreaterThan).
+		WithValueDate(time.Now())
+	objects, err := s.getAll(RevokedTokenClass, []string{"tokenID", "expiresAt"}, where,
+		graphql.Sort{Path: []string{"_id"}, Order: graphql.Asc})
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := make(map[string]time.Time, len(objects))
+	for _, obj := range objects {
+		tokenID, _ := obj["tokenID"].(string)
+		expiresAt, _ := obj["expiresAt"].(string)
+		at, err := time.Parse(time.RFC3339, expiresAt)
+		if tokenID == "" || err != nil {
+			continue
+		}
+		revoked[tokenID] = at
+	}
+	return revoked, nil
+}
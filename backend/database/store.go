@@ -0,0 +1,335 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/davidulloa/mimir/models"
+)
+
+// Store is the persistence layer used by the handlers. The hosted deployment
+// runs against Weaviate; laptops and CI can use the embedded bbolt backend.
+type Store interface {
+	CreateChatThread(thread models.ChatThread) (string, error)
+	// GetChatThread returns the thread with the messages of its active
+	// branch; see ChatTree.
+	GetChatThread(threadID string) (*models.ChatThread, error)
+	// UpdateChatThread leaves the thread's ActiveMessageID and summary as
+	// they are.
+	UpdateChatThread(thread models.ChatThread) error
+	DeleteChatThread(threadID string) error
+	GetChatThreadsByInstanceID(instanceID string) ([]models.ChatThread, error)
+
+	// AddChatMessage persists a message, makes it the end of the thread's
+	// active branch and returns its generated ID.
+	AddChatMessage(threadID string, message models.ChatMessage) (string, error)
+	// GetChatMessages returns every message of the thread, on all branches.
+	GetChatMessages(threadID string) ([]models.ChatMessage, error)
+	// SetActiveChatMessage makes the branch ending at messageID the one the
+	// thread shows.
+	SetActiveChatMessage(threadID string, messageID string) error
+	// SetChatSummary stores the thread's rolling summary of the conversation
+	// through messageID.
+	SetChatSummary(threadID string, summary string, messageID string) error
+
+	// SaveChatMessageVectors stores the embeddings chat history search
+	// matches the thread's messages against, by message ID.
+	SaveChatMessageVectors(threadID string, vectors map[string][]float32) error
+	// GetChatMessageVectors returns the thread's stored message embeddings
+	// by message ID.
+	GetChatMessageVectors(threadID string) (map[string][]float32, error)
+	// GetUnembeddedChatMessages returns the threads' messages that have no
+	// stored embedding, by thread ID.
+	GetUnembeddedChatMessages(threadIDs []string) (map[string][]models.ChatMessage, error)
+	// SearchChatMessages ranks the threads' messages against the query, on
+	// every branch, best first.
+	SearchChatMessages(query ChatMessageQuery) ([]ChatMessageMatch, error)
+
+	// SaveGenerationJob creates the job if it has no ID, filling in its ID
+	// and creation time, and otherwise replaces it. It sets UpdatedAt.
+	SaveGenerationJob(job *models.GenerationJob) error
+	// GetGenerationJobs returns the thread's jobs with any of statuses, or
+	// all of them if none are given, oldest first.
+	GetGenerationJobs(threadID string, statuses ...string) ([]models.GenerationJob, error)
+	// ClaimGenerationJob starts the thread's job jobID on behalf of owner
+	// and returns it, or returns nil if it isn't the job to run next; see
+	// nextGenerationJob. Of concurrent claims, in this process or another,
+	// at most one succeeds.
+	ClaimGenerationJob(threadID string, jobID string, owner string, lease time.Duration) (*models.GenerationJob, error)
+
+	GetAcceleratorByID(acceleratorID string) (*models.Accelerator, error)
+	GetAllAccelerators() ([]models.Accelerator, error)
+	// SaveAccelerator creates or replaces the accelerator stored under its ID,
+	// along with the embedding of its title and description used by search.
+	SaveAccelerator(accelerator models.Accelerator, vector []float32) error
+	// DeleteAccelerator returns ErrAcceleratorNotFound if there is nothing to delete.
+	DeleteAccelerator(acceleratorID string) error
+	// SearchAccelerators ranks accelerators by a hybrid of keyword matches on
+	// query and similarity to vector, best first.
+	SearchAccelerators(query string, vector []float32, category string, limit int) ([]models.AcceleratorMatch, error)
+
+	// Document chunks are indexed per accelerator. ReplaceDocumentChunks swaps
+	// out the whole index; SearchDocumentChunks returns the k chunks nearest
+	// to vector.
+	ReplaceDocumentChunks(acceleratorID string, chunks []models.DocumentChunk) error
+	SearchDocumentChunks(acceleratorID string, vector []float32, k int) ([]models.DocumentChunk, error)
+	CountDocumentChunks(acceleratorID string) (int, error)
+
+	RetrieveTickets(ids []string) ([]models.Ticket, error)
+	// StoreTickets upserts tickets by ID; ones without an ID get a new one.
+	StoreTickets(tickets []models.Ticket) error
+	// GetTicketsByInstanceID returns every stored ticket of the instance,
+	// including ones marked deleted.
+	GetTicketsByInstanceID(instanceID string) ([]models.Ticket, error)
+
+	// GetSyncState returns nil when the table has never been synced.
+	GetSyncState(instanceID string, table string) (*SyncState, error)
+	SaveSyncState(state SyncState) error
+
+	CreateSuggestionRun(run models.SuggestionRun) (string, error)
+	GetSuggestionRun(runID string) (*models.SuggestionRun, error)
+	// GetSuggestionRunsByInstanceID returns up to limit of the instance's
+	// runs, newest first, after skipping offset of them. A limit of 0 returns
+	// the rest.
+	GetSuggestionRunsByInstanceID(instanceID string, offset int, limit int) ([]models.SuggestionRun, error)
+
+	// FindAuthRecord looks up a record by exact hash. Only legacy SHA-256
+	// records can be found this way.
+	FindAuthRecord(instanceID string, authHash string) (*AuthRecord, error)
+	FindAuthRecordsByUser(instanceID string, userDigest string) ([]AuthRecord, error)
+	CreateAuthRecord(record AuthRecord) (string, error)
+	UpdateAuthRecord(record AuthRecord) error
+
+	// Sessions back refresh tokens; FindSession returns nil when none matches.
+	CreateSession(session SessionRecord) (string, error)
+	FindSession(tokenHash string) (*SessionRecord, error)
+	DeleteSession(sessionID string) error
+	// RevokeAccessToken denylists an access token by its ID until expiresAt,
+	// dropping entries that have expired. ListRevokedAccessTokens returns
+	// the denylist, token IDs mapped to when they expire.
+	RevokeAccessToken(tokenID string, expiresAt time.Time) error
+	ListRevokedAccessTokens() (map[string]time.Time, error)
+
+	// Credentials are stored per instance; SaveCredential replaces any
+	// existing record and FindCredential returns nil when there is none.
+	SaveCredential(record CredentialRecord) error
+	FindCredential(instanceID string) (*CredentialRecord, error)
+	ListCredentials() ([]CredentialRecord, error)
+
+	Close() error
+}
+
+// AuthRecord is a registered set of ServiceNow credentials for an instance.
+// Legacy records only carry an unsalted SHA-256 AuthHash; they are upgraded
+// to argon2id on their next successful login.
+type AuthRecord struct {
+	ID         string `json:"id"`
+	InstanceID string `json:"instanceID"`
+	AuthHash   string `json:"authHash"`
+	// UserDigest identifies the username without storing it, so records can
+	// be found before the (salted) hash is checked.
+	UserDigest string `json:"userDigest,omitempty"`
+	Algorithm  string `json:"algorithm,omitempty"`
+	PepperID   string `json:"pepperID,omitempty"`
+}
+
+const (
+	StoreWeaviate = "weaviate"
+	StoreBolt     = "bolt"
+)
+
+var (
+	store   Store
+	storeMu sync.Mutex
+)
+
+// ConfiguredStore returns the backend named by MIMIR_STORE. When it is unset,
+// Weaviate is used if WEAVIATE_URL is configured and the embedded store
+// otherwise.
+func ConfiguredStore() string {
+	if kind := os.Getenv("MIMIR_STORE"); kind != "" {
+		return kind
+	}
+	if os.Getenv("WEAVIATE_URL") != "" {
+		return StoreWeaviate
+	}
+	return StoreBolt
+}
+
+// OpenStore opens the backend returned by ConfiguredStore.
+func OpenStore() (Store, error) {
+	switch kind := ConfiguredStore(); kind {
+	case StoreWeaviate:
+		return NewWeaviateStore()
+	case StoreBolt:
+		path := os.Getenv("MIMIR_BOLT_PATH")
+		if path == "" {
+			path = "mimir.db"
+		}
+		return OpenBoltStore(path)
+	default:
+		return nil, fmt.Errorf("unknown MIMIR_STORE %q", kind)
+	}
+}
+
+// GetStore returns the process-wide store, opening it on first use.
+func GetStore() (Store, error) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	if store != nil {
+		return store, nil
+	}
+
+	s, err := OpenStore()
+	if err != nil {
+		return nil, err
+	}
+	store = s
+	return store, nil
+}
+
+// SetStore replaces the process-wide store. It is used by main and by tests.
+func SetStore(s Store) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	store = s
+}
+
+func CreateChatThread(thread models.ChatThread) (string, error) {
+	s, err := GetStore()
+	if err != nil {
+		return "", err
+	}
+	return s.CreateChatThread(thread)
+}
+
+func GetChatThread(threadID string) (*models.ChatThread, error) {
+	s, err := GetStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetChatThread(threadID)
+}
+
+func UpdateChatThread(thread models.ChatThread) error {
+	s, err := GetStore()
+	if err != nil {
+		return err
+	}
+	return s.UpdateChatThread(thread)
+}
+
+func DeleteChatThread(threadID string) error {
+	s, err := GetStore()
+	if err != nil {
+		return err
+	}
+	return s.DeleteChatThread(threadID)
+}
+
+func GetChatThreadsByInstanceID(instanceID string) ([]models.ChatThread, error) {
+	s, err := GetStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetChatThreadsByInstanceID(instanceID)
+}
+
+func AddChatMessage(threadID string, message models.ChatMessage) (string, error) {
+	s, err := GetStore()
+	if err != nil {
+		return "", err
+	}
+	return s.AddChatMessage(threadID, message)
+}
+
+func GetChatMessages(threadID string) ([]models.ChatMessage, error) {
+	s, err := GetStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetChatMessages(threadID)
+}
+
+func SetActiveChatMessage(threadID string, messageID string) error {
+	s, err := GetStore()
+	if err != nil {
+		return err
+	}
+	return s.SetActiveChatMessage(threadID, messageID)
+}
+
+func SetChatSummary(threadID string, summary string, messageID string) error {
+	s, err := GetStore()
+	if err != nil {
+		return err
+	}
+	return s.SetChatSummary(threadID, summary, messageID)
+}
+
+func GetAcceleratorByID(acceleratorID string) (*models.Accelerator, error) {
+	s, err := GetStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetAcceleratorByID(acceleratorID)
+}
+
+func GetAllAccelerators() ([]models.Accelerator, error) {
+	s, err := GetStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetAllAccelerators()
+}
+
+func SaveAccelerator(accelerator models.Accelerator, vector []float32) error {
+	s, err := GetStore()
+	if err != nil {
+		return err
+	}
+	return s.SaveAccelerator(accelerator, vector)
+}
+
+func DeleteAccelerator(acceleratorID string) error {
+	s, err := GetStore()
+	if err != nil {
+		return err
+	}
+	return s.DeleteAccelerator(acceleratorID)
+}
+
+func SearchAccelerators(query string, vector []float32, category string, limit int) ([]models.AcceleratorMatch, error) {
+	s, err := GetStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.SearchAccelerators(query, vector, category, limit)
+}
+
+func RetrieveTickets(ids []string) ([]models.Ticket, error) {
+	s, err := GetStore()
+	if err != nil {
+		return []models.Ticket{}, err
+	}
+	return s.RetrieveTickets(ids)
+}
+
+func StoreTickets(tickets []models.Ticket) error {
+	s, err := GetStore()
+	if err != nil {
+		return err
+	}
+	return s.StoreTickets(tickets)
+}
+
+func GetTicketsByInstanceID(instanceID string) ([]models.Ticket, error) {
+	s, err := GetStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetTicketsByInstanceID(instanceID)
+}
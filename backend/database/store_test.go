@@ -0,0 +1,179 @@
+package database
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMain runs the package tests against an embedded store unless a Weaviate
+// cluster is configured, so they work offline.
+func TestMain(m *testing.M) {
+	if os.Getenv("WEAVIATE_URL") == "" {
+		dir, err := os.MkdirTemp("", "mimir-store")
+		if err != nil {
+			log.Fatalf("Failed to create temp dir: %v", err)
+		}
+
+		s, err := OpenBoltStore(filepath.Join(dir, "mimir.db"))
+		if err != nil {
+			log.Fatalf("Failed to open bolt store: %v", err)
+		}
+		SetStore(s)
+
+		code := m.Run()
+		s.Close()
+		os.RemoveAll(dir)
+		os.Exit(code)
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestAuthenticationRoundTrip(t *testing.T) {
+	err := RegisterAuthentication("dev000001", "admin", "hunter2")
+	assert.NoError(t, err)
+
+	valid, err := ValidateAuthentication("dev000001", "admin", "hunter2")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+
+	valid, err = ValidateAuthentication("dev000001", "admin", "wrong")
+	assert.NoError(t, err)
+	assert.False(t, valid)
+
+	valid, err = ValidateAuthentication("dev000002", "admin", "hunter2")
+	assert.NoError(t, err)
+	assert.False(t, valid)
+}
+
+// cheapArgon2 keeps argon2id fast enough for tests.
+func cheapArgon2(t *testing.T) {
+	t.Setenv("AUTH_ARGON2_MEMORY", "1024")
+	t.Setenv("AUTH_ARGON2_ITERATIONS", "1")
+	t.Setenv("AUTH_ARGON2_PARALLELISM", "1")
+}
+
+func findUserRecords(t *testing.T, instanceID string, username string) []AuthRecord {
+	s, err := GetStore()
+	assert.NoError(t, err)
+	records, err := s.FindAuthRecordsByUser(instanceID, userDigest(instanceID, username))
+	assert.NoError(t, err)
+	return records
+}
+
+func TestRegisterHashesWithArgon2id(t *testing.T) {
+	cheapArgon2(t)
+
+	assert.NoError(t, RegisterAuthentication("dev000010", "admin", "hunter2"))
+	assert.NoError(t, RegisterAuthentication("dev000010", "admin", "hunter3"))
+
+	records := findUserRecords(t, "dev000010", "admin")
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, AlgorithmArgon2id, records[0].Algorithm)
+		assert.Contains(t, records[0].AuthHash, "$argon2id$v=19$m=1024,t=1,p=1$")
+		assert.NotContains(t, records[0].AuthHash, "hunter")
+	}
+
+	valid, err := ValidateAuthentication("dev000010", "admin", "hunter2")
+	assert.NoError(t, err)
+	assert.False(t, valid)
+
+	valid, err = ValidateAuthentication("dev000010", "admin", "hunter3")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+}
+
+func TestLegacyRecordIsUpgradedOnLogin(t *testing.T) {
+	cheapArgon2(t)
+	// The store is shared by the package, so repeated runs need their own instance.
+	instanceID := "dev000011-" + uuid.NewString()
+
+	s, err := GetStore()
+	assert.NoError(t, err)
+	_, err = s.CreateAuthRecord(AuthRecord{
+		InstanceID: instanceID,
+		AuthHash:   CreateHash("admin", "hunter2"),
+	})
+	assert.NoError(t, err)
+
+	valid, err := ValidateAuthentication(instanceID, "admin", "wrong")
+	assert.NoError(t, err)
+	assert.False(t, valid)
+
+	valid, err = ValidateAuthentication(instanceID, "admin", "hunter2")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+
+	records := findUserRecords(t, instanceID, "admin")
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, AlgorithmArgon2id, records[0].Algorithm)
+	}
+
+	legacy, err := s.FindAuthRecord(instanceID, CreateHash("admin", "hunter2"))
+	assert.NoError(t, err)
+	assert.Nil(t, legacy)
+
+	valid, err = ValidateAuthentication(instanceID, "admin", "hunter2")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+}
+
+func TestRegisterUpgradesLegacyRecord(t *testing.T) {
+	cheapArgon2(t)
+	instanceID := "dev000013-" + uuid.NewString()
+
+	s, err := GetStore()
+	assert.NoError(t, err)
+	id, err := s.CreateAuthRecord(AuthRecord{
+		InstanceID: instanceID,
+		AuthHash:   CreateHash("admin", "hunter2"),
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, RegisterAuthentication(instanceID, "admin", "hunter2"))
+
+	records := findUserRecords(t, instanceID, "admin")
+	if assert.Len(t, records, 1, "the legacy record is reused, not duplicated") {
+		assert.Equal(t, id, records[0].ID)
+		assert.Equal(t, AlgorithmArgon2id, records[0].Algorithm)
+	}
+
+	legacy, err := s.FindAuthRecord(instanceID, CreateHash("admin", "hunter2"))
+	assert.NoError(t, err)
+	assert.Nil(t, legacy, "the SHA-256 hash is gone")
+}
+
+func TestPepperRotationAndRehash(t *testing.T) {
+	cheapArgon2(t)
+	t.Setenv("AUTHORIZATION_PEPPER", "old-pepper")
+
+	assert.NoError(t, RegisterAuthentication("dev000012", "admin", "hunter2"))
+	before := findUserRecords(t, "dev000012", "admin")[0]
+
+	// Rotate the pepper and raise the cost; the record is still accepted and is
+	// rehashed with the new settings.
+	t.Setenv("AUTHORIZATION_PEPPER", "new-pepper")
+	t.Setenv("AUTHORIZATION_PEPPER_PREVIOUS", "old-pepper")
+	t.Setenv("AUTH_ARGON2_ITERATIONS", "2")
+
+	valid, err := ValidateAuthentication("dev000012", "admin", "hunter2")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+
+	after := findUserRecords(t, "dev000012", "admin")[0]
+	assert.Equal(t, before.ID, after.ID)
+	assert.NotEqual(t, before.PepperID, after.PepperID)
+	assert.Equal(t, pepperID("new-pepper"), after.PepperID)
+	assert.Contains(t, after.AuthHash, ",t=2,")
+
+	// Once the old pepper is retired the upgraded record keeps working.
+	t.Setenv("AUTHORIZATION_PEPPER_PREVIOUS", "")
+	valid, err = ValidateAuthentication("dev000012", "admin", "hunter2")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+}
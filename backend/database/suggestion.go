@@ -0,0 +1,261 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/davidulloa/mimir/models"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/fault"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
+)
+
+const (
+	SuggestionRunClass = "SuggestionRun"
+)
+
+func CreateSuggestionRun(run models.SuggestionRun) (string, error) {
+	s, err := GetStore()
+	if err != nil {
+		return "", err
+	}
+	return s.CreateSuggestionRun(run)
+}
+
+func GetSuggestionRun(runID string) (*models.SuggestionRun, error) {
+	s, err := GetStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetSuggestionRun(runID)
+}
+
+func GetSuggestionRunsByInstanceID(instanceID string, offset int, limit int) ([]models.SuggestionRun, error) {
+	s, err := GetStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetSuggestionRunsByInstanceID(instanceID, offset, limit)
+}
+
+// suggestionKey identifies a suggestion across runs by the accelerators it
+// recommends, since the wording changes from one run to the next.
+func suggestionKey(suggestion models.Suggestion) string {
+	keys := make([]string, 0, len(suggestion.Accelerators))
+	for _, accelerator := range suggestion.Accelerators {
+		key := accelerator.Url
+		if key == "" {
+			key = accelerator.Title
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return suggestion.Title
+	}
+	sort.Strings(keys)
+	return fmt.Sprint(keys)
+}
+
+// DiffSuggestionRuns reports which recommendations and tickets appeared or
+// disappeared between two runs.
+func DiffSuggestionRuns(from models.SuggestionRun, to models.SuggestionRun) models.SuggestionRunDiff {
+	diff := models.SuggestionRunDiff{
+		From:               from.ID,
+		To:                 to.ID,
+		AddedSuggestions:   []models.Suggestion{},
+		RemovedSuggestions: []models.Suggestion{},
+		KeptSuggestions:    []models.Suggestion{},
+		AddedTickets:       []string{},
+		RemovedTickets:     []string{},
+	}
+
+	before := make(map[string]bool, len(from.Suggestions))
+	for _, suggestion := range from.Suggestions {
+		before[suggestionKey(suggestion)] = true
+	}
+	after := make(map[string]bool, len(to.Suggestions))
+	for _, suggestion := range to.Suggestions {
+		key := suggestionKey(suggestion)
+		after[key] = true
+		if before[key] {
+			diff.KeptSuggestions = append(diff.KeptSuggestions, suggestion)
+		} else {
+			diff.AddedSuggestions = append(diff.AddedSuggestions, suggestion)
+		}
+	}
+	for _, suggestion := range from.Suggestions {
+		if !after[suggestionKey(suggestion)] {
+			diff.RemovedSuggestions = append(diff.RemovedSuggestions, suggestion)
+		}
+	}
+
+	beforeTickets := make(map[string]bool, len(from.TicketNumbers))
+	for _, number := range from.TicketNumbers {
+		beforeTickets[number] = true
+	}
+	afterTickets := make(map[string]bool, len(to.TicketNumbers))
+	for _, number := range to.TicketNumbers {
+		afterTickets[number] = true
+		if !beforeTickets[number] {
+			diff.AddedTickets = append(diff.AddedTickets, number)
+		}
+	}
+	for _, number := range from.TicketNumbers {
+		if !afterTickets[number] {
+			diff.RemovedTickets = append(diff.RemovedTickets, number)
+		}
+	}
+
+	return diff
+}
+
+func sortRunsNewestFirst(runs []models.SuggestionRun) {
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].CreatedAt.After(runs[j].CreatedAt)
+	})
+}
+
+func (s *WeaviateStore) CreateSuggestionRun(run models.SuggestionRun) (string, error) {
+	client := s.client
+
+	if run.CreatedAt.IsZero() {
+		run.CreatedAt = time.Now()
+	}
+
+	clusters, err := json.Marshal(run.Clusters)
+	if err != nil {
+		return "", err
+	}
+	suggestions, err := json.Marshal(run.Suggestions)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := client.Data().Creator().
+		WithClassName(SuggestionRunClass).
+		WithProperties(map[string]interface{}{
+			"instanceID":    run.InstanceID,
+			"createdAt":     run.CreatedAt,
+			"ticketNumbers": run.TicketNumbers,
+			"table":         run.Table,
+			"clusters":      string(clusters),
+			"suggestions":   string(suggestions),
+		}).
+		Do(context.Background())
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(response.Object.ID), nil
+}
+
+// suggestionRunFromProperties decodes a run from its Weaviate properties.
+func suggestionRunFromProperties(id string, properties map[string]interface{}) (models.SuggestionRun, error) {
+	run := models.SuggestionRun{ID: id}
+	run.InstanceID, _ = properties["instanceID"].(string)
+	run.Table, _ = properties["table"].(string)
+
+	if createdAt, ok := properties["createdAt"].(string); ok {
+		parsed, err := time.Parse(time.RFC3339, createdAt)
+		if err != nil {
+			return run, fmt.Errorf("error parsing createdAt for suggestion run %s: %v", id, err)
+		}
+		run.CreatedAt = parsed
+	}
+
+	if numbers, ok := properties["ticketNumbers"].([]interface{}); ok {
+		for _, number := range numbers {
+			if s, ok := number.(string); ok {
+				run.TicketNumbers = append(run.TicketNumbers, s)
+			}
+		}
+	}
+
+	if clusters, ok := properties["clusters"].(string); ok && clusters != "" {
+		if err := json.Unmarshal([]byte(clusters), &run.Clusters); err != nil {
+			return run, err
+		}
+	}
+	if suggestions, ok := properties["suggestions"].(string); ok && suggestions != "" {
+		if err := json.Unmarshal([]byte(suggestions), &run.Suggestions); err != nil {
+			return run, err
+		}
+	}
+
+	return run, nil
+}
+
+func (s *WeaviateStore) GetSuggestionRun(runID string) (*models.SuggestionRun, error) {
+	client := s.client
+
+	result, err := client.Data().ObjectsGetter().
+		WithClassName(SuggestionRunClass).
+		WithID(runID).
+		Do(context.Background())
+
+	if err != nil {
+		if clientErr, ok := err.(*fault.WeaviateClientError); ok && clientErr.StatusCode == 404 {
+			return nil, fmt.Errorf("suggestion run not found")
+		}
+		return nil, err
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("suggestion run not found")
+	}
+
+	properties, ok := result[0].Properties.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid properties for suggestion run with ID: %s", runID)
+	}
+
+	run, err := suggestionRunFromProperties(runID, properties)
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func (s *WeaviateStore) GetSuggestionRunsByInstanceID(instanceID string, offset int, limit int) ([]models.SuggestionRun, error) {
+	fields := []string{"instanceID", "table", "createdAt", "ticketNumbers", "clusters", "suggestions", "_additional { id }"}
+	where := filters.Where().WithPath([]string{"instanceID"}).WithOperator(filters.Equal).WithValueString(instanceID)
+	newestFirst := []graphql.Sort{
+		{Path: []string{"createdAt"}, Order: graphql.Desc},
+		{Path: []string{"_id"}, Order: graphql.Asc},
+	}
+
+	var classObjects []map[string]interface{}
+	var err error
+	if limit > 0 {
+		classObjects, err = s.getPage(SuggestionRunClass, fields, where, offset, limit, newestFirst...)
+	} else {
+		classObjects, err = s.getAll(SuggestionRunClass, fields, where, newestFirst...)
+		if err == nil {
+			classObjects = classObjects[min(offset, len(classObjects)):]
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]models.SuggestionRun, 0, len(classObjects))
+	for _, obj := range classObjects {
+		var id string
+		if additional, ok := obj["_additional"].(map[string]interface{}); ok {
+			id, _ = additional["id"].(string)
+		}
+
+		run, err := suggestionRunFromProperties(id, obj)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+
+	sortRunsNewestFirst(runs)
+	return runs, nil
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
+)
+
+const (
+	SyncStateClass = "SyncState"
+)
+
+// SyncState records how far a ServiceNow table has been mirrored for an
+// instance; see the ticketsync package.
+type SyncState struct {
+	ID         string `json:"id"`
+	InstanceID string `json:"instanceID"`
+	Table      string `json:"table"`
+	// Watermark is the newest sys_updated_on synced so far.
+	Watermark time.Time `json:"watermark"`
+	// DeletionWatermark is the newest sys_audit_delete entry applied so far.
+	DeletionWatermark time.Time `json:"deletionWatermark"`
+	LastSync          time.Time `json:"lastSync"`
+	LastFullSync      time.Time `json:"lastFullSync"`
+	// LastError is the error of the last sync, empty if it succeeded.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// syncStateID returns the ID a table's state is stored under.
+func syncStateID(instanceID string, table string) string {
+	return uuid.NewSHA1(ticketNamespace, []byte("sync/"+instanceID+"/"+table)).String()
+}
+
+func GetSyncState(instanceID string, table string) (*SyncState, error) {
+	s, err := GetStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetSyncState(instanceID, table)
+}
+
+func SaveSyncState(state SyncState) error {
+	s, err := GetStore()
+	if err != nil {
+		return err
+	}
+	return s.SaveSyncState(state)
+}
+
+var syncStateFields = []string{"instanceID", "table", "watermark", "deletionWatermark", "lastSync", "lastFullSync", "lastError"}
+
+func (s *WeaviateStore) GetSyncState(instanceID string, table string) (*SyncState, error) {
+	client := s.client
+
+	graphqlFields := make([]graphql.Field, len(syncStateFields))
+	for i, field := range syncStateFields {
+		graphqlFields[i] = graphql.Field{Name: field}
+	}
+
+	where := filters.Where().
+		WithPath([]string{"id"}).
+		WithOperator(filters.Equal).
+		WithValueText(syncStateID(instanceID, table))
+
+	response, err := client.GraphQL().Get().
+		WithClassName(SyncStateClass).
+		WithFields(graphqlFields...).
+		WithWhere(where).
+		Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	getObject, ok := response.Data["Get"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("unable to parse 'Get' from response data")
+	}
+
+	// The class doesn't exist until the first state is saved.
+	classObjects, _ := getObject[SyncStateClass].([]interface{})
+	if len(classObjects) == 0 {
+		return nil, nil
+	}
+
+	obj, ok := classObjects[0].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("unable to parse sync state object")
+	}
+
+	state := &SyncState{ID: syncStateID(instanceID, table)}
+	state.InstanceID, _ = obj["instanceID"].(string)
+	state.Table, _ = obj["table"].(string)
+	state.LastError, _ = obj["lastError"].(string)
+	for field, target := range map[string]*time.Time{
+		"watermark":         &state.Watermark,
+		"deletionWatermark": &state.DeletionWatermark,
+		"lastSync":          &state.LastSync,
+		"lastFullSync":      &state.LastFullSync,
+	} {
+		if value, ok := obj[field].(string); ok {
+			*target, _ = time.Parse(time.RFC3339, value)
+		}
+	}
+	return state, nil
+}
+
+func (s *WeaviateStore) SaveSyncState(state SyncState) error {
+	client := s.client
+	state.ID = syncStateID(state.InstanceID, state.Table)
+
+	properties := map[string]interface{}{
+		"instanceID":        state.InstanceID,
+		"table":             state.Table,
+		"watermark":         state.Watermark,
+		"deletionWatermark": state.DeletionWatermark,
+		"lastSync":          state.LastSync,
+		"lastFullSync":      state.LastFullSync,
+		"lastError":         state.LastError,
+	}
+
+	exists, err := client.Data().Checker().
+		WithClassName(SyncStateClass).
+		WithID(state.ID).
+		Do(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return client.Data().Updater().
+			WithID(state.ID).
+			WithClassName(SyncStateClass).
+			WithProperties(properties).
+			Do(context.Background())
+	}
+
+	_, err = client.Data().Creator().
+		WithClassName(SyncStateClass).
+		WithID(state.ID).
+		WithProperties(properties).
+		Do(context.Background())
+	return err
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 
 	"github.com/davidulloa/mimir/models"
+	"github.com/go-openapi/strfmt"
+	"github.com/google/uuid"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
 	WeaviateModels "github.com/weaviate/weaviate/entities/models"
@@ -12,29 +14,39 @@ import (
 
 const (
 	TicketClass = "Ticket"
+
+	// ticketQueryLimit bounds how many tickets one instance query returns.
+	ticketQueryLimit = 10000
 )
 
-func RetrieveTickets(ids []string) ([]models.Ticket, error) {
-	client, err := GetWeaviateClient()
-	if err != nil {
-		return []models.Ticket{}, err
-	}
+// ticketNamespace is the UUID namespace ticket IDs are derived in.
+var ticketNamespace = uuid.MustParse("0c3c1a52-7b0e-5d4f-8e6a-2f9b4d1c7e85")
+
+// TicketID returns the ID a ServiceNow record is stored under, so syncing the
+// same record again replaces it instead of adding a copy.
+func TicketID(instanceID string, sysID string) string {
+	return uuid.NewSHA1(ticketNamespace, []byte(instanceID+"/"+sysID)).String()
+}
+
+var ticketFields = []string{"_additional { id }", "shortDescription", "state", "priority", "number",
+	"instanceID", "table", "sysID", "sysCreatedOn", "sysUpdatedOn", "problemID", "changeID", "causedByID", "deleted",
+	"openedAt", "resolvedAt", "category", "assignmentGroup", "reopenCount", "madeSLA"}
+
+func (s *WeaviateStore) findTickets(where *filters.WhereBuilder, limit int) ([]models.Ticket, error) {
+	client := s.client
 
-	fields := []string{"_additional { id }", "shortDescription", "state", "priority", "number"}
-	graphqlFields := make([]graphql.Field, len(fields))
-	for i, field := range fields {
+	graphqlFields := make([]graphql.Field, len(ticketFields))
+	for i, field := range ticketFields {
 		graphqlFields[i] = graphql.Field{Name: field}
 	}
 
-	inIds := filters.Where().
-		WithPath([]string{"_additional { id }"}).
-		WithOperator(filters.ContainsAny).
-		WithValueText(ids...)
+	query := client.GraphQL().Get().WithClassName(TicketClass).WithFields(graphqlFields...).
+		WithWhere(where)
+	if limit > 0 {
+		query = query.WithLimit(limit)
+	}
 
-	response, err := client.GraphQL().Get().WithClassName(TicketClass).WithFields(graphqlFields...).
-		WithWhere(inIds).
-		Do(context.Background())
-	
+	response, err := query.Do(context.Background())
 	if err != nil {
 		return []models.Ticket{}, err
 	}
@@ -44,73 +56,113 @@ func RetrieveTickets(ids []string) ([]models.Ticket, error) {
 		return []models.Ticket{}, errors.New("unable to parse 'Get' from response data")
 	}
 
-	classObjects, ok := getObject[AuthorizationClass].([]interface{})
+	classObjects, ok := getObject[TicketClass].([]interface{})
 	if !ok {
-		return []models.Ticket{}, errors.New("unable to parse 'Authorization' class from class object")
+		return []models.Ticket{}, errors.New("unable to parse 'Ticket' class from class object")
 	}
 
 	tickets := []models.Ticket{}
+	for _, obj := range classObjects {
+		objMap, ok := obj.(map[string]interface{})
+		if !ok {
+			return []models.Ticket{}, errors.New("unable to parse ticket object")
+		}
 
-    for _, obj := range classObjects {
-        objMap, ok := obj.(map[string]interface{})
-        if !ok {
-            return []models.Ticket{}, errors.New("unable to parse ticket object")
-        }
-
-        var ticket models.Ticket
-
-        // Map the fields from objMap to the ticket struct
-        if id, ok := objMap["id"].(string); ok {
-            ticket.ID = id
-        }
-
-        if priority, ok := objMap["priority"].(int); ok {
-            ticket.Priority = string(priority)
-        }
+		var ticket models.Ticket
+		if additional, ok := objMap["_additional"].(map[string]interface{}); ok {
+			ticket.ID, _ = additional["id"].(string)
+		}
+		ticket.Priority, _ = objMap["priority"].(string)
+		ticket.ShortDescription, _ = objMap["shortDescription"].(string)
+		ticket.State, _ = objMap["state"].(string)
+		ticket.Number, _ = objMap["number"].(string)
+		ticket.InstanceID, _ = objMap["instanceID"].(string)
+		ticket.Table, _ = objMap["table"].(string)
+		ticket.SysID, _ = objMap["sysID"].(string)
+		ticket.SysCreatedOn, _ = objMap["sysCreatedOn"].(string)
+		ticket.SysUpdatedOn, _ = objMap["sysUpdatedOn"].(string)
+		ticket.ProblemID, _ = objMap["problemID"].(string)
+		ticket.ChangeID, _ = objMap["changeID"].(string)
+		ticket.CausedByID, _ = objMap["causedByID"].(string)
+		ticket.Deleted, _ = objMap["deleted"].(bool)
+		ticket.OpenedAt, _ = objMap["openedAt"].(string)
+		ticket.ResolvedAt, _ = objMap["resolvedAt"].(string)
+		ticket.Category, _ = objMap["category"].(string)
+		ticket.AssignmentGroup, _ = objMap["assignmentGroup"].(string)
+		ticket.ReopenCount, _ = objMap["reopenCount"].(string)
+		ticket.MadeSLA, _ = objMap["madeSLA"].(string)
+
+		tickets = append(tickets, ticket)
+	}
 
-        if shortDescription, ok := objMap["shortDescription"].(string); ok {
-            ticket.ShortDescription = shortDescription
-        }
+	return tickets, nil
+}
 
-        if state, ok := objMap["state"].(int); ok {
-            ticket.State = string(state)
-		}
+func (s *WeaviateStore) RetrieveTickets(ids []string) ([]models.Ticket, error) {
+	inIds := filters.Where().
+		WithPath([]string{"id"}).
+		WithOperator(filters.ContainsAny).
+		WithValueText(ids...)
 
-		if number, ok := objMap["number"].(int); ok {
-			ticket.Number = string(number)
-        }
+	return s.findTickets(inIds, len(ids))
+}
 
-        tickets = append(tickets, ticket)
-    }
+func (s *WeaviateStore) GetTicketsByInstanceID(instanceID string) ([]models.Ticket, error) {
+	where := filters.Where().
+		WithPath([]string{"instanceID"}).
+		WithOperator(filters.Equal).
+		WithValueString(instanceID)
 
-	return tickets, nil
-	
+	return s.findTickets(where, ticketQueryLimit)
 }
 
-func StoreTickets(tickets []models.Ticket) error {
-	client, err := GetWeaviateClient()
-	if err != nil {
-		return err
+// StoreTickets writes tickets in one batch. Tickets with an ID replace the
+// object stored under it.
+func (s *WeaviateStore) StoreTickets(tickets []models.Ticket) error {
+	if len(tickets) == 0 {
+		return nil
 	}
 
-	batcher := client.Batch().ObjectsBatcher()
-	dataObjs := []WeaviateModels.PropertySchema{}
+	batcher := s.client.Batch().ObjectsBatcher()
 	for _, ticket := range tickets {
-		dataObjs = append(dataObjs, map[string]interface{}{
-			"priority": ticket.Priority,
-			"shortDescription": ticket.ShortDescription,
-			"state": ticket.State,
-			"number": ticket.Number,
-		})
-	}
-
-	for _, dataObj := range dataObjs {
-		batcher.WithObjects(&WeaviateModels.Object {
+		object := &WeaviateModels.Object{
 			Class: TicketClass,
-			Properties: dataObj,
-		})
+			Properties: map[string]interface{}{
+				"priority":         ticket.Priority,
+				"shortDescription": ticket.ShortDescription,
+				"state":            ticket.State,
+				"number":           ticket.Number,
+				"instanceID":       ticket.InstanceID,
+				"table":            ticket.Table,
+				"sysID":            ticket.SysID,
+				"sysCreatedOn":     ticket.SysCreatedOn,
+				"sysUpdatedOn":     ticket.SysUpdatedOn,
+				"problemID":        ticket.ProblemID,
+				"changeID":         ticket.ChangeID,
+				"causedByID":       ticket.CausedByID,
+				"deleted":          ticket.Deleted,
+				"openedAt":         ticket.OpenedAt,
+				"resolvedAt":       ticket.ResolvedAt,
+				"category":         ticket.Category,
+				"assignmentGroup":  ticket.AssignmentGroup,
+				"reopenCount":      ticket.ReopenCount,
+				"madeSLA":          ticket.MadeSLA,
+			},
+		}
+		if ticket.ID != "" {
+			object.ID = strfmt.UUID(ticket.ID)
+		}
+		batcher.WithObjects(object)
 	}
 
-	_, err = batcher.Do(context.Background())
-	return err
-}
\ No newline at end of file
+	responses, err := batcher.Do(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, response := range responses {
+		if response.Result != nil && response.Result.Errors != nil && len(response.Result.Errors.Error) > 0 {
+			return errors.New(response.Result.Errors.Error[0].Message)
+		}
+	}
+	return nil
+}
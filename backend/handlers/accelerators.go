@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/davidulloa/mimir/catalog"
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/models"
+)
+
+const (
+	defaultAcceleratorPageSize = 50
+	maxAcceleratorPageSize     = 200
+	defaultSearchLimit         = 10
+)
+
+// AcceleratorList is one page of the catalog.
+type AcceleratorList struct {
+	Accelerators []models.Accelerator `json:"accelerators"`
+	Total        int                  `json:"total"`
+	Offset       int                  `json:"offset"`
+	Limit        int                  `json:"limit"`
+}
+
+type AcceleratorsHandler struct{}
+
+func NewAcceleratorsHandler() *AcceleratorsHandler {
+	return &AcceleratorsHandler{}
+}
+
+// AcceleratorsHandler serves /accelerators: GET lists the catalog, POST adds
+// an accelerator.
+func (h *AcceleratorsHandler) AcceleratorsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.list(w, r)
+	case http.MethodPost:
+		if requireAdmin(w, r) {
+			h.create(w, r)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// AcceleratorHandler serves /accelerators/{id}: GET, PUT and DELETE.
+func (h *AcceleratorsHandler) AcceleratorHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		accelerator, err := database.GetAcceleratorByID(id)
+		if err != nil {
+			http.Error(w, err.Error(), acceleratorStatus(err))
+			return
+		}
+		jsonResponse(w, accelerator)
+	case http.MethodPut:
+		if requireAdmin(w, r) {
+			h.update(w, r, id)
+		}
+	case http.MethodDelete:
+		if !requireAdmin(w, r) {
+			return
+		}
+		if err := catalog.Delete(id); err != nil {
+			http.Error(w, err.Error(), acceleratorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// SearchHandler serves GET /accelerators/search?q=...&category=...&limit=...,
+// ranking accelerators by a hybrid of keyword and semantic similarity to q.
+func (h *AcceleratorsHandler) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := queryInt(r, "limit", defaultSearchLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limit > maxAcceleratorPageSize {
+		limit = maxAcceleratorPageSize
+	}
+
+	matches, err := catalog.Search(r.Context(), query, r.URL.Query().Get("category"), limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error searching accelerators: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if matches == nil {
+		matches = []models.AcceleratorMatch{}
+	}
+	jsonResponse(w, matches)
+}
+
+func (h *AcceleratorsHandler) list(w http.ResponseWriter, r *http.Request) {
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultAcceleratorPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limit > maxAcceleratorPageSize {
+		limit = maxAcceleratorPageSize
+	}
+
+	all, err := database.GetAllAccelerators()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	category := r.URL.Query().Get("category")
+	accelerators := []models.Accelerator{}
+	for _, accelerator := range all {
+		if category == "" || strings.EqualFold(accelerator.Category, category) {
+			accelerators = append(accelerators, accelerator)
+		}
+	}
+	// Sort so pages are stable between requests.
+	sort.SliceStable(accelerators, func(i, j int) bool {
+		if accelerators[i].Title != accelerators[j].Title {
+			return accelerators[i].Title < accelerators[j].Title
+		}
+		return accelerators[i].ID < accelerators[j].ID
+	})
+
+	page := AcceleratorList{Total: len(accelerators), Offset: offset, Limit: limit}
+	if offset < len(accelerators) {
+		end := offset + limit
+		if end > len(accelerators) {
+			end = len(accelerators)
+		}
+		page.Accelerators = accelerators[offset:end]
+	} else {
+		page.Accelerators = []models.Accelerator{}
+	}
+	jsonResponse(w, page)
+}
+
+func (h *AcceleratorsHandler) create(w http.ResponseWriter, r *http.Request) {
+	var accelerator models.Accelerator
+	if err := decodeBody(r, &accelerator); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateAccelerator(&accelerator); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if owner, err := acceleratorWithURL(accelerator.Url); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if owner != nil {
+		http.Error(w, fmt.Sprintf("Accelerator %s already has this url", owner.ID), http.StatusConflict)
+		return
+	}
+
+	accelerator.ID = catalog.ID(accelerator.Url)
+	if accelerator.Description == "" {
+		description, err := catalog.Describe(r.Context(), accelerator)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error generating description: %s", err), http.StatusInternalServerError)
+			return
+		}
+		accelerator.Description = description
+	}
+
+	if err := catalog.Save(r.Context(), accelerator); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/accelerators/"+accelerator.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	// The status is sent, so an encoding error can only be logged.
+	if err := json.NewEncoder(w).Encode(accelerator); err != nil {
+		log.Printf("Error encoding accelerator %s: %v", accelerator.ID, err)
+	}
+}
+
+func (h *AcceleratorsHandler) update(w http.ResponseWriter, r *http.Request, id string) {
+	existing, err := database.GetAcceleratorByID(id)
+	if err != nil {
+		http.Error(w, err.Error(), acceleratorStatus(err))
+		return
+	}
+
+	var accelerator models.Accelerator
+	if err := decodeBody(r, &accelerator); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateAccelerator(&accelerator); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	accelerator.ID = id
+
+	if owner, err := acceleratorWithURL(accelerator.Url); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if owner != nil && owner.ID != id {
+		http.Error(w, fmt.Sprintf("Accelerator %s already has this url", owner.ID), http.StatusConflict)
+		return
+	}
+
+	// An empty description keeps the stored one, or is generated like on
+	// create when there is none.
+	if accelerator.Description == "" {
+		accelerator.Description = existing.Description
+	}
+	if accelerator.Description == "" {
+		description, err := catalog.Describe(r.Context(), accelerator)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error generating description: %s", err), http.StatusInternalServerError)
+			return
+		}
+		accelerator.Description = description
+	}
+
+	if err := catalog.Save(r.Context(), accelerator); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, accelerator)
+}
+
+func validateAccelerator(accelerator *models.Accelerator) error {
+	accelerator.Title = strings.TrimSpace(accelerator.Title)
+	accelerator.Url = strings.TrimSpace(accelerator.Url)
+	accelerator.Category = strings.TrimSpace(accelerator.Category)
+	accelerator.Description = strings.TrimSpace(accelerator.Description)
+
+	if accelerator.Title == "" || accelerator.Url == "" {
+		return errors.New("title and url are required")
+	}
+	if !strings.HasPrefix(accelerator.Url, "http://") && !strings.HasPrefix(accelerator.Url, "https://") {
+		return errors.New("url must be an http(s) URL")
+	}
+	return nil
+}
+
+// acceleratorWithURL returns the accelerator documented at url, if any.
+func acceleratorWithURL(url string) (*models.Accelerator, error) {
+	all, err := database.GetAllAccelerators()
+	if err != nil {
+		return nil, err
+	}
+	for _, accelerator := range all {
+		if catalog.ID(accelerator.Url) == catalog.ID(url) {
+			return &accelerator, nil
+		}
+	}
+	return nil, nil
+}
+
+func acceleratorStatus(err error) int {
+	if errors.Is(err, database.ErrAcceleratorNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// queryInt reads a non-negative integer query parameter.
+func queryInt(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	return n, nil
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/davidulloa/mimir/database/databasetest"
+	"github.com/davidulloa/mimir/llm/llmtest"
+	"github.com/davidulloa/mimir/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func acceleratorsMux() *http.ServeMux {
+	h := NewAcceleratorsHandler()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/accelerators", h.AcceleratorsHandler)
+	mux.HandleFunc("/accelerators/search", h.SearchHandler)
+	mux.HandleFunc("/accelerators/{id}", h.AcceleratorHandler)
+	return mux
+}
+
+func adminRequest(mux http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(AdminTokenHeader, "admin-secret")
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAcceleratorCRUD(t *testing.T) {
+	// The real store, not the fixed accelerator the chat tests use.
+	databasetest.UseBoltStore(t)
+	llmtest.UseFake(t)
+	t.Setenv("MIMIR_ADMIN_TOKEN", "admin-secret")
+	mux := acceleratorsMux()
+
+	rr := serve(mux, http.MethodPost, "/accelerators", "", `{"title":"Sneaky","url":"https://example.com/x"}`)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = adminRequest(mux, http.MethodPost, "/accelerators",
+		`{"title":"Incident Health Check","url":"https://example.com/ihc","category":"ITSM","description":"Reviews incident handling."}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var created models.Accelerator
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	require.NotEmpty(t, created.ID)
+
+	rr = adminRequest(mux, http.MethodPost, "/accelerators",
+		`{"title":"CMDB Jumpstart","url":"https://example.com/cmdb","category":"ITOM","description":"Populates the CMDB."}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	rr = adminRequest(mux, http.MethodPost, "/accelerators", `{"title":"Again","url":"https://example.com/ihc/"}`)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	rr = serve(mux, http.MethodGet, "/accelerators?category=itsm", "", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var page AcceleratorList
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	assert.Equal(t, 1, page.Total)
+	assert.Equal(t, []models.Accelerator{created}, page.Accelerators)
+
+	rr = serve(mux, http.MethodGet, "/accelerators?offset=1&limit=1", "", "")
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	assert.Equal(t, 2, page.Total)
+	require.Len(t, page.Accelerators, 1)
+	assert.Equal(t, "Incident Health Check", page.Accelerators[0].Title)
+
+	rr = adminRequest(mux, http.MethodPut, "/accelerators/"+created.ID,
+		`{"title":"Incident Health Check","url":"https://example.com/ihc","category":"ITSM","description":"Scores assignment groups."}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = serve(mux, http.MethodGet, "/accelerators/"+created.ID, "", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var fetched models.Accelerator
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &fetched))
+	assert.Equal(t, "Scores assignment groups.", fetched.Description)
+
+	rr = adminRequest(mux, http.MethodPut, "/accelerators/"+created.ID,
+		`{"title":"Incident Health Check","url":"https://example.com/ihc","category":"ITSM"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &fetched))
+	assert.Equal(t, "Scores assignment groups.", fetched.Description, "an empty description keeps the stored one")
+
+	rr = serve(mux, http.MethodGet, "/accelerators/search?q=assignment+groups", "", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var matches []models.AcceleratorMatch
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &matches))
+	require.NotEmpty(t, matches)
+	assert.Equal(t, created.ID, matches[0].Accelerator.ID)
+
+	rr = adminRequest(mux, http.MethodDelete, "/accelerators/"+created.ID, "")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	rr = serve(mux, http.MethodGet, "/accelerators/"+created.ID, "", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	rr = adminRequest(mux, http.MethodDelete, "/accelerators/"+created.ID, "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
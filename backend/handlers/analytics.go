@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/davidulloa/mimir/analytics"
+	"github.com/davidulloa/mimir/models"
+	"github.com/davidulloa/mimir/notify"
+	"github.com/davidulloa/mimir/servicenow"
+)
+
+// maxTrendIncidents caps how many of the newest incidents in its range a
+// trend report reads.
+const maxTrendIncidents = 10000
+
+type trendsBody struct {
+	InstanceID string `json:"instanceId"`
+	// Granularity is "day" (the default), "week" or "month".
+	Granularity string `json:"granularity,omitempty"`
+	// From and To bound when incidents were opened, as dates (2006-01-02,
+	// both inclusive) or RFC 3339 times (To exclusive).
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+type AnalyticsHandler struct {
+	Client *http.Client
+}
+
+func NewAnalyticsHandler(client *http.Client) *AnalyticsHandler {
+	return &AnalyticsHandler{Client: client}
+}
+
+// parseBound parses a trendsBody date. A date-only upper bound covers the
+// whole day.
+func parseBound(value string, upper bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: use YYYY-MM-DD or RFC 3339", value)
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// incidentClusters maps incident numbers to the cluster the instance's latest
+// suggestion run over incidents put them in.
+func incidentClusters(instanceID string) (map[string]string, error) {
+	clusters := make(map[string]string)
+	err := eachSuggestionRun(instanceID, func(run models.SuggestionRun) bool {
+		if run.Table != "" && run.Table != servicenow.IncidentTable {
+			return true
+		}
+		for _, cluster := range run.Clusters {
+			for _, number := range cluster.TicketNumbers {
+				clusters[number] = cluster.Description
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return clusters, nil
+}
+
+// TrendsHandler reports how the instance's incidents evolve: counts, mean
+// time to resolve, reopen and SLA breach rates per time bucket, broken down
+// by cluster, category and priority. Clusters are those of the latest
+// suggestion run.
+func (h *AnalyticsHandler) TrendsHandler(w http.ResponseWriter, r *http.Request) {
+	instanceID, username, password, err := ParseServiceNowCredentials(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var body trendsBody
+	if err := decodeBody(r, &body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := analytics.Options{Granularity: body.Granularity}
+	if opts.From, err = parseBound(body.From, false); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.To, err = parseBound(body.To, true); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tickets, err := LoadTicketsOpened(r.Context(), h.Client, instanceID, username, password, servicenow.IncidentTable, opts.From, opts.To, maxTrendIncidents)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving incidents: %s", err), serviceNowStatus(err))
+		return
+	}
+
+	clusters, err := incidentClusters(instanceID)
+	if err != nil {
+		log.Printf("Error fetching suggestion runs for instance %s: %v", instanceID, err)
+	}
+	opts.Cluster = func(number string) string { return clusters[number] }
+
+	report, err := analytics.Trends(tickets, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jsonResponse(w, report)
+}
+
+type emergingBody struct {
+	InstanceID string `json:"instanceId"`
+	// Method is "ewma" (the default) or "zscore".
+	Method string `json:"method,omitempty"`
+	// Window is the length of each window as a Go duration, e.g. "24h".
+	Window    string  `json:"window,omitempty"`
+	Windows   int     `json:"windows,omitempty"`
+	Threshold float64 `json:"threshold,omitempty"`
+	MinCount  int     `json:"minCount,omitempty"`
+	// Notify sends flagged clusters to the alert hook.
+	Notify bool `json:"notify,omitempty"`
+}
+
+// EmergingResponse is the detection report and how many alerts were sent.
+type EmergingResponse struct {
+	*analytics.EmergingReport
+	Notified int `json:"notified"`
+}
+
+// EmergingHandler clusters the instance's recent incidents and flags the
+// clusters whose volume in the latest window is unusually high, optionally
+// notifying the alert hook about them.
+func (h *AnalyticsHandler) EmergingHandler(w http.ResponseWriter, r *http.Request) {
+	instanceID, username, password, err := ParseServiceNowCredentials(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var body emergingBody
+	if err := decodeBody(r, &body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := analytics.EmergingOptions{
+		Method:    body.Method,
+		Windows:   body.Windows,
+		Threshold: body.Threshold,
+		MinCount:  body.MinCount,
+	}
+	if body.Window != "" {
+		if opts.Window, err = time.ParseDuration(body.Window); err != nil {
+			http.Error(w, "window must be a duration such as 24h", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tickets, err := LoadTickets(r.Context(), h.Client, instanceID, username, password, servicenow.IncidentTable, maxTrendIncidents)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving incidents: %s", err), serviceNowStatus(err))
+		return
+	}
+
+	report, err := analytics.DetectEmerging(time.Now(), tickets, opts)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, analytics.ErrClustering) {
+			status = http.StatusInternalServerError
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	response := EmergingResponse{EmergingReport: report}
+	if alerts := analytics.Alerts(instanceID, report); body.Notify && len(alerts) > 0 {
+		notifier, err := notify.Default()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		for _, alert := range alerts {
+			if err := notifier.Notify(r.Context(), alert); err != nil {
+				log.Printf("Error notifying about emerging issue on %s: %v", instanceID, err)
+				continue
+			}
+			response.Notified++
+		}
+	}
+	jsonResponse(w, response)
+}
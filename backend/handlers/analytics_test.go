@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/davidulloa/mimir/analytics"
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func trendsRequest(client *http.Client, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/analytics/trends", bytes.NewBufferString(body))
+	req.Header.Set(ServiceNowAuthorizationHeader, "Basic dGVzdHVzZXI6dGVzdHBhc3M=")
+	rr := httptest.NewRecorder()
+	NewAnalyticsHandler(client).TrendsHandler(rr, req)
+	return rr
+}
+
+func TestTrendsHandler(t *testing.T) {
+	useTestStore(t)
+
+	client := serviceNowClient(map[string]string{"incident": `{"result": [
+		{"sys_id": "a1", "number": "INC001", "short_description": "Email is down", "priority": "1", "category": "email",
+		 "opened_at": "2026-10-01 09:00:00", "resolved_at": "2026-10-01 11:00:00", "reopen_count": "1", "made_sla": "false", "sys_created_on": "2026-10-01 09:00:00"},
+		{"sys_id": "a2", "number": "INC002", "short_description": "Email is slow", "priority": "3", "category": "email",
+		 "opened_at": "2026-10-02 09:00:00", "resolved_at": "2026-10-02 13:00:00", "reopen_count": "0", "made_sla": "true", "sys_created_on": "2026-10-02 09:00:00"},
+		{"sys_id": "a3", "number": "INC003", "short_description": "VPN will not connect", "priority": "3", "category": "network",
+		 "opened_at": "2026-10-09 09:00:00", "sys_created_on": "2026-10-09 09:00:00"}
+	]}`})
+
+	_, err := database.CreateSuggestionRun(models.SuggestionRun{
+		InstanceID: "test-instance",
+		Clusters:   []models.SuggestionCluster{{Description: "Email problems", TicketNumbers: []string{"INC001", "INC002"}}},
+	})
+	require.NoError(t, err)
+
+	rr := trendsRequest(client, `{"instanceId": "test-instance", "granularity": "week", "from": "2026-10-01", "to": "2026-10-09"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var report analytics.Report
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Equal(t, 3, report.Total.Count, "the to date is inclusive")
+	assert.InDelta(t, 3, report.Total.MeanTimeToResolveHours, 1e-9)
+	assert.InDelta(t, 1.0/3, report.Total.ReopenRate, 1e-9)
+	assert.InDelta(t, 0.5, report.Total.SLABreachRate, 1e-9)
+	assert.Len(t, report.Buckets, 2)
+
+	require.Len(t, report.Clusters, 2)
+	assert.Equal(t, "Email problems", report.Clusters[0].Key)
+	assert.Equal(t, 2, report.Clusters[0].Total.Count)
+	assert.Equal(t, analytics.Unclustered, report.Clusters[1].Key)
+	require.Len(t, report.Categories, 2)
+	assert.Equal(t, "email", report.Categories[0].Key)
+
+	rr = trendsRequest(client, `{"instanceId": "test-instance", "granularity": "year"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = trendsRequest(client, `{"instanceId": "test-instance", "from": "last week"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestEmergingHandler(t *testing.T) {
+	useTestStore(t)
+	client := serviceNowClient(map[string]string{"incident": `{"result": [
+		{"sys_id": "a1", "number": "INC001", "short_description": "Email is down", "opened_at": "2020-01-01 09:00:00", "sys_created_on": "2020-01-01 09:00:00"}
+	]}`})
+
+	emerging := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/analytics/emerging", bytes.NewBufferString(body))
+		req.Header.Set(ServiceNowAuthorizationHeader, "Basic dGVzdHVzZXI6dGVzdHBhc3M=")
+		rr := httptest.NewRecorder()
+		NewAnalyticsHandler(client).EmergingHandler(rr, req)
+		return rr
+	}
+
+	// Nothing was opened recently, so there is nothing to cluster.
+	rr := emerging(`{"instanceId": "test-instance", "method": "zscore", "window": "12h"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var response EmergingResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "zscore", response.Method)
+	assert.Empty(t, response.Clusters)
+	assert.Equal(t, 12*time.Hour, response.WindowEnd.Sub(response.WindowStart))
+
+	rr = emerging(`{"instanceId": "test-instance", "window": "a day"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = emerging(`{"instanceId": "test-instance", "method": "arima"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
@@ -2,17 +2,44 @@ package handlers
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/servicenow"
+	"github.com/davidulloa/mimir/session"
+	"github.com/davidulloa/mimir/vault"
 )
 
 type TicketRequestBody struct {
     InstanceID string `json:"instanceId"`
+    // Limit caps how many incidents are clustered, up to maxIncidentLimit
+    // (maxAgglomerativeIncidentLimit for agglomerative clustering).
+    Limit int `json:"limit,omitempty"`
+    // Table is the ServiceNow table to cluster, "incident" (the default) or
+    // "problem".
+    Table string `json:"table,omitempty"`
+    // Optional clustering mode and fixed cluster count or k range; see
+    // database.ClusteringOptions.
+    database.ClusteringOptions
+}
+
+// decodeBody unmarshals the JSON request body into v and restores r.Body so
+// later readers (middleware, then handler) can decode it again.
+func decodeBody(r *http.Request, v interface{}) error {
+	body, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
 }
 
 func ParseCredentials(r *http.Request) (string, string, string, error) {
@@ -21,15 +48,51 @@ func ParseCredentials(r *http.Request) (string, string, string, error) {
 		return "",  "", "", errors.New("basic authentication could not be collected from request")
 	}
 
-	body, err := io.ReadAll(r.Body)
-	r.Body = io.NopCloser(bytes.NewBuffer(body))
+	var responseBody TicketRequestBody
+	err := decodeBody(r, &responseBody)
 	if err != nil {
 		return "", "", "", err
 	}
 
+	if responseBody.InstanceID == "" {
+		return "", "", "", errors.New("`instanceId` not passed into request body")
+	}
+
+	return responseBody.InstanceID, username, password, nil
+}
+
+// AdminTokenHeader carries the shared secret, MIMIR_ADMIN_TOKEN, required to
+// change the accelerator catalog or issue webhook secrets. Both are refused
+// when it is unset.
+const AdminTokenHeader = "X-Mimir-Admin-Token"
+
+// requireAdmin reports whether the request carries the admin token, writing
+// the error response if it doesn't.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	expected := os.Getenv("MIMIR_ADMIN_TOKEN")
+	if expected == "" {
+		http.Error(w, "Administration is disabled", http.StatusForbidden)
+		return false
+	}
+
+	token := r.Header.Get(AdminTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		http.Error(w, "Admin token required", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// ServiceNowAuthorizationHeader can carry Basic credentials for handlers that
+// call ServiceNow, overriding the ones stored in the vault at login.
+const ServiceNowAuthorizationHeader = "X-ServiceNow-Authorization"
+
+// ParseServiceNowCredentials returns the instance ID from the request body and
+// the ServiceNow credentials to call it with: those in
+// ServiceNowAuthorizationHeader if present, otherwise the ones in the vault.
+func ParseServiceNowCredentials(r *http.Request) (string, string, string, error) {
 	var responseBody TicketRequestBody
-	err = json.Unmarshal(body, &responseBody)
-	if err != nil {
+	if err := decodeBody(r, &responseBody); err != nil {
 		return "", "", "", err
 	}
 
@@ -37,32 +100,79 @@ func ParseCredentials(r *http.Request) (string, string, string, error) {
 		return "", "", "", errors.New("`instanceId` not passed into request body")
 	}
 
-	return responseBody.InstanceID, username, password, nil
+	if header := r.Header.Get(ServiceNowAuthorizationHeader); header != "" {
+		username, password, ok := (&http.Request{Header: http.Header{"Authorization": {header}}}).BasicAuth()
+		if !ok {
+			return "", "", "", fmt.Errorf("the %s header must hold basic credentials", ServiceNowAuthorizationHeader)
+		}
+		return responseBody.InstanceID, username, password, nil
+	}
+
+	v, err := vault.Default()
+	if err != nil {
+		return "", "", "", fmt.Errorf("no ServiceNow credentials available: %v", err)
+	}
+
+	creds, err := v.Get(responseBody.InstanceID)
+	if err != nil {
+		return "", "", "", fmt.Errorf("no ServiceNow credentials available: %v", err)
+	}
+
+	return responseBody.InstanceID, creds.Username, creds.Password, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, session.TokenType) || token == "" {
+		return "", false
+	}
+	return token, true
 }
 
-type AuthorizationHandler struct {}
+type AuthorizationHandler struct {
+	// Client calls ServiceNow to verify credentials at login.
+	Client *http.Client
+}
 
-// NewDocumentationHandler creates and returns a new DocumentationHandler instance
-func NewAuthorizationHandler() *AuthorizationHandler{
-	return &AuthorizationHandler{}
+// NewAuthorizationHandler creates and returns a new AuthorizationHandler instance
+func NewAuthorizationHandler(client *http.Client) *AuthorizationHandler{
+	return &AuthorizationHandler{Client: client}
 }
 
+// AuthMiddleware requires a valid session token in the Authorization header.
+// If the body names an instance it must be the one the session was issued for.
 func AuthMiddleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		instanceID, username, password, err := ParseCredentials(r)
+		token, ok := bearerToken(r)
+		if !ok {
+			http.Error(w, "Bearer token required", http.StatusUnauthorized)
+			return
+		}
+
+		manager, err := session.Default()
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, fmt.Sprintf("Error validating session: %s", err), http.StatusInternalServerError)
 			return
 		}
 
-		valid, err := database.ValidateAuthentication(instanceID, username, password)
+		claims, err := manager.Validate(token)
+		if errors.Is(err, session.ErrInvalidToken) || errors.Is(err, session.ErrRevokedToken) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Error validating credentials: %s", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Error validating session: %s", err), http.StatusInternalServerError)
 			return
 		}
 
-		if !valid {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		var body struct {
+			InstanceID string `json:"instanceId"`
+		}
+		// Not every body is a JSON object; only an explicit instanceId is checked.
+		decodeBody(r, &body)
+		if body.InstanceID != "" && body.InstanceID != claims.InstanceID {
+			http.Error(w, "Session is not valid for this instance", http.StatusForbidden)
 			return
 		}
 
@@ -78,37 +188,135 @@ func (h *AuthorizationHandler) AuthorizationHandler(w http.ResponseWriter, r *ht
         return
     }
 
-    apiURL := fmt.Sprintf("https://%s.service-now.com/api/now/table/incident", instanceID)
-    req, err := http.NewRequest("GET", apiURL, nil)
+    snClient, err := servicenow.NewClient(instanceID, username, password, servicenow.WithHTTPClient(h.Client))
     if err != nil {
-        errMsg := fmt.Sprintf("Could not create request: %s", err)
-        http.Error(w, errMsg, http.StatusInternalServerError)
+        http.Error(w, err.Error(), http.StatusBadRequest)
         return
     }
-
-    req.SetBasicAuth(username, password)
-    client := &http.Client{}
-    resp, err := client.Do(req)
+    err = snClient.Ping(r.Context())
     if err != nil {
         errMsg := fmt.Sprintf("Could not validate credentials: %s", err)
-        http.Error(w, errMsg, http.StatusUnauthorized)
+        http.Error(w, errMsg, serviceNowStatus(err))
         return
     }
-    defer resp.Body.Close()
 
-    if resp.StatusCode != http.StatusOK {
-        errMsg := fmt.Sprintf("Invalid credentials: received status code %d", resp.StatusCode)
-        http.Error(w, errMsg, http.StatusUnauthorized)
+    // Checking the stored hash rehashes stale and legacy records. Only new
+    // users and changed passwords need registering.
+    valid, err := database.ValidateAuthentication(instanceID, username, password)
+    if err != nil {
+        errMsg := fmt.Sprintf("Could not validate authentication: %s", err)
+        http.Error(w, errMsg, http.StatusInternalServerError)
         return
     }
+    if !valid {
+        err = database.RegisterAuthentication(instanceID, username, password)
+        if err != nil {
+            errMsg := fmt.Sprintf("Could not register authentication: %s", err)
+            http.Error(w, errMsg, http.StatusInternalServerError)
+            return
+        }
+    }
 
-    err = database.RegisterAuthentication(instanceID, username, password)
+    // Keep the credentials for background jobs. Without a vault key the
+    // ServiceNow handlers need them in ServiceNowAuthorizationHeader instead.
+    if v, err := vault.Default(); err == nil {
+        creds := vault.Credentials{Username: username, Password: password}
+        // Logging in again must not drop the instance's webhook secret.
+        if existing, err := v.Get(instanceID); err == nil {
+            creds.WebhookSecret = existing.WebhookSecret
+        }
+        if err := v.Put(instanceID, creds); err != nil {
+            http.Error(w, fmt.Sprintf("Could not store credentials: %s", err), http.StatusInternalServerError)
+            return
+        }
+    } else {
+        log.Printf("Not storing ServiceNow credentials: %v", err)
+    }
+
+    manager, err := session.Default()
     if err != nil {
-        errMsg := fmt.Sprintf("Could not register authentication: %s", err)
-        http.Error(w, errMsg, http.StatusInternalServerError)
+        http.Error(w, fmt.Sprintf("Could not start session: %s", err), http.StatusInternalServerError)
+        return
+    }
+
+    tokens, err := manager.Issue(instanceID)
+    if err != nil {
+        http.Error(w, fmt.Sprintf("Could not start session: %s", err), http.StatusInternalServerError)
         return
     }
 
-    w.WriteHeader(http.StatusOK)
-    w.Write([]byte("Authorization granted"))
+    writeTokens(w, tokens)
+}
+
+type refreshBody struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+func writeTokens(w http.ResponseWriter, tokens *session.Tokens) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// RefreshHandler exchanges a refresh token for a new token pair.
+func (h *AuthorizationHandler) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body refreshBody
+	if err := decodeBody(r, &body); err != nil || body.RefreshToken == "" {
+		http.Error(w, "refreshToken is required", http.StatusBadRequest)
+		return
+	}
+
+	manager, err := session.Default()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not refresh session: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := manager.Refresh(body.RefreshToken)
+	if errors.Is(err, session.ErrInvalidToken) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not refresh session: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeTokens(w, tokens)
+}
+
+// LogoutHandler revokes the bearer token and, if given, the refresh token.
+func (h *AuthorizationHandler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body refreshBody
+	decodeBody(r, &body)
+	accessToken, _ := bearerToken(r)
+
+	if accessToken == "" && body.RefreshToken == "" {
+		http.Error(w, "A bearer token or refreshToken is required", http.StatusBadRequest)
+		return
+	}
+
+	manager, err := session.Default()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not end session: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := manager.Revoke(accessToken, body.RefreshToken); err != nil {
+		http.Error(w, fmt.Sprintf("Could not end session: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
\ No newline at end of file
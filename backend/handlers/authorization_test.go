@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/session"
+	"github.com/davidulloa/mimir/vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func useTestSessions(t *testing.T) *session.Manager {
+	manager := session.NewManager([]byte("test-secret"))
+	session.SetDefault(manager)
+	t.Cleanup(func() { session.SetDefault(nil) })
+	return manager
+}
+
+// protected answers 200 to whatever the middleware lets through.
+var protected = AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+func serve(handler http.Handler, method, target, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	useTestStore(t)
+	manager := useTestSessions(t)
+
+	tokens, err := manager.Issue("dev000001")
+	require.NoError(t, err)
+
+	rr := serve(protected, http.MethodPost, "/chat", tokens.AccessToken, `{"instanceId":"dev000001"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = serve(protected, http.MethodPost, "/chat", tokens.AccessToken, `{"instanceId":"dev000002"}`)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = serve(protected, http.MethodPost, "/chat", "", `{"instanceId":"dev000001"}`)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr = serve(protected, http.MethodPost, "/chat", "not-a-token", `{"instanceId":"dev000001"}`)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestRefreshAndLogout(t *testing.T) {
+	useTestStore(t)
+	manager := useTestSessions(t)
+	handler := NewAuthorizationHandler(http.DefaultClient)
+
+	tokens, err := manager.Issue("dev000001")
+	require.NoError(t, err)
+
+	rr := serve(http.HandlerFunc(handler.RefreshHandler), http.MethodPost, "/authorization/refresh", "",
+		`{"refreshToken":"`+tokens.RefreshToken+`"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var refreshed session.Tokens
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &refreshed))
+	assert.Equal(t, "dev000001", refreshed.InstanceID)
+
+	rr = serve(protected, http.MethodPost, "/chat", refreshed.AccessToken, `{}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = serve(http.HandlerFunc(handler.LogoutHandler), http.MethodPost, "/authorization/logout", refreshed.AccessToken,
+		`{"refreshToken":"`+refreshed.RefreshToken+`"}`)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	rr = serve(protected, http.MethodPost, "/chat", refreshed.AccessToken, `{}`)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr = serve(http.HandlerFunc(handler.RefreshHandler), http.MethodPost, "/authorization/refresh", "",
+		`{"refreshToken":"`+refreshed.RefreshToken+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestLoginUpgradesLegacyRecord(t *testing.T) {
+	store := useTestStore(t)
+	useTestSessions(t)
+	t.Setenv("AUTH_ARGON2_MEMORY", "1024")
+	t.Setenv("AUTH_ARGON2_ITERATIONS", "1")
+	t.Setenv("AUTH_ARGON2_PARALLELISM", "1")
+
+	legacyHash := database.CreateHash("admin", "hunter2")
+	_, err := store.CreateAuthRecord(database.AuthRecord{InstanceID: "dev000001", AuthHash: legacyHash})
+	require.NoError(t, err)
+
+	handler := NewAuthorizationHandler(serviceNowClient(nil))
+	req := httptest.NewRequest(http.MethodPost, "/authorization", bytes.NewBufferString(`{"instanceId":"dev000001"}`))
+	req.SetBasicAuth("admin", "hunter2")
+	rr := httptest.NewRecorder()
+	handler.AuthorizationHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var tokens session.Tokens
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &tokens))
+	assert.Equal(t, "dev000001", tokens.InstanceID)
+
+	legacy, err := store.FindAuthRecord("dev000001", legacyHash)
+	require.NoError(t, err)
+	assert.Nil(t, legacy, "the SHA-256 record was rehashed")
+
+	valid, err := database.ValidateAuthentication("dev000001", "admin", "hunter2")
+	require.NoError(t, err)
+	assert.True(t, valid)
+}
+
+func TestParseServiceNowCredentials(t *testing.T) {
+	useTestStore(t)
+	v, err := vault.New(bytes.Repeat([]byte{1}, vault.KeySize))
+	require.NoError(t, err)
+	vault.SetDefault(v)
+	t.Cleanup(func() { vault.SetDefault(nil) })
+
+	require.NoError(t, v.Put("dev000001", vault.Credentials{Username: "admin", Password: "hunter2"}))
+
+	req := httptest.NewRequest(http.MethodPost, "/tickets", bytes.NewBufferString(`{"instanceId":"dev000001"}`))
+	instanceID, username, password, err := ParseServiceNowCredentials(req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dev000001", "admin", "hunter2"}, []string{instanceID, username, password})
+
+	// The header overrides the vault.
+	req = httptest.NewRequest(http.MethodPost, "/tickets", bytes.NewBufferString(`{"instanceId":"dev000001"}`))
+	req.Header.Set(ServiceNowAuthorizationHeader, "Basic dGVzdHVzZXI6dGVzdHBhc3M=")
+	_, username, password, err = ParseServiceNowCredentials(req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"testuser", "testpass"}, []string{username, password})
+
+	req = httptest.NewRequest(http.MethodPost, "/tickets", bytes.NewBufferString(`{"instanceId":"dev000002"}`))
+	_, _, _, err = ParseServiceNowCredentials(req)
+	assert.Error(t, err)
+}
@@ -3,41 +3,28 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/llm"
 	"github.com/davidulloa/mimir/models"
-	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/option"
+	"github.com/davidulloa/mimir/retrieval"
 )
 
-type ChatHandler struct{}
-
-func NewChatHandler() *ChatHandler {
-	return &ChatHandler{}
+type ChatHandler struct {
+	// Retriever grounds replies in the accelerator's documentation. Replies
+	// are ungrounded when it is nil.
+	Retriever *retrieval.Retriever
 }
 
-func (h *ChatHandler) verifyBasicAuth(r *http.Request, instanceID string) error {
-	username, password, ok := r.BasicAuth()
-
-	if !ok {
-		return fmt.Errorf("basic authentication required")
-	}
-
-	validated, err := database.ValidateAuthentication(instanceID, username, password)
-	if err != nil {
-		return fmt.Errorf("authentication validation error: %v", err)
-	}
-
-	if !validated {
-		return fmt.Errorf("invalid credentials")
+func NewChatHandler() *ChatHandler {
+	return &ChatHandler{
+		Retriever: retrieval.NewRetriever(http.DefaultClient),
 	}
-
-	return nil
 }
 
 func (h *ChatHandler) ChatHandler(w http.ResponseWriter, r *http.Request) {
@@ -53,21 +40,26 @@ func (h *ChatHandler) ChatHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.verifyBasicAuth(r, instanceID); err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
 	if createThread, ok := body["createThread"].(bool); ok && createThread {
 		h.createChatThread(w, body)
 		return
 	}
 
 	if threadID, ok := body["threadId"].(string); ok {
-		if _, ok := body["message"]; ok {
-			h.postNewMessage(w, body)
+		if messageID, ok := body["editMessageId"].(string); ok {
+			h.editMessage(w, instanceID, threadID, messageID, body)
+		} else if messageID, ok := body["regenerateMessageId"].(string); ok {
+			h.regenerateMessage(w, instanceID, threadID, messageID)
+		} else if messageID, ok := body["activeMessageId"].(string); ok {
+			h.switchBranch(w, instanceID, threadID, messageID)
+		} else if messageID, ok := body["siblingsOf"].(string); ok {
+			h.listSiblings(w, instanceID, threadID, messageID)
+		} else if jobID, ok := body["retryJobId"].(string); ok {
+			h.retryJob(w, instanceID, threadID, jobID)
+		} else if _, ok := body["message"]; ok {
+			h.postNewMessage(w, instanceID, threadID, body)
 		} else {
-			h.fetchChatThread(w, threadID)
+			h.fetchChatThread(w, instanceID, threadID)
 		}
 		return
 	}
@@ -106,7 +98,7 @@ func (h *ChatHandler) createChatThread(w http.ResponseWriter, body map[string]in
 		return
 	}
 
-	go h.generateInitialBotResponse(threadID, acceleratorID)
+	go h.generateInitialBotResponse(threadID)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -114,46 +106,102 @@ func (h *ChatHandler) createChatThread(w http.ResponseWriter, body map[string]in
 	})
 }
 
-func (h *ChatHandler) getBotResponse(systemPrompt string, threadID string, userMessage models.ChatMessage) string {
-	client := openai.NewClient(
-		option.WithAPIKey(os.Getenv("OPENAI_API_KEY")),
-	)
-	previousMessages, err := database.GetChatMessages(threadID)
+// getBotResponse answers the last message of history, the branch being
+// replied to.
+func (h *ChatHandler) getBotResponse(systemPrompt string, threadID string, history []models.ChatMessage) (string, error) {
+	provider, err := llm.Default()
 	if err != nil {
-		log.Printf("Error fetching previous messages for thread %s: %v", threadID, err)
-		return "I'm sorry, I encountered an error while processing your request."
+		return "", fmt.Errorf("the language model is unavailable: %w", err)
 	}
 
-	messages := []openai.ChatCompletionMessageParamUnion{
-		openai.SystemMessage(systemPrompt),
+	messages := compactConversation(context.TODO(), provider, threadID, systemPrompt, history)
+
+	reply, err := provider.Complete(context.TODO(), llm.Request{Messages: messages})
+
+	if errors.Is(err, llm.ErrEmptyResponse) {
+		return "", errors.New("the model returned an empty response")
 	}
 
-	for _, msg := range previousMessages {
+	if err != nil {
+		return "", fmt.Errorf("the model failed to respond: %w", err)
+	}
+
+	return reply, nil
+}
+
+// conversationMessages turns stored chat history into a model conversation
+// that starts with the system prompt.
+func conversationMessages(systemPrompt string, history []models.ChatMessage) []llm.Message {
+	messages := []llm.Message{
+		llm.System(systemPrompt),
+	}
+
+	for _, msg := range history {
 		if msg.Role == "user" {
-			messages = append(messages, openai.UserMessage(msg.Content))
+			messages = append(messages, llm.User(msg.Content))
 		} else if msg.Role == "assistant" {
-			messages = append(messages, openai.AssistantMessage(msg.Content))
+			messages = append(messages, llm.Assistant(msg.Content))
 		}
 	}
 
-	messages = append(messages, openai.UserMessage(userMessage.Content))
-
-	chat, err := client.Chat.Completions.New(context.TODO(), openai.ChatCompletionNewParams{
-		Messages: openai.F(messages),
-		Model:    openai.F(openai.ChatModelGPT4o2024_08_06),
-	})
+	return messages
+}
 
+// compactConversation is conversationMessages within the model's token
+// budget. Turns that don't fit are folded into the thread's rolling summary,
+// which is sent after the system prompt in their place.
+func compactConversation(ctx context.Context, provider llm.Provider, threadID string, systemPrompt string, history []models.ChatMessage) []llm.Message {
+	thread, err := database.GetChatThread(threadID)
 	if err != nil {
-		log.Printf("Error generating bot response for thread %s: %v", threadID, err)
-		return "I apologize, but I'm having trouble generating a response right now. Please try again later."
+		log.Printf("Error fetching summary of thread %s: %v", threadID, err)
+		return conversationMessages(systemPrompt, history)
+	}
+
+	// The summary only covers this branch if it passes through the last
+	// message summarized.
+	summary := ""
+	if thread.Summary != "" {
+		for i, msg := range history {
+			if msg.ID == thread.SummaryMessageID {
+				summary, history = thread.Summary, history[i+1:]
+				break
+			}
+		}
 	}
 
-	if len(chat.Choices) == 0 || chat.Choices[0].Message.Content == "" {
-		log.Printf("Received empty response from OpenAI for thread %s", threadID)
-		return "I'm sorry, but I couldn't generate a meaningful response. Please rephrase your question or try again later."
+	var turns []models.ChatMessage
+	for _, msg := range history {
+		if msg.Role == "user" || msg.Role == "assistant" {
+			turns = append(turns, msg)
+		}
 	}
 
-	return chat.Choices[0].Message.Content
+	compactor := &llm.Compactor{
+		Provider: provider,
+		Budget:   llm.ContextBudget(llm.ConfiguredModel()),
+	}
+	if fold := compactor.Fold(systemPrompt, summary, conversationMessages(systemPrompt, turns)[1:]); fold > 0 {
+		folded, err := compactor.Summarize(ctx, summary, conversationMessages(systemPrompt, turns[:fold])[1:])
+		if err != nil {
+			// The turns are dropped all the same, to keep the request within
+			// the budget.
+			log.Printf("Error summarizing thread %s: %v", threadID, err)
+		} else {
+			summary = folded
+			if err := database.SetChatSummary(threadID, summary, turns[fold-1].ID); err != nil {
+				log.Printf("Error storing summary of thread %s: %v", threadID, err)
+			}
+		}
+		turns = turns[fold:]
+	}
+
+	messages := conversationMessages(systemPrompt, turns)
+	if summary != "" {
+		messages = append(messages[:1], append([]llm.Message{
+			llm.System("Summary of the earlier conversation:\n\n" + summary),
+		}, messages[1:]...)...)
+	}
+	return messages
 }
 
 func (h *ChatHandler) generateSystemPrompt(acceleratorID string) (string, error) {
@@ -197,107 +245,224 @@ func (h *ChatHandler) generateSystemPrompt(acceleratorID string) (string, error)
 	return systemPrompt, nil
 }
 
-func (h *ChatHandler) generateInitialBotResponse(threadID, acceleratorID string) {
+// groundedPrompt extends the accelerator's system prompt with the passages of
+// its documentation most relevant to query, and returns citations for them.
+// A retrieval failure only costs the grounding, not the reply.
+func (h *ChatHandler) groundedPrompt(ctx context.Context, acceleratorID string, query string) (string, []models.Citation, error) {
 	systemPrompt, err := h.generateSystemPrompt(acceleratorID)
 	if err != nil {
-		log.Printf("Error adding initial user message to thread %s: %v", threadID, err)
-		return
+		return "", nil, err
+	}
+
+	if h.Retriever == nil {
+		return systemPrompt, nil, nil
+	}
+
+	chunks, err := h.Retriever.Retrieve(ctx, acceleratorID, query)
+	if err != nil {
+		log.Printf("Error retrieving documentation for accelerator %s: %v", acceleratorID, err)
+		return systemPrompt, nil, nil
+	}
+	if len(chunks) == 0 {
+		return systemPrompt, nil, nil
+	}
+
+	return systemPrompt + "\n\n" + retrieval.PromptContext(chunks), retrieval.Citations(chunks), nil
+}
+
+// nextParentID returns the parent of a message added to the thread's active
+// branch.
+func nextParentID(thread *models.ChatThread) string {
+	if len(thread.Messages) == 0 {
+		return thread.ID
+	}
+	return thread.Messages[len(thread.Messages)-1].ID
+}
+
+// maxReanswers bounds how often reply starts over for follow-ups that
+// arrived while it was generating.
+const maxReanswers = 2
+
+// reply generates and stores the assistant's answer to the user message
+// messageID, along with its follow-ups; see questionHistory. Follow-ups sent
+// while the answer is generated make it start over, up to maxReanswers
+// times, so the answer is stored after them without ignoring them. Those
+// still arriving after that are left for a reply of their own. It returns
+// the answer's ID and the follow-ups it answered.
+func (h *ChatHandler) reply(threadID string, messageID string) (string, []string, error) {
+	thread, history, followUps, err := questionHistory(threadID, messageID)
+	if err != nil {
+		return "", nil, err
 	}
 
+	for attempt := 0; ; attempt++ {
+		question := history[len(history)-1]
+		systemPrompt, citations, err := h.groundedPrompt(context.TODO(), thread.AcceleratorId, question.Content)
+		if err != nil {
+			return "", nil, fmt.Errorf("error loading accelerator: %w", err)
+		}
+
+		botResponse, err := h.getBotResponse(systemPrompt, threadID, history)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if attempt < maxReanswers {
+			latest, latestHistory, latestFollowUps, err := questionHistory(threadID, messageID)
+			if err != nil {
+				return "", nil, err
+			}
+			if len(latestHistory) > len(history) {
+				thread, history, followUps = latest, latestHistory, latestFollowUps
+				continue
+			}
+		}
+
+		botMessage := models.ChatMessage{
+			Content:   botResponse,
+			Role:      "assistant",
+			Citations: retrieval.Cited(citations, botResponse),
+			ParentID:  question.ID,
+		}
+
+		id, err := database.AddChatMessage(threadID, botMessage)
+		if err != nil {
+			return "", nil, fmt.Errorf("error saving reply: %w", err)
+		}
+		return id, followUps, nil
+	}
+}
+
+func (h *ChatHandler) generateInitialBotResponse(threadID string) {
 	userMessage := models.ChatMessage{
-		Content: "How can I use this accelerator in my service?",
-		Role:    "user",
+		Content:  "How can I use this accelerator in my service?",
+		Role:     "user",
+		ParentID: threadID,
 	}
 
-	err = database.AddChatMessage(threadID, userMessage)
+	var err error
+	userMessage.ID, err = database.AddChatMessage(threadID, userMessage)
 	if err != nil {
 		log.Printf("Error adding initial user message to thread %s: %v", threadID, err)
 		return
 	}
 
-	botResponse := h.getBotResponse(systemPrompt, threadID, userMessage)
-
-	botMessage := models.ChatMessage{
-		Content: botResponse,
-		Role:    "assistant",
+	// Messages posted meanwhile are queued behind the opening question and
+	// answered once it is.
+	job, err := reserveReply(threadID, userMessage.ID)
+	if err != nil {
+		log.Printf("Error queueing reply in thread %s: %v", threadID, err)
+		return
+	}
+	if claimed, err := waitForTurn(context.Background(), &job); !claimed {
+		if err != nil {
+			log.Printf("Error claiming reply in thread %s: %v", threadID, err)
+		}
+		return
 	}
 
-	err = database.AddChatMessage(threadID, botMessage)
+	err = h.answer(job)
+	replies.handOn(h, threadID)
 	if err != nil {
-		log.Printf("Error adding bot response to thread %s: %v", threadID, err)
 		return
 	}
 
 	database.EditChatThreadTitle(threadID)
 }
 
-func (h *ChatHandler) fetchChatThread(w http.ResponseWriter, threadID string) {
-	chatThread, err := database.GetChatThread(threadID)
+func (h *ChatHandler) fetchChatThread(w http.ResponseWriter, instanceID, threadID string) {
+	chatThread, tree, ok := chatTree(w, instanceID, threadID)
+	if !ok {
+		return
+	}
+
+	jobs, err := database.GetGenerationJobs(threadID)
 	if err != nil {
-		log.Printf("Error fetching chat thread: %v", err)
+		log.Printf("Error fetching generation jobs: %v", err)
 		http.Error(w, "Error fetching chat thread", http.StatusInternalServerError)
 		return
 	}
 
+	// The job of interest answers the branch's last user message. A new
+	// thread has none until its opening question is stored, and threads
+	// from before jobs have none for a question still waiting for a reply.
 	status := "ready"
-	if len(chatThread.Messages) == 0 {
+	var job *models.GenerationJob
+	for i := len(chatThread.Messages) - 1; i >= 0; i-- {
+		if chatThread.Messages[i].Role == "user" {
+			job = jobFor(jobs, chatThread.Messages[i].ID, time.Now())
+			break
+		}
+	}
+	if job != nil && job.Status == models.JobFailed {
+		status = "failed"
+	} else if job != nil && job.Status != models.JobSucceeded {
+		status = "processing"
+	} else if len(chatThread.Messages) == 0 || (job == nil && chatThread.Messages[len(chatThread.Messages)-1].Role == "user") {
 		status = "processing"
 	}
 
 	response := struct {
 		*models.ChatThread
+		// Status is "processing" while a reply is pending, "failed" when
+		// generating it failed and "ready" otherwise.
 		Status string `json:"status"`
+		// Job is the generation of the reply to the branch's last user
+		// message. A failed job can be retried.
+		Job *models.GenerationJob `json:"job,omitempty"`
+		// Siblings lists, for each message on the branch that was edited or
+		// regenerated, the IDs of its alternatives in order, itself included.
+		Siblings map[string][]string `json:"siblings,omitempty"`
 	}{
 		ChatThread: chatThread,
 		Status:     status,
+		Job:        job,
+		Siblings:   branchSiblings(tree, chatThread.Messages),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-func (h *ChatHandler) postNewMessage(w http.ResponseWriter, body map[string]interface{}) {
-	threadID := body["threadId"].(string)
-	acceleratorID, ok := body["acceleratorId"].(string)
-
-	if !ok {
+func (h *ChatHandler) postNewMessage(w http.ResponseWriter, instanceID, threadID string, body map[string]interface{}) {
+	// Still required for compatibility; replies use the thread's accelerator.
+	if _, ok := body["acceleratorId"].(string); !ok {
 		http.Error(w, "acceleratorId is required", http.StatusBadRequest)
 		return
 	}
 
-	messageContent := body["message"].(map[string]interface{})["content"].(string)
+	message, _ := body["message"].(map[string]interface{})
+	messageContent, _ := message["content"].(string)
+	if messageContent == "" {
+		http.Error(w, "message.content is required", http.StatusBadRequest)
+		return
+	}
 
-	message := models.ChatMessage{
-		Content: messageContent,
-		Role:    "user",
+	thread, _, ok := chatTree(w, instanceID, threadID)
+	if !ok {
+		return
 	}
 
-	err := database.AddChatMessage(threadID, message)
+	question := models.ChatMessage{
+		Content:  messageContent,
+		Role:     "user",
+		ParentID: nextParentID(thread),
+	}
+
+	var err error
+	question.ID, err = database.AddChatMessage(threadID, question)
 	if err != nil {
 		log.Printf("Error adding user message: %v", err)
 		http.Error(w, "Error adding message", http.StatusInternalServerError)
 		return
 	}
 
-	go func() {
-		systemPrompt, err := h.generateSystemPrompt(acceleratorID)
-		botResponse := h.getBotResponse(systemPrompt, threadID, message)
-		if err != nil {
-			log.Printf("Error generating bot response: %v", err)
-			return
-		}
-
-		botMessage := models.ChatMessage{
-			Content: botResponse,
-			Role:    "assistant",
-		}
-
-		err = database.AddChatMessage(threadID, botMessage)
-		if err != nil {
-			log.Printf("Error adding bot message: %v", err)
-			return
-		}
-	}()
+	if _, err := queueReply(threadID, question.ID); err != nil {
+		log.Printf("Error queueing reply: %v", err)
+		http.Error(w, "Error queueing reply", http.StatusInternalServerError)
+		return
+	}
+	replies.kick(h, threadID)
 
 	chatThread, err := database.GetChatThread(threadID)
 	if err != nil {
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/models"
+)
+
+// chatTree loads the thread and the tree of all its messages, answering the
+// request itself when the thread is missing or belongs to another instance.
+func chatTree(w http.ResponseWriter, instanceID, threadID string) (*models.ChatThread, *database.ChatTree, bool) {
+	thread, err := database.GetChatThread(threadID)
+	if err != nil {
+		log.Printf("Error fetching chat thread: %v", err)
+		http.Error(w, "Error fetching chat thread", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	if thread.UserID != instanceID {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	messages, err := database.GetChatMessages(threadID)
+	if err != nil {
+		log.Printf("Error fetching chat messages: %v", err)
+		http.Error(w, "Error fetching chat thread", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	return thread, database.NewChatTree(threadID, messages), true
+}
+
+// branchSiblings maps each message of branch that has alternatives to the
+// IDs of all of them, oldest first.
+func branchSiblings(tree *database.ChatTree, branch []models.ChatMessage) map[string][]string {
+	var siblings map[string][]string
+	for _, message := range branch {
+		alternatives := tree.Siblings(message.ID)
+		if len(alternatives) < 2 {
+			continue
+		}
+		if siblings == nil {
+			siblings = make(map[string][]string)
+		}
+		for _, alternative := range alternatives {
+			siblings[message.ID] = append(siblings[message.ID], alternative.ID)
+		}
+	}
+	return siblings
+}
+
+// editMessage adds an edited copy of a user message as its sibling, which
+// starts a new branch, and answers it in the background.
+func (h *ChatHandler) editMessage(w http.ResponseWriter, instanceID, threadID, messageID string, body map[string]interface{}) {
+	message, _ := body["message"].(map[string]interface{})
+	content, _ := message["content"].(string)
+	if content == "" {
+		http.Error(w, "message.content is required", http.StatusBadRequest)
+		return
+	}
+
+	_, tree, ok := chatTree(w, instanceID, threadID)
+	if !ok {
+		return
+	}
+
+	original, ok := tree.Message(messageID)
+	if !ok {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if original.Role != "user" {
+		http.Error(w, "Only user messages can be edited", http.StatusBadRequest)
+		return
+	}
+
+	edited := models.ChatMessage{
+		Content:  content,
+		Role:     "user",
+		ParentID: original.ParentID,
+	}
+
+	var err error
+	edited.ID, err = database.AddChatMessage(threadID, edited)
+	if err != nil {
+		log.Printf("Error adding edited message: %v", err)
+		http.Error(w, "Error adding message", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := queueReply(threadID, edited.ID); err != nil {
+		log.Printf("Error queueing reply: %v", err)
+		http.Error(w, "Error queueing reply", http.StatusInternalServerError)
+		return
+	}
+	replies.kick(h, threadID)
+
+	h.fetchChatThread(w, instanceID, threadID)
+}
+
+// regenerateMessage answers an assistant message's question again. The new
+// reply becomes the message's sibling; until it arrives the thread shows the
+// branch ending with the question.
+func (h *ChatHandler) regenerateMessage(w http.ResponseWriter, instanceID, threadID, messageID string) {
+	_, tree, ok := chatTree(w, instanceID, threadID)
+	if !ok {
+		return
+	}
+
+	original, ok := tree.Message(messageID)
+	if !ok {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if original.Role != "assistant" || len(tree.Branch(original.ParentID)) == 0 {
+		http.Error(w, "Only assistant replies can be regenerated", http.StatusBadRequest)
+		return
+	}
+
+	if err := database.SetActiveChatMessage(threadID, original.ParentID); err != nil {
+		log.Printf("Error switching branch: %v", err)
+		http.Error(w, "Error regenerating message", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := queueReply(threadID, original.ParentID); err != nil {
+		log.Printf("Error queueing reply: %v", err)
+		http.Error(w, "Error queueing reply", http.StatusInternalServerError)
+		return
+	}
+	replies.kick(h, threadID)
+
+	h.fetchChatThread(w, instanceID, threadID)
+}
+
+// switchBranch shows the branch through messageID, down to its most recent
+// reply.
+func (h *ChatHandler) switchBranch(w http.ResponseWriter, instanceID, threadID, messageID string) {
+	_, tree, ok := chatTree(w, instanceID, threadID)
+	if !ok {
+		return
+	}
+
+	if _, ok := tree.Message(messageID); !ok {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	if err := database.SetActiveChatMessage(threadID, tree.LatestLeaf(messageID)); err != nil {
+		log.Printf("Error switching branch: %v", err)
+		http.Error(w, "Error switching branch", http.StatusInternalServerError)
+		return
+	}
+
+	h.fetchChatThread(w, instanceID, threadID)
+}
+
+// listSiblings returns the alternatives of a message, itself included,
+// oldest first.
+func (h *ChatHandler) listSiblings(w http.ResponseWriter, instanceID, threadID, messageID string) {
+	_, tree, ok := chatTree(w, instanceID, threadID)
+	if !ok {
+		return
+	}
+
+	siblings := tree.Siblings(messageID)
+	if siblings == nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(siblings)
+}
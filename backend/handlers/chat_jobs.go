@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/models"
+	"github.com/google/uuid"
+)
+
+// staleJobAfter is how long a job can go without progress before it is
+// taken to have died with the process running it. Replies take seconds, so
+// this is generous.
+const staleJobAfter = 10 * time.Minute
+
+const errJobInterrupted = "generation was interrupted"
+
+// queueReply records a queued job answering the user message messageID.
+func queueReply(threadID string, messageID string) (models.GenerationJob, error) {
+	job := models.GenerationJob{
+		ThreadID:  threadID,
+		MessageID: messageID,
+		Status:    models.JobQueued,
+	}
+	err := database.SaveGenerationJob(&job)
+	return job, err
+}
+
+// reserveReply records a queued job answering the user message messageID
+// that only the caller can start, with waitForTurn.
+func reserveReply(threadID string, messageID string) (models.GenerationJob, error) {
+	job := models.GenerationJob{
+		ThreadID:  threadID,
+		MessageID: messageID,
+		Status:    models.JobQueued,
+		Owner:     uuid.NewString(),
+	}
+	err := database.SaveGenerationJob(&job)
+	return job, err
+}
+
+// setJobStatus records the job's progress. A failure's cause is kept as the
+// job's error. Jobs start running when they are claimed.
+func setJobStatus(job *models.GenerationJob, status string, cause error) {
+	job.Status = status
+	job.Error = ""
+	if cause != nil {
+		job.Error = cause.Error()
+	}
+
+	if err := database.SaveGenerationJob(job); err != nil {
+		log.Printf("Error saving generation job %s of thread %s: %v", job.ID, job.ThreadID, err)
+	}
+}
+
+// jobState returns the job as clients see it: one that stopped making
+// progress is reported as failed, so it can be retried.
+func jobState(job models.GenerationJob, now time.Time) *models.GenerationJob {
+	pending := job.Status == models.JobQueued || job.Status == models.JobRunning
+	if pending && now.Sub(job.UpdatedAt) > staleJobAfter {
+		job.Status = models.JobFailed
+		job.Error = errJobInterrupted
+	}
+	return &job
+}
+
+// jobFor returns the state of the latest job answering messageID, or nil.
+func jobFor(jobs []models.GenerationJob, messageID string, now time.Time) *models.GenerationJob {
+	for i := len(jobs) - 1; i >= 0; i-- {
+		if jobs[i].MessageID == messageID {
+			return jobState(jobs[i], now)
+		}
+	}
+	return nil
+}
+
+// retryJob runs a failed job again, showing the branch ending with the
+// message it answers until the new reply arrives.
+func (h *ChatHandler) retryJob(w http.ResponseWriter, instanceID, threadID, jobID string) {
+	_, tree, ok := chatTree(w, instanceID, threadID)
+	if !ok {
+		return
+	}
+
+	jobs, err := database.GetGenerationJobs(threadID)
+	if err != nil {
+		log.Printf("Error fetching generation jobs: %v", err)
+		http.Error(w, "Error fetching generation jobs", http.StatusInternalServerError)
+		return
+	}
+
+	var job *models.GenerationJob
+	for _, candidate := range jobs {
+		if candidate.ID == jobID {
+			job = jobState(candidate, time.Now())
+		}
+	}
+	if job == nil {
+		http.Error(w, "Generation job not found", http.StatusNotFound)
+		return
+	}
+	if job.Status != models.JobFailed {
+		http.Error(w, "Only failed generation jobs can be retried", http.StatusConflict)
+		return
+	}
+
+	if _, ok := tree.Message(job.MessageID); !ok {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	if err := database.SetActiveChatMessage(threadID, job.MessageID); err != nil {
+		log.Printf("Error switching branch: %v", err)
+		http.Error(w, "Error retrying generation job", http.StatusInternalServerError)
+		return
+	}
+
+	// Queue the job again before answering, so the thread shows it pending.
+	job.Owner = ""
+	setJobStatus(job, models.JobQueued, nil)
+	replies.kick(h, threadID)
+
+	h.fetchChatThread(w, instanceID, threadID)
+}
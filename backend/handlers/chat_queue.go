@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/models"
+	"github.com/google/uuid"
+)
+
+// replyQueue generates each thread's replies one at a time, in order. The
+// queue proper is the thread's generation jobs in the store: a job only runs
+// once it is claimed there, which takes the thread's oldest queued job while
+// no other job of the thread is running, so replicas sharing the store take
+// turns. A process only remembers which threads it is draining, so it doesn't
+// start a drain per kick.
+type replyQueue struct {
+	mu      sync.Mutex
+	threads map[string]*threadQueue
+}
+
+type threadQueue struct {
+	// kicked records jobs queued while draining, which the drain may have
+	// missed.
+	kicked bool
+}
+
+var replies = &replyQueue{threads: make(map[string]*threadQueue)}
+
+// jobOwner identifies this process as the owner of the jobs it drains.
+var jobOwner = uuid.NewString()
+
+// claimPollInterval is how often a request waiting for its turn in a thread
+// tries to claim its job.
+const claimPollInterval = 100 * time.Millisecond
+
+// handOn kicks the thread if it has queued jobs. Whoever is done running a
+// job outside a drain hands the thread on, so jobs left waiting on it are
+// answered.
+func (q *replyQueue) handOn(h *ChatHandler, threadID string) {
+	jobs, err := database.GetGenerationJobs(threadID, models.JobQueued)
+	if err != nil {
+		log.Printf("Error fetching generation jobs of thread %s: %v", threadID, err)
+		return
+	}
+	if len(jobs) > 0 {
+		q.kick(h, threadID)
+	}
+}
+
+// kick has h answer the thread's queued jobs in the background, unless this
+// process is already answering them.
+func (q *replyQueue) kick(h *ChatHandler, threadID string) {
+	q.mu.Lock()
+	if t, ok := q.threads[threadID]; ok {
+		t.kicked = true
+		q.mu.Unlock()
+		return
+	}
+	t := &threadQueue{}
+	q.threads[threadID] = t
+	q.mu.Unlock()
+
+	go func() {
+		for {
+			h.drain(threadID)
+
+			q.mu.Lock()
+			again := t.kicked
+			t.kicked = false
+			if !again {
+				delete(q.threads, threadID)
+			}
+			q.mu.Unlock()
+			if !again {
+				return
+			}
+		}
+	}()
+}
+
+// drain answers the thread's queued jobs, oldest first, until none are left
+// or the next can't be claimed: another process or request is running one,
+// or the next is reserved for a request.
+func (h *ChatHandler) drain(threadID string) {
+	for {
+		jobs, err := database.GetGenerationJobs(threadID, models.JobQueued)
+		if err != nil {
+			log.Printf("Error fetching generation jobs of thread %s: %v", threadID, err)
+			return
+		}
+		if len(jobs) == 0 {
+			return
+		}
+		next := &jobs[0]
+
+		job, err := database.ClaimGenerationJob(threadID, next.ID, jobOwner, staleJobAfter)
+		if err != nil {
+			log.Printf("Error claiming generation job %s of thread %s: %v", next.ID, threadID, err)
+			return
+		}
+		if job == nil {
+			return
+		}
+		h.answer(*job)
+	}
+}
+
+// waitForTurn claims the reserved job once it is the thread's next, polling
+// until then. It returns false if the job stopped being queued meanwhile,
+// typically because an earlier reply answered its message as a follow-up.
+// If ctx is done first, the reservation is dropped so the job can be
+// answered in the background.
+func waitForTurn(ctx context.Context, job *models.GenerationJob) (bool, error) {
+	for {
+		claimed, err := database.ClaimGenerationJob(job.ThreadID, job.ID, job.Owner, staleJobAfter)
+		if err != nil {
+			return false, err
+		}
+		if claimed != nil {
+			*job = *claimed
+			return true, nil
+		}
+
+		jobs, err := database.GetGenerationJobs(job.ThreadID, models.JobQueued)
+		if err != nil {
+			return false, err
+		}
+		queued := false
+		for _, stored := range jobs {
+			if stored.ID == job.ID {
+				queued = true
+				break
+			}
+		}
+		if !queued {
+			return false, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			job.Owner = ""
+			if err := database.SaveGenerationJob(job); err != nil {
+				log.Printf("Error saving generation job %s of thread %s: %v", job.ID, job.ThreadID, err)
+			}
+			return false, ctx.Err()
+		case <-time.After(claimPollInterval):
+		}
+	}
+}
+
+// questionHistory returns the thread and the history to answer messageID
+// with, read now so that it includes earlier replies. User messages sent
+// after it, before any reply, are answered along with it; followUps lists
+// them.
+func questionHistory(threadID string, messageID string) (thread *models.ChatThread, history []models.ChatMessage, followUps []string, err error) {
+	thread, err = database.GetChatThread(threadID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for i, message := range thread.Messages {
+		if message.ID != messageID {
+			continue
+		}
+
+		history = append(history, thread.Messages[:i+1]...)
+		for _, next := range thread.Messages[i+1:] {
+			if next.Role != "user" {
+				break
+			}
+			history = append(history, next)
+			followUps = append(followUps, next.ID)
+		}
+		return thread, history, followUps, nil
+	}
+
+	// The question is off the branch shown, so nothing follows it there.
+	messages, err := database.GetChatMessages(threadID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	tree := database.NewChatTree(threadID, messages)
+	if _, ok := tree.Message(messageID); !ok {
+		return nil, nil, nil, errors.New("the message to answer no longer exists")
+	}
+	return thread, tree.Branch(messageID), nil, nil
+}
+
+// completeFollowUps marks the queued jobs for follow-up messages as answered
+// by replyID.
+func completeFollowUps(threadID string, followUps []string, replyID string) {
+	if len(followUps) == 0 {
+		return
+	}
+
+	jobs, err := database.GetGenerationJobs(threadID, models.JobQueued)
+	if err != nil {
+		log.Printf("Error fetching generation jobs of thread %s: %v", threadID, err)
+		return
+	}
+
+	answered := make(map[string]bool, len(followUps))
+	for _, id := range followUps {
+		answered[id] = true
+	}
+	// Only queued jobs: a running job belongs to whoever claimed it, who
+	// stores its own reply.
+	for _, job := range jobs {
+		if answered[job.MessageID] {
+			job.ReplyID = replyID
+			setJobStatus(&job, models.JobSucceeded, nil)
+		}
+	}
+}
+
+// answeredReply returns the reply that completed job, if another job
+// answered its message as a follow-up.
+func answeredReply(job models.GenerationJob) *models.ChatMessage {
+	jobs, err := database.GetGenerationJobs(job.ThreadID, models.JobSucceeded)
+	if err != nil {
+		log.Printf("Error fetching generation jobs of thread %s: %v", job.ThreadID, err)
+		return nil
+	}
+
+	for _, stored := range jobs {
+		if stored.ID != job.ID {
+			continue
+		}
+		messages, err := database.GetChatMessages(job.ThreadID)
+		if err != nil {
+			log.Printf("Error fetching chat messages of thread %s: %v", job.ThreadID, err)
+			return nil
+		}
+		if reply, ok := database.NewChatTree(job.ThreadID, messages).Message(stored.ReplyID); ok {
+			return &reply
+		}
+	}
+	return nil
+}
+
+// answer runs the job, which must have been claimed, and records how it
+// went. The error is already logged and recorded on the job.
+func (h *ChatHandler) answer(job models.GenerationJob) error {
+	replyID, followUps, err := h.reply(job.ThreadID, job.MessageID)
+	if err != nil {
+		log.Printf("Error generating reply in thread %s: %v", job.ThreadID, err)
+		setJobStatus(&job, models.JobFailed, err)
+		return err
+	}
+
+	job.ReplyID = replyID
+	setJobStatus(&job, models.JobSucceeded, nil)
+	completeFollowUps(job.ThreadID, followUps, replyID)
+	return nil
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/models"
+)
+
+const (
+	defaultChatSearchLimit = 20
+	maxChatSearchLimit     = 100
+)
+
+type chatSearchBody struct {
+	InstanceID string `json:"instanceId"`
+	Query      string `json:"query"`
+	// Mode is "hybrid" (the default), "keyword" or "semantic".
+	Mode          string `json:"mode,omitempty"`
+	AcceleratorID string `json:"acceleratorId,omitempty"`
+	// From and To bound when messages were sent, as dates (2006-01-02, both
+	// inclusive) or RFC 3339 times (To exclusive).
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+// SearchHandler serves POST /chat/search, finding the messages and thread
+// titles in the instance's chat history that best match the query.
+func (h *ChatHandler) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body chatSearchBody
+	if err := decodeBody(r, &body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if body.InstanceID == "" {
+		http.Error(w, "instanceId is required", http.StatusBadRequest)
+		return
+	}
+
+	search := database.ChatSearch{
+		Query:         strings.TrimSpace(body.Query),
+		Mode:          body.Mode,
+		AcceleratorID: body.AcceleratorID,
+		Limit:         body.Limit,
+	}
+	if search.Query == "" {
+		http.Error(w, "query is required", http.StatusBadRequest)
+		return
+	}
+	switch search.Mode {
+	case "", database.SearchKeyword, database.SearchSemantic, database.SearchHybrid:
+	default:
+		http.Error(w, fmt.Sprintf("unknown mode %q: use keyword, semantic or hybrid", search.Mode), http.StatusBadRequest)
+		return
+	}
+	if search.Limit <= 0 {
+		search.Limit = defaultChatSearchLimit
+	}
+	if search.Limit > maxChatSearchLimit {
+		search.Limit = maxChatSearchLimit
+	}
+
+	var err error
+	if search.From, err = parseBound(body.From, false); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if search.To, err = parseBound(body.To, true); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results, err := database.SearchChatHistory(r.Context(), body.InstanceID, search)
+	if err != nil {
+		log.Printf("Error searching chat history of instance %s: %v", body.InstanceID, err)
+		http.Error(w, "Error searching chat history", http.StatusInternalServerError)
+		return
+	}
+	if results == nil {
+		results = []models.ChatSearchResult{}
+	}
+	jsonResponse(w, results)
+}
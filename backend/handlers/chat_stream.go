@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/llm"
+	"github.com/davidulloa/mimir/models"
+	"github.com/davidulloa/mimir/retrieval"
+)
+
+// Events sent on the /chat/stream response.
+const (
+	// eventDelta carries the next piece of the assistant's reply.
+	eventDelta = "delta"
+	// eventDone carries the persisted assistant message once the reply is complete.
+	eventDone = "done"
+	// eventError reports a failure after the stream has started.
+	eventError = "error"
+)
+
+type streamMessageBody struct {
+	InstanceID string `json:"instanceId"`
+	ThreadID   string `json:"threadId"`
+	Message    struct {
+		Content string `json:"content"`
+	} `json:"message"`
+}
+
+type deltaEvent struct {
+	Content string `json:"content"`
+}
+
+type doneEvent struct {
+	MessageID string             `json:"messageId"`
+	Message   models.ChatMessage `json:"message"`
+}
+
+type errorEvent struct {
+	Error string `json:"error"`
+}
+
+// StreamHandler stores the user's message, then streams the assistant's reply
+// over Server-Sent Events as the model produces it. The stream ends with a
+// "done" event carrying the persisted reply, or an "error" event.
+func (h *ChatHandler) StreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body streamMessageBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if body.ThreadID == "" || body.Message.Content == "" {
+		http.Error(w, "threadId and message.content are required", http.StatusBadRequest)
+		return
+	}
+
+	thread, err := database.GetChatThread(body.ThreadID)
+	if err != nil {
+		log.Printf("Error fetching chat thread: %v", err)
+		http.Error(w, "Error fetching chat thread", http.StatusNotFound)
+		return
+	}
+
+	if thread.UserID != body.InstanceID {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	systemPrompt, citations, err := h.groundedPrompt(r.Context(), thread.AcceleratorId, body.Message.Content)
+	if err != nil {
+		http.Error(w, "Error loading accelerator", http.StatusInternalServerError)
+		return
+	}
+
+	provider, err := llm.Default()
+	if err != nil {
+		log.Printf("Error getting LLM provider: %v", err)
+		http.Error(w, "Error generating response", http.StatusInternalServerError)
+		return
+	}
+
+	userMessage := models.ChatMessage{
+		Content:  body.Message.Content,
+		Role:     "user",
+		ParentID: nextParentID(thread),
+	}
+
+	if userMessage.ID, err = database.AddChatMessage(thread.ID, userMessage); err != nil {
+		log.Printf("Error adding user message: %v", err)
+		http.Error(w, "Error adding message", http.StatusInternalServerError)
+		return
+	}
+
+	// The job is reserved for this request rather than left to the thread's
+	// queue. The request still waits its turn, and hands the thread on when
+	// it is done.
+	job, err := reserveReply(thread.ID, userMessage.ID)
+	if err != nil {
+		log.Printf("Error saving generation job: %v", err)
+		http.Error(w, "Error queueing reply", http.StatusInternalServerError)
+		return
+	}
+	defer replies.handOn(h, thread.ID)
+
+	claimed, err := waitForTurn(r.Context(), &job)
+	if err != nil {
+		log.Printf("Error waiting to reply in thread %s: %v", thread.ID, err)
+		http.Error(w, "Error generating response", http.StatusInternalServerError)
+		return
+	}
+
+	stream, err := newSSEWriter(w)
+	if err != nil {
+		if claimed {
+			setJobStatus(&job, models.JobFailed, err)
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// A reply generated while waiting may have answered the message along
+	// with an earlier one.
+	if !claimed {
+		if answered := answeredReply(job); answered != nil {
+			stream.Send(eventDone, doneEvent{MessageID: answered.ID, Message: *answered})
+		} else {
+			stream.Send(eventError, errorEvent{Error: "Error generating response"})
+		}
+		return
+	}
+
+	_, history, followUps, err := questionHistory(thread.ID, userMessage.ID)
+	if err != nil {
+		log.Printf("Error reading history of thread %s: %v", thread.ID, err)
+		setJobStatus(&job, models.JobFailed, err)
+		stream.Send(eventError, errorEvent{Error: "Error generating response"})
+		return
+	}
+
+	reply, err := provider.Stream(r.Context(), llm.Request{
+		Messages: compactConversation(r.Context(), provider, thread.ID, systemPrompt, history),
+	}, func(delta string) error {
+		return stream.Send(eventDelta, deltaEvent{Content: delta})
+	})
+
+	if err != nil {
+		log.Printf("Error streaming bot response for thread %s: %v", thread.ID, err)
+		setJobStatus(&job, models.JobFailed, fmt.Errorf("the model failed to respond: %w", err))
+		stream.Send(eventError, errorEvent{Error: "Error generating response"})
+		return
+	}
+
+	// The reply answers what it was generated from. Follow-ups sent while
+	// streaming stay queued and are answered once the thread is handed on.
+	botMessage := models.ChatMessage{
+		Content:   reply,
+		Role:      "assistant",
+		Citations: retrieval.Cited(citations, reply),
+		ParentID:  history[len(history)-1].ID,
+	}
+
+	botMessage.ID, err = database.AddChatMessage(thread.ID, botMessage)
+	if err != nil {
+		log.Printf("Error adding bot message: %v", err)
+		setJobStatus(&job, models.JobFailed, fmt.Errorf("error saving reply: %w", err))
+		stream.Send(eventError, errorEvent{Error: "Error saving response"})
+		return
+	}
+
+	job.ReplyID = botMessage.ID
+	setJobStatus(&job, models.JobSucceeded, nil)
+	completeFollowUps(thread.ID, followUps, botMessage.ID)
+
+	stream.Send(eventDone, doneEvent{MessageID: botMessage.ID, Message: botMessage})
+}
@@ -0,0 +1,711 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/database/databasetest"
+	"github.com/davidulloa/mimir/llm"
+	"github.com/davidulloa/mimir/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// acceleratorStore serves a fixed accelerator on top of an embedded store.
+type acceleratorStore struct {
+	database.Store
+	accelerator models.Accelerator
+}
+
+func (s *acceleratorStore) GetAcceleratorByID(acceleratorID string) (*models.Accelerator, error) {
+	accelerator := s.accelerator
+	return &accelerator, nil
+}
+
+func useTestStore(t *testing.T) database.Store {
+	store := &acceleratorStore{
+		Store: databasetest.UseBoltStore(t),
+		accelerator: models.Accelerator{
+			Title:       "Incident Management Health Check",
+			Description: "Reviews incident processes",
+			Category:    "ITSM",
+		},
+	}
+	database.SetStore(store)
+	return store
+}
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+func readEvents(t *testing.T, body []byte) []sseEvent {
+	var events []sseEvent
+	var current sseEvent
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			current.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			current.data = strings.TrimPrefix(line, "data: ")
+		case line == "":
+			events = append(events, current)
+			current = sseEvent{}
+		}
+	}
+	require.NoError(t, scanner.Err())
+	return events
+}
+
+func TestStreamHandler(t *testing.T) {
+	store := useTestStore(t)
+	llm.SetDefault(llm.NewFake("Run the health check first."))
+	defer llm.SetDefault(nil)
+
+	threadID, err := store.CreateChatThread(models.ChatThread{UserID: "dev000001", AcceleratorId: "acc1"})
+	require.NoError(t, err)
+
+	body := `{"instanceId": "dev000001", "threadId": "` + threadID + `", "message": {"content": "Where do I start?"}}`
+	req := httptest.NewRequest(http.MethodPost, "/chat/stream", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	NewChatHandler().StreamHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+
+	events := readEvents(t, rr.Body.Bytes())
+	require.NotEmpty(t, events)
+
+	var streamed string
+	for _, event := range events[:len(events)-1] {
+		assert.Equal(t, eventDelta, event.name)
+		var delta deltaEvent
+		require.NoError(t, json.Unmarshal([]byte(event.data), &delta))
+		streamed += delta.Content
+	}
+	assert.Equal(t, "Run the health check first.", streamed)
+
+	last := events[len(events)-1]
+	require.Equal(t, eventDone, last.name)
+	var done doneEvent
+	require.NoError(t, json.Unmarshal([]byte(last.data), &done))
+
+	messages, err := store.GetChatMessages(threadID)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "Where do I start?", messages[0].Content)
+	assert.Equal(t, done.MessageID, messages[1].ID)
+	assert.Equal(t, streamed, messages[1].Content)
+}
+
+func TestStreamHandlerReportsErrors(t *testing.T) {
+	store := useTestStore(t)
+	// A failing responder simulates the model erroring mid-request.
+	fake := llm.NewFake()
+	fake.Responder = func(req llm.Request) (string, error) {
+		return "", llm.ErrEmptyResponse
+	}
+	llm.SetDefault(fake)
+	defer llm.SetDefault(nil)
+
+	threadID, err := store.CreateChatThread(models.ChatThread{UserID: "dev000001", AcceleratorId: "acc1"})
+	require.NoError(t, err)
+
+	body := `{"instanceId": "dev000001", "threadId": "` + threadID + `", "message": {"content": "Hello?"}}`
+	req := httptest.NewRequest(http.MethodPost, "/chat/stream", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	NewChatHandler().StreamHandler(rr, req)
+
+	events := readEvents(t, rr.Body.Bytes())
+	require.Len(t, events, 1)
+	assert.Equal(t, eventError, events[0].name)
+
+	messages, err := store.GetChatMessages(threadID)
+	require.NoError(t, err)
+	assert.Len(t, messages, 1, "no canned apology should be stored")
+
+	jobs, err := store.GetGenerationJobs(threadID)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, models.JobFailed, jobs[0].Status)
+	assert.Equal(t, messages[0].ID, jobs[0].MessageID)
+	assert.NotEmpty(t, jobs[0].Error)
+}
+
+func TestStreamHandlerCitesDocumentation(t *testing.T) {
+	docs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><title>Health Check</title></head><body><main>
+			<h2>Scope</h2><p>The health check reviews incident assignment and resolution.</p>
+			<h2>Pricing</h2><p>Engagements are billed per instance reviewed.</p>
+		</main></body></html>`))
+	}))
+	defer docs.Close()
+
+	store := useTestStore(t)
+	store.(*acceleratorStore).accelerator.Url = docs.URL
+
+	fake := llm.NewFake("It covers assignment and resolution [1].")
+	llm.SetDefault(fake)
+	defer llm.SetDefault(nil)
+
+	threadID, err := store.CreateChatThread(models.ChatThread{UserID: "dev000001", AcceleratorId: "acc1"})
+	require.NoError(t, err)
+
+	body := `{"instanceId": "dev000001", "threadId": "` + threadID + `", "message": {"content": "What does the health check review?"}}`
+	req := httptest.NewRequest(http.MethodPost, "/chat/stream", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	h := NewChatHandler()
+	h.Retriever.HTTPClient = docs.Client()
+	h.Retriever.TopK = 1
+	h.StreamHandler(rr, req)
+
+	events := readEvents(t, rr.Body.Bytes())
+	last := events[len(events)-1]
+	require.Equal(t, eventDone, last.name)
+	var done doneEvent
+	require.NoError(t, json.Unmarshal([]byte(last.data), &done))
+
+	require.Len(t, done.Message.Citations, 1)
+	assert.Equal(t, docs.URL, done.Message.Citations[0].URL)
+	assert.Equal(t, "Health Check - Scope", done.Message.Citations[0].Title)
+
+	require.Len(t, fake.Requests, 1)
+	assert.Contains(t, fake.Requests[0].Messages[0].Content, "The health check reviews incident assignment and resolution.")
+
+	messages, err := store.GetChatMessages(threadID)
+	require.NoError(t, err)
+	assert.Equal(t, done.Message.Citations, messages[1].Citations)
+}
+
+func TestChatBranches(t *testing.T) {
+	store := useTestStore(t)
+	fake := llm.NewFake()
+	llm.SetDefault(fake)
+	defer llm.SetDefault(nil)
+
+	threadID, err := store.CreateChatThread(models.ChatThread{UserID: "dev000001", AcceleratorId: "acc1"})
+	require.NoError(t, err)
+	question, err := store.AddChatMessage(threadID, models.ChatMessage{Role: "user", Content: "Where do I start?", ParentID: threadID})
+	require.NoError(t, err)
+	answer, err := store.AddChatMessage(threadID, models.ChatMessage{Role: "assistant", Content: "With the scope.", ParentID: question})
+	require.NoError(t, err)
+
+	chat := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		NewChatHandler().ChatHandler(rr, httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(body)))
+		return rr
+	}
+	type threadResponse struct {
+		models.ChatThread
+		Status   string              `json:"status"`
+		Siblings map[string][]string `json:"siblings"`
+	}
+	waitForMessages := func(n int) {
+		require.Eventually(t, func() bool {
+			messages, err := store.GetChatMessages(threadID)
+			return err == nil && len(messages) == n
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+	contents := func(messages []models.ChatMessage) []string {
+		var out []string
+		for _, message := range messages {
+			out = append(out, message.Content)
+		}
+		return out
+	}
+
+	// Editing the question answers the edit on a new branch.
+	rr := chat(`{"instanceId": "dev000001", "threadId": "` + threadID + `", "editMessageId": "` + question + `", "message": {"content": "What does it cost?"}}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	waitForMessages(4)
+
+	rr = chat(`{"instanceId": "dev000001", "threadId": "` + threadID + `"}`)
+	var thread threadResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &thread))
+	assert.Equal(t, "ready", thread.Status)
+	assert.Equal(t, []string{"What does it cost?", "Echo: What does it cost?"}, contents(thread.Messages))
+	edited := thread.Messages[0].ID
+	assert.Equal(t, []string{question, edited}, thread.Siblings[edited])
+
+	require.Len(t, fake.Requests, 1)
+	for _, message := range fake.Requests[0].Messages {
+		assert.NotEqual(t, "Where do I start?", message.Content, "the original question isn't on the edited branch")
+	}
+
+	// Switching back to the original question shows its answer.
+	rr = chat(`{"instanceId": "dev000001", "threadId": "` + threadID + `", "activeMessageId": "` + question + `"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	thread = threadResponse{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &thread))
+	assert.Equal(t, []string{"Where do I start?", "With the scope."}, contents(thread.Messages))
+
+	// Regenerating the answer adds a sibling reply.
+	rr = chat(`{"instanceId": "dev000001", "threadId": "` + threadID + `", "regenerateMessageId": "` + answer + `"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	waitForMessages(5)
+
+	rr = chat(`{"instanceId": "dev000001", "threadId": "` + threadID + `", "siblingsOf": "` + answer + `"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var siblings []models.ChatMessage
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &siblings))
+	assert.Equal(t, []string{"With the scope.", "Echo: Where do I start?"}, contents(siblings))
+
+	active, err := store.GetChatThread(threadID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Where do I start?", "Echo: Where do I start?"}, contents(active.Messages))
+
+	// Only user messages can be edited and only replies regenerated.
+	rr = chat(`{"instanceId": "dev000001", "threadId": "` + threadID + `", "editMessageId": "` + answer + `", "message": {"content": "x"}}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = chat(`{"instanceId": "dev000001", "threadId": "` + threadID + `", "regenerateMessageId": "` + question + `"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = chat(`{"instanceId": "dev000002", "threadId": "` + threadID + `", "siblingsOf": "` + answer + `"}`)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestChatThreadsAreOwned(t *testing.T) {
+	store := useTestStore(t)
+	llm.SetDefault(llm.NewFake())
+	defer llm.SetDefault(nil)
+
+	threadID, err := store.CreateChatThread(models.ChatThread{UserID: "dev000001", AcceleratorId: "acc1"})
+	require.NoError(t, err)
+
+	chat := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		NewChatHandler().ChatHandler(rr, httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(body)))
+		return rr
+	}
+
+	// Another instance can neither read the thread nor post to it.
+	rr := chat(`{"instanceId": "dev000002", "threadId": "` + threadID + `"}`)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr = chat(`{"instanceId": "dev000002", "threadId": "` + threadID + `", "acceleratorId": "acc1", "message": {"content": "Hello"}}`)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// Malformed messages are rejected rather than crashing the handler.
+	for _, message := range []string{`"Hello"`, `{}`, `{"content": 42}`, `null`} {
+		rr = chat(`{"instanceId": "dev000001", "threadId": "` + threadID + `", "acceleratorId": "acc1", "message": ` + message + `}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, message)
+	}
+
+	messages, err := store.GetChatMessages(threadID)
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+
+	rr = chat(`{"instanceId": "dev000001", "threadId": "` + threadID + `"}`)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+}
+
+func TestLongThreadsAreSummarized(t *testing.T) {
+	store := useTestStore(t)
+	t.Setenv("LLM_MODEL", "gpt-4o")
+	t.Setenv("LLM_CONTEXT_BUDGETS", "gpt-4o=1000")
+
+	fake := llm.NewFake()
+	fake.Responder = func(req llm.Request) (string, error) {
+		if strings.HasPrefix(req.Messages[0].Content, "You maintain a running summary") {
+			return "The user is planning a health check.", nil
+		}
+		return "Noted.", nil
+	}
+	llm.SetDefault(fake)
+	defer llm.SetDefault(nil)
+
+	threadID, err := store.CreateChatThread(models.ChatThread{UserID: "dev000001", AcceleratorId: "acc1"})
+	require.NoError(t, err)
+	for i := 0; i < 8; i++ {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		content := fmt.Sprintf("Turn %d. %s", i, strings.Repeat("Some details about our instance. ", 12))
+		_, err := store.AddChatMessage(threadID, models.ChatMessage{Role: role, Content: content})
+		require.NoError(t, err)
+	}
+
+	stream := func(content string) {
+		body := `{"instanceId": "dev000001", "threadId": "` + threadID + `", "message": {"content": "` + content + `"}}`
+		rr := httptest.NewRecorder()
+		NewChatHandler().StreamHandler(rr, httptest.NewRequest(http.MethodPost, "/chat/stream", strings.NewReader(body)))
+		events := readEvents(t, rr.Body.Bytes())
+		require.Equal(t, eventDone, events[len(events)-1].name, rr.Body.String())
+	}
+
+	stream("Where do I start?")
+	require.Len(t, fake.Requests, 2, "one summary, one reply")
+
+	thread, err := store.GetChatThread(threadID)
+	require.NoError(t, err)
+	assert.Equal(t, "The user is planning a health check.", thread.Summary)
+	require.NotEmpty(t, thread.SummaryMessageID)
+
+	reply := fake.Requests[1].Messages
+	assert.Equal(t, "Summary of the earlier conversation:\n\nThe user is planning a health check.", reply[1].Content)
+	assert.True(t, strings.HasPrefix(reply[2].Content, "Turn "), "older turns follow verbatim")
+	assert.NotContains(t, reply[2].Content, "Turn 0.")
+	assert.Equal(t, "Where do I start?", reply[len(reply)-1].Content)
+
+	var tokens int
+	for _, message := range reply {
+		tokens += llm.MessageTokens(message)
+	}
+	assert.LessOrEqual(t, tokens, 1000)
+
+	// The next turn still fits next to the summary.
+	stream("And then?")
+	require.Len(t, fake.Requests, 3)
+	assert.Equal(t, reply[1], fake.Requests[2].Messages[1])
+}
+
+func TestGenerationJobs(t *testing.T) {
+	store := useTestStore(t)
+	fake := llm.NewFake()
+	failing := true
+	fake.Responder = func(req llm.Request) (string, error) {
+		if failing {
+			return "", errors.New("rate limited")
+		}
+		return "Start with the scope.", nil
+	}
+	llm.SetDefault(fake)
+	defer llm.SetDefault(nil)
+
+	threadID, err := store.CreateChatThread(models.ChatThread{UserID: "dev000001", AcceleratorId: "acc1"})
+	require.NoError(t, err)
+
+	type threadResponse struct {
+		models.ChatThread
+		Status string                `json:"status"`
+		Job    *models.GenerationJob `json:"job"`
+	}
+	chat := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		NewChatHandler().ChatHandler(rr, httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(body)))
+		return rr
+	}
+	fetch := func() threadResponse {
+		rr := chat(`{"instanceId": "dev000001", "threadId": "` + threadID + `"}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var thread threadResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &thread))
+		return thread
+	}
+	waitFor := func(status string) threadResponse {
+		var thread threadResponse
+		require.Eventually(t, func() bool {
+			thread = fetch()
+			return thread.Status == status
+		}, 5*time.Second, 10*time.Millisecond)
+		return thread
+	}
+
+	rr := chat(`{"instanceId": "dev000001", "threadId": "` + threadID + `", "acceleratorId": "acc1", "message": {"content": "Where do I start?"}}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	thread := waitFor("failed")
+	require.NotNil(t, thread.Job)
+	assert.Equal(t, models.JobFailed, thread.Job.Status)
+	assert.Contains(t, thread.Job.Error, "rate limited")
+	assert.Equal(t, 1, thread.Job.Attempts)
+	assert.Len(t, thread.Messages, 1, "the failure isn't stored as a reply")
+
+	failing = false
+	rr = chat(`{"instanceId": "dev000001", "threadId": "` + threadID + `", "retryJobId": "` + thread.Job.ID + `"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	thread = waitFor("ready")
+	require.Len(t, thread.Messages, 2)
+	assert.Equal(t, "Start with the scope.", thread.Messages[1].Content)
+	require.NotNil(t, thread.Job)
+	assert.Equal(t, models.JobSucceeded, thread.Job.Status)
+	assert.Empty(t, thread.Job.Error)
+	assert.Equal(t, 2, thread.Job.Attempts)
+	assert.Equal(t, thread.Messages[1].ID, thread.Job.ReplyID)
+
+	rr = chat(`{"instanceId": "dev000001", "threadId": "` + threadID + `", "retryJobId": "` + thread.Job.ID + `"}`)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	rr = chat(`{"instanceId": "dev000001", "threadId": "` + threadID + `", "retryJobId": "missing"}`)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestStalledJobsAreFailed(t *testing.T) {
+	now := time.Now()
+	jobs := []models.GenerationJob{
+		{ID: "old", MessageID: "m1", Status: models.JobSucceeded, UpdatedAt: now.Add(-time.Hour)},
+		{ID: "new", MessageID: "m1", Status: models.JobRunning, UpdatedAt: now.Add(-time.Hour)},
+		{ID: "live", MessageID: "m2", Status: models.JobQueued, UpdatedAt: now},
+	}
+
+	job := jobFor(jobs, "m1", now)
+	require.NotNil(t, job)
+	assert.Equal(t, "new", job.ID)
+	assert.Equal(t, models.JobFailed, job.Status)
+	assert.Equal(t, errJobInterrupted, job.Error)
+	assert.Equal(t, models.JobRunning, jobs[1].Status, "the stored job is left alone")
+
+	assert.Equal(t, models.JobQueued, jobFor(jobs, "m2", now).Status)
+	assert.Nil(t, jobFor(jobs, "m3", now))
+}
+
+func TestRepliesAreAnsweredInOrder(t *testing.T) {
+	store := useTestStore(t)
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	fake := llm.NewFake()
+	fake.Responder = func(req llm.Request) (string, error) {
+		select {
+		case started <- struct{}{}:
+			<-release
+		default:
+		}
+		return "Reply to: " + req.Messages[len(req.Messages)-1].Content, nil
+	}
+	llm.SetDefault(fake)
+	defer llm.SetDefault(nil)
+
+	threadID, err := store.CreateChatThread(models.ChatThread{UserID: "dev000001", AcceleratorId: "acc1"})
+	require.NoError(t, err)
+
+	post := func(content string) {
+		body := `{"instanceId": "dev000001", "threadId": "` + threadID + `", "acceleratorId": "acc1", "message": {"content": "` + content + `"}}`
+		rr := httptest.NewRecorder()
+		NewChatHandler().ChatHandler(rr, httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(body)))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	}
+	succeeded := func() bool {
+		jobs, err := store.GetGenerationJobs(threadID)
+		if err != nil {
+			return false
+		}
+		for _, job := range jobs {
+			if job.Status != models.JobSucceeded {
+				return false
+			}
+		}
+		return len(jobs) > 0
+	}
+
+	// The second message arrives while the first is being answered.
+	post("Where do I start?")
+	<-started
+	post("And how long does it take?")
+	close(release)
+	require.Eventually(t, succeeded, 5*time.Second, 10*time.Millisecond)
+
+	thread, err := store.GetChatThread(threadID)
+	require.NoError(t, err)
+	require.Len(t, thread.Messages, 3, "one reply answers both messages")
+	assert.Equal(t, "Reply to: And how long does it take?", thread.Messages[2].Content)
+	assert.Equal(t, thread.Messages[1].ID, thread.Messages[2].ParentID)
+	for i := 1; i < len(thread.Messages); i++ {
+		assert.Greater(t, thread.Messages[i].Sequence, thread.Messages[i-1].Sequence)
+	}
+
+	jobs, err := store.GetGenerationJobs(threadID)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	assert.Equal(t, thread.Messages[2].ID, jobs[0].ReplyID)
+	assert.Equal(t, thread.Messages[2].ID, jobs[1].ReplyID)
+	require.Len(t, fake.Requests, 2, "the first answer started over with the follow-up")
+
+	// Replies wait while another process runs a job of the thread, until
+	// it hands the thread on.
+	other := models.GenerationJob{ThreadID: threadID, MessageID: thread.Messages[1].ID, Status: models.JobRunning, Owner: "other-replica"}
+	require.NoError(t, store.SaveGenerationJob(&other))
+	post("Who runs it?")
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, succeeded(), "the reply waits for the thread")
+	other.Status = models.JobSucceeded
+	require.NoError(t, store.SaveGenerationJob(&other))
+	replies.kick(NewChatHandler(), threadID)
+	require.Eventually(t, succeeded, 5*time.Second, 10*time.Millisecond)
+
+	thread, err = store.GetChatThread(threadID)
+	require.NoError(t, err)
+	require.Len(t, thread.Messages, 5)
+	assert.Equal(t, "Reply to: Who runs it?", thread.Messages[4].Content)
+	assert.Contains(t, fake.Requests[2].Messages[len(fake.Requests[2].Messages)-2].Content, "Reply to: And how long", "history includes earlier replies")
+}
+
+func TestLateFollowUpsGetTheirOwnReply(t *testing.T) {
+	store := useTestStore(t)
+
+	threadID, err := store.CreateChatThread(models.ChatThread{UserID: "dev000001", AcceleratorId: "acc1"})
+	require.NoError(t, err)
+
+	post := func(content string) {
+		body := `{"instanceId": "dev000001", "threadId": "` + threadID + `", "acceleratorId": "acc1", "message": {"content": "` + content + `"}}`
+		rr := httptest.NewRecorder()
+		NewChatHandler().ChatHandler(rr, httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(body)))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	// A follow-up arrives during each of the first maxReanswers+1 answers;
+	// the last one comes too late to be taken in.
+	fake := llm.NewFake()
+	calls := 0
+	fake.Responder = func(req llm.Request) (string, error) {
+		if calls <= maxReanswers {
+			post(fmt.Sprintf("Follow-up %d", calls))
+		}
+		calls++
+		return "Reply to: " + req.Messages[len(req.Messages)-1].Content, nil
+	}
+	llm.SetDefault(fake)
+	defer llm.SetDefault(nil)
+
+	post("Where do I start?")
+	require.Eventually(t, func() bool {
+		jobs, err := store.GetGenerationJobs(threadID)
+		if err != nil || len(jobs) != maxReanswers+2 {
+			return false
+		}
+		for _, job := range jobs {
+			if job.Status != models.JobSucceeded {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	messages, err := store.GetChatMessages(threadID)
+	require.NoError(t, err)
+	require.Len(t, messages, maxReanswers+4)
+	ids, parents := make(map[string]string), make(map[string]string)
+	for _, message := range messages {
+		ids[message.Content] = message.ID
+		parents[message.Content] = message.ParentID
+	}
+
+	// The first reply saw the follow-ups up to the last restart only.
+	late := fmt.Sprintf("Follow-up %d", maxReanswers)
+	previous := fmt.Sprintf("Follow-up %d", maxReanswers-1)
+	assert.Equal(t, ids[previous], parents["Reply to: "+previous])
+	assert.Equal(t, ids[late], parents["Reply to: "+late], "the late follow-up is answered on its own")
+	assert.Len(t, fake.Requests, maxReanswers+2)
+}
+
+func TestFollowUpsSentWhileStreamingAreAnswered(t *testing.T) {
+	store := useTestStore(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fake := llm.NewFake()
+	first := true
+	fake.Responder = func(req llm.Request) (string, error) {
+		if first {
+			first = false
+			close(started)
+			<-release
+		}
+		return "Reply to: " + req.Messages[len(req.Messages)-1].Content, nil
+	}
+	llm.SetDefault(fake)
+	defer llm.SetDefault(nil)
+
+	threadID, err := store.CreateChatThread(models.ChatThread{UserID: "dev000001", AcceleratorId: "acc1"})
+	require.NoError(t, err)
+
+	streamed := make(chan *httptest.ResponseRecorder)
+	go func() {
+		body := `{"instanceId": "dev000001", "threadId": "` + threadID + `", "message": {"content": "Where do I start?"}}`
+		rr := httptest.NewRecorder()
+		NewChatHandler().StreamHandler(rr, httptest.NewRequest(http.MethodPost, "/chat/stream", strings.NewReader(body)))
+		streamed <- rr
+	}()
+
+	<-started
+	body := `{"instanceId": "dev000001", "threadId": "` + threadID + `", "acceleratorId": "acc1", "message": {"content": "And what does it cost?"}}`
+	rr := httptest.NewRecorder()
+	NewChatHandler().ChatHandler(rr, httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(body)))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	close(release)
+
+	events := readEvents(t, (<-streamed).Body.Bytes())
+	require.Equal(t, eventDone, events[len(events)-1].name)
+
+	require.Eventually(t, func() bool {
+		messages, err := store.GetChatMessages(threadID)
+		return err == nil && len(messages) == 4
+	}, 5*time.Second, 10*time.Millisecond)
+
+	messages, err := store.GetChatMessages(threadID)
+	require.NoError(t, err)
+	tree := database.NewChatTree(threadID, messages)
+	question, followUp := messages[0], messages[1]
+	assert.Equal(t, "And what does it cost?", followUp.Content)
+	for _, message := range messages[2:] {
+		switch message.Content {
+		case "Reply to: Where do I start?":
+			assert.Equal(t, question.ID, message.ParentID, "the streamed reply answers what it saw")
+		case "Reply to: And what does it cost?":
+			assert.Equal(t, followUp.ID, message.ParentID)
+		default:
+			t.Errorf("unexpected message %q", message.Content)
+		}
+	}
+	assert.Len(t, tree.Siblings(followUp.ID), 2)
+}
+
+func TestChatSearch(t *testing.T) {
+	useTestStore(t)
+	llm.SetDefault(llm.NewFake())
+	defer llm.SetDefault(nil)
+
+	threadID, err := database.CreateChatThread(models.ChatThread{UserID: "inst1", Title: "Major incidents", AcceleratorId: "acc1"})
+	require.NoError(t, err)
+	_, err = database.AddChatMessage(threadID, models.ChatMessage{Role: "user", Content: "Who approves a major incident?"})
+	require.NoError(t, err)
+	replyID, err := database.AddChatMessage(threadID, models.ChatMessage{Role: "assistant", Content: "The major incident manager approves it."})
+	require.NoError(t, err)
+
+	search := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		NewChatHandler().SearchHandler(rr, httptest.NewRequest(http.MethodPost, "/chat/search", strings.NewReader(body)))
+		return rr
+	}
+
+	rr := search(`{"instanceId": "inst1", "query": "incident manager", "mode": "keyword"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var results []models.ChatSearchResult
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &results))
+	if assert.NotEmpty(t, results) {
+		assert.Equal(t, threadID, results[0].ThreadID)
+		assert.Equal(t, replyID, results[0].MessageID)
+		assert.Equal(t, "The major <mark>incident</mark> <mark>manager</mark> approves it.", results[0].Snippet)
+	}
+
+	rr = search(`{"instanceId": "inst1", "query": "incident", "acceleratorId": "acc2"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[]`, rr.Body.String())
+
+	rr = search(`{"instanceId": "inst1", "query": "incident", "to": "2000-01-01"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[]`, rr.Body.String())
+
+	for _, body := range []string{
+		`{"query": "incident"}`,
+		`{"instanceId": "inst1", "query": " "}`,
+		`{"instanceId": "inst1", "query": "incident", "mode": "fuzzy"}`,
+		`{"instanceId": "inst1", "query": "incident", "from": "last week"}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, search(body).Code, body)
+	}
+}
@@ -17,24 +17,6 @@ func NewDocumentationHandler() *DocumentationHandler {
 	return &DocumentationHandler{}
 }
 
-func (h *DocumentationHandler) verifyBasicAuth(r *http.Request, instanceID string) error {
-    username, password, ok := r.BasicAuth()
-    if !ok {
-        return fmt.Errorf("basic authentication required")
-    }
-
-    validated, err := database.ValidateAuthentication(instanceID, username, password)
-    if err != nil {
-        return fmt.Errorf("authentication validation error: %v", err)
-    }
-
-    if !validated {
-        return fmt.Errorf("invalid credentials")
-    }
-
-    return nil
-}
-
 func (h *DocumentationHandler) DocumentationHandler(w http.ResponseWriter, r *http.Request) {
     var requestBody struct {
         InstanceID     string   `json:"instanceId"`
@@ -50,11 +32,6 @@ func (h *DocumentationHandler) DocumentationHandler(w http.ResponseWriter, r *ht
         return
     }
 
-    if err := h.verifyBasicAuth(r, requestBody.InstanceID); err != nil {
-        http.Error(w, "Unauthorized", http.StatusUnauthorized)
-        return
-    }
-
     documentation, err := h.scrapeDocumentations(requestBody.AcceleratorIds)
     if err != nil {
         http.Error(w, err.Error(), http.StatusInternalServerError)
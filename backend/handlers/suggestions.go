@@ -3,43 +3,52 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
-	"os"
+	"strings"
+	"time"
 
 	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/llm"
 	"github.com/davidulloa/mimir/models"
-	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/option"
 )
 
 // SuggestionsHandler handles suggestions-related requests
 type SuggestionsHandler struct {
-	// You can add dependencies here, such as database connections, services, etc.
+	Client *http.Client
 }
 
 type SuggestionsBody struct {
-	ticketIds []string `json:"tickets"`
+	TicketIds []string `json:"tickets"`
+	// Table is the ServiceNow table to base suggestions on, "incident" (the
+	// default) or "problem".
+	Table string `json:"table,omitempty"`
 }
 
-func NewSuggestionsHandler() *SuggestionsHandler {
-	return &SuggestionsHandler{}
+func NewSuggestionsHandler(client *http.Client) *SuggestionsHandler {
+	return &SuggestionsHandler{
+		Client: client,
+	}
 }
 
 type SuggestionOpenAiSchema struct {
-	suggestion []Suggestion
+	Suggestions []Suggestion `json:"suggestions"`
 }
 
 type Suggestion struct {
-	description string
-	accelerator models.Accelerator
+	Title       string             `json:"title"`
+	Description string             `json:"description"`
+	Accelerator models.Accelerator `json:"accelerator"`
 }
 
 func GenerateSuggestions(clusters []database.ClusterEntry, accelerators []models.Accelerator) (SuggestionOpenAiSchema, error) {
 
-	client := openai.NewClient(
-		option.WithAPIKey(os.Getenv("OPENAI_API_KEY")),
-	)
+	provider, err := llm.Default()
+	if err != nil {
+		return SuggestionOpenAiSchema{}, err
+	}
 
 	suggestionPrompt := fmt.Sprintf(`# Task
 	You're a ServiceNow Accelerator Assistant - meant to find the best support for ServiceNow users in ServiceNow accelerators.
@@ -51,79 +60,322 @@ func GenerateSuggestions(clusters []database.ClusterEntry, accelerators []models
 	%s
 	Accelerators:
 	%s
-	`, clusters, accelerators)
+	`, promptClusters(clusters), accelerators)
 
 
-	schemaParam := openai.ResponseFormatJSONSchemaJSONSchemaParam{
-		Name:        openai.F("suggestion_response"),
-		Description: openai.F("Provides practical suggestion tied to accelerator based on ticket used"),
-		Schema:      openai.F(database.GenerateSchema[SuggestionOpenAiSchema]()),
-		Strict:      openai.Bool(true),
+	var response SuggestionOpenAiSchema
+	err = provider.CompleteJSON(context.TODO(), llm.Request{
+		Messages: []llm.Message{
+			llm.System(suggestionPrompt),
+		},
+	}, llm.Schema{
+		Name:        "suggestion_response",
+		Description: "Provides practical suggestion tied to accelerator based on ticket used",
+		Schema:      database.GenerateSchema[SuggestionOpenAiSchema](),
+	}, &response)
+	if err != nil {
+		return SuggestionOpenAiSchema{}, err
 	}
 
-	chat, err := client.Chat.Completions.New(context.TODO(), openai.ChatCompletionNewParams{
-		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
-			openai.SystemMessage(suggestionPrompt),
-		}),
-		ResponseFormat: openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
-			openai.ResponseFormatJSONSchemaParam{
-				Type:       openai.F(openai.ResponseFormatJSONSchemaTypeJSONSchema),
-				JSONSchema: openai.F(schemaParam),
-			},
-		),
-		Model: openai.F(openai.ChatModelGPT4o2024_08_06),
-	 })
+	return response, nil
+}
 
-	var response SuggestionOpenAiSchema
-	err = json.Unmarshal([]byte(chat.Choices[0].Message.Content), &response)
+// promptClusters renders each cluster's description and ticket texts for the
+// suggestion prompt, one per line.
+func promptClusters(clusters []database.ClusterEntry) string {
+	var b strings.Builder
+	for _, cluster := range clusters {
+		fmt.Fprintf(&b, "- %s: %s\n", cluster.ClusterDescription, strings.Join(cluster.TextEntries, "; "))
+	}
+	return b.String()
+}
+
+// resolveAccelerator swaps the accelerator the model echoed back for the
+// catalog entry it names, so stored runs carry the real URL and description.
+func resolveAccelerator(accelerators []models.Accelerator, suggested models.Accelerator) models.Accelerator {
+	for _, accelerator := range accelerators {
+		if suggested.Url != "" && accelerator.Url == suggested.Url {
+			return accelerator
+		}
+	}
+	for _, accelerator := range accelerators {
+		if strings.EqualFold(accelerator.Title, suggested.Title) {
+			return accelerator
+		}
+	}
+	return suggested
+}
+
+// newSuggestionRun assembles the record of one suggestions request.
+func newSuggestionRun(instanceID string, tickets []models.Ticket, clusters []database.ClusterEntry, accelerators []models.Accelerator, response SuggestionOpenAiSchema) models.SuggestionRun {
+	run := models.SuggestionRun{
+		InstanceID:    instanceID,
+		CreatedAt:     time.Now(),
+		TicketNumbers: make([]string, 0, len(tickets)),
+		Clusters:      make([]models.SuggestionCluster, 0, len(clusters)),
+		Suggestions:   make([]models.Suggestion, 0, len(response.Suggestions)),
+	}
+
+	for _, ticket := range tickets {
+		run.TicketNumbers = append(run.TicketNumbers, ticket.Number)
+	}
+
+	// Cluster members index the tickets in the order they were clustered.
+	for _, cluster := range clusters {
+		numbers := make([]string, 0, len(cluster.Members))
+		for _, index := range cluster.Members {
+			if index >= 0 && index < len(tickets) {
+				numbers = append(numbers, tickets[index].Number)
+			}
+		}
+		run.Clusters = append(run.Clusters, models.SuggestionCluster{
+			Description:   cluster.ClusterDescription,
+			TicketNumbers: numbers,
+		})
+	}
+
+	for i, suggestion := range response.Suggestions {
+		accelerator := resolveAccelerator(accelerators, suggestion.Accelerator)
+		title := suggestion.Title
+		if title == "" {
+			title = accelerator.Title
+		}
+		run.Suggestions = append(run.Suggestions, models.Suggestion{
+			ID:           i + 1,
+			Title:        title,
+			Description:  suggestion.Description,
+			Accelerators: []models.Accelerator{accelerator},
+		})
+	}
+
+	return run
+}
+
+// errClustering wraps clustering failures, which mean there were too few
+// tickets to cluster.
+var errClustering = errors.New("error clustering tickets")
+
+// SuggestForTickets clusters tickets from table, asks the model for
+// accelerator recommendations and returns the run without storing it.
+func SuggestForTickets(instanceID string, table string, tickets []models.Ticket) (models.SuggestionRun, error) {
+	var descriptions []string
+	for _, ticket := range tickets {
+		descriptions = append(descriptions, ticket.ShortDescription)
+	}
+
+	clusters, err := database.TFIDFKMeansClustering(descriptions)
 	if err != nil {
-		return SuggestionOpenAiSchema{}, fmt.Errorf("error parsing JSON response: %v", err)
+		return models.SuggestionRun{}, fmt.Errorf("%w: %v", errClustering, err)
 	}
 
-	return response, nil
+	accelerators, err := database.GetAllAccelerators()
+	if err != nil {
+		return models.SuggestionRun{}, err
+	}
+
+	suggestions, err := GenerateSuggestions(clusters.Clusters, accelerators)
+	if err != nil {
+		return models.SuggestionRun{}, err
+	}
+
+	run := newSuggestionRun(instanceID, tickets, clusters.Clusters, accelerators, suggestions)
+	run.Table = table
+	return run, nil
 }
 
-// SuggestionsHandler serves the suggestions route and returns dummy data
+// SuggestionsHandler clusters the instance's incidents, asks the model for
+// accelerator recommendations and stores the result as a suggestion run.
 func (h *SuggestionsHandler) SuggestionsHandler(w http.ResponseWriter, r *http.Request) {
-	// Dummy suggestion data
 	var data SuggestionsBody
-	err := json.NewDecoder(r.Body).Decode(&data)
+	err := decodeBody(r, &data)
     if err != nil {
         http.Error(w, err.Error(), http.StatusBadRequest)
         return
     }
 
-	instanceId, username, password, err := ParseCredentials(r)
+	instanceId, username, password, err := ParseServiceNowCredentials(r)
 	if err != nil {
         http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	client := &http.Client{}
-	incidents := GetIncidents(client, instanceId, username, password)
-
-	tickets := ToTickets(incidents)
-	var descriptions []string
-	for _, ticket := range tickets {
-		descriptions = append(descriptions, ticket.ShortDescription)
+	table, err := clusterTable(data.Table)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	clusters, err := database.TFIDFKMeansClustering(descriptions)
+	tickets, err := LoadTickets(r.Context(), h.Client, instanceId, username, password, table, 0)
 	if err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, fmt.Sprintf("Error retrieving incidents: %s", err), serviceNowStatus(err))
 		return
 	}
 
-	accelerators, err := database.GetAllAccelerators()
+	run, err := SuggestForTickets(instanceId, table, tickets)
+	if errors.Is(err, errClustering) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	run.ID, err = database.CreateSuggestionRun(run)
 	if err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
+		log.Printf("Error storing suggestion run: %v", err)
+		http.Error(w, "Error storing suggestion run", http.StatusInternalServerError)
 		return
 	}
 
-	suggestions, err := GenerateSuggestions(clusters.Clusters, accelerators)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(run); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+type suggestionRunsBody struct {
+	InstanceID string `json:"instanceId"`
+	// RunID fetches a single run instead of listing them.
+	RunID string `json:"runId,omitempty"`
+	// Offset skips that many of the newest runs, and Limit caps how many
+	// are listed after them.
+	Offset int `json:"offset,omitempty"`
+	Limit  int `json:"limit,omitempty"`
+}
+
+// runPageSize is how many suggestion runs eachSuggestionRun reads at a time.
+const runPageSize = 20
+
+// eachSuggestionRun calls fn with the instance's runs, newest first, until fn
+// returns false.
+func eachSuggestionRun(instanceID string, fn func(run models.SuggestionRun) bool) error {
+	for offset := 0; ; offset += runPageSize {
+		runs, err := database.GetSuggestionRunsByInstanceID(instanceID, offset, runPageSize)
+		if err != nil {
+			return err
+		}
+		for _, run := range runs {
+			if !fn(run) {
+				return nil
+			}
+		}
+		if len(runs) < runPageSize {
+			return nil
+		}
+	}
+}
+
+// getOwnedRun fetches a run and checks it belongs to instanceID.
+func getOwnedRun(w http.ResponseWriter, runID string, instanceID string) (*models.SuggestionRun, bool) {
+	run, err := database.GetSuggestionRun(runID)
+	if err != nil || run.InstanceID != instanceID {
+		http.Error(w, "Suggestion run not found", http.StatusNotFound)
+		return nil, false
+	}
+	return run, true
+}
+
+// RunsHandler lists an instance's past suggestion runs, newest first, or
+// returns one run when runId is given.
+func (h *SuggestionsHandler) RunsHandler(w http.ResponseWriter, r *http.Request) {
+	var body suggestionRunsBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if body.InstanceID == "" {
+		http.Error(w, "instanceId is required", http.StatusBadRequest)
+		return
+	}
+	if body.Offset < 0 || body.Limit < 0 {
+		http.Error(w, "offset and limit must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	var response interface{}
+	if body.RunID != "" {
+		run, ok := getOwnedRun(w, body.RunID, body.InstanceID)
+		if !ok {
+			return
+		}
+		response = run
+	} else {
+		runs, err := database.GetSuggestionRunsByInstanceID(body.InstanceID, body.Offset, body.Limit)
+		if err != nil {
+			log.Printf("Error fetching suggestion runs: %v", err)
+			http.Error(w, "Error fetching suggestion runs", http.StatusInternalServerError)
+			return
+		}
+		response = runs
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+type suggestionDiffBody struct {
+	InstanceID string `json:"instanceId"`
+	From       string `json:"from,omitempty"`
+	To         string `json:"to,omitempty"`
+}
+
+// DiffHandler compares two suggestion runs. Without from and to it compares
+// the instance's most recent run with the previous run over the same table.
+func (h *SuggestionsHandler) DiffHandler(w http.ResponseWriter, r *http.Request) {
+	var body suggestionDiffBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if body.InstanceID == "" {
+		http.Error(w, "instanceId is required", http.StatusBadRequest)
+		return
+	}
+
+	if (body.From == "") != (body.To == "") {
+		http.Error(w, "from and to must be given together", http.StatusBadRequest)
+		return
+	}
+
+	var from, to *models.SuggestionRun
+	if body.From != "" {
+		var ok bool
+		if from, ok = getOwnedRun(w, body.From, body.InstanceID); !ok {
+			return
+		}
+		if to, ok = getOwnedRun(w, body.To, body.InstanceID); !ok {
+			return
+		}
+	} else {
+		// Compare the newest run with the one before it over the same table.
+		err := eachSuggestionRun(body.InstanceID, func(run models.SuggestionRun) bool {
+			if to == nil {
+				to = &run
+				return true
+			}
+			if run.Table == to.Table {
+				from = &run
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			log.Printf("Error fetching suggestion runs: %v", err)
+			http.Error(w, "Error fetching suggestion runs", http.StatusInternalServerError)
+			return
+		}
+		if from == nil {
+			http.Error(w, "At least two suggestion runs are needed to compare", http.StatusNotFound)
+			return
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(suggestions); err != nil {
+	if err := json.NewEncoder(w).Encode(database.DiffSuggestionRuns(*from, *to)); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
\ No newline at end of file
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/llm"
+	"github.com/davidulloa/mimir/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// catalogStore serves a fixed accelerator catalog on top of an embedded store.
+type catalogStore struct {
+	database.Store
+	accelerators []models.Accelerator
+}
+
+func (s *catalogStore) GetAllAccelerators() ([]models.Accelerator, error) {
+	return s.accelerators, nil
+}
+
+func TestSuggestionsHandlerStoresRun(t *testing.T) {
+	store := &catalogStore{
+		Store: useTestStore(t),
+		accelerators: []models.Accelerator{
+			{Title: "Incident Health Check", Url: "https://example.com/ihc", Category: "ITSM"},
+			{Title: "CMDB Jumpstart", Url: "https://example.com/cmdb", Category: "ITOM"},
+		},
+	}
+	database.SetStore(store)
+
+	client := serviceNowClient(map[string]string{"incident": `{"result": [
+		{"sys_id": "a1", "number": "INC001", "short_description": "Email is down", "priority": "1", "state": "1", "sys_created_on": "2026-10-02 09:00:00"},
+		{"sys_id": "a2", "number": "INC002", "short_description": "VPN will not connect", "priority": "2", "state": "1", "sys_created_on": "2026-10-01 09:00:00"}
+	]}`})
+
+	fake := llm.NewFake()
+	fake.Responder = func(req llm.Request) (string, error) {
+		if strings.Contains(req.Messages[0].Content, "Accelerator Assistant") {
+			return `{"suggestions":[{"title":"Review incident handling","description":"Outages keep recurring.","accelerator":{"title":"incident health check"}}]}`, nil
+		}
+		return `{"clusters":[{"cluster_description":"Connectivity","text_entries":["Email is down","VPN will not connect"]}]}`, nil
+	}
+	llm.SetDefault(fake)
+	defer llm.SetDefault(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/suggestions", strings.NewReader(`{"instanceId": "dev000001"}`))
+	req.Header.Set(ServiceNowAuthorizationHeader, "Basic dGVzdHVzZXI6dGVzdHBhc3M=")
+	rr := httptest.NewRecorder()
+
+	NewSuggestionsHandler(client).SuggestionsHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var run models.SuggestionRun
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &run))
+	assert.NotEmpty(t, run.ID)
+	assert.Equal(t, []string{"INC001", "INC002"}, run.TicketNumbers)
+	require.Len(t, run.Clusters, 1)
+	assert.ElementsMatch(t, []string{"INC001", "INC002"}, run.Clusters[0].TicketNumbers)
+	require.Len(t, run.Suggestions, 1)
+	assert.Equal(t, 1, run.Suggestions[0].ID)
+	// The echoed accelerator is resolved against the catalog.
+	assert.Equal(t, []models.Accelerator{store.accelerators[0]}, run.Suggestions[0].Accelerators)
+
+	stored, err := database.GetSuggestionRunsByInstanceID("dev000001", 0, 0)
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	assert.Equal(t, run.ID, stored[0].ID)
+}
+
+func TestSuggestionRunsAndDiff(t *testing.T) {
+	useTestStore(t)
+
+	ihc := models.Accelerator{Title: "Incident Health Check", Url: "https://example.com/ihc"}
+	cmdb := models.Accelerator{Title: "CMDB Jumpstart", Url: "https://example.com/cmdb"}
+	now := time.Now()
+
+	first, err := database.CreateSuggestionRun(models.SuggestionRun{
+		InstanceID:    "dev000001",
+		CreatedAt:     now.Add(-time.Hour),
+		TicketNumbers: []string{"INC001", "INC002"},
+		Suggestions:   []models.Suggestion{{ID: 1, Title: "Fix incidents", Accelerators: []models.Accelerator{ihc}}},
+	})
+	require.NoError(t, err)
+	second, err := database.CreateSuggestionRun(models.SuggestionRun{
+		InstanceID:    "dev000001",
+		CreatedAt:     now,
+		TicketNumbers: []string{"INC002", "INC003"},
+		Suggestions: []models.Suggestion{
+			{ID: 1, Title: "Tidy the CMDB", Accelerators: []models.Accelerator{cmdb}},
+			{ID: 2, Title: "Reworded incident advice", Accelerators: []models.Accelerator{ihc}},
+		},
+	})
+	require.NoError(t, err)
+	other, err := database.CreateSuggestionRun(models.SuggestionRun{InstanceID: "dev000002", CreatedAt: now})
+	require.NoError(t, err)
+
+	h := NewSuggestionsHandler(nil)
+
+	rr := serve(http.HandlerFunc(h.RunsHandler), http.MethodPost, "/suggestions/runs", "", `{"instanceId": "dev000001"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var runs []models.SuggestionRun
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &runs))
+	require.Len(t, runs, 2)
+	assert.Equal(t, second, runs[0].ID)
+	assert.Equal(t, first, runs[1].ID)
+
+	rr = serve(http.HandlerFunc(h.RunsHandler), http.MethodPost, "/suggestions/runs", "", `{"instanceId": "dev000001", "offset": 1, "limit": 1}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &runs))
+	if assert.Len(t, runs, 1) {
+		assert.Equal(t, first, runs[0].ID)
+	}
+
+	rr = serve(http.HandlerFunc(h.RunsHandler), http.MethodPost, "/suggestions/runs", "", `{"instanceId": "dev000001", "runId": "`+other+`"}`)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = serve(http.HandlerFunc(h.DiffHandler), http.MethodPost, "/suggestions/diff", "", `{"instanceId": "dev000001"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var diff models.SuggestionRunDiff
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &diff))
+
+	assert.Equal(t, first, diff.From)
+	assert.Equal(t, second, diff.To)
+	require.Len(t, diff.AddedSuggestions, 1)
+	assert.Equal(t, "Tidy the CMDB", diff.AddedSuggestions[0].Title)
+	assert.Empty(t, diff.RemovedSuggestions)
+	require.Len(t, diff.KeptSuggestions, 1)
+	assert.Equal(t, []string{"INC003"}, diff.AddedTickets)
+	assert.Equal(t, []string{"INC001"}, diff.RemovedTickets)
+
+	// Diffing in the other direction reports the CMDB advice as removed.
+	rr = serve(http.HandlerFunc(h.DiffHandler), http.MethodPost, "/suggestions/diff", "",
+		`{"instanceId": "dev000001", "from": "`+second+`", "to": "`+first+`"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &diff))
+	require.Len(t, diff.RemovedSuggestions, 1)
+	assert.Equal(t, "Tidy the CMDB", diff.RemovedSuggestions[0].Title)
+
+	rr = serve(http.HandlerFunc(h.DiffHandler), http.MethodPost, "/suggestions/diff", "",
+		`{"instanceId": "dev000001", "from": "`+first+`", "to": "`+other+`"}`)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -1,33 +1,26 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"net/url"
-	"sync"
 	"time"
 
 	"github.com/davidulloa/mimir/database"
 	"github.com/davidulloa/mimir/models"
+	"github.com/davidulloa/mimir/servicenow"
+	"github.com/davidulloa/mimir/ticketsync"
 )
 
 type TicketResponseBody struct {
     Result []models.Ticket `json:"result"`
 }
 
-type TicketCache struct {
-    mu             sync.RWMutex
-    lastIncidents  IncidentsApiResponse
-    lastClusters   database.TicketResponse
-    lastUpdateTime time.Time
-}
-
 type TicketHandler struct {
     Client *http.Client
-    // Cache  *TicketCache
 }
 
 type IncidentsApiResponse struct {
@@ -40,7 +33,48 @@ type ClusteredTickets struct {
 }
 
 type ClusteredTicketResponse struct {
-    Clusters []ClusteredTickets `json:"clusters"`
+    Clusters        []ClusteredTickets `json:"clusters"`
+    NumClusters     int                `json:"num_clusters"`
+    SilhouetteScore float64            `json:"silhouette_score"`
+    // Mode is the clustering mode used, "tfidf" or "embedding".
+    Mode            string             `json:"mode"`
+    Algorithm       string             `json:"algorithm"`
+    // Noise holds tickets DBSCAN found too isolated to cluster.
+    Noise           []models.Ticket    `json:"noise"`
+    // Dendrogram is the merge tree of an agglomerative run, for drilling
+    // into sub-clusters.
+    Dendrogram      *TicketDendrogram  `json:"dendrogram,omitempty"`
+    // Table is the ServiceNow table the tickets came from.
+    Table           string             `json:"table"`
+    // Related holds the problems and change requests the clustered tickets
+    // link to, keyed by sys_id.
+    Related         map[string]models.Ticket `json:"related"`
+}
+
+// TicketDendrogram is a database.DendrogramNode with tickets at the leaves.
+type TicketDendrogram struct {
+	Height   float64             `json:"height"`
+	Size     int                 `json:"size"`
+	Ticket   *models.Ticket      `json:"ticket,omitempty"`
+	Children []*TicketDendrogram `json:"children,omitempty"`
+}
+
+// ticketDendrogram maps the leaves of node, which index the clustered
+// documents, to the tickets they were built from.
+func ticketDendrogram(node *database.DendrogramNode, tickets []models.Ticket) *TicketDendrogram {
+	if node == nil {
+		return nil
+	}
+
+	result := &TicketDendrogram{Height: node.Height, Size: node.Size}
+	if len(node.Children) == 0 && node.Index >= 0 && node.Index < len(tickets) {
+		ticket := tickets[node.Index]
+		result.Ticket = &ticket
+	}
+	for _, child := range node.Children {
+		result.Children = append(result.Children, ticketDendrogram(child, tickets))
+	}
+	return result
 }
 
 // NewTicketHandler creates a new instance of the TicketHandler
@@ -50,125 +84,220 @@ func NewTicketHandler(client *http.Client) *TicketHandler {
     }
 }
 
-func GetIncidents(client *http.Client, instanceID string, username string, password string) *IncidentsApiResponse {
-    apiURL := fmt.Sprintf("https://%s.service-now.com/api/now/table/incident", instanceID)
+// DefaultIncidentLimit caps how many incidents are clustered when the request
+// doesn't specify a limit.
+const DefaultIncidentLimit = 100
 
-    queryParams := url.Values{}
-    queryParams.Add("sysparm_limit", "10")
-    queryParams.Add("sysparm_fields", "number,short_description,priority,state")
+// Requests can't raise the limit past these. Agglomerative clustering is
+// cubic in the number of tickets, so it gets a lower ceiling.
+const (
+    maxIncidentLimit              = 1000
+    maxAgglomerativeIncidentLimit = 300
+)
 
-    req, err := http.NewRequest("GET", apiURL, nil)
-    if err != nil {
-        log.Fatalf("Error creating request: %v", err)
+// incidentLimit resolves how many tickets a clustering request loads.
+func incidentLimit(body TicketRequestBody) int {
+    limit := body.Limit
+    if limit <= 0 {
+        limit = DefaultIncidentLimit
+    }
+    ceiling := maxIncidentLimit
+    if body.Algorithm == database.AlgorithmAgglomerative {
+        ceiling = maxAgglomerativeIncidentLimit
     }
+    if limit > ceiling {
+        limit = ceiling
+    }
+    return limit
+}
 
-    req.URL.RawQuery = queryParams.Encode()
-    req.Header.Set("Accept", "application/json")
-    req.Header.Set("Content-Type", "application/json")
-    req.SetBasicAuth(username, password)
+// clusterTable checks the table a request asks to cluster, defaulting to
+// incidents.
+func clusterTable(table string) (string, error) {
+    switch table {
+    case "":
+        return servicenow.IncidentTable, nil
+    case servicenow.IncidentTable, servicenow.ProblemTable:
+        return table, nil
+    default:
+        return "", fmt.Errorf("table must be %q or %q", servicenow.IncidentTable, servicenow.ProblemTable)
+    }
+}
 
-    resp, err := client.Do(req)
-    if err != nil {
-        log.Fatalf("Error making request: %v", err)
+// LoadTickets returns up to limit of the instance's synced records from
+// table, newest first, syncing them with the given credentials first if the
+// local copy is stale. A sync that fails for any reason but bad credentials
+// falls back to the stored tickets, if there are any.
+func LoadTickets(ctx context.Context, client *http.Client, instanceID string, username string, password string, table string, limit int) ([]models.Ticket, error) {
+    return LoadTicketsOpened(ctx, client, instanceID, username, password, table, time.Time{}, time.Time{}, limit)
+}
+
+// LoadTicketsOpened is LoadTickets restricted to records opened from from
+// until to, as ticketsync.TicketsOpened restricts them, before the limit
+// applies.
+func LoadTicketsOpened(ctx context.Context, client *http.Client, instanceID string, username string, password string, table string, from time.Time, to time.Time, limit int) ([]models.Ticket, error) {
+    if limit <= 0 {
+        limit = DefaultIncidentLimit
     }
-    defer resp.Body.Close()
 
-    incidents := &IncidentsApiResponse{}
-    if resp.StatusCode == http.StatusOK {
-        body, err := io.ReadAll(resp.Body)
-        if err != nil {
-            log.Println("Error reading response body: %s\nError:%v", resp.Body, err)
-            return incidents
+    syncer := ticketsync.Default()
+    snClient, err := servicenow.NewClient(instanceID, username, password, servicenow.WithHTTPClient(client))
+    if err != nil {
+        return nil, err
+    }
+    syncErr := syncer.SyncIfStale(ctx, instanceID, table, snClient)
+    if syncErr != nil {
+        log.Printf("Error syncing %s for instance %s: %v", table, instanceID, syncErr)
+        if errors.Is(syncErr, servicenow.ErrUnauthorized) || errors.Is(syncErr, servicenow.ErrForbidden) {
+            return nil, syncErr
         }
+    }
 
-        err = json.Unmarshal(body, incidents)
-        if err != nil {
-            log.Println("Error parsing JSON, body: %s\nError: %v", body, err)
-            return incidents
+    // Incidents link to problems and changes, which only enrich the response,
+    // so failing to sync them isn't fatal.
+    if table == servicenow.IncidentTable {
+        for _, related := range []string{servicenow.ProblemTable, servicenow.ChangeRequestTable} {
+            if err := syncer.SyncIfStale(ctx, instanceID, related, snClient); err != nil {
+                log.Printf("Error syncing %s for instance %s: %v", related, instanceID, err)
+            }
         }
-    } else {
-        body, _ := io.ReadAll(resp.Body)
-        fmt.Printf("Failed to retrieve data. Status code: %d, Response: %s\n", resp.StatusCode, string(body))
-    }
-    return incidents
-}
-
-func ToTickets(incidents *IncidentsApiResponse) []models.Ticket {
-	tickets := []models.Ticket{}
-	for _, incident := range incidents.Result {
-		tickets = append(tickets, models.Ticket{
-			ShortDescription: incident.ShortDescription,	
-			Priority: incident.Priority,
-			Number: incident.Number,
-			State: incident.State,
-		})
-	}
-    return tickets
+    }
+
+    tickets, err := ticketsync.TicketsOpened(instanceID, table, from, to, limit)
+    if err != nil {
+        return nil, err
+    }
+    if syncErr != nil && len(tickets) == 0 {
+        return nil, syncErr
+    }
+    return tickets, nil
+}
+
+// serviceNowStatus maps a ServiceNow error onto the status we return to the client.
+func serviceNowStatus(err error) int {
+    switch {
+    case errors.Is(err, servicenow.ErrUnauthorized), errors.Is(err, servicenow.ErrForbidden):
+        return http.StatusUnauthorized
+    case errors.Is(err, servicenow.ErrRateLimited):
+        return http.StatusTooManyRequests
+    case errors.Is(err, servicenow.ErrInvalidInstance):
+        return http.StatusBadRequest
+    default:
+        return http.StatusBadGateway
+    }
 }
 
 func (h *TicketHandler) TicketsHandler(w http.ResponseWriter, r *http.Request) {
-    instanceID, username, password, err := ParseCredentials(r)
+    instanceID, username, password, err := ParseServiceNowCredentials(r)
     if err != nil {
         http.Error(w, err.Error(), http.StatusBadRequest)
         return
     }
 
-    incidents := GetIncidents(h.Client, instanceID, username, password)
+    var body TicketRequestBody
+    if err := decodeBody(r, &body); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
 
-    // h.Cache.mu.RLock()
-    // cacheValid := reflect.DeepEqual(incidents, h.Cache.lastIncidents) && time.Since(h.Cache.lastUpdateTime) < 5*time.Minute
-    // h.Cache.mu.RUnlock()
+    table, err := clusterTable(body.Table)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
 
-    var clusters database.TicketResponse
+    if err := body.ClusteringOptions.Validate(); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
 
-    // // Caching TF-IDF Clusters
-    // if cacheValid {
-    //     h.Cache.mu.RLock()
-    //     clusters = h.Cache.lastClusters
-    //     h.Cache.mu.RUnlock()
-    // } else {
-        tickets := ToTickets(incidents)
-        // database.StoreTickets(tickets)
-        shortDescriptions := make([]string, len(tickets))
-        for i, ticket := range tickets {
-            shortDescriptions[i] = ticket.ShortDescription 
-        }
-        // var err error
-        clusters, err = database.TFIDFKMeansClustering(shortDescriptions)
-        if err != nil {
-            http.Error(w, err.Error(), http.StatusBadRequest)
-            return
-        }
+    tickets, err := LoadTickets(r.Context(), h.Client, instanceID, username, password, table, incidentLimit(body))
+    if err != nil {
+        http.Error(w, fmt.Sprintf("Error retrieving incidents: %s", err), serviceNowStatus(err))
+        return
+    }
 
-    //     h.Cache.mu.Lock()
-    //     h.Cache.lastIncidents = *incidents
-    //     h.Cache.lastClusters = clusters
-    //     h.Cache.lastUpdateTime = time.Now()
-    //     h.Cache.mu.Unlock()
-    // }
+    shortDescriptions := make([]string, len(tickets))
+    for i, ticket := range tickets {
+        shortDescriptions[i] = ticket.ShortDescription
+    }
+    clusters, err := database.ClusterDocuments(r.Context(), shortDescriptions, body.ClusteringOptions)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
 
-    response := createClusteredTicketResponse(clusters, ToTickets(incidents))
+    response := createClusteredTicketResponse(clusters, tickets)
+    response.Table = table
+    response.Related, err = relatedTickets(tickets)
+    if err != nil {
+        log.Printf("Error loading problems and changes linked to instance %s tickets: %v", instanceID, err)
+    }
     jsonResponse(w, response)
 }
 
+// relatedTickets loads the stored problems and change requests tickets link to.
+func relatedTickets(tickets []models.Ticket) (map[string]models.Ticket, error) {
+    related := make(map[string]models.Ticket)
+
+    var ids []string
+    seen := make(map[string]bool)
+    for _, ticket := range tickets {
+        for _, sysID := range []string{ticket.ProblemID, ticket.ChangeID, ticket.CausedByID} {
+            if sysID != "" && !seen[sysID] {
+                seen[sysID] = true
+                ids = append(ids, database.TicketID(ticket.InstanceID, sysID))
+            }
+        }
+    }
+    if len(ids) == 0 {
+        return related, nil
+    }
+
+    linked, err := database.RetrieveTickets(ids)
+    if err != nil {
+        return related, err
+    }
+    for _, ticket := range linked {
+        if !ticket.Deleted {
+            related[ticket.SysID] = ticket
+        }
+    }
+    return related, nil
+}
+
 func createClusteredTicketResponse(clusters database.TicketResponse, tickets []models.Ticket) ClusteredTicketResponse {
     response := ClusteredTicketResponse{
-        Clusters: make([]ClusteredTickets, len(clusters.Clusters)),
+        Clusters:        make([]ClusteredTickets, len(clusters.Clusters)),
+        NumClusters:     clusters.NumClusters,
+        SilhouetteScore: clusters.SilhouetteScore,
+        Mode:            clusters.Mode,
+        Algorithm:       clusters.Algorithm,
+        Noise:           make([]models.Ticket, 0),
+        // The documents clustered were the tickets' short descriptions, in order.
+        Dendrogram:      ticketDendrogram(clusters.Dendrogram, tickets),
     }
 
-    ticketMap := make(map[string]models.Ticket)
-    for _, ticket := range tickets {
-        ticketMap[ticket.ShortDescription] = ticket
+    // Members index the documents clustered: the tickets' short descriptions,
+    // in order. Each ticket is placed once; any the clustering missed are
+    // reported as noise rather than dropped.
+    placed := make([]bool, len(tickets))
+    place := func(index int) (models.Ticket, bool) {
+        if index < 0 || index >= len(tickets) || placed[index] {
+            return models.Ticket{}, false
+        }
+        placed[index] = true
+        return tickets[index], true
     }
 
     for i, cluster := range clusters.Clusters {
         clusteredTickets := ClusteredTickets{
             ClusterDescription: cluster.ClusterDescription,
-            Tickets:            make([]models.Ticket, 0),
+            Tickets:            make([]models.Ticket, 0, len(cluster.Members)),
         }
 
-        for _, textEntry := range cluster.TextEntries {
-            if ticket, found := ticketMap[textEntry]; found {
+        for _, index := range cluster.Members {
+            if ticket, ok := place(index); ok {
                 clusteredTickets.Tickets = append(clusteredTickets.Tickets, ticket)
             }
         }
@@ -176,6 +305,19 @@ func createClusteredTicketResponse(clusters database.TicketResponse, tickets []m
         response.Clusters[i] = clusteredTickets
     }
 
+    for _, index := range clusters.Noise {
+        if ticket, ok := place(index); ok {
+            response.Noise = append(response.Noise, ticket)
+        }
+    }
+
+    for index := range tickets {
+        if ticket, ok := place(index); ok {
+            log.Printf("Ticket %s was not clustered; reporting it as noise", ticket.Number)
+            response.Noise = append(response.Noise, ticket)
+        }
+    }
+
     return response
 }
 
@@ -6,16 +6,22 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/llm"
 	"github.com/davidulloa/mimir/models"
 )
 
 func TestTicketsHandler(t *testing.T) {
+	useTestStore(t)
+
 	// Sample JSON response to be returned by the mock HTTP client
 	testResponse := `{
 		"result": [
 			{
+				"sys_id": "a1",
 				"number": "INC0010110",
 				"short_description": "Jira sprint planning feature is glitchy, we lost all story points for a session.",
 				"priority": "5",
@@ -24,23 +30,17 @@ func TestTicketsHandler(t *testing.T) {
 		]
 	}`
 
-	client := &http.Client{
-		Transport: RoundTripFunc(func(req *http.Request) *http.Response {
-			// Optionally, check the request details here (e.g., URL, headers)
-			return &http.Response{
-				StatusCode: http.StatusOK,
-				Body:       io.NopCloser(bytes.NewBufferString(testResponse)),
-				Header:     make(http.Header),
-			}
-		}),
-	}
+	client := serviceNowClient(map[string]string{"incident": testResponse})
+
+	llm.SetDefault(llm.NewFake(`{"clusters":[{"cluster_description":"Jira sprint planning","text_entries":["Jira sprint planning feature is glitchy, we lost all story points for a session."]}]}`))
+	defer llm.SetDefault(nil)
 
 	handler := NewTicketHandler(client)
 
-	requestBody := `{"instanceId": "test_instance"}`
+	requestBody := `{"instanceId": "test-instance"}`
 	req := httptest.NewRequest("POST", "/tickets", bytes.NewBufferString(requestBody))
 	req.Header.Set("Content-Type", "application/json")
-	req.SetBasicAuth("testuser", "testpass")
+	req.Header.Set(ServiceNowAuthorizationHeader, "Basic dGVzdHVzZXI6dGVzdHBhc3M=")
 
 	rr := httptest.NewRecorder()
 
@@ -52,19 +52,28 @@ func TestTicketsHandler(t *testing.T) {
 
 	expectedTickets := []models.Ticket{
 		{
+			ID:               database.TicketID("test-instance", "a1"),
 			Number:           "INC0010110",
 			ShortDescription: "Jira sprint planning feature is glitchy, we lost all story points for a session.",
 			Priority:         "5",
 			State:            "1",
+			InstanceID:       "test-instance",
+			Table:            "incident",
+			SysID:            "a1",
 		},
 	}
 
-	var actualTickets []models.Ticket
-	err := json.Unmarshal(rr.Body.Bytes(), &actualTickets)
+	var response ClusteredTicketResponse
+	err := json.Unmarshal(rr.Body.Bytes(), &response)
 	if err != nil {
 		t.Fatalf("Error unmarshalling response body: %v", err)
 	}
 
+	var actualTickets []models.Ticket
+	for _, cluster := range response.Clusters {
+		actualTickets = append(actualTickets, cluster.Tickets...)
+	}
+
 	if len(actualTickets) != len(expectedTickets) {
 		t.Fatalf("Expected %d tickets, got %d", len(expectedTickets), len(actualTickets))
 	}
@@ -76,8 +85,153 @@ func TestTicketsHandler(t *testing.T) {
 	}
 }
 
+func TestTicketsHandlerKeepsDuplicateDescriptions(t *testing.T) {
+	useTestStore(t)
+
+	client := serviceNowClient(map[string]string{"incident": `{"result": [
+		{"sys_id": "a1", "number": "INC001", "short_description": "Email is down", "priority": "1", "state": "1", "sys_created_on": "2026-10-03 09:00:00"},
+		{"sys_id": "a2", "number": "INC002", "short_description": "Email is down", "priority": "2", "state": "1", "sys_created_on": "2026-10-02 09:00:00"},
+		{"sys_id": "a3", "number": "INC003", "short_description": "VPN will not connect", "priority": "3", "state": "1", "sys_created_on": "2026-10-01 09:00:00"}
+	]}`})
+
+	// The model's label echoes none of the entries; membership must not depend on it.
+	llm.SetDefault(llm.NewFake(`{"clusters":[{"cluster_description":"Outages","text_entries":["email outage"]}]}`))
+	defer llm.SetDefault(nil)
+
+	req := httptest.NewRequest("POST", "/tickets", bytes.NewBufferString(`{"instanceId": "test-instance", "numClusters": 1}`))
+	req.Header.Set(ServiceNowAuthorizationHeader, "Basic dGVzdHVzZXI6dGVzdHBhc3M=")
+	rr := httptest.NewRecorder()
+
+	NewTicketHandler(client).TicketsHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v, want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	var response ClusteredTicketResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Error unmarshalling response body: %v", err)
+	}
+
+	if len(response.Clusters) != 1 {
+		t.Fatalf("Expected 1 cluster, got %d", len(response.Clusters))
+	}
+	var numbers []string
+	for _, ticket := range response.Clusters[0].Tickets {
+		numbers = append(numbers, ticket.Number)
+	}
+	if len(numbers) != 3 || numbers[0] != "INC001" || numbers[1] != "INC002" || numbers[2] != "INC003" {
+		t.Errorf("Expected every incident exactly once, got %v", numbers)
+	}
+	if len(response.Noise) != 0 {
+		t.Errorf("Expected no noise, got %v", response.Noise)
+	}
+}
+
+func TestTicketsHandlerClustersProblems(t *testing.T) {
+	useTestStore(t)
+
+	client := serviceNowClient(map[string]string{
+		"incident": `{"result": [
+			{"sys_id": "a1", "number": "INC001", "short_description": "Email is down", "problem_id": "p1", "rfc": "c1"},
+			{"sys_id": "a2", "number": "INC002", "short_description": "Email is slow", "problem_id": "p1"}
+		]}`,
+		"problem": `{"result": [
+			{"sys_id": "p1", "number": "PRB001", "short_description": "Mail server overloaded"},
+			{"sys_id": "p2", "number": "PRB002", "short_description": "VPN certificates expire"}
+		]}`,
+		"change_request": `{"result": [{"sys_id": "c1", "number": "CHG001", "short_description": "Add mail server capacity"}]}`,
+	})
+	llm.SetDefault(llm.NewFake(`{"clusters":[{"cluster_description":"Email"}]}`, `{"clusters":[{"cluster_description":"Infrastructure"}]}`))
+	defer llm.SetDefault(nil)
+
+	handler := NewTicketHandler(client)
+	post := func(body string) ClusteredTicketResponse {
+		req := httptest.NewRequest("POST", "/tickets", bytes.NewBufferString(body))
+		req.Header.Set(ServiceNowAuthorizationHeader, "Basic dGVzdHVzZXI6dGVzdHBhc3M=")
+		rr := httptest.NewRecorder()
+		handler.TicketsHandler(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Handler returned wrong status code: got %v, want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+		var response ClusteredTicketResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Error unmarshalling response body: %v", err)
+		}
+		return response
+	}
+
+	response := post(`{"instanceId": "test-instance", "numClusters": 1}`)
+	if response.Table != "incident" || len(response.Clusters[0].Tickets) != 2 {
+		t.Fatalf("Expected both incidents clustered, got %+v", response)
+	}
+	if response.Related["p1"].Number != "PRB001" || response.Related["c1"].Number != "CHG001" || len(response.Related) != 2 {
+		t.Errorf("Expected the linked problem and change, got %+v", response.Related)
+	}
+
+	response = post(`{"instanceId": "test-instance", "numClusters": 1, "table": "problem"}`)
+	numbers := make(map[string]bool)
+	for _, ticket := range response.Clusters[0].Tickets {
+		numbers[ticket.Number] = true
+	}
+	if response.Table != "problem" || len(numbers) != 2 || !numbers["PRB001"] || !numbers["PRB002"] {
+		t.Errorf("Expected the problems clustered, got %v", numbers)
+	}
+
+	req := httptest.NewRequest("POST", "/tickets", bytes.NewBufferString(`{"instanceId": "test-instance", "table": "kb_knowledge"}`))
+	req.Header.Set(ServiceNowAuthorizationHeader, "Basic dGVzdHVzZXI6dGVzdHBhc3M=")
+	rr := httptest.NewRecorder()
+	handler.TicketsHandler(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unsupported table to be rejected, got %v", rr.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/tickets", bytes.NewBufferString(`{"instanceId": "test-instance", "minClusters": 2, "maxClusters": 500}`))
+	req.Header.Set(ServiceNowAuthorizationHeader, "Basic dGVzdHVzZXI6dGVzdHBhc3M=")
+	rr = httptest.NewRecorder()
+	handler.TicketsHandler(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected too many clusters to be rejected, got %v", rr.Code)
+	}
+}
+
+// serviceNowClient fakes the Table API, answering each table in results with
+// its body and any other table with no records.
+func serviceNowClient(results map[string]string) *http.Client {
+	return &http.Client{
+		Transport: RoundTripFunc(func(req *http.Request) *http.Response {
+			body, ok := results[strings.TrimPrefix(req.URL.Path, "/api/now/table/")]
+			if !ok {
+				body = `{"result": []}`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(body)),
+				Header:     make(http.Header),
+			}
+		}),
+	}
+}
+
 type RoundTripFunc func(req *http.Request) *http.Response
 
 func (f RoundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
 	return f(req), nil
 }
+
+func TestIncidentLimit(t *testing.T) {
+	cases := []struct {
+		body TicketRequestBody
+		want int
+	}{
+		{TicketRequestBody{}, DefaultIncidentLimit},
+		{TicketRequestBody{Limit: 50}, 50},
+		{TicketRequestBody{Limit: 1 << 20}, maxIncidentLimit},
+		{TicketRequestBody{Limit: 1 << 20, ClusteringOptions: database.ClusteringOptions{Algorithm: database.AlgorithmAgglomerative}}, maxAgglomerativeIncidentLimit},
+		{TicketRequestBody{Limit: 50, ClusteringOptions: database.ClusteringOptions{Algorithm: database.AlgorithmAgglomerative}}, 50},
+	}
+	for _, c := range cases {
+		if got := incidentLimit(c.body); got != c.want {
+			t.Errorf("incidentLimit(%+v) = %d, want %d", c.body, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/servicenow"
+	"github.com/davidulloa/mimir/ticketsync"
+	"github.com/davidulloa/mimir/vault"
+	"github.com/davidulloa/mimir/webhook"
+)
+
+const (
+	// DefaultReclusterThreshold is how many new incidents an instance's
+	// webhook accepts before its incidents are clustered again.
+	DefaultReclusterThreshold = 10
+
+	maxWebhookBody = 1 << 20
+)
+
+// WebhookResponse reports whether an event changed the stored tickets.
+type WebhookResponse struct {
+	// Status is "applied", or "ignored" when the stored copy was as new.
+	Status string `json:"status"`
+}
+
+type WebhookHandler struct {
+	// ReclusterThreshold is the number of inserts that triggers Recluster.
+	ReclusterThreshold int
+	// Recluster runs in the background once enough incidents have arrived.
+	Recluster func(instanceID string) error
+
+	mu       sync.Mutex
+	inserted map[string]int
+	running  map[string]bool
+}
+
+// NewWebhookHandler reads the threshold from MIMIR_RECLUSTER_THRESHOLD and
+// reclusters by storing a new suggestion run.
+func NewWebhookHandler() *WebhookHandler {
+	threshold := DefaultReclusterThreshold
+	if value, err := strconv.Atoi(os.Getenv("MIMIR_RECLUSTER_THRESHOLD")); err == nil && value > 0 {
+		threshold = value
+	}
+	return &WebhookHandler{
+		ReclusterThreshold: threshold,
+		Recluster:          reclusterIncidents,
+		inserted:           make(map[string]int),
+		running:            make(map[string]bool),
+	}
+}
+
+// reclusterIncidents stores a suggestion run over the instance's stored
+// incidents, as if the suggestions had been requested.
+func reclusterIncidents(instanceID string) error {
+	tickets, err := ticketsync.Tickets(instanceID, servicenow.IncidentTable, DefaultIncidentLimit)
+	if err != nil {
+		return err
+	}
+
+	run, err := SuggestForTickets(instanceID, servicenow.IncidentTable, tickets)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.CreateSuggestionRun(run)
+	return err
+}
+
+// webhookSecret returns the secret events for instanceID are signed with.
+func webhookSecret(instanceID string) (string, error) {
+	v, err := vault.Default()
+	if err != nil {
+		return "", err
+	}
+	creds, err := v.Get(instanceID)
+	if err != nil {
+		return "", err
+	}
+	return creds.WebhookSecret, nil
+}
+
+// EventsHandler serves POST /webhooks/servicenow/{instanceId}, applying a
+// signed incident event to the stored tickets.
+func (h *WebhookHandler) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	instanceID := r.PathValue("instanceId")
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	// Unknown instances and instances without a secret look like bad
+	// signatures, so callers can't probe which instances are registered.
+	secret, err := webhookSecret(instanceID)
+	if err != nil && !errors.Is(err, vault.ErrNotFound) {
+		log.Printf("Error reading webhook secret for %s: %v", instanceID, err)
+	}
+	if !webhook.Verify(secret, body, r.Header.Get(webhook.SignatureHeader)) {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var event webhook.Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, "Invalid event", http.StatusBadRequest)
+		return
+	}
+	if event.Table != "" && event.Table != servicenow.IncidentTable {
+		http.Error(w, "Only incident events are accepted", http.StatusBadRequest)
+		return
+	}
+	if event.Record.SysID == "" {
+		http.Error(w, "record.sys_id is required", http.StatusBadRequest)
+		return
+	}
+
+	var applied bool
+	switch event.Event {
+	case webhook.EventInsert, webhook.EventUpdate, webhook.EventClose:
+		applied, err = ticketsync.Apply(instanceID, event.Record, false)
+	case webhook.EventDelete:
+		applied, err = ticketsync.Apply(instanceID, event.Record, true)
+	default:
+		http.Error(w, "Unknown event "+strconv.Quote(event.Event), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error applying %s event for %s: %v", event.Event, instanceID, err)
+		http.Error(w, "Error storing ticket", http.StatusInternalServerError)
+		return
+	}
+
+	status := "ignored"
+	if applied {
+		status = "applied"
+		if event.Event == webhook.EventInsert {
+			h.countInsert(instanceID)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WebhookResponse{Status: status})
+}
+
+// countInsert starts Recluster once ReclusterThreshold incidents have been
+// inserted since the last one, unless one is still running.
+func (h *WebhookHandler) countInsert(instanceID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.inserted[instanceID]++
+	if h.inserted[instanceID] < h.ReclusterThreshold || h.running[instanceID] || h.Recluster == nil {
+		return
+	}
+	h.inserted[instanceID] = 0
+	h.running[instanceID] = true
+
+	go func() {
+		if err := h.Recluster(instanceID); err != nil {
+			log.Printf("Error reclustering incidents for %s: %v", instanceID, err)
+		}
+		h.mu.Lock()
+		h.running[instanceID] = false
+		h.mu.Unlock()
+	}()
+}
+
+// SecretHandler serves PUT /webhooks/servicenow/{instanceId}/secret for
+// admins, replacing the instance's webhook secret and returning the new one.
+func (h *WebhookHandler) SecretHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	v, err := vault.Default()
+	if err != nil {
+		http.Error(w, "Credential vault is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	instanceID := r.PathValue("instanceId")
+	creds, err := v.Get(instanceID)
+	if errors.Is(err, vault.ErrNotFound) {
+		http.Error(w, "Instance has not logged in", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error reading credentials", http.StatusInternalServerError)
+		return
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		http.Error(w, "Error generating secret", http.StatusInternalServerError)
+		return
+	}
+	creds.WebhookSecret = secret
+	if err := v.Put(instanceID, *creds); err != nil {
+		http.Error(w, "Error storing secret", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"secret": secret})
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/servicenow"
+	"github.com/davidulloa/mimir/ticketsync"
+	"github.com/davidulloa/mimir/vault"
+	"github.com/davidulloa/mimir/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func webhookMux(h *WebhookHandler) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhooks/servicenow/{instanceId}", h.EventsHandler)
+	mux.HandleFunc("/webhooks/servicenow/{instanceId}/secret", h.SecretHandler)
+	return mux
+}
+
+// useWebhookVault stores credentials with secret for dev000001.
+func useWebhookVault(t *testing.T, secret string) *vault.Vault {
+	v, err := vault.New(bytes.Repeat([]byte{1}, vault.KeySize))
+	require.NoError(t, err)
+	vault.SetDefault(v)
+	t.Cleanup(func() { vault.SetDefault(nil) })
+	require.NoError(t, v.Put("dev000001", vault.Credentials{Username: "admin", Password: "secret", WebhookSecret: secret}))
+	return v
+}
+
+func signedEvent(mux http.Handler, secret string, event string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/servicenow/dev000001", bytes.NewBufferString(event))
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(secret, []byte(event)))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	return rr
+}
+
+func incidentEvent(event, sysID, description, updated string) string {
+	return fmt.Sprintf(`{"event":%q,"table":"incident","record":{"sys_id":%q,"number":"INC-%s","short_description":%q,"sys_created_on":"2026-10-12 08:00:00","sys_updated_on":%q}}`,
+		event, sysID, sysID, description, updated)
+}
+
+func webhookStatus(t *testing.T, rr *httptest.ResponseRecorder) string {
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var response WebhookResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	return response.Status
+}
+
+func TestWebhookAppliesSignedEvents(t *testing.T) {
+	useTestStore(t)
+	useWebhookVault(t, "shared")
+	h := NewWebhookHandler()
+	h.Recluster = nil
+	mux := webhookMux(h)
+
+	event := incidentEvent(webhook.EventInsert, "a1", "Email is down", "2026-10-12 08:00:00")
+	assert.Equal(t, "applied", webhookStatus(t, signedEvent(mux, "shared", event)))
+
+	tickets, err := ticketsync.Tickets("dev000001", servicenow.IncidentTable, 0)
+	require.NoError(t, err)
+	require.Len(t, tickets, 1)
+	assert.Equal(t, "Email is down", tickets[0].ShortDescription)
+
+	// The same event again, and an older one arriving late, change nothing.
+	assert.Equal(t, "ignored", webhookStatus(t, signedEvent(mux, "shared", event)))
+	assert.Equal(t, "applied", webhookStatus(t, signedEvent(mux, "shared",
+		incidentEvent(webhook.EventClose, "a1", "Email is back", "2026-10-12 10:00:00"))))
+	assert.Equal(t, "ignored", webhookStatus(t, signedEvent(mux, "shared",
+		incidentEvent(webhook.EventUpdate, "a1", "Email is slow", "2026-10-12 09:00:00"))))
+
+	tickets, err = ticketsync.Tickets("dev000001", servicenow.IncidentTable, 0)
+	require.NoError(t, err)
+	require.Len(t, tickets, 1)
+	assert.Equal(t, "Email is back", tickets[0].ShortDescription)
+
+	assert.Equal(t, "applied", webhookStatus(t, signedEvent(mux, "shared", `{"event":"delete","record":{"sys_id":"a1"}}`)))
+	tickets, err = ticketsync.Tickets("dev000001", servicenow.IncidentTable, 0)
+	require.NoError(t, err)
+	assert.Empty(t, tickets)
+
+	rr := signedEvent(mux, "shared", `{"event":"insert","table":"problem","record":{"sys_id":"p1"}}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = signedEvent(mux, "shared", `{"event":"insert","record":{}}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestWebhookRejectsBadSignatures(t *testing.T) {
+	useTestStore(t)
+	useWebhookVault(t, "shared")
+	mux := webhookMux(NewWebhookHandler())
+	event := incidentEvent(webhook.EventInsert, "a1", "Email is down", "2026-10-12 08:00:00")
+
+	rr := signedEvent(mux, "guessed", event)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr = serve(mux, http.MethodPost, "/webhooks/servicenow/dev000001", "", event)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/servicenow/dev000002", bytes.NewBufferString(event))
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign("shared", []byte(event)))
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "unknown instances have no secret")
+
+	all, err := database.GetTicketsByInstanceID("dev000001")
+	require.NoError(t, err)
+	assert.Empty(t, all)
+}
+
+func TestWebhookReclustersAfterThreshold(t *testing.T) {
+	useTestStore(t)
+	useWebhookVault(t, "shared")
+	reclustered := make(chan string, 2)
+	h := NewWebhookHandler()
+	h.ReclusterThreshold = 3
+	h.Recluster = func(instanceID string) error {
+		reclustered <- instanceID
+		return nil
+	}
+	mux := webhookMux(h)
+
+	for i := 1; i <= 3; i++ {
+		select {
+		case <-reclustered:
+			t.Fatalf("reclustered after %d incidents", i-1)
+		default:
+		}
+		// Updates don't count towards the threshold.
+		if i == 2 {
+			webhookStatus(t, signedEvent(mux, "shared",
+				incidentEvent(webhook.EventUpdate, "a1", "Email is still down", "2026-10-12 09:00:00")))
+		}
+		webhookStatus(t, signedEvent(mux, "shared",
+			incidentEvent(webhook.EventInsert, fmt.Sprintf("a%d", i), "Email is down", "2026-10-12 08:00:00")))
+	}
+
+	select {
+	case instanceID := <-reclustered:
+		assert.Equal(t, "dev000001", instanceID)
+	case <-time.After(time.Second):
+		t.Fatal("incidents were not reclustered")
+	}
+}
+
+func TestWebhookSecretRotation(t *testing.T) {
+	useTestStore(t)
+	v := useWebhookVault(t, "")
+	t.Setenv("MIMIR_ADMIN_TOKEN", "admin-secret")
+	mux := webhookMux(NewWebhookHandler())
+
+	rr := serve(mux, http.MethodPut, "/webhooks/servicenow/dev000001/secret", "", "")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = adminRequest(mux, http.MethodPut, "/webhooks/servicenow/dev000002/secret", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = adminRequest(mux, http.MethodPut, "/webhooks/servicenow/dev000001/secret", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var response map[string]string
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	require.NotEmpty(t, response["secret"])
+
+	creds, err := v.Get("dev000001")
+	require.NoError(t, err)
+	assert.Equal(t, response["secret"], creds.WebhookSecret)
+	assert.Equal(t, "admin", creds.Username, "the ServiceNow credentials are kept")
+
+	event := incidentEvent(webhook.EventInsert, "a1", "Email is down", "2026-10-12 08:00:00")
+	assert.Equal(t, "applied", webhookStatus(t, signedEvent(mux, response["secret"], event)))
+}
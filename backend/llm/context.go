@@ -0,0 +1,153 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultContextBudget is the history budget, in tokens, for models without
+// a configured or built-in one.
+const DefaultContextBudget = 8000
+
+// contextBudgets are the built-in history budgets by model name prefix. They
+// are well below the models' context windows: past a point, replaying more
+// history costs more than it helps.
+var contextBudgets = map[string]int{
+	"gpt-4o":        16000,
+	"gpt-4-turbo":   16000,
+	"gpt-4":         6000,
+	"gpt-3.5-turbo": 8000,
+}
+
+// messageOverhead approximates the tokens each message costs beyond its
+// content, for its role and delimiters.
+const messageOverhead = 4
+
+// CountTokens estimates the tokens text encodes to. Without the model's
+// tokenizer it assumes four characters per token, which is close for
+// English with the GPT tokenizers and errs high for code.
+func CountTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// MessageTokens estimates the tokens a message costs in a request.
+func MessageTokens(message Message) int {
+	return messageOverhead + CountTokens(message.Content)
+}
+
+// ConfiguredModel returns the chat model named by LLM_MODEL, or DefaultModel.
+func ConfiguredModel() string {
+	if model := os.Getenv("LLM_MODEL"); model != "" {
+		return model
+	}
+	return DefaultModel
+}
+
+// ContextBudget returns how many tokens of a request to model, system prompt
+// included, may go to conversation history. LLM_CONTEXT_BUDGETS overrides
+// the built-in budgets with comma-separated model=tokens pairs, matched by
+// longest model name prefix like the built-in ones, e.g.
+// "gpt-4o=32000,llama3=6000".
+func ContextBudget(model string) int {
+	budgets := contextBudgets
+	if configured := os.Getenv("LLM_CONTEXT_BUDGETS"); configured != "" {
+		budgets = make(map[string]int, len(contextBudgets))
+		for prefix, budget := range contextBudgets {
+			budgets[prefix] = budget
+		}
+		for _, pair := range strings.Split(configured, ",") {
+			prefix, tokens, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			budget, err := strconv.Atoi(strings.TrimSpace(tokens))
+			if !ok || err != nil || budget <= 0 {
+				continue
+			}
+			budgets[strings.TrimSpace(prefix)] = budget
+		}
+	}
+
+	budget, matched := DefaultContextBudget, ""
+	for prefix, b := range budgets {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(matched) {
+			budget, matched = b, prefix
+		}
+	}
+	return budget
+}
+
+const summaryPrompt = `You maintain a running summary of a conversation between a user and an assistant about a ServiceNow accelerator. Fold the new messages into the summary. Keep the user's goals, facts about their instance, decisions, open questions and anything the assistant committed to; drop pleasantries. Write plain prose of at most 300 words. Reply with the summary only.`
+
+// Compactor keeps a conversation within a token budget by folding its
+// oldest messages into a rolling summary.
+type Compactor struct {
+	Provider Provider
+	// Budget is the tokens the system prompt, summary and verbatim messages
+	// may take together.
+	Budget int
+}
+
+// Fold returns how many of the oldest messages must be folded into the
+// summary for the rest to fit the budget alongside system and summary. The
+// last message is always kept.
+//
+// Once the budget is exceeded, Fold trims the verbatim messages to half of
+// what is left of it, so that the next few turns fit without summarizing
+// again.
+func (c *Compactor) Fold(system string, summary string, messages []Message) int {
+	fixed := MessageTokens(System(system))
+	if summary != "" {
+		fixed += MessageTokens(System(summary))
+	}
+
+	total := fixed
+	for _, message := range messages {
+		total += MessageTokens(message)
+	}
+	if total <= c.Budget || len(messages) < 2 {
+		return 0
+	}
+
+	keep := (c.Budget - fixed) / 2
+	n := len(messages) - 1
+	used := MessageTokens(messages[n])
+	for n > 0 && used+MessageTokens(messages[n-1]) <= keep {
+		n--
+		used += MessageTokens(messages[n])
+	}
+	return n
+}
+
+// Summarize folds messages into summary and returns the new summary. Long
+// runs of messages are folded in chunks that fit the budget.
+func (c *Compactor) Summarize(ctx context.Context, summary string, messages []Message) (string, error) {
+	limit := c.Budget - MessageTokens(System(summaryPrompt))
+	for len(messages) > 0 {
+		used := MessageTokens(User(summary))
+		var transcript strings.Builder
+		n := 0
+		for ; n < len(messages); n++ {
+			line := messages[n].Role + ": " + messages[n].Content + "\n\n"
+			if n > 0 && used+CountTokens(line) > limit {
+				break
+			}
+			used += CountTokens(line)
+			transcript.WriteString(line)
+		}
+
+		content := "New messages:\n\n" + transcript.String()
+		if summary != "" {
+			content = "Summary so far:\n\n" + summary + "\n\n" + content
+		}
+		reply, err := c.Provider.Complete(ctx, Request{Messages: []Message{System(summaryPrompt), User(content)}})
+		if err != nil {
+			return "", fmt.Errorf("summarizing conversation: %w", err)
+		}
+
+		summary = strings.TrimSpace(reply)
+		messages = messages[n:]
+	}
+	return summary, nil
+}
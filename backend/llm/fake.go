@@ -0,0 +1,133 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ErrScriptExhausted is returned by Fake.CompleteJSON when no scripted reply is left.
+var ErrScriptExhausted = errors.New("llm: fake has no scripted response left")
+
+// Fake is a deterministic Provider for tests and offline runs. Replies come
+// from Responder when it is set, otherwise from Responses in order. Once the
+// script runs out, Complete echoes the last user message and CompleteJSON
+// fails. Every request is recorded in Requests. Embed fails with EmbedErr
+// when it is set.
+type Fake struct {
+	mu        sync.Mutex
+	Responses []string
+	Responder func(req Request) (string, error)
+	Requests  []Request
+	EmbedErr  error
+}
+
+func NewFake(responses ...string) *Fake {
+	return &Fake{Responses: responses}
+}
+
+func (f *Fake) next(req Request) (string, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Requests = append(f.Requests, req)
+
+	if f.Responder != nil {
+		content, err := f.Responder(req)
+		return content, true, err
+	}
+
+	if len(f.Responses) == 0 {
+		return "", false, nil
+	}
+
+	content := f.Responses[0]
+	f.Responses = f.Responses[1:]
+	return content, true, nil
+}
+
+func (f *Fake) Complete(ctx context.Context, req Request) (string, error) {
+	content, ok, err := f.next(req)
+	if err != nil || ok {
+		return content, err
+	}
+	return "Echo: " + lastUserMessage(req), nil
+}
+
+func (f *Fake) CompleteJSON(ctx context.Context, req Request, schema Schema, out interface{}) error {
+	content, ok, err := f.next(req)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrScriptExhausted
+	}
+	return decodeJSON(content, out)
+}
+
+// Stream delivers the reply Complete would give one word at a time.
+func (f *Fake) Stream(ctx context.Context, req Request, onDelta func(delta string) error) (string, error) {
+	content, err := f.Complete(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	for _, word := range strings.SplitAfter(content, " ") {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if err := onDelta(word); err != nil {
+			return "", err
+		}
+	}
+	return content, nil
+}
+
+// FakeEmbeddingDimensions is the length of the vectors returned by Fake.Embed.
+const FakeEmbeddingDimensions = 256
+
+// Embed hashes each word into a normalized bag-of-words vector, so texts that
+// share words are close to each other.
+func (f *Fake) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if f.EmbedErr != nil {
+		return nil, f.EmbedErr
+	}
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, FakeEmbeddingDimensions)
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		for _, word := range words {
+			h := fnv.New32a()
+			h.Write([]byte(word))
+			vector[h.Sum32()%FakeEmbeddingDimensions]++
+		}
+
+		var norm float64
+		for _, value := range vector {
+			norm += float64(value) * float64(value)
+		}
+		if norm > 0 {
+			norm = math.Sqrt(norm)
+			for j := range vector {
+				vector[j] = float32(float64(vector[j]) / norm)
+			}
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+func lastUserMessage(req Request) string {
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == RoleUser {
+			return req.Messages[i].Content
+		}
+	}
+	return ""
+}
@@ -0,0 +1,139 @@
+// Package llm hides the chat-completion backend behind a Provider so the rest
+// of the backend doesn't depend on a particular vendor SDK, and tests can run
+// against a scripted fake.
+package llm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+
+	DefaultModel          = "gpt-4o-2024-08-06"
+	DefaultEmbeddingModel = "text-embedding-3-small"
+)
+
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+func System(content string) Message {
+	return Message{Role: RoleSystem, Content: content}
+}
+
+func User(content string) Message {
+	return Message{Role: RoleUser, Content: content}
+}
+
+func Assistant(content string) Message {
+	return Message{Role: RoleAssistant, Content: content}
+}
+
+// Request is a single chat completion. An empty Model uses the provider's
+// configured default.
+type Request struct {
+	Model    string    `json:"model,omitempty"`
+	Messages []Message `json:"messages"`
+}
+
+// Schema describes the JSON document a structured completion must produce.
+type Schema struct {
+	Name        string
+	Description string
+	// Schema is a JSON Schema value, e.g. from database.GenerateSchema.
+	Schema interface{}
+}
+
+// Provider generates chat completions.
+type Provider interface {
+	// Complete returns the assistant's reply to the conversation.
+	Complete(ctx context.Context, req Request) (string, error)
+	// CompleteJSON asks for a reply matching schema and decodes it into out.
+	CompleteJSON(ctx context.Context, req Request, schema Schema, out interface{}) error
+	// Stream generates a reply like Complete, calling onDelta with each piece
+	// of content as it arrives. It returns the full reply. An error from
+	// onDelta aborts the stream and is returned as-is.
+	Stream(ctx context.Context, req Request, onDelta func(delta string) error) (string, error)
+	// Embed returns one embedding vector per input text, in order.
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+const (
+	ProviderOpenAI     = "openai"
+	ProviderCompatible = "compatible"
+	ProviderFake       = "fake"
+)
+
+// FromEnv builds the provider named by LLM_PROVIDER:
+//
+//	openai      api.openai.com using OPENAI_API_KEY (default)
+//	compatible  any OpenAI-compatible endpoint at LLM_BASE_URL, using LLM_API_KEY
+//	fake        a deterministic offline fake that echoes the last user message
+//
+// LLM_MODEL and LLM_EMBEDDING_MODEL override the default chat and embedding
+// models for the openai and compatible providers. LLM_EMBEDDING_BASE_URL (and
+// LLM_EMBEDDING_API_KEY) send embeddings to a separate OpenAI-compatible
+// server, such as a local model server.
+func FromEnv() (Provider, error) {
+	model := ConfiguredModel()
+
+	embeddingModel := os.Getenv("LLM_EMBEDDING_MODEL")
+	embeddingURL := os.Getenv("LLM_EMBEDDING_BASE_URL")
+	embeddingKey := os.Getenv("LLM_EMBEDDING_API_KEY")
+
+	switch kind := os.Getenv("LLM_PROVIDER"); kind {
+	case "", ProviderOpenAI:
+		return NewOpenAI(os.Getenv("OPENAI_API_KEY"), model).
+			WithEmbeddingModel(embeddingModel).
+			WithEmbeddingEndpoint(embeddingURL, embeddingKey), nil
+	case ProviderCompatible:
+		baseURL := os.Getenv("LLM_BASE_URL")
+		if baseURL == "" {
+			return nil, fmt.Errorf("LLM_BASE_URL is required for the %s provider", ProviderCompatible)
+		}
+		return NewCompatible(baseURL, os.Getenv("LLM_API_KEY"), model).
+			WithEmbeddingModel(embeddingModel).
+			WithEmbeddingEndpoint(embeddingURL, embeddingKey), nil
+	case ProviderFake:
+		return NewFake(), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM_PROVIDER %q", kind)
+	}
+}
+
+var (
+	defaultProvider Provider
+	defaultMu       sync.Mutex
+)
+
+// Default returns the process-wide provider, building it from the environment
+// on first use.
+func Default() (Provider, error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultProvider != nil {
+		return defaultProvider, nil
+	}
+
+	p, err := FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	defaultProvider = p
+	return defaultProvider, nil
+}
+
+// SetDefault replaces the process-wide provider. Tests use it to install a Fake.
+func SetDefault(p Provider) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultProvider = p
+}
@@ -0,0 +1,212 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type label struct {
+	Label string `json:"label"`
+}
+
+func TestFakeScript(t *testing.T) {
+	fake := NewFake("first", `{"label":"second"}`)
+	ctx := context.Background()
+
+	reply, err := fake.Complete(ctx, Request{Messages: []Message{User("hi")}})
+	require.NoError(t, err)
+	assert.Equal(t, "first", reply)
+
+	var out label
+	require.NoError(t, fake.CompleteJSON(ctx, Request{Messages: []Message{User("label it")}}, Schema{}, &out))
+	assert.Equal(t, "second", out.Label)
+
+	reply, err = fake.Complete(ctx, Request{Messages: []Message{System("sys"), User("echo me")}})
+	require.NoError(t, err)
+	assert.Equal(t, "Echo: echo me", reply)
+
+	assert.ErrorIs(t, fake.CompleteJSON(ctx, Request{}, Schema{}, &out), ErrScriptExhausted)
+	assert.Len(t, fake.Requests, 4)
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("LLM_PROVIDER", ProviderFake)
+	p, err := FromEnv()
+	require.NoError(t, err)
+	assert.IsType(t, &Fake{}, p)
+
+	t.Setenv("LLM_PROVIDER", ProviderCompatible)
+	t.Setenv("LLM_BASE_URL", "")
+	_, err = FromEnv()
+	assert.Error(t, err)
+
+	t.Setenv("LLM_PROVIDER", "bogus")
+	_, err = FromEnv()
+	assert.Error(t, err)
+}
+
+func TestCompatibleCompleteJSON(t *testing.T) {
+	var received struct {
+		Model    string `json:"model"`
+		Messages []struct {
+			Role string `json:"role"`
+		} `json:"messages"`
+		ResponseFormat struct {
+			Type string `json:"type"`
+		} `json:"response_format"`
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/chat/completions", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      "chatcmpl-1",
+			"object":  "chat.completion",
+			"created": 0,
+			"model":   received.Model,
+			"choices": []map[string]interface{}{{
+				"index":         0,
+				"finish_reason": "stop",
+				"message":       map[string]string{"role": "assistant", "content": `{"label":"network"}`},
+			}},
+		})
+	}))
+	defer server.Close()
+
+	p := NewCompatible(server.URL+"/v1", "", "llama3")
+
+	var out label
+	err := p.CompleteJSON(context.Background(), Request{Messages: []Message{User("classify")}}, Schema{
+		Name:        "label",
+		Description: "a label",
+		Schema:      map[string]interface{}{"type": "object"},
+	}, &out)
+	require.NoError(t, err)
+
+	assert.Equal(t, "network", out.Label)
+	assert.Equal(t, "llama3", received.Model)
+	assert.Equal(t, "json_object", received.ResponseFormat.Type)
+	require.Len(t, received.Messages, 2)
+	assert.Equal(t, RoleSystem, received.Messages[0].Role)
+}
+
+func TestCompatibleEmbed(t *testing.T) {
+	var received struct {
+		Model string   `json:"model"`
+		Input []string `json:"input"`
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/embeddings", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+
+		// Answer out of order to check vectors are matched up by index.
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"object": "list",
+			"model":  received.Model,
+			"data": []map[string]interface{}{
+				{"object": "embedding", "index": 1, "embedding": []float64{0, 1}},
+				{"object": "embedding", "index": 0, "embedding": []float64{1, 0}},
+			},
+			"usage": map[string]int{"prompt_tokens": 2, "total_tokens": 2},
+		})
+	}))
+	defer server.Close()
+
+	p := NewCompatible(server.URL+"/v1", "", "llama3").WithEmbeddingModel("nomic-embed-text")
+
+	vectors, err := p.Embed(context.Background(), []string{"first", "second"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "nomic-embed-text", received.Model)
+	assert.Equal(t, []string{"first", "second"}, received.Input)
+	assert.Equal(t, [][]float32{{1, 0}, {0, 1}}, vectors)
+}
+
+func TestEmbeddingEndpoint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/embeddings", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"object": "list",
+			"data":   []map[string]interface{}{{"object": "embedding", "index": 0, "embedding": []float64{0.5}}},
+		})
+	}))
+	defer server.Close()
+
+	// Chat stays on the hosted API; only embeddings go to the local server.
+	p := NewOpenAI("unused", DefaultModel).WithEmbeddingEndpoint(server.URL+"/v1", "")
+
+	vectors, err := p.Embed(context.Background(), []string{"local"})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{0.5}}, vectors)
+}
+
+func TestFakeEmbedIsDeterministic(t *testing.T) {
+	f := NewFake()
+	vectors, err := f.Embed(context.Background(), []string{"Reset a password", "reset, a PASSWORD!", "configure the CMDB"})
+	require.NoError(t, err)
+	require.Len(t, vectors, 3)
+
+	assert.Len(t, vectors[0], FakeEmbeddingDimensions)
+	assert.Equal(t, vectors[0], vectors[1])
+	assert.NotEqual(t, vectors[0], vectors[2])
+}
+
+func TestContextBudget(t *testing.T) {
+	assert.Equal(t, 16000, ContextBudget("gpt-4o-2024-08-06"))
+	assert.Equal(t, 6000, ContextBudget("gpt-4-0613"))
+	assert.Equal(t, DefaultContextBudget, ContextBudget("llama3"))
+
+	t.Setenv("LLM_CONTEXT_BUDGETS", "llama3=3000, gpt-4o-mini=12000,broken")
+	assert.Equal(t, 3000, ContextBudget("llama3:8b"))
+	assert.Equal(t, 12000, ContextBudget("gpt-4o-mini"))
+	assert.Equal(t, 16000, ContextBudget("gpt-4o"))
+}
+
+func TestCompactorFoldsOldestMessages(t *testing.T) {
+	// Each message is 10 tokens of content plus the overhead.
+	turn := func(i int) Message {
+		content := strings.Repeat("x", 40)
+		if i%2 == 0 {
+			return User(content)
+		}
+		return Assistant(content)
+	}
+	var messages []Message
+	for i := 0; i < 10; i++ {
+		messages = append(messages, turn(i))
+	}
+
+	c := &Compactor{Budget: 200}
+	assert.Zero(t, c.Fold("", "", messages), "140 tokens fit")
+
+	c.Budget = 100
+	fold := c.Fold("", "", messages)
+	assert.Equal(t, 7, fold, "keeps the last messages within half the budget")
+
+	c.Budget = 10
+	assert.Equal(t, 9, c.Fold("", "", messages), "the last message is always kept")
+
+	fake := NewFake()
+	fake.Responder = func(req Request) (string, error) {
+		return fmt.Sprintf("summary %d", len(fake.Requests)), nil
+	}
+	c = &Compactor{Provider: fake, Budget: 150}
+	summary, err := c.Summarize(context.Background(), "", messages[:fold])
+	require.NoError(t, err)
+	require.Greater(t, len(fake.Requests), 1, "folded in chunks")
+	assert.Equal(t, fmt.Sprintf("summary %d", len(fake.Requests)), summary)
+	assert.Contains(t, fake.Requests[1].Messages[1].Content, "Summary so far:\n\nsummary 1")
+}
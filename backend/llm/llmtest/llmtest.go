@@ -0,0 +1,19 @@
+// Package llmtest sets up fake models for the tests of packages that use the
+// llm package.
+package llmtest
+
+import (
+	"testing"
+
+	"github.com/davidulloa/mimir/llm"
+)
+
+// UseFake makes a Fake scripted with responses the default provider until the
+// test ends. Tests that need a Responder set it on the returned Fake.
+func UseFake(t testing.TB, responses ...string) *llm.Fake {
+	t.Helper()
+	fake := llm.NewFake(responses...)
+	llm.SetDefault(fake)
+	t.Cleanup(func() { llm.SetDefault(nil) })
+	return fake
+}
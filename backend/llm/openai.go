@@ -0,0 +1,226 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// ErrEmptyResponse is returned when the model produced no choices or content.
+var ErrEmptyResponse = errors.New("llm: empty response from the model")
+
+// OpenAI is a Provider for the OpenAI API or any server that speaks its chat
+// completions protocol (Ollama, vLLM, LM Studio, llama.cpp, ...).
+type OpenAI struct {
+	client         *openai.Client
+	model          string
+	embeddingModel string
+	// embeddingClient, if set, serves Embed instead of client, e.g. a local
+	// embedding server next to a hosted chat model.
+	embeddingClient *openai.Client
+	// strictSchema uses json_schema response formats. Compatible servers often
+	// only understand json_object, so for those the schema goes in the prompt.
+	strictSchema bool
+}
+
+func NewOpenAI(apiKey string, model string) *OpenAI {
+	return &OpenAI{
+		client:         openai.NewClient(option.WithAPIKey(apiKey)),
+		model:          model,
+		embeddingModel: DefaultEmbeddingModel,
+		strictSchema:   true,
+	}
+}
+
+// NewCompatible returns a Provider for an OpenAI-compatible endpoint, e.g.
+// http://localhost:11434/v1 for Ollama.
+func NewCompatible(baseURL string, apiKey string, model string) *OpenAI {
+	opts := []option.RequestOption{option.WithBaseURL(strings.TrimRight(baseURL, "/") + "/")}
+	if apiKey != "" {
+		opts = append(opts, option.WithAPIKey(apiKey))
+	}
+	return &OpenAI{
+		client:         openai.NewClient(opts...),
+		model:          model,
+		embeddingModel: DefaultEmbeddingModel,
+	}
+}
+
+// WithEmbeddingModel sets the model used by Embed. An empty model is ignored.
+func (p *OpenAI) WithEmbeddingModel(model string) *OpenAI {
+	if model != "" {
+		p.embeddingModel = model
+	}
+	return p
+}
+
+// WithEmbeddingEndpoint sends Embed calls to an OpenAI-compatible server at
+// baseURL instead. An empty baseURL is ignored.
+func (p *OpenAI) WithEmbeddingEndpoint(baseURL string, apiKey string) *OpenAI {
+	if baseURL != "" {
+		p.embeddingClient = NewCompatible(baseURL, apiKey, p.model).client
+	}
+	return p
+}
+
+func (p *OpenAI) params(req Request) openai.ChatCompletionNewParams {
+	model := req.Model
+	if model == "" {
+		model = p.model
+	}
+
+	messages := make([]openai.ChatCompletionMessageParamUnion, 0, len(req.Messages))
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case RoleSystem:
+			messages = append(messages, openai.SystemMessage(msg.Content))
+		case RoleAssistant:
+			messages = append(messages, openai.AssistantMessage(msg.Content))
+		default:
+			messages = append(messages, openai.UserMessage(msg.Content))
+		}
+	}
+
+	return openai.ChatCompletionNewParams{
+		Messages: openai.F(messages),
+		Model:    openai.F(model),
+	}
+}
+
+func (p *OpenAI) complete(ctx context.Context, params openai.ChatCompletionNewParams) (string, error) {
+	chat, err := p.client.Chat.Completions.New(ctx, params)
+	if err != nil {
+		return "", fmt.Errorf("error getting chat completion: %w", err)
+	}
+
+	if len(chat.Choices) == 0 || chat.Choices[0].Message.Content == "" {
+		return "", ErrEmptyResponse
+	}
+
+	return chat.Choices[0].Message.Content, nil
+}
+
+func (p *OpenAI) Complete(ctx context.Context, req Request) (string, error) {
+	return p.complete(ctx, p.params(req))
+}
+
+func (p *OpenAI) Stream(ctx context.Context, req Request, onDelta func(delta string) error) (string, error) {
+	stream := p.client.Chat.Completions.NewStreaming(ctx, p.params(req))
+	defer stream.Close()
+
+	var content strings.Builder
+	for stream.Next() {
+		chunk := stream.Current()
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+
+		delta := chunk.Choices[0].Delta.Content
+		content.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			return content.String(), err
+		}
+	}
+
+	if err := stream.Err(); err != nil {
+		return content.String(), fmt.Errorf("error streaming chat completion: %w", err)
+	}
+	if content.Len() == 0 {
+		return "", ErrEmptyResponse
+	}
+
+	return content.String(), nil
+}
+
+func (p *OpenAI) CompleteJSON(ctx context.Context, req Request, schema Schema, out interface{}) error {
+	if p.strictSchema {
+		params := p.params(req)
+		params.ResponseFormat = openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
+			openai.ResponseFormatJSONSchemaParam{
+				Type: openai.F(openai.ResponseFormatJSONSchemaTypeJSONSchema),
+				JSONSchema: openai.F(openai.ResponseFormatJSONSchemaJSONSchemaParam{
+					Name:        openai.F(schema.Name),
+					Description: openai.F(schema.Description),
+					Schema:      openai.F(schema.Schema),
+					Strict:      openai.Bool(true),
+				}),
+			},
+		)
+
+		content, err := p.complete(ctx, params)
+		if err != nil {
+			return err
+		}
+		return decodeJSON(content, out)
+	}
+
+	schemaJSON, err := json.Marshal(schema.Schema)
+	if err != nil {
+		return fmt.Errorf("error marshaling schema: %w", err)
+	}
+
+	req.Messages = append([]Message{
+		System(fmt.Sprintf("Respond only with a JSON document (%s) matching this JSON Schema:\n%s", schema.Description, schemaJSON)),
+	}, req.Messages...)
+
+	params := p.params(req)
+	params.ResponseFormat = openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
+		openai.ResponseFormatJSONObjectParam{
+			Type: openai.F(openai.ResponseFormatJSONObjectTypeJSONObject),
+		},
+	)
+
+	content, err := p.complete(ctx, params)
+	if err != nil {
+		return err
+	}
+	return decodeJSON(content, out)
+}
+
+func decodeJSON(content string, out interface{}) error {
+	if err := json.Unmarshal([]byte(content), out); err != nil {
+		return fmt.Errorf("error parsing JSON response: %w", err)
+	}
+	return nil
+}
+
+func (p *OpenAI) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	client := p.client
+	if p.embeddingClient != nil {
+		client = p.embeddingClient
+	}
+
+	res, err := client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+		Input: openai.F[openai.EmbeddingNewParamsInputUnion](openai.EmbeddingNewParamsInputArrayOfStrings(texts)),
+		Model: openai.F(p.embeddingModel),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating embeddings: %w", err)
+	}
+
+	if len(res.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(res.Data))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, embedding := range res.Data {
+		if embedding.Index < 0 || int(embedding.Index) >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", embedding.Index)
+		}
+		vector := make([]float32, len(embedding.Embedding))
+		for i, value := range embedding.Embedding {
+			vector[i] = float32(value)
+		}
+		vectors[embedding.Index] = vector
+	}
+	return vectors, nil
+}
@@ -1,12 +1,19 @@
 package main
 
 import (
+    "context"
     "os"
 	"fmt"
 	"log"
 	"net/http"
 
+	"github.com/davidulloa/mimir/analytics"
+	"github.com/davidulloa/mimir/database"
 	"github.com/davidulloa/mimir/handlers"
+	"github.com/davidulloa/mimir/notify"
+	"github.com/davidulloa/mimir/session"
+	"github.com/davidulloa/mimir/ticketsync"
+	"github.com/davidulloa/mimir/vault"
 )
 
 func enableCORS(next http.Handler) http.Handler {
@@ -15,7 +22,7 @@ func enableCORS(next http.Handler) http.Handler {
         // Set the necessary headers
         w.Header().Set("Access-Control-Allow-Origin", frontend)
         w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-        w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, " + handlers.ServiceNowAuthorizationHeader + ", " + handlers.AdminTokenHeader)
 
         // If it's an OPTIONS request, end here
         if r.Method == http.MethodOptions {
@@ -30,19 +37,71 @@ func enableCORS(next http.Handler) http.Handler {
 
 
 func main() {
+	store, err := database.OpenStore()
+	if err != nil {
+		log.Fatalf("Error opening store: %v", err)
+	}
+	defer store.Close()
+	database.SetStore(store)
+
+	sessions, err := session.Default()
+	if err != nil {
+		log.Fatalf("Error configuring sessions: %v", err)
+	}
+	// Keep the denylist of logged-out access tokens current.
+	go sessions.Run(context.Background())
+
+	// Re-encrypt stored credentials if the vault key has been rotated.
+	if v, err := vault.Default(); err == nil {
+		rotated, err := v.Rotate()
+		if err != nil {
+			log.Printf("Error rotating vault key: %v", err)
+		}
+		if rotated > 0 {
+			log.Printf("Re-encrypted credentials for %d instances", rotated)
+		}
+
+		// Keep every registered instance's incidents mirrored locally.
+		go ticketsync.Default().Run(context.Background())
+
+		// Watch for emerging issues when there's somewhere to report them.
+		if _, err := notify.Default(); err == nil {
+			go analytics.NewWatcher().Run(context.Background())
+		}
+	} else {
+		log.Printf("Credential vault disabled: %v", err)
+	}
+
 	client := &http.Client{}
 
 	ticketHandler := handlers.NewTicketHandler(client)
-	suggestionsHandler := handlers.NewSuggestionsHandler()
+	suggestionsHandler := handlers.NewSuggestionsHandler(client)
 	chatHandler := handlers.NewChatHandler()
 	docHandler := handlers.NewDocumentationHandler()
-	authHandler := handlers.NewAuthorizationHandler()
+	authHandler := handlers.NewAuthorizationHandler(client)
+	acceleratorsHandler := handlers.NewAcceleratorsHandler()
+	webhookHandler := handlers.NewWebhookHandler()
+	analyticsHandler := handlers.NewAnalyticsHandler(client)
 
 	http.Handle("/tickets", enableCORS(handlers.AuthMiddleware(http.HandlerFunc(ticketHandler.TicketsHandler))))
 	http.Handle("/suggestions", enableCORS(handlers.AuthMiddleware(http.HandlerFunc(suggestionsHandler.SuggestionsHandler))))
+	http.Handle("/suggestions/runs", enableCORS(handlers.AuthMiddleware(http.HandlerFunc(suggestionsHandler.RunsHandler))))
+	http.Handle("/suggestions/diff", enableCORS(handlers.AuthMiddleware(http.HandlerFunc(suggestionsHandler.DiffHandler))))
+	http.Handle("/analytics/trends", enableCORS(handlers.AuthMiddleware(http.HandlerFunc(analyticsHandler.TrendsHandler))))
+	http.Handle("/analytics/emerging", enableCORS(handlers.AuthMiddleware(http.HandlerFunc(analyticsHandler.EmergingHandler))))
 	http.Handle("/chat", enableCORS(handlers.AuthMiddleware(http.HandlerFunc(chatHandler.ChatHandler))))
+	http.Handle("/chat/stream", enableCORS(handlers.AuthMiddleware(http.HandlerFunc(chatHandler.StreamHandler))))
+	http.Handle("/chat/search", enableCORS(handlers.AuthMiddleware(http.HandlerFunc(chatHandler.SearchHandler))))
 	http.Handle("/documentation", enableCORS(handlers.AuthMiddleware(http.HandlerFunc(docHandler.DocumentationHandler))))
+	http.Handle("/accelerators", enableCORS(handlers.AuthMiddleware(http.HandlerFunc(acceleratorsHandler.AcceleratorsHandler))))
+	http.Handle("/accelerators/search", enableCORS(handlers.AuthMiddleware(http.HandlerFunc(acceleratorsHandler.SearchHandler))))
+	http.Handle("/accelerators/{id}", enableCORS(handlers.AuthMiddleware(http.HandlerFunc(acceleratorsHandler.AcceleratorHandler))))
+	// ServiceNow signs its events, so the webhook needs no session.
+	http.Handle("/webhooks/servicenow/{instanceId}", http.HandlerFunc(webhookHandler.EventsHandler))
+	http.Handle("/webhooks/servicenow/{instanceId}/secret", http.HandlerFunc(webhookHandler.SecretHandler))
 	http.Handle("/authorization", enableCORS(http.HandlerFunc(authHandler.AuthorizationHandler)))
+	http.Handle("/authorization/refresh", enableCORS(http.HandlerFunc(authHandler.RefreshHandler)))
+	http.Handle("/authorization/logout", enableCORS(http.HandlerFunc(authHandler.LogoutHandler)))
 
 	fmt.Println("Server is running on port 8080...")
 	log.Fatal(http.ListenAndServe(":8080", nil))
@@ -1,8 +1,15 @@
 package models
 
 type Accelerator struct {
+    ID    string `json:"id,omitempty"`
     Url   string `json:"url"`
     Title string `json:"title"`
 	Description string `json:"description"`
     Category string `json:"category"`
-}
\ No newline at end of file
+}
+
+// AcceleratorMatch is an accelerator returned by search with its relevance.
+type AcceleratorMatch struct {
+	Accelerator Accelerator `json:"accelerator"`
+	Score       float64     `json:"score"`
+}
@@ -0,0 +1,35 @@
+package models
+
+// ChangeRequest is a record of the ServiceNow change_request table. Reference
+// fields hold the referenced record's sys_id.
+type ChangeRequest struct {
+	SysID              string `json:"sys_id"`
+	Number             string `json:"number"`
+	ShortDescription   string `json:"short_description"`
+	Description        string `json:"description"`
+	Type               string `json:"type"`
+	State              string `json:"state"`
+	Phase              string `json:"phase"`
+	Priority           string `json:"priority"`
+	Risk               string `json:"risk"`
+	Impact             string `json:"impact"`
+	Category           string `json:"category"`
+	AssignmentGroup    string `json:"assignment_group"`
+	AssignedTo         string `json:"assigned_to"`
+	CmdbCI             string `json:"cmdb_ci"`
+	Reason             string `json:"reason"`
+	Justification      string `json:"justification"`
+	ImplementationPlan string `json:"implementation_plan"`
+	BackoutPlan        string `json:"backout_plan"`
+	TestPlan           string `json:"test_plan"`
+	Approval           string `json:"approval"`
+	CloseCode          string `json:"close_code"`
+	CloseNotes         string `json:"close_notes"`
+	Active             string `json:"active"`
+	StartDate          string `json:"start_date"`
+	EndDate            string `json:"end_date"`
+	OpenedAt           string `json:"opened_at"`
+	ClosedAt           string `json:"closed_at"`
+	SysCreatedOn       string `json:"sys_created_on"`
+	SysUpdatedOn       string `json:"sys_updated_on"`
+}
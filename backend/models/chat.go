@@ -14,11 +14,83 @@ type ChatThread struct {
 	IsActive      bool          `json:"is_active"`
 	Metadata      string        `json:"metadata"`
 	AcceleratorId string        `json:"accelerator_id"`
+	// ActiveMessageID is the last message of the branch the thread shows.
+	// Empty means the most recently added message.
+	ActiveMessageID string `json:"active_message_id,omitempty"`
+	// Summary condenses the conversation from the first message through
+	// SummaryMessageID, so long threads can be replayed to the model within
+	// its token budget. It applies to the branches containing that message.
+	Summary          string `json:"summary,omitempty"`
+	SummaryMessageID string `json:"summary_message_id,omitempty"`
+	// MessageSequence is the Sequence of the latest message added.
+	MessageSequence int64 `json:"message_sequence,omitempty"`
 }
 
 type ChatMessage struct {
-	ID        string    `json:"id"`
-	Role      string    `json:"role"`
-	Content   string    `json:"content"`
+	ID        string     `json:"id"`
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	Timestamp time.Time  `json:"timestamp"`
+	Citations []Citation `json:"citations,omitempty"`
+	// ParentID is the message this one follows, or the thread ID for the
+	// first message. Editing or regenerating a message adds a sibling with
+	// the same parent. Messages stored before branching have none and follow
+	// the message added before them.
+	ParentID string `json:"parent_id,omitempty"`
+	// Sequence orders a thread's messages: it increases with every message
+	// added. Messages stored before it existed have none and are ordered by
+	// Timestamp, ahead of the rest.
+	Sequence int64 `json:"sequence"`
+}
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// GenerationJob tracks the generation of an assistant reply to one user
+// message, so clients can tell a pending reply from a failed one.
+type GenerationJob struct {
+	ID       string `json:"id"`
+	ThreadID string `json:"thread_id"`
+	// MessageID is the user message being answered.
+	MessageID string `json:"message_id"`
+	Status    string `json:"status"`
+	// Error is why the last attempt failed.
+	Error    string `json:"error,omitempty"`
+	Attempts int    `json:"attempts"`
+	// Owner is who runs the job. A queued job with an owner is reserved for
+	// it.
+	Owner string `json:"owner,omitempty"`
+	// ReplyID is the assistant message the job produced.
+	ReplyID   string    `json:"reply_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ChatSearchResult is a message, or a thread title, matching a search over
+// an instance's chat history.
+type ChatSearchResult struct {
+	ThreadID      string `json:"thread_id"`
+	ThreadTitle   string `json:"thread_title"`
+	AcceleratorID string `json:"accelerator_id"`
+	// MessageID is empty when the thread's title matched.
+	MessageID string    `json:"message_id,omitempty"`
+	Role      string    `json:"role,omitempty"`
 	Timestamp time.Time `json:"timestamp"`
+	// Snippet is an excerpt of the match, HTML-escaped, with the query's
+	// terms wrapped in <mark> tags.
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+}
+
+// Citation points an assistant reply back at the documentation it drew on.
+// Index matches the [n] markers in the reply.
+type Citation struct {
+	Index   int    `json:"index"`
+	URL     string `json:"url"`
+	Title   string `json:"title"`
+	Snippet string `json:"snippet"`
 }
@@ -4,4 +4,15 @@ package models
 type Documentation struct {
 	Title   string `json:"title"`
 	Accelerator Accelerator `json:"accelerator"`
-}
\ No newline at end of file
+}
+// DocumentChunk is a passage of an accelerator's documentation page, indexed
+// for retrieval by its embedding Vector.
+type DocumentChunk struct {
+	ID            string    `json:"id"`
+	AcceleratorID string    `json:"acceleratorId"`
+	URL           string    `json:"url"`
+	Title         string    `json:"title"`
+	Position      int       `json:"position"`
+	Content       string    `json:"content"`
+	Vector        []float32 `json:"vector,omitempty"`
+}
@@ -1,5 +1,7 @@
 package models
 
+import "encoding/json"
+
 type Incident struct {
 	PromotedBy             string     `json:"promoted_by"`
 	Parent                 string     `json:"parent"`
@@ -111,6 +113,25 @@ type LinkObject struct {
 	Value string `json:"value"`
 }
 
+// UnmarshalJSON accepts both the {"link", "value"} object and the bare sys_id
+// string ServiceNow sends for empty references or when reference links are
+// excluded.
+func (l *LinkObject) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*l = LinkObject{Value: value}
+		return nil
+	}
+
+	type linkObject LinkObject
+	var obj linkObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	*l = LinkObject(obj)
+	return nil
+}
+
 type ApiResponse struct {
 	Result []Incident `json:"result"`
 }
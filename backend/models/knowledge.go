@@ -0,0 +1,21 @@
+package models
+
+// KnowledgeArticle is a record of the ServiceNow kb_knowledge table. Text is
+// the article body as HTML.
+type KnowledgeArticle struct {
+	SysID            string `json:"sys_id"`
+	Number           string `json:"number"`
+	ShortDescription string `json:"short_description"`
+	Text             string `json:"text"`
+	KnowledgeBase    string `json:"kb_knowledge_base"`
+	Category         string `json:"kb_category"`
+	ArticleType      string `json:"article_type"`
+	WorkflowState    string `json:"workflow_state"`
+	Author           string `json:"author"`
+	Rating           string `json:"rating"`
+	ViewCount        string `json:"sys_view_count"`
+	ValidTo          string `json:"valid_to"`
+	Published        string `json:"published"`
+	SysCreatedOn     string `json:"sys_created_on"`
+	SysUpdatedOn     string `json:"sys_updated_on"`
+}
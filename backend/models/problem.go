@@ -0,0 +1,33 @@
+package models
+
+// Problem is a record of the ServiceNow problem table. Reference fields hold
+// the referenced record's sys_id.
+type Problem struct {
+	SysID               string `json:"sys_id"`
+	Number              string `json:"number"`
+	ShortDescription    string `json:"short_description"`
+	Description         string `json:"description"`
+	State               string `json:"state"`
+	ProblemState        string `json:"problem_state"`
+	Priority            string `json:"priority"`
+	Impact              string `json:"impact"`
+	Urgency             string `json:"urgency"`
+	Category            string `json:"category"`
+	Subcategory         string `json:"subcategory"`
+	AssignmentGroup     string `json:"assignment_group"`
+	AssignedTo          string `json:"assigned_to"`
+	CmdbCI              string `json:"cmdb_ci"`
+	KnownError          string `json:"known_error"`
+	CauseNotes          string `json:"cause_notes"`
+	FixNotes            string `json:"fix_notes"`
+	Workaround          string `json:"workaround"`
+	RelatedIncidents    string `json:"related_incidents"`
+	FirstReportedByTask string `json:"first_reported_by_task"`
+	RFC                 string `json:"rfc"`
+	Active              string `json:"active"`
+	OpenedAt            string `json:"opened_at"`
+	ResolvedAt          string `json:"resolved_at"`
+	ClosedAt            string `json:"closed_at"`
+	SysCreatedOn        string `json:"sys_created_on"`
+	SysUpdatedOn        string `json:"sys_updated_on"`
+}
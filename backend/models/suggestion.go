@@ -1,8 +1,41 @@
 package models
 
+import "time"
+
 type Suggestion struct {
 	ID           int               `json:"id"`
 	Title        string            `json:"title"`
 	Description  string            `json:"description"`
 	Accelerators []Accelerator `json:"accelerators"` // Assuming the Accelerator is part of your models package
-}
\ No newline at end of file
+}
+
+// SuggestionCluster is one cluster of incidents a suggestion run was based on.
+type SuggestionCluster struct {
+	Description   string   `json:"description"`
+	TicketNumbers []string `json:"ticketNumbers"`
+}
+
+// SuggestionRun records one request to /suggestions: the incidents it saw,
+// how they clustered and what was recommended.
+type SuggestionRun struct {
+	ID            string              `json:"id"`
+	InstanceID    string              `json:"instanceId"`
+	CreatedAt     time.Time           `json:"createdAt"`
+	TicketNumbers []string            `json:"ticketNumbers"`
+	Clusters      []SuggestionCluster `json:"clusters"`
+	Suggestions   []Suggestion        `json:"suggestions"`
+	// Table is the ServiceNow table the tickets came from; empty means incident.
+	Table string `json:"table,omitempty"`
+}
+
+// SuggestionRunDiff compares two runs of the same instance. Suggestions are
+// matched by their recommended accelerators.
+type SuggestionRunDiff struct {
+	From               string       `json:"from"`
+	To                 string       `json:"to"`
+	AddedSuggestions   []Suggestion `json:"addedSuggestions"`
+	RemovedSuggestions []Suggestion `json:"removedSuggestions"`
+	KeptSuggestions    []Suggestion `json:"keptSuggestions"`
+	AddedTickets       []string     `json:"addedTickets"`
+	RemovedTickets     []string     `json:"removedTickets"`
+}
@@ -7,4 +7,31 @@ type Ticket struct {
 	ShortDescription string `json:"short_description"`
 	State            string `json:"state"`
 	Priority         string `json:"priority"`
+
+	// The fields below are set on tickets mirrored from ServiceNow by the
+	// incident sync. Timestamps keep ServiceNow's "2006-01-02 15:04:05" UTC
+	// format, so they sort as strings.
+	InstanceID string `json:"instance_id,omitempty"`
+	// Table is the ServiceNow table the ticket mirrors; empty means incident.
+	Table        string `json:"table,omitempty"`
+	SysID        string `json:"sys_id,omitempty"`
+	SysCreatedOn string `json:"sys_created_on,omitempty"`
+	SysUpdatedOn string `json:"sys_updated_on,omitempty"`
+	// ProblemID, ChangeID and CausedByID are the sys_ids of the problem and
+	// change requests an incident is linked to: its problem_id, the change
+	// that resolved it (rfc) and the change that caused it (caused_by).
+	ProblemID  string `json:"problem_id,omitempty"`
+	ChangeID   string `json:"change_id,omitempty"`
+	CausedByID string `json:"caused_by_id,omitempty"`
+	// OpenedAt through MadeSLA are only set on incidents, for trend
+	// analytics. ReopenCount and MadeSLA keep ServiceNow's string values,
+	// e.g. "2" and "false".
+	OpenedAt        string `json:"opened_at,omitempty"`
+	ResolvedAt      string `json:"resolved_at,omitempty"`
+	Category        string `json:"category,omitempty"`
+	AssignmentGroup string `json:"assignment_group,omitempty"`
+	ReopenCount     string `json:"reopen_count,omitempty"`
+	MadeSLA         string `json:"made_sla,omitempty"`
+	// Deleted marks a ticket that was deleted in ServiceNow.
+	Deleted bool `json:"deleted,omitempty"`
 }
@@ -0,0 +1,118 @@
+// Package notify delivers alerts, such as incident clusters growing unusually
+// fast, to an outside hook. The hook is a URL configured in
+// MIMIR_ALERT_WEBHOOK_URL that receives each Alert as a JSON POST; its text
+// field makes it readable by chat webhooks such as Slack's.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const KindEmergingIssue = "emerging_issue"
+
+var ErrNotConfigured = errors.New("no alert hook configured; set MIMIR_ALERT_WEBHOOK_URL")
+
+// Alert is one notification.
+type Alert struct {
+	Kind       string    `json:"kind"`
+	InstanceID string    `json:"instanceId"`
+	CreatedAt  time.Time `json:"createdAt"`
+	// Text summarises the alert for people.
+	Text string `json:"text"`
+	// Cluster describes the incidents the alert is about, and TicketNumbers
+	// samples them.
+	Cluster       string   `json:"cluster"`
+	TicketNumbers []string `json:"ticketNumbers"`
+	// Count is the cluster's incidents in the latest window, Baseline what
+	// was expected and Score how unusual the difference is.
+	Count    int     `json:"count"`
+	Baseline float64 `json:"baseline"`
+	Score    float64 `json:"score"`
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// Webhook posts alerts as JSON to URL.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+func (h *Webhook) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("notify: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notify: hook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// FromEnv returns a Webhook for MIMIR_ALERT_WEBHOOK_URL, or ErrNotConfigured.
+func FromEnv() (Notifier, error) {
+	url := os.Getenv("MIMIR_ALERT_WEBHOOK_URL")
+	if url == "" {
+		return nil, ErrNotConfigured
+	}
+	return &Webhook{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+var (
+	defaultNotifier Notifier
+	defaultMu       sync.Mutex
+)
+
+// Default returns the process-wide notifier, building it from the environment
+// on first use. It returns ErrNotConfigured when no hook is configured.
+func Default() (Notifier, error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultNotifier != nil {
+		return defaultNotifier, nil
+	}
+
+	n, err := FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	defaultNotifier = n
+	return defaultNotifier, nil
+}
+
+// SetDefault replaces the process-wide notifier. It is used by tests.
+func SetDefault(n Notifier) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultNotifier = n
+}
@@ -0,0 +1,38 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookPostsAlerts(t *testing.T) {
+	var received Alert
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	t.Setenv("MIMIR_ALERT_WEBHOOK_URL", server.URL)
+	notifier, err := FromEnv()
+	require.NoError(t, err)
+
+	alert := Alert{Kind: KindEmergingIssue, InstanceID: "dev000001", Cluster: "Email outage", TicketNumbers: []string{"INC001"}, Count: 6}
+	require.NoError(t, notifier.Notify(context.Background(), alert))
+	assert.Equal(t, alert, received)
+
+	status = http.StatusInternalServerError
+	assert.Error(t, notifier.Notify(context.Background(), alert))
+
+	t.Setenv("MIMIR_ALERT_WEBHOOK_URL", "")
+	_, err = FromEnv()
+	assert.ErrorIs(t, err, ErrNotConfigured)
+}
@@ -0,0 +1,145 @@
+package retrieval
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Section is a run of paragraphs under one heading of a documentation page.
+type Section struct {
+	Heading    string
+	Paragraphs []string
+}
+
+// Passage is a chunk of text ready to be embedded.
+type Passage struct {
+	Heading string
+	Content string
+}
+
+// Fetch downloads a documentation page and splits its readable text into
+// sections by heading. It returns the page title as well.
+func Fetch(ctx context.Context, client *http.Client, url string) (string, []Section, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to fetch URL: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(res.Body)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	title := strings.TrimSpace(doc.Find("title").First().Text())
+	return title, sections(doc), nil
+}
+
+func sections(doc *goquery.Document) []Section {
+	doc.Find("script, style, noscript, nav, header, footer, aside, form").Remove()
+
+	root := doc.Find("main").First()
+	if root.Length() == 0 {
+		root = doc.Find("article").First()
+	}
+	if root.Length() == 0 {
+		root = doc.Find("body")
+	}
+
+	var result []Section
+	current := Section{}
+	root.Find("h1, h2, h3, h4, p, li, pre, td").Each(func(_ int, s *goquery.Selection) {
+		// Text is collected from the innermost blocks; a list item holding a
+		// paragraph is read through the paragraph.
+		if s.Find("p, li, pre, td").Length() > 0 {
+			return
+		}
+
+		text := strings.Join(strings.Fields(s.Text()), " ")
+		if text == "" {
+			return
+		}
+
+		switch goquery.NodeName(s) {
+		case "h1", "h2", "h3", "h4":
+			if len(current.Paragraphs) > 0 {
+				result = append(result, current)
+			}
+			current = Section{Heading: text}
+		default:
+			current.Paragraphs = append(current.Paragraphs, text)
+		}
+	})
+
+	if len(current.Paragraphs) > 0 {
+		result = append(result, current)
+	}
+	return result
+}
+
+// Split packs each section's paragraphs into passages of at most maxWords
+// words. Paragraphs longer than that are cut into windows that overlap by
+// overlapWords so a sentence spanning the cut is still found whole in one.
+// A non-positive maxWords means DefaultChunkWords, and the overlap is kept
+// below maxWords so the windows always advance.
+func Split(sections []Section, maxWords int, overlapWords int) []Passage {
+	if maxWords <= 0 {
+		maxWords = DefaultChunkWords
+	}
+	if overlapWords < 0 {
+		overlapWords = 0
+	}
+	if overlapWords >= maxWords {
+		overlapWords = maxWords / 2
+	}
+
+	var passages []Passage
+	for _, section := range sections {
+		var current []string
+		flush := func() {
+			if len(current) > 0 {
+				passages = append(passages, Passage{Heading: section.Heading, Content: strings.Join(current, " ")})
+				current = nil
+			}
+		}
+
+		for _, paragraph := range section.Paragraphs {
+			words := strings.Fields(paragraph)
+
+			if len(words) > maxWords {
+				flush()
+				for start := 0; start < len(words); start += maxWords - overlapWords {
+					end := start + maxWords
+					if end > len(words) {
+						end = len(words)
+					}
+					passages = append(passages, Passage{Heading: section.Heading, Content: strings.Join(words[start:end], " ")})
+					if end == len(words) {
+						break
+					}
+				}
+				continue
+			}
+
+			if len(current)+len(words) > maxWords {
+				flush()
+			}
+			current = append(current, words...)
+		}
+		flush()
+	}
+	return passages
+}
@@ -0,0 +1,239 @@
+// Package retrieval grounds chat replies in accelerator documentation. Each
+// accelerator's documentation page is fetched, split into passages, embedded
+// and indexed in the store; every user turn then retrieves the passages most
+// relevant to the question.
+package retrieval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/llm"
+	"github.com/davidulloa/mimir/models"
+)
+
+const (
+	DefaultTopK         = 4
+	DefaultChunkWords   = 180
+	DefaultOverlapWords = 30
+
+	// retryAfter is how long to wait before trying to index a page that failed.
+	retryAfter = 10 * time.Minute
+	// snippetWords is the length of the excerpt carried by a citation.
+	snippetWords = 40
+)
+
+var ErrNoDocumentation = errors.New("accelerator has no documentation URL")
+
+type Retriever struct {
+	HTTPClient   *http.Client
+	TopK         int
+	ChunkWords   int
+	OverlapWords int
+
+	mu       sync.Mutex
+	indexing map[string]*sync.Mutex
+	failed   map[string]time.Time
+}
+
+func NewRetriever(client *http.Client) *Retriever {
+	return &Retriever{
+		HTTPClient:   client,
+		TopK:         DefaultTopK,
+		ChunkWords:   DefaultChunkWords,
+		OverlapWords: DefaultOverlapWords,
+		indexing:     make(map[string]*sync.Mutex),
+		failed:       make(map[string]time.Time),
+	}
+}
+
+// lock serializes indexing of one accelerator so concurrent turns on a new
+// thread fetch the page once.
+func (r *Retriever) lock(acceleratorID string) *sync.Mutex {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.indexing[acceleratorID]
+	if !ok {
+		m = &sync.Mutex{}
+		r.indexing[acceleratorID] = m
+	}
+	return m
+}
+
+// Index fetches the accelerator's documentation page, embeds its passages and
+// replaces whatever was indexed for it before. It returns the passage count.
+func (r *Retriever) Index(ctx context.Context, acceleratorID string, accelerator models.Accelerator) (int, error) {
+	if accelerator.Url == "" {
+		return 0, ErrNoDocumentation
+	}
+
+	title, sections, err := Fetch(ctx, r.HTTPClient, accelerator.Url)
+	if err != nil {
+		return 0, err
+	}
+	if title == "" {
+		title = accelerator.Title
+	}
+
+	passages := Split(sections, r.ChunkWords, r.OverlapWords)
+	if len(passages) == 0 {
+		return 0, fmt.Errorf("no text found at %s", accelerator.Url)
+	}
+
+	provider, err := llm.Default()
+	if err != nil {
+		return 0, err
+	}
+
+	texts := make([]string, len(passages))
+	for i, passage := range passages {
+		// The heading gives short passages the context they need to match.
+		texts[i] = strings.TrimSpace(passage.Heading + "\n" + passage.Content)
+	}
+
+	vectors, err := provider.Embed(ctx, texts)
+	if err != nil {
+		return 0, err
+	}
+	if len(vectors) != len(passages) {
+		return 0, fmt.Errorf("expected %d embeddings, got %d", len(passages), len(vectors))
+	}
+
+	chunks := make([]models.DocumentChunk, len(passages))
+	for i, passage := range passages {
+		chunkTitle := title
+		if passage.Heading != "" && passage.Heading != title {
+			chunkTitle = title + " - " + passage.Heading
+		}
+		chunks[i] = models.DocumentChunk{
+			AcceleratorID: acceleratorID,
+			URL:           accelerator.Url,
+			Title:         chunkTitle,
+			Position:      i,
+			Content:       passage.Content,
+			Vector:        vectors[i],
+		}
+	}
+
+	if err := database.ReplaceDocumentChunks(acceleratorID, chunks); err != nil {
+		return 0, err
+	}
+	return len(chunks), nil
+}
+
+// ensureIndexed indexes the accelerator the first time it is asked about.
+// Pages that failed are not retried until retryAfter has passed.
+func (r *Retriever) ensureIndexed(ctx context.Context, acceleratorID string) error {
+	m := r.lock(acceleratorID)
+	m.Lock()
+	defer m.Unlock()
+
+	count, err := database.CountDocumentChunks(acceleratorID)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	r.mu.Lock()
+	failedAt, failed := r.failed[acceleratorID]
+	r.mu.Unlock()
+	if failed && time.Since(failedAt) < retryAfter {
+		return fmt.Errorf("indexing accelerator %s failed recently", acceleratorID)
+	}
+
+	accelerator, err := database.GetAcceleratorByID(acceleratorID)
+	if err == nil {
+		_, err = r.Index(ctx, acceleratorID, *accelerator)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.failed[acceleratorID] = time.Now()
+		return err
+	}
+	delete(r.failed, acceleratorID)
+	return nil
+}
+
+// Retrieve returns the passages of the accelerator's documentation most
+// relevant to query, indexing the page first if needed.
+func (r *Retriever) Retrieve(ctx context.Context, acceleratorID string, query string) ([]models.DocumentChunk, error) {
+	if err := r.ensureIndexed(ctx, acceleratorID); err != nil {
+		return nil, err
+	}
+
+	provider, err := llm.Default()
+	if err != nil {
+		return nil, err
+	}
+
+	vectors, err := provider.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("expected 1 embedding, got %d", len(vectors))
+	}
+
+	return database.SearchDocumentChunks(acceleratorID, vectors[0], r.TopK)
+}
+
+// Citations numbers the chunks in the order they appear in PromptContext.
+func Citations(chunks []models.DocumentChunk) []models.Citation {
+	citations := make([]models.Citation, len(chunks))
+	for i, chunk := range chunks {
+		snippet := chunk.Content
+		if words := strings.Fields(snippet); len(words) > snippetWords {
+			snippet = strings.Join(words[:snippetWords], " ") + "..."
+		}
+		citations[i] = models.Citation{
+			Index:   i + 1,
+			URL:     chunk.URL,
+			Title:   chunk.Title,
+			Snippet: snippet,
+		}
+	}
+	return citations
+}
+
+// PromptContext renders the chunks as numbered excerpts for the system prompt,
+// with instructions to cite them by number.
+func PromptContext(chunks []models.DocumentChunk) string {
+	if len(chunks) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("Use the following excerpts from the accelerator's documentation to answer. ")
+	b.WriteString("When a statement relies on an excerpt, cite it with its number in square brackets, e.g. [1]. ")
+	b.WriteString("If the excerpts don't cover the question, say so rather than guessing.\n")
+	for i, chunk := range chunks {
+		fmt.Fprintf(&b, "\n[%d] %s (%s)\n%s\n", i+1, chunk.Title, chunk.URL, chunk.Content)
+	}
+	return b.String()
+}
+
+// Cited keeps the citations whose [n] marker appears in reply. If the reply
+// cites none of them, all are kept as the sources the reply was given.
+func Cited(citations []models.Citation, reply string) []models.Citation {
+	var cited []models.Citation
+	for _, citation := range citations {
+		if strings.Contains(reply, fmt.Sprintf("[%d]", citation.Index)) {
+			cited = append(cited, citation)
+		}
+	}
+	if len(cited) == 0 {
+		return citations
+	}
+	return cited
+}
@@ -0,0 +1,158 @@
+package retrieval
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/database/databasetest"
+	"github.com/davidulloa/mimir/llm/llmtest"
+	"github.com/davidulloa/mimir/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const page = `<html>
+<head><title>Incident Health Check</title><script>var tracking = true;</script></head>
+<body>
+<nav><a href="/">Home</a></nav>
+<main>
+  <h1>Overview</h1>
+  <p>The health check reviews how incidents are logged, assigned and resolved.</p>
+  <h2>Assignment rules</h2>
+  <p>Assignment groups are scored on reassignment counts and time to first touch.</p>
+  <ul><li>Reassignment above three hops is flagged.</li></ul>
+  <h2>Major incidents</h2>
+  <p>Major incident workflows are compared with the recommended communication plan.</p>
+</main>
+<footer>Copyright</footer>
+</body>
+</html>`
+
+func TestFetchSections(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	title, sections, err := Fetch(context.Background(), server.Client(), server.URL)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Incident Health Check", title)
+	require.Len(t, sections, 3)
+	assert.Equal(t, "Overview", sections[0].Heading)
+	assert.Equal(t, "Assignment rules", sections[1].Heading)
+	assert.Equal(t, []string{
+		"Assignment groups are scored on reassignment counts and time to first touch.",
+		"Reassignment above three hops is flagged.",
+	}, sections[1].Paragraphs)
+
+	for _, section := range sections {
+		for _, paragraph := range section.Paragraphs {
+			assert.NotContains(t, paragraph, "tracking")
+			assert.NotContains(t, paragraph, "Copyright")
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	words := make([]string, 25)
+	for i := range words {
+		words[i] = fmt.Sprintf("w%d", i)
+	}
+
+	passages := Split([]Section{
+		{Heading: "Short", Paragraphs: []string{"one two", "three four", "five six seven"}},
+		{Heading: "Long", Paragraphs: []string{strings.Join(words, " ")}},
+	}, 10, 2)
+
+	require.Len(t, passages, 4)
+	assert.Equal(t, Passage{Heading: "Short", Content: "one two three four five six seven"}, passages[0])
+	assert.Equal(t, "w0 w1 w2 w3 w4 w5 w6 w7 w8 w9", passages[1].Content)
+	// Windows overlap by two words.
+	assert.True(t, strings.HasPrefix(passages[2].Content, "w8 w9 "))
+	assert.True(t, strings.HasSuffix(passages[3].Content, "w24"))
+
+	// Unset sizes, as on a zero Retriever, fall back to the defaults.
+	long := strings.Repeat("word ", DefaultChunkWords+1)
+	passages = Split([]Section{{Paragraphs: []string{long}}}, 0, 0)
+	assert.Len(t, passages, 2)
+
+	passages = Split([]Section{{Paragraphs: []string{strings.Join(words, " ")}}}, 1, 1)
+	assert.Len(t, passages, len(words), "one-word windows still advance")
+}
+
+func TestIndexAndRetrieve(t *testing.T) {
+	databasetest.UseBoltStore(t)
+	llmtest.UseFake(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	r := NewRetriever(server.Client())
+	r.ChunkWords = 25
+	r.TopK = 1
+
+	count, err := r.Index(context.Background(), "acc-1", models.Accelerator{Url: server.URL, Title: "Health Check"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	chunks, err := r.Retrieve(context.Background(), "acc-1", "How are major incident communication plans compared?")
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	assert.Equal(t, "Incident Health Check - Major incidents", chunks[0].Title)
+	assert.Equal(t, server.URL, chunks[0].URL)
+
+	citations := Citations(chunks)
+	assert.Equal(t, []models.Citation{{
+		Index:   1,
+		URL:     server.URL,
+		Title:   "Incident Health Check - Major incidents",
+		Snippet: "Major incident workflows are compared with the recommended communication plan.",
+	}}, citations)
+	assert.Contains(t, PromptContext(chunks), "[1] Incident Health Check - Major incidents")
+}
+
+func TestRetrieveIndexesOnFirstUse(t *testing.T) {
+	llmtest.UseFake(t)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	s := databasetest.UseBoltStore(t)
+	database.SetStore(&acceleratorStore{Store: s, url: server.URL})
+
+	r := NewRetriever(server.Client())
+	for i := 0; i < 2; i++ {
+		chunks, err := r.Retrieve(context.Background(), "acc-1", "assignment groups")
+		require.NoError(t, err)
+		assert.NotEmpty(t, chunks)
+	}
+	assert.Equal(t, 1, requests)
+}
+
+func TestCited(t *testing.T) {
+	citations := []models.Citation{{Index: 1}, {Index: 2}, {Index: 3}}
+
+	assert.Equal(t, []models.Citation{{Index: 2}}, Cited(citations, "Reassignments are flagged [2]."))
+	assert.Equal(t, citations, Cited(citations, "No markers here."))
+}
+
+type acceleratorStore struct {
+	database.Store
+	url string
+}
+
+func (s *acceleratorStore) GetAcceleratorByID(acceleratorID string) (*models.Accelerator, error) {
+	return &models.Accelerator{Url: s.url, Title: "Health Check"}, nil
+}
@@ -0,0 +1,241 @@
+// Package servicenow is a small typed client for the ServiceNow Table API.
+package servicenow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/davidulloa/mimir/models"
+)
+
+const (
+	DefaultPageSize = 100
+
+	IncidentTable      = "incident"
+	ProblemTable       = "problem"
+	ChangeRequestTable = "change_request"
+	KnowledgeTable     = "kb_knowledge"
+)
+
+// Client talks to a single ServiceNow instance with basic authentication.
+type Client struct {
+	baseURL    string
+	username   string
+	password   string
+	httpClient *http.Client
+	pageSize   int
+}
+
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithBaseURL overrides the https://<instance>.service-now.com base URL.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// WithPageSize sets how many records are requested per page.
+func WithPageSize(pageSize int) Option {
+	return func(c *Client) {
+		if pageSize > 0 {
+			c.pageSize = pageSize
+		}
+	}
+}
+
+var instanceIDPattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// NewClient returns a client for https://<instanceID>.service-now.com. The
+// instance ID must be lowercase letters, digits and hyphens, so it can't
+// point the credentials at another host.
+func NewClient(instanceID string, username string, password string, opts ...Option) (*Client, error) {
+	if !instanceIDPattern.MatchString(instanceID) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidInstance, instanceID)
+	}
+	c := &Client{
+		baseURL:    fmt.Sprintf("https://%s.service-now.com", instanceID),
+		username:   username,
+		password:   password,
+		httpClient: http.DefaultClient,
+		pageSize:   DefaultPageSize,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// ListOptions narrows a Table API listing.
+type ListOptions struct {
+	// Query is the encoded query sent as sysparm_query.
+	Query *Query
+	// Fields limits the returned columns (sysparm_fields). Empty returns all.
+	Fields []string
+	// Limit caps the total number of records returned across pages. Zero
+	// fetches every matching record.
+	Limit int
+	// Offset skips the first records of the result set.
+	Offset int
+}
+
+type tableResponse struct {
+	Result []json.RawMessage `json:"result"`
+}
+
+var nextLinkPattern = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// nextLink returns the rel="next" URL from a Link header, if present.
+func nextLink(header string) string {
+	match := nextLinkPattern.FindStringSubmatch(header)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+func (c *Client) tableURL(table string, opts ListOptions, offset int, pageSize int) string {
+	params := url.Values{}
+	if query := opts.Query.String(); query != "" {
+		params.Set("sysparm_query", query)
+	}
+	if len(opts.Fields) > 0 {
+		params.Set("sysparm_fields", strings.Join(opts.Fields, ","))
+	}
+	// Reference fields come back as plain sys_ids, matching the string
+	// fields on the models.
+	params.Set("sysparm_exclude_reference_link", "true")
+	params.Set("sysparm_limit", strconv.Itoa(pageSize))
+	params.Set("sysparm_offset", strconv.Itoa(offset))
+
+	return fmt.Sprintf("%s/api/now/table/%s?%s", c.baseURL, table, params.Encode())
+}
+
+func (c *Client) get(ctx context.Context, requestURL string) (*tableResponse, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("servicenow: creating request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(c.username, c.password)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("servicenow: request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("servicenow: reading response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, newError(resp.StatusCode, body)
+	}
+
+	page := &tableResponse{}
+	if err := json.Unmarshal(body, page); err != nil {
+		return nil, nil, fmt.Errorf("servicenow: parsing response: %w", err)
+	}
+
+	return page, resp.Header, nil
+}
+
+// ListRaw pages through a table and returns each record undecoded. It follows
+// the Link header when the instance sends one and otherwise advances
+// sysparm_offset until a short page comes back.
+func (c *Client) ListRaw(ctx context.Context, table string, opts ListOptions) ([]json.RawMessage, error) {
+	var records []json.RawMessage
+	offset := opts.Offset
+	requestURL := ""
+
+	for {
+		pageSize := c.pageSize
+		if opts.Limit > 0 && opts.Limit-len(records) < pageSize {
+			pageSize = opts.Limit - len(records)
+		}
+		if requestURL == "" {
+			requestURL = c.tableURL(table, opts, offset, pageSize)
+		}
+
+		page, header, err := c.get(ctx, requestURL)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, page.Result...)
+		offset += len(page.Result)
+
+		if opts.Limit > 0 && len(records) >= opts.Limit {
+			return records[:opts.Limit], nil
+		}
+		if len(page.Result) == 0 {
+			return records, nil
+		}
+
+		requestURL = nextLink(header.Get("Link"))
+		if requestURL == "" && len(page.Result) < pageSize {
+			return records, nil
+		}
+		// A limited listing must keep its own page size, so don't reuse the
+		// instance's next link once we are close to the limit.
+		if opts.Limit > 0 && opts.Limit-len(records) < c.pageSize {
+			requestURL = ""
+		}
+	}
+}
+
+// List pages through a table and decodes every record into T.
+func List[T any](ctx context.Context, c *Client, table string, opts ListOptions) ([]T, error) {
+	raw, err := c.ListRaw(ctx, table, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]T, 0, len(raw))
+	for _, r := range raw {
+		var record T
+		if err := json.Unmarshal(r, &record); err != nil {
+			return nil, fmt.Errorf("servicenow: decoding %s record: %w", table, err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (c *Client) Incidents(ctx context.Context, opts ListOptions) ([]models.Incident, error) {
+	return List[models.Incident](ctx, c, IncidentTable, opts)
+}
+
+func (c *Client) Problems(ctx context.Context, opts ListOptions) ([]models.Problem, error) {
+	return List[models.Problem](ctx, c, ProblemTable, opts)
+}
+
+func (c *Client) ChangeRequests(ctx context.Context, opts ListOptions) ([]models.ChangeRequest, error) {
+	return List[models.ChangeRequest](ctx, c, ChangeRequestTable, opts)
+}
+
+func (c *Client) KnowledgeArticles(ctx context.Context, opts ListOptions) ([]models.KnowledgeArticle, error) {
+	return List[models.KnowledgeArticle](ctx, c, KnowledgeTable, opts)
+}
+
+// Ping checks that the credentials can read the incident table.
+func (c *Client) Ping(ctx context.Context) error {
+	_, _, err := c.get(ctx, c.tableURL(IncidentTable, ListOptions{Fields: []string{"sys_id"}}, 0, 1))
+	return err
+}
@@ -0,0 +1,156 @@
+package servicenow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// incidentServer serves total incidents, honouring sysparm_limit/offset. When
+// withLink is set it advertises the next page through the Link header.
+func incidentServer(t *testing.T, total int, withLink bool, requests *[]string) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r.URL.RawQuery)
+
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "admin" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":{"message":"User Not Authenticated","detail":"Required to provide Auth information"},"status":"failure"}`)
+			return
+		}
+
+		limit, _ := strconv.Atoi(r.URL.Query().Get("sysparm_limit"))
+		offset, _ := strconv.Atoi(r.URL.Query().Get("sysparm_offset"))
+
+		end := offset + limit
+		if end > total {
+			end = total
+		}
+
+		if withLink && end < total {
+			next := fmt.Sprintf("%s/api/now/table/incident?sysparm_limit=%d&sysparm_offset=%d", server.URL, limit, end)
+			w.Header().Set("Link", fmt.Sprintf(`<%s>;rel="first",<%s>;rel="next"`, server.URL, next))
+		}
+
+		fmt.Fprint(w, `{"result":[`)
+		for i := offset; i < end; i++ {
+			if i > offset {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprintf(w, `{"number":"INC%07d","short_description":"incident %d","opened_by":""}`, i, i)
+		}
+		fmt.Fprint(w, `]}`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestListPagesByOffset(t *testing.T) {
+	var requests []string
+	server := incidentServer(t, 7, false, &requests)
+	client, err := NewClient("dev000001", "admin", "secret", WithBaseURL(server.URL), WithPageSize(3))
+	require.NoError(t, err)
+
+	incidents, err := client.Incidents(context.Background(), ListOptions{})
+	require.NoError(t, err)
+
+	assert.Len(t, incidents, 7)
+	assert.Equal(t, "INC0000006", incidents[6].Number)
+	assert.Len(t, requests, 3)
+}
+
+func TestListFollowsLinkHeader(t *testing.T) {
+	var requests []string
+	server := incidentServer(t, 5, true, &requests)
+	client, err := NewClient("dev000001", "admin", "secret", WithBaseURL(server.URL), WithPageSize(2))
+	require.NoError(t, err)
+
+	incidents, err := client.Incidents(context.Background(), ListOptions{})
+	require.NoError(t, err)
+
+	assert.Len(t, incidents, 5)
+	assert.Len(t, requests, 3)
+	assert.Contains(t, requests[1], "sysparm_offset=2")
+}
+
+func TestListRespectsLimit(t *testing.T) {
+	var requests []string
+	server := incidentServer(t, 50, true, &requests)
+	client, err := NewClient("dev000001", "admin", "secret", WithBaseURL(server.URL), WithPageSize(4))
+	require.NoError(t, err)
+
+	incidents, err := client.Incidents(context.Background(), ListOptions{Limit: 10})
+	require.NoError(t, err)
+
+	assert.Len(t, incidents, 10)
+	assert.Contains(t, requests[len(requests)-1], "sysparm_limit=2")
+}
+
+func TestListSendsQueryAndFields(t *testing.T) {
+	var requests []string
+	server := incidentServer(t, 1, false, &requests)
+	client, err := NewClient("dev000001", "admin", "secret", WithBaseURL(server.URL))
+	require.NoError(t, err)
+
+	_, err = client.Incidents(context.Background(), ListOptions{
+		Query:  NewQuery().Equals("active", "true").OrderByDesc("sys_created_on"),
+		Fields: []string{"number", "short_description"},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, requests, 1)
+	assert.Contains(t, requests[0], "sysparm_query=active%3Dtrue%5EORDERBYDESCsys_created_on")
+	assert.Contains(t, requests[0], "sysparm_fields=number%2Cshort_description")
+}
+
+func TestTypedErrors(t *testing.T) {
+	var requests []string
+	server := incidentServer(t, 1, false, &requests)
+	client, err := NewClient("dev000001", "admin", "wrong", WithBaseURL(server.URL))
+	require.NoError(t, err)
+
+	err = client.Ping(context.Background())
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+
+	var snErr *Error
+	require.True(t, errors.As(err, &snErr))
+	assert.Equal(t, http.StatusUnauthorized, snErr.StatusCode)
+	assert.Equal(t, "User Not Authenticated", snErr.Message)
+}
+
+func TestNewClientRejectsInvalidInstance(t *testing.T) {
+	for _, instanceID := range []string{"", "evil.com/#", "dev000001.evil.com", "Dev000001", "dev 01"} {
+		_, err := NewClient(instanceID, "admin", "secret")
+		assert.ErrorIs(t, err, ErrInvalidInstance, instanceID)
+	}
+}
+
+func TestQueryString(t *testing.T) {
+	since := time.Date(2024, 10, 1, 8, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		query    *Query
+		expected string
+	}{
+		{NewQuery(), ""},
+		{NewQuery().Equals("active", "true"), "active=true"},
+		{NewQuery().Equals("priority", "1").Or("priority", "2"), "priority=1^ORpriority=2"},
+		{NewQuery().Since("sys_updated_on", since).OrderBy("sys_updated_on"), "sys_updated_on>2024-10-01 08:30:00^ORDERBYsys_updated_on"},
+		{NewQuery().OnOrAfter("sys_updated_on", since), "sys_updated_on>=2024-10-01 08:30:00"},
+		{NewQuery().In("state", "1", "2").Contains("short_description", "a^b"), "stateIN1,2^short_descriptionLIKEa^^b"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.query.String())
+	}
+}
@@ -0,0 +1,81 @@
+package servicenow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrBadRequest   = errors.New("servicenow: bad request")
+	ErrUnauthorized = errors.New("servicenow: unauthorized")
+	ErrForbidden    = errors.New("servicenow: forbidden")
+	ErrNotFound     = errors.New("servicenow: not found")
+	ErrRateLimited  = errors.New("servicenow: rate limited")
+	ErrServer       = errors.New("servicenow: server error")
+
+	// ErrInvalidInstance is returned by NewClient for an instance ID that
+	// isn't a plain <instance>.service-now.com host label.
+	ErrInvalidInstance = errors.New("servicenow: invalid instance id")
+)
+
+// Error is a failed Table API call. It unwraps to one of the Err* sentinels
+// so callers can use errors.Is without inspecting status codes.
+type Error struct {
+	StatusCode int
+	Message    string
+	Detail     string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("servicenow: status %d", e.StatusCode)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Detail != "" {
+		msg += " (" + e.Detail + ")"
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusBadRequest:
+		return ErrBadRequest
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode >= 500:
+		return ErrServer
+	}
+	return nil
+}
+
+// errorBody is the envelope the Table API uses for failures:
+// {"error": {"message": "...", "detail": "..."}, "status": "failure"}
+type errorBody struct {
+	Error struct {
+		Message string `json:"message"`
+		Detail  string `json:"detail"`
+	} `json:"error"`
+}
+
+func newError(statusCode int, body []byte) *Error {
+	e := &Error{StatusCode: statusCode}
+
+	var parsed errorBody
+	if json.Unmarshal(body, &parsed) == nil && parsed.Error.Message != "" {
+		e.Message = parsed.Error.Message
+		e.Detail = parsed.Error.Detail
+	} else {
+		e.Message = http.StatusText(statusCode)
+	}
+
+	return e
+}
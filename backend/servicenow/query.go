@@ -0,0 +1,117 @@
+package servicenow
+
+import (
+	"strings"
+	"time"
+)
+
+// DateTimeLayout is the format ServiceNow uses for glide_date_time values in
+// responses and encoded queries (always UTC for the Table API).
+const DateTimeLayout = "2006-01-02 15:04:05"
+
+// Query builds a ServiceNow encoded query (sysparm_query). Conditions are
+// ANDed together unless joined with Or.
+type Query struct {
+	terms   []string
+	orderBy []string
+}
+
+func NewQuery() *Query {
+	return &Query{}
+}
+
+func escapeValue(value string) string {
+	return strings.ReplaceAll(value, "^", "^^")
+}
+
+func (q *Query) add(op string, term string) *Query {
+	if len(q.terms) > 0 {
+		term = op + term
+	}
+	q.terms = append(q.terms, term)
+	return q
+}
+
+func (q *Query) where(field, operator, value string) *Query {
+	return q.add("^", field+operator+escapeValue(value))
+}
+
+func (q *Query) Equals(field, value string) *Query {
+	return q.where(field, "=", value)
+}
+
+func (q *Query) NotEquals(field, value string) *Query {
+	return q.where(field, "!=", value)
+}
+
+func (q *Query) Contains(field, value string) *Query {
+	return q.where(field, "LIKE", value)
+}
+
+func (q *Query) GreaterThan(field, value string) *Query {
+	return q.where(field, ">", value)
+}
+
+func (q *Query) GreaterThanOrEqual(field, value string) *Query {
+	return q.where(field, ">=", value)
+}
+
+func (q *Query) LessThan(field, value string) *Query {
+	return q.where(field, "<", value)
+}
+
+// Since matches records whose date-time field is strictly after t.
+func (q *Query) Since(field string, t time.Time) *Query {
+	return q.GreaterThan(field, t.UTC().Format(DateTimeLayout))
+}
+
+// OnOrAfter matches records whose date-time field is t or later.
+func (q *Query) OnOrAfter(field string, t time.Time) *Query {
+	return q.GreaterThanOrEqual(field, t.UTC().Format(DateTimeLayout))
+}
+
+func (q *Query) In(field string, values ...string) *Query {
+	escaped := make([]string, len(values))
+	for i, value := range values {
+		escaped[i] = escapeValue(value)
+	}
+	return q.add("^", field+"IN"+strings.Join(escaped, ","))
+}
+
+func (q *Query) IsEmpty(field string) *Query {
+	return q.add("^", field+"ISEMPTY")
+}
+
+func (q *Query) IsNotEmpty(field string) *Query {
+	return q.add("^", field+"ISNOTEMPTY")
+}
+
+// Or joins the next condition to the previous one with OR instead of AND.
+func (q *Query) Or(field, value string) *Query {
+	return q.add("^OR", field+"="+escapeValue(value))
+}
+
+func (q *Query) OrderBy(field string) *Query {
+	q.orderBy = append(q.orderBy, "ORDERBY"+field)
+	return q
+}
+
+func (q *Query) OrderByDesc(field string) *Query {
+	q.orderBy = append(q.orderBy, "ORDERBYDESC"+field)
+	return q
+}
+
+// String returns the encoded query, e.g. "active=true^priority<3^ORDERBYnumber".
+func (q *Query) String() string {
+	if q == nil {
+		return ""
+	}
+	parts := strings.Join(q.terms, "")
+	for _, order := range q.orderBy {
+		if parts != "" {
+			parts += "^"
+		}
+		parts += order
+	}
+	return parts
+}
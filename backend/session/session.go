@@ -0,0 +1,339 @@
+// Package session issues the tokens clients use after logging in.
+//
+// Access tokens are short-lived HS256 JWTs carrying the instance ID; they are
+// validated locally, by their signature and an in-process copy of the
+// store's denylist. Refresh tokens are opaque random strings whose digest is
+// kept in the store, so they can be rotated and revoked. Logging out revokes
+// the refresh token and denylists the access token until it expires. Both
+// live in the store, so every replica sharing it, and SESSION_SECRET, honors
+// them: a replica that didn't handle the logout rejects the access token once
+// it next reloads the denylist, every DenylistRefresh.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultAccessTTL  = 15 * time.Minute
+	DefaultRefreshTTL = 7 * 24 * time.Hour
+
+	// DenylistRefresh is how often Run reloads the revoked access tokens.
+	DenylistRefresh = 30 * time.Second
+
+	TokenType = "Bearer"
+	issuer    = "mimir"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid or expired token")
+	ErrRevokedToken = errors.New("token has been revoked")
+)
+
+// Claims are carried by every access token.
+type Claims struct {
+	InstanceID string `json:"instanceId"`
+	jwt.RegisteredClaims
+}
+
+// Tokens is returned by /authorization and /authorization/refresh.
+type Tokens struct {
+	AccessToken      string    `json:"accessToken"`
+	RefreshToken     string    `json:"refreshToken"`
+	TokenType        string    `json:"tokenType"`
+	InstanceID       string    `json:"instanceId"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+	RefreshExpiresAt time.Time `json:"refreshExpiresAt"`
+}
+
+type Manager struct {
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	now        func() time.Time
+
+	mu      sync.RWMutex
+	revoked map[string]time.Time
+}
+
+type Option func(*Manager)
+
+func WithAccessTTL(ttl time.Duration) Option {
+	return func(m *Manager) { m.accessTTL = ttl }
+}
+
+func WithRefreshTTL(ttl time.Duration) Option {
+	return func(m *Manager) { m.refreshTTL = ttl }
+}
+
+// WithClock replaces time.Now; tests use it to expire tokens.
+func WithClock(now func() time.Time) Option {
+	return func(m *Manager) { m.now = now }
+}
+
+func NewManager(secret []byte, opts ...Option) *Manager {
+	m := &Manager{
+		secret:     secret,
+		accessTTL:  DefaultAccessTTL,
+		refreshTTL: DefaultRefreshTTL,
+		now:        time.Now,
+		revoked:    make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// FromEnv builds a Manager from SESSION_SECRET, SESSION_ACCESS_TTL and
+// SESSION_REFRESH_TTL (Go durations, e.g. "15m"). Replicas must share the
+// secret, so it is required with the Weaviate store. With the embedded store,
+// which one process owns, a random secret is generated when it is unset, and
+// sessions don't survive a restart.
+func FromEnv() (*Manager, error) {
+	secret := []byte(os.Getenv("SESSION_SECRET"))
+	if len(secret) == 0 {
+		if database.ConfiguredStore() != database.StoreBolt {
+			return nil, errors.New("SESSION_SECRET is required: without it each replica signs tokens with its own key")
+		}
+		log.Println("WARNING: SESSION_SECRET is not set; using a random secret, so sessions will not survive a restart")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+
+	var opts []Option
+	for name, option := range map[string]func(time.Duration) Option{
+		"SESSION_ACCESS_TTL":  WithAccessTTL,
+		"SESSION_REFRESH_TTL": WithRefreshTTL,
+	} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid %s %q", name, value)
+		}
+		opts = append(opts, option(ttl))
+	}
+
+	return NewManager(secret, opts...), nil
+}
+
+var (
+	defaultManager *Manager
+	defaultMu      sync.Mutex
+)
+
+// Default returns the process-wide manager, building it from the environment
+// on first use.
+func Default() (*Manager, error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultManager != nil {
+		return defaultManager, nil
+	}
+
+	m, err := FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	defaultManager = m
+	return defaultManager, nil
+}
+
+// SetDefault replaces the process-wide manager. It is used by tests.
+func SetDefault(m *Manager) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultManager = m
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Issue starts a new session for instanceID.
+func (m *Manager) Issue(instanceID string) (*Tokens, error) {
+	now := m.now()
+
+	claims := Claims{
+		InstanceID: instanceID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    issuer,
+			Subject:   instanceID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(m.accessTTL)),
+		},
+	}
+	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(m.secret)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	refreshExpiresAt := now.Add(m.refreshTTL)
+	_, err = database.CreateSession(database.SessionRecord{
+		TokenHash:  hashToken(refreshToken),
+		InstanceID: instanceID,
+		CreatedAt:  now,
+		ExpiresAt:  refreshExpiresAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error storing session: %v", err)
+	}
+
+	return &Tokens{
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		TokenType:        TokenType,
+		InstanceID:       instanceID,
+		ExpiresAt:        claims.ExpiresAt.Time,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, nil
+}
+
+// Validate checks an access token's signature, expiry and revocation.
+func (m *Manager) Validate(accessToken string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(accessToken, claims, func(*jwt.Token) (interface{}, error) {
+		return m.secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(m.now),
+	)
+	if err != nil || claims.InstanceID == "" {
+		return nil, ErrInvalidToken
+	}
+
+	m.mu.RLock()
+	_, revoked := m.revoked[claims.ID]
+	m.mu.RUnlock()
+	if revoked {
+		return nil, ErrRevokedToken
+	}
+
+	return claims, nil
+}
+
+// LoadDenylist reloads the revoked access tokens Validate checks from the
+// store. Tokens this manager revoked are kept until they expire, in case the
+// store was read before they were added.
+func (m *Manager) LoadDenylist() error {
+	revoked, err := database.ListRevokedAccessTokens()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	for tokenID, expiresAt := range m.revoked {
+		if _, ok := revoked[tokenID]; !ok && expiresAt.After(now) {
+			revoked[tokenID] = expiresAt
+		}
+	}
+	m.revoked = revoked
+	return nil
+}
+
+// Run loads the denylist now and then every DenylistRefresh until ctx is
+// done, so tokens revoked through other replicas are rejected.
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(DenylistRefresh)
+	defer ticker.Stop()
+
+	for {
+		if err := m.LoadDenylist(); err != nil {
+			log.Printf("Error loading revoked access tokens: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh exchanges a refresh token for a new session. The old refresh token
+// is consumed, so each one can only be used once.
+func (m *Manager) Refresh(refreshToken string) (*Tokens, error) {
+	session, err := database.FindSession(hashToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, ErrInvalidToken
+	}
+
+	if err := database.DeleteSession(session.ID); err != nil {
+		return nil, err
+	}
+
+	if !m.now().Before(session.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+
+	return m.Issue(session.InstanceID)
+}
+
+// Revoke ends a session. Either token may be empty; an access token that
+// doesn't validate is ignored.
+func (m *Manager) Revoke(accessToken string, refreshToken string) error {
+	if accessToken != "" {
+		if claims, err := m.Validate(accessToken); err == nil {
+			if err := database.RevokeAccessToken(claims.ID, claims.ExpiresAt.Time); err != nil {
+				return err
+			}
+			m.mu.Lock()
+			m.revoked[claims.ID] = claims.ExpiresAt.Time
+			m.mu.Unlock()
+		}
+	}
+
+	if refreshToken != "" {
+		session, err := database.FindSession(hashToken(refreshToken))
+		if err != nil {
+			return err
+		}
+		if session != nil {
+			return database.DeleteSession(session.ID)
+		}
+	}
+
+	return nil
+}
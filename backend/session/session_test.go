@@ -0,0 +1,120 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/database/databasetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time { return c.now }
+
+func TestIssueAndValidate(t *testing.T) {
+	databasetest.UseBoltStore(t)
+	m := NewManager([]byte("secret"))
+
+	tokens, err := m.Issue("dev000001")
+	require.NoError(t, err)
+	assert.Equal(t, TokenType, tokens.TokenType)
+	assert.NotEmpty(t, tokens.RefreshToken)
+
+	claims, err := m.Validate(tokens.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "dev000001", claims.InstanceID)
+
+	_, err = NewManager([]byte("other")).Validate(tokens.AccessToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = m.Validate(tokens.AccessToken + "x")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestAccessTokenExpires(t *testing.T) {
+	databasetest.UseBoltStore(t)
+	c := &clock{now: time.Now()}
+	m := NewManager([]byte("secret"), WithAccessTTL(time.Minute), WithClock(c.Now))
+
+	tokens, err := m.Issue("dev000001")
+	require.NoError(t, err)
+
+	c.now = c.now.Add(2 * time.Minute)
+	_, err = m.Validate(tokens.AccessToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	databasetest.UseBoltStore(t)
+	c := &clock{now: time.Now()}
+	m := NewManager([]byte("secret"), WithRefreshTTL(time.Hour), WithClock(c.Now))
+
+	tokens, err := m.Issue("dev000001")
+	require.NoError(t, err)
+
+	refreshed, err := m.Refresh(tokens.RefreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, "dev000001", refreshed.InstanceID)
+	assert.NotEqual(t, tokens.RefreshToken, refreshed.RefreshToken)
+
+	// A refresh token can only be used once.
+	_, err = m.Refresh(tokens.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	c.now = c.now.Add(2 * time.Hour)
+	_, err = m.Refresh(refreshed.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestRevoke(t *testing.T) {
+	databasetest.UseBoltStore(t)
+	m := NewManager([]byte("secret"))
+
+	tokens, err := m.Issue("dev000001")
+	require.NoError(t, err)
+
+	require.NoError(t, m.Revoke(tokens.AccessToken, tokens.RefreshToken))
+
+	_, err = m.Validate(tokens.AccessToken)
+	assert.ErrorIs(t, err, ErrRevokedToken)
+
+	_, err = m.Refresh(tokens.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestRevocationIsSharedThroughTheStore(t *testing.T) {
+	databasetest.UseBoltStore(t)
+	issuer := NewManager([]byte("secret"))
+	other := NewManager([]byte("secret"))
+
+	tokens, err := issuer.Issue("dev000001")
+	require.NoError(t, err)
+
+	require.NoError(t, other.Revoke(tokens.AccessToken, ""))
+
+	_, err = issuer.Validate(tokens.AccessToken)
+	assert.NoError(t, err, "validation doesn't read the store")
+
+	require.NoError(t, issuer.LoadDenylist())
+	_, err = issuer.Validate(tokens.AccessToken)
+	assert.ErrorIs(t, err, ErrRevokedToken, "a replica honors logouts handled by another")
+}
+
+func TestFromEnvRequiresSecretWithSharedStore(t *testing.T) {
+	t.Setenv("SESSION_SECRET", "")
+	t.Setenv("MIMIR_STORE", database.StoreWeaviate)
+	_, err := FromEnv()
+	assert.Error(t, err)
+
+	t.Setenv("MIMIR_STORE", database.StoreBolt)
+	_, err = FromEnv()
+	assert.NoError(t, err)
+
+	t.Setenv("MIMIR_STORE", database.StoreWeaviate)
+	t.Setenv("SESSION_SECRET", "shared")
+	_, err = FromEnv()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,543 @@
+// Package ticketsync mirrors each registered instance's ServiceNow incident,
+// problem and change_request tables into the store as tickets, so /tickets
+// and /suggestions read local data instead of calling ServiceNow on every
+// request.
+//
+// Each table is synced on its own. The first sync backfills the whole table.
+// Later syncs only fetch records updated at or after the sys_updated_on
+// watermark the previous one left, and apply deletions recorded in
+// sys_audit_delete. Reading that table needs elevated roles, so a full sync
+// also runs every FullSyncInterval and marks stored tickets it no longer sees
+// as deleted.
+package ticketsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/models"
+	"github.com/davidulloa/mimir/servicenow"
+	"github.com/davidulloa/mimir/vault"
+)
+
+const (
+	DefaultInterval         = 15 * time.Minute
+	DefaultFullSyncInterval = 24 * time.Hour
+
+	AuditDeleteTable = "sys_audit_delete"
+)
+
+var ticketFields = []string{"sys_id", "number", "short_description", "priority", "state", "sys_created_on", "sys_updated_on"}
+
+// incidentFields adds the links to problems and changes and the fields trend
+// analytics read.
+var incidentFields = append([]string{"problem_id", "rfc", "caused_by",
+	"opened_at", "resolved_at", "category", "assignment_group", "reopen_count", "made_sla"}, ticketFields...)
+
+// Tables are the tables a Syncer mirrors by default, in the order they sync.
+var Tables = []string{servicenow.IncidentTable, servicenow.ProblemTable, servicenow.ChangeRequestTable}
+
+// table describes how records of one ServiceNow table become tickets.
+type table struct {
+	fields []string
+	list   func(ctx context.Context, client *servicenow.Client, instanceID string, opts servicenow.ListOptions) ([]models.Ticket, error)
+}
+
+var tables = map[string]table{
+	servicenow.IncidentTable: {
+		fields: incidentFields,
+		list:   listAs(servicenow.IncidentTable, FromIncident),
+	},
+	servicenow.ProblemTable: {
+		fields: ticketFields,
+		list:   listAs(servicenow.ProblemTable, FromProblem),
+	},
+	servicenow.ChangeRequestTable: {
+		fields: ticketFields,
+		list:   listAs(servicenow.ChangeRequestTable, FromChangeRequest),
+	},
+}
+
+// listAs lists a table as T and converts each record with ticket.
+func listAs[T any](name string, ticket func(instanceID string, record T) models.Ticket) func(context.Context, *servicenow.Client, string, servicenow.ListOptions) ([]models.Ticket, error) {
+	return func(ctx context.Context, client *servicenow.Client, instanceID string, opts servicenow.ListOptions) ([]models.Ticket, error) {
+		records, err := servicenow.List[T](ctx, client, name, opts)
+		if err != nil {
+			return nil, err
+		}
+		tickets := make([]models.Ticket, 0, len(records))
+		for _, record := range records {
+			tickets = append(tickets, ticket(instanceID, record))
+		}
+		return tickets, nil
+	}
+}
+
+// auditDelete is a sys_audit_delete record; DocumentKey is the sys_id of the
+// deleted record.
+type auditDelete struct {
+	DocumentKey  string `json:"documentkey"`
+	SysCreatedOn string `json:"sys_created_on"`
+}
+
+// Result summarizes one sync of a table.
+type Result struct {
+	InstanceID string
+	Table      string
+	Full       bool
+	// Upserted counts new or changed tickets written.
+	Upserted int
+	// Deleted counts tickets newly marked deleted.
+	Deleted int
+}
+
+type Syncer struct {
+	// Client returns the ServiceNow client scheduled syncs use for an
+	// instance. The default logs in with the credentials in the vault.
+	Client func(instanceID string) (*servicenow.Client, error)
+	// Tables lists the tables to mirror; see the package variable.
+	Tables           []string
+	Interval         time.Duration
+	FullSyncInterval time.Duration
+
+	now   func() time.Time
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func New() *Syncer {
+	return &Syncer{
+		Client:           vaultClient,
+		Tables:           Tables,
+		Interval:         DefaultInterval,
+		FullSyncInterval: DefaultFullSyncInterval,
+		now:              time.Now,
+		locks:            make(map[string]*sync.Mutex),
+	}
+}
+
+func vaultClient(instanceID string) (*servicenow.Client, error) {
+	v, err := vault.Default()
+	if err != nil {
+		return nil, err
+	}
+	return v.Client(instanceID)
+}
+
+var (
+	defaultSyncer *Syncer
+	defaultMu     sync.Mutex
+)
+
+// Default returns the process-wide syncer.
+func Default() *Syncer {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultSyncer == nil {
+		defaultSyncer = New()
+	}
+	return defaultSyncer
+}
+
+// SetDefault replaces the process-wide syncer. It is used by tests.
+func SetDefault(s *Syncer) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultSyncer = s
+}
+
+// lock returns the mutex that keeps syncs of one table from overlapping.
+func (s *Syncer) lock(instanceID string, name string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := instanceID + "/" + name
+	lock, ok := s.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.locks[key] = lock
+	}
+	return lock
+}
+
+// Run syncs every instance with stored credentials now and then every
+// Interval until ctx is done.
+func (s *Syncer) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.SyncAll(ctx); err != nil {
+			log.Printf("Error syncing tickets: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SyncAll syncs every instance with stored credentials. A failing instance
+// doesn't stop the others; their errors are joined.
+func (s *Syncer) SyncAll(ctx context.Context) error {
+	store, err := database.GetStore()
+	if err != nil {
+		return err
+	}
+
+	records, err := store.ListCredentials()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, record := range records {
+		client, err := s.Client(record.InstanceID)
+		if err == nil {
+			_, err = s.Sync(ctx, record.InstanceID, client)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("instance %s: %w", record.InstanceID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SyncIfStale syncs one table of the instance with client unless it was
+// synced within the last Interval. Instances without stored credentials are
+// skipped by the scheduled sync, so this keeps them current while they are in
+// use.
+func (s *Syncer) SyncIfStale(ctx context.Context, instanceID string, name string, client *servicenow.Client) error {
+	state, err := database.GetSyncState(instanceID, name)
+	if err != nil {
+		return err
+	}
+	if state != nil && !state.LastSync.IsZero() && s.now().Sub(state.LastSync) < s.Interval {
+		return nil
+	}
+
+	_, err = s.SyncTable(ctx, instanceID, name, client)
+	return err
+}
+
+// Sync syncs every table of the instance. A failing table doesn't stop the
+// others; their errors are joined.
+func (s *Syncer) Sync(ctx context.Context, instanceID string, client *servicenow.Client) ([]*Result, error) {
+	var results []*Result
+	var errs []error
+	for _, name := range s.Tables {
+		result, err := s.SyncTable(ctx, instanceID, name, client)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, errors.Join(errs...)
+}
+
+// SyncTable brings the instance's stored tickets for one table up to date
+// with ServiceNow. The outcome is recorded in the table's SyncState;
+// watermarks only advance when the sync succeeds.
+func (s *Syncer) SyncTable(ctx context.Context, instanceID string, name string, client *servicenow.Client) (*Result, error) {
+	spec, ok := tables[name]
+	if !ok {
+		return nil, fmt.Errorf("table %q can't be synced", name)
+	}
+
+	lock := s.lock(instanceID, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	state, err := database.GetSyncState(instanceID, name)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		state = &database.SyncState{InstanceID: instanceID, Table: name}
+	}
+
+	started := s.now()
+	result := &Result{
+		InstanceID: instanceID,
+		Table:      name,
+		Full:       state.LastFullSync.IsZero() || started.Sub(state.LastFullSync) >= s.FullSyncInterval,
+	}
+
+	err = s.sync(ctx, client, spec, state, result)
+	if err != nil {
+		state.LastError = err.Error()
+	} else {
+		state.LastError = ""
+		state.LastSync = started
+		if result.Full {
+			state.LastFullSync = started
+		}
+	}
+
+	if saveErr := database.SaveSyncState(*state); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return result, err
+}
+
+func (s *Syncer) sync(ctx context.Context, client *servicenow.Client, spec table, state *database.SyncState, result *Result) error {
+	query := servicenow.NewQuery()
+	if !result.Full {
+		// sys_updated_on only has one-second resolution, so the watermark's
+		// second is read again rather than missing records updated within it.
+		query.OnOrAfter("sys_updated_on", state.Watermark)
+	}
+	query.OrderBy("sys_updated_on")
+
+	fetched, err := spec.list(ctx, client, state.InstanceID, servicenow.ListOptions{Query: query, Fields: spec.fields})
+	if err != nil {
+		return err
+	}
+
+	stored, err := database.GetTicketsByInstanceID(state.InstanceID)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]models.Ticket, len(stored))
+	for _, ticket := range stored {
+		if ticketTable(ticket) == state.Table {
+			byID[ticket.ID] = ticket
+		}
+	}
+
+	var tickets []models.Ticket
+	seen := make(map[string]bool, len(fetched))
+	watermark := state.Watermark
+	for _, ticket := range fetched {
+		if ticket.SysID == "" {
+			continue
+		}
+
+		seen[ticket.ID] = true
+		if updated, err := parseTime(ticket.SysUpdatedOn); err == nil && updated.After(watermark) {
+			watermark = updated
+		}
+
+		if existing, ok := byID[ticket.ID]; ok && existing == ticket {
+			continue
+		}
+		tickets = append(tickets, ticket)
+	}
+	result.Upserted = len(tickets)
+
+	markDeleted := func(id string) {
+		ticket, ok := byID[id]
+		if !ok || ticket.Deleted || seen[id] {
+			return
+		}
+		ticket.Deleted = true
+		tickets = append(tickets, ticket)
+		result.Deleted++
+	}
+
+	deletionWatermark := state.DeletionWatermark
+	if result.Full {
+		for id := range byID {
+			markDeleted(id)
+		}
+		// The backfill reflects every deletion up to the newest update it
+		// saw, which is the instance's clock rather than ours.
+		if watermark.After(deletionWatermark) {
+			deletionWatermark = watermark
+		}
+	} else {
+		deleted, err := deletedSince(ctx, client, state.Table, state.DeletionWatermark)
+		switch {
+		case errors.Is(err, servicenow.ErrForbidden):
+			log.Printf("Cannot read %s for instance %s; deletions wait for the next full sync: %v", AuditDeleteTable, state.InstanceID, err)
+		case err != nil:
+			return err
+		default:
+			for _, record := range deleted {
+				markDeleted(database.TicketID(state.InstanceID, record.DocumentKey))
+				if created, err := parseTime(record.SysCreatedOn); err == nil && created.After(deletionWatermark) {
+					deletionWatermark = created
+				}
+			}
+		}
+	}
+
+	if err := database.StoreTickets(tickets); err != nil {
+		return err
+	}
+
+	state.Watermark = watermark
+	state.DeletionWatermark = deletionWatermark
+	return nil
+}
+
+// deletedSince lists the records of table deleted at or after since.
+func deletedSince(ctx context.Context, client *servicenow.Client, table string, since time.Time) ([]auditDelete, error) {
+	query := servicenow.NewQuery().Equals("tablename", table)
+	if !since.IsZero() {
+		query.OnOrAfter("sys_created_on", since)
+	}
+
+	return servicenow.List[auditDelete](ctx, client, AuditDeleteTable, servicenow.ListOptions{
+		Query:  query,
+		Fields: []string{"documentkey", "sys_created_on"},
+	})
+}
+
+func parseTime(value string) (time.Time, error) {
+	return time.ParseInLocation(servicenow.DateTimeLayout, value, time.UTC)
+}
+
+// FromIncident returns the ticket an incident is stored as.
+func FromIncident(instanceID string, incident models.Incident) models.Ticket {
+	return models.Ticket{
+		ID:               database.TicketID(instanceID, incident.SysID),
+		Number:           incident.Number,
+		ShortDescription: incident.ShortDescription,
+		State:            incident.State,
+		Priority:         incident.Priority,
+		InstanceID:       instanceID,
+		Table:            servicenow.IncidentTable,
+		SysID:            incident.SysID,
+		SysCreatedOn:     incident.SysCreatedOn,
+		SysUpdatedOn:     incident.SysUpdatedOn,
+		ProblemID:        incident.ProblemID,
+		ChangeID:         incident.RFC,
+		CausedByID:       incident.CausedBy,
+		OpenedAt:         incident.OpenedAt,
+		ResolvedAt:       incident.ResolvedAt,
+		Category:         incident.Category,
+		AssignmentGroup:  incident.AssignmentGroup,
+		ReopenCount:      incident.ReopenCount,
+		MadeSLA:          incident.MadeSLA,
+	}
+}
+
+// FromProblem returns the ticket a problem is stored as.
+func FromProblem(instanceID string, problem models.Problem) models.Ticket {
+	return models.Ticket{
+		ID:               database.TicketID(instanceID, problem.SysID),
+		Number:           problem.Number,
+		ShortDescription: problem.ShortDescription,
+		State:            problem.State,
+		Priority:         problem.Priority,
+		InstanceID:       instanceID,
+		Table:            servicenow.ProblemTable,
+		SysID:            problem.SysID,
+		SysCreatedOn:     problem.SysCreatedOn,
+		SysUpdatedOn:     problem.SysUpdatedOn,
+	}
+}
+
+// FromChangeRequest returns the ticket a change request is stored as.
+func FromChangeRequest(instanceID string, change models.ChangeRequest) models.Ticket {
+	return models.Ticket{
+		ID:               database.TicketID(instanceID, change.SysID),
+		Number:           change.Number,
+		ShortDescription: change.ShortDescription,
+		State:            change.State,
+		Priority:         change.Priority,
+		InstanceID:       instanceID,
+		Table:            servicenow.ChangeRequestTable,
+		SysID:            change.SysID,
+		SysCreatedOn:     change.SysCreatedOn,
+		SysUpdatedOn:     change.SysUpdatedOn,
+	}
+}
+
+// Apply stores an incident pushed to us rather than synced, marking it
+// deleted if deleted is set. It reports false and changes nothing when the
+// stored copy is the same or newer, so replayed or out-of-order events can't
+// roll a ticket back, and never revives a deleted ticket.
+func Apply(instanceID string, incident models.Incident, deleted bool) (bool, error) {
+	stored, err := database.RetrieveTickets([]string{database.TicketID(instanceID, incident.SysID)})
+	if err != nil {
+		return false, err
+	}
+
+	var ticket models.Ticket
+	if deleted {
+		if len(stored) == 0 || stored[0].Deleted {
+			return false, nil
+		}
+		ticket = stored[0]
+		ticket.Deleted = true
+	} else {
+		ticket = FromIncident(instanceID, incident)
+		if len(stored) > 0 && (stored[0].Deleted || stored[0] == ticket || stored[0].SysUpdatedOn > ticket.SysUpdatedOn) {
+			return false, nil
+		}
+	}
+
+	return true, database.StoreTickets([]models.Ticket{ticket})
+}
+
+// ticketTable returns the table a ticket mirrors. Tickets synced before
+// other tables were supported are incidents.
+func ticketTable(ticket models.Ticket) string {
+	if ticket.Table == "" {
+		return servicenow.IncidentTable
+	}
+	return ticket.Table
+}
+
+// Tickets returns the instance's stored tickets from table that haven't been
+// deleted, newest first. A positive limit keeps only that many.
+func Tickets(instanceID string, table string, limit int) ([]models.Ticket, error) {
+	return TicketsOpened(instanceID, table, time.Time{}, time.Time{}, limit)
+}
+
+// TicketsOpened is Tickets restricted to tickets opened from from until to,
+// to exclusive, before the limit applies. Zero times leave that side open.
+// Tickets synced before opened_at was stored count as opened when created.
+func TicketsOpened(instanceID string, table string, from time.Time, to time.Time, limit int) ([]models.Ticket, error) {
+	stored, err := database.GetTicketsByInstanceID(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	var since, until string
+	if !from.IsZero() {
+		since = from.UTC().Format(servicenow.DateTimeLayout)
+	}
+	if !to.IsZero() {
+		until = to.UTC().Format(servicenow.DateTimeLayout)
+	}
+
+	tickets := make([]models.Ticket, 0, len(stored))
+	for _, ticket := range stored {
+		if ticket.Deleted || ticketTable(ticket) != table {
+			continue
+		}
+		opened := ticket.OpenedAt
+		if opened == "" {
+			opened = ticket.SysCreatedOn
+		}
+		if since != "" && opened < since || until != "" && opened >= until {
+			continue
+		}
+		tickets = append(tickets, ticket)
+	}
+
+	sort.Slice(tickets, func(i, j int) bool {
+		if tickets[i].SysCreatedOn != tickets[j].SysCreatedOn {
+			return tickets[i].SysCreatedOn > tickets[j].SysCreatedOn
+		}
+		return tickets[i].Number > tickets[j].Number
+	})
+
+	if limit > 0 && len(tickets) > limit {
+		tickets = tickets[:limit]
+	}
+	return tickets, nil
+}
@@ -0,0 +1,276 @@
+package ticketsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/database/databasetest"
+	"github.com/davidulloa/mimir/models"
+	"github.com/davidulloa/mimir/servicenow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// instance is a fake ServiceNow instance serving its incident and problem
+// tables and sys_audit_delete. It honours the sys_updated_on>= condition
+// syncs send to the incident table.
+type instance struct {
+	incidents []models.Incident
+	problems  []models.Problem
+	deletions []auditDelete
+	// auditForbidden makes sys_audit_delete answer 403, as it does for users
+	// without the admin role.
+	auditForbidden bool
+	queries        []string
+}
+
+func (inst *instance) serve(t *testing.T) *servicenow.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("sysparm_query")
+		inst.queries = append(inst.queries, r.URL.Path+"?"+query)
+
+		var result interface{}
+		switch r.URL.Path {
+		case "/api/now/table/incident":
+			var incidents []models.Incident
+			for _, incident := range inst.incidents {
+				if since, ok := condition(query, "sys_updated_on>="); ok && incident.SysUpdatedOn < since {
+					continue
+				}
+				incidents = append(incidents, incident)
+			}
+			result = incidents
+		case "/api/now/table/problem":
+			result = inst.problems
+		case "/api/now/table/sys_audit_delete":
+			if inst.auditForbidden {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `{"error":{"message":"Operation Failed","detail":"ACL Exception"},"status":"failure"}`)
+				return
+			}
+			var deletions []auditDelete
+			for _, deletion := range inst.deletions {
+				if since, ok := condition(query, "sys_created_on>="); ok && deletion.SysCreatedOn < since {
+					continue
+				}
+				deletions = append(deletions, deletion)
+			}
+			result = deletions
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": result})
+	}))
+	t.Cleanup(server.Close)
+	client, err := servicenow.NewClient("dev000001", "admin", "secret", servicenow.WithBaseURL(server.URL))
+	require.NoError(t, err)
+	return client
+}
+
+// condition returns the value of the first term of query starting with prefix.
+func condition(query string, prefix string) (string, bool) {
+	for _, term := range strings.Split(query, "^") {
+		if strings.HasPrefix(term, prefix) {
+			return strings.TrimPrefix(term, prefix), true
+		}
+	}
+	return "", false
+}
+
+func incident(sysID, number, description, updated string) models.Incident {
+	return models.Incident{
+		SysID:            sysID,
+		Number:           number,
+		ShortDescription: description,
+		SysCreatedOn:     updated,
+		SysUpdatedOn:     updated,
+	}
+}
+
+func newTestSyncer(now *time.Time) *Syncer {
+	s := New()
+	s.now = func() time.Time { return *now }
+	return s
+}
+
+func numbers(tickets []models.Ticket) []string {
+	var result []string
+	for _, ticket := range tickets {
+		result = append(result, ticket.Number)
+	}
+	return result
+}
+
+func TestSyncBackfillsThenFetchesChanges(t *testing.T) {
+	databasetest.UseBoltStore(t)
+	ctx := context.Background()
+	now := time.Date(2026, 10, 5, 12, 0, 0, 0, time.UTC)
+	syncer := newTestSyncer(&now)
+
+	inst := &instance{incidents: []models.Incident{
+		incident("a1", "INC001", "Email is down", "2026-10-01 09:00:00"),
+		incident("a2", "INC002", "VPN will not connect", "2026-10-02 09:00:00"),
+		{Number: "INC000", ShortDescription: "No sys_id"},
+	}}
+	client := inst.serve(t)
+
+	result, err := syncer.SyncTable(ctx, "dev000001", servicenow.IncidentTable, client)
+	require.NoError(t, err)
+	assert.True(t, result.Full)
+	assert.Equal(t, 2, result.Upserted)
+	assert.NotContains(t, inst.queries[0], "sys_updated_on>=")
+
+	tickets, err := Tickets("dev000001", servicenow.IncidentTable, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"INC002", "INC001"}, numbers(tickets))
+
+	tickets, err = TicketsOpened("dev000001", servicenow.IncidentTable,
+		time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC), 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"INC001"}, numbers(tickets))
+
+	state, err := database.GetSyncState("dev000001", servicenow.IncidentTable)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 10, 2, 9, 0, 0, 0, time.UTC), state.Watermark)
+	assert.Equal(t, now, state.LastFullSync)
+
+	inst.incidents[0].ShortDescription = "Email is down for everyone"
+	inst.incidents[0].SysUpdatedOn = "2026-10-05 11:00:00"
+	inst.incidents = append(inst.incidents, incident("a3", "INC003", "Printer jammed", "2026-10-05 11:30:00"))
+	inst.queries = nil
+	now = now.Add(time.Hour)
+
+	result, err = syncer.SyncTable(ctx, "dev000001", servicenow.IncidentTable, client)
+	require.NoError(t, err)
+	assert.False(t, result.Full)
+	// INC002 comes back because it sits on the watermark, but is unchanged.
+	assert.Equal(t, 2, result.Upserted)
+	assert.Contains(t, inst.queries[0], "sys_updated_on>=2026-10-02 09:00:00")
+
+	tickets, err = Tickets("dev000001", servicenow.IncidentTable, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"INC003", "INC002"}, numbers(tickets))
+
+	stored, err := database.RetrieveTickets([]string{database.TicketID("dev000001", "a1")})
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	assert.Equal(t, "Email is down for everyone", stored[0].ShortDescription)
+}
+
+func TestSyncRecordsDeletions(t *testing.T) {
+	databasetest.UseBoltStore(t)
+	ctx := context.Background()
+	now := time.Date(2026, 10, 5, 12, 0, 0, 0, time.UTC)
+	syncer := newTestSyncer(&now)
+
+	inst := &instance{incidents: []models.Incident{
+		incident("a1", "INC001", "Email is down", "2026-10-01 09:00:00"),
+		incident("a2", "INC002", "VPN will not connect", "2026-10-02 09:00:00"),
+		incident("a3", "INC003", "Printer jammed", "2026-10-03 09:00:00"),
+	}}
+	client := inst.serve(t)
+
+	_, err := syncer.SyncTable(ctx, "dev000001", servicenow.IncidentTable, client)
+	require.NoError(t, err)
+
+	// An incremental sync applies sys_audit_delete.
+	inst.incidents = inst.incidents[1:]
+	inst.deletions = []auditDelete{{DocumentKey: "a1", SysCreatedOn: "2026-10-05 12:30:00"}}
+	now = now.Add(time.Hour)
+
+	result, err := syncer.SyncTable(ctx, "dev000001", servicenow.IncidentTable, client)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Deleted)
+
+	tickets, err := Tickets("dev000001", servicenow.IncidentTable, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"INC003", "INC002"}, numbers(tickets))
+
+	// Without access to sys_audit_delete the sync still succeeds, and the
+	// next full sync notices what disappeared.
+	inst.incidents = inst.incidents[1:]
+	inst.auditForbidden = true
+	now = now.Add(time.Hour)
+
+	result, err = syncer.SyncTable(ctx, "dev000001", servicenow.IncidentTable, client)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Deleted)
+
+	now = now.Add(DefaultFullSyncInterval)
+	result, err = syncer.SyncTable(ctx, "dev000001", servicenow.IncidentTable, client)
+	require.NoError(t, err)
+	assert.True(t, result.Full)
+	assert.Equal(t, 1, result.Deleted)
+
+	tickets, err = Tickets("dev000001", servicenow.IncidentTable, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"INC003"}, numbers(tickets))
+
+	all, err := database.GetTicketsByInstanceID("dev000001")
+	require.NoError(t, err)
+	assert.Len(t, all, 3, "deleted tickets are kept, marked deleted")
+}
+
+func TestSyncLinksIncidentsToProblems(t *testing.T) {
+	databasetest.UseBoltStore(t)
+	ctx := context.Background()
+	now := time.Date(2026, 10, 5, 12, 0, 0, 0, time.UTC)
+	syncer := newTestSyncer(&now)
+	syncer.Tables = []string{servicenow.IncidentTable, servicenow.ProblemTable}
+
+	linked := incident("a1", "INC001", "Email is down", "2026-10-01 09:00:00")
+	linked.ProblemID = "p1"
+	linked.RFC = "c1"
+	inst := &instance{
+		incidents: []models.Incident{linked},
+		problems:  []models.Problem{{SysID: "p1", Number: "PRB001", ShortDescription: "Mail server overloaded"}},
+	}
+	client := inst.serve(t)
+
+	results, err := syncer.Sync(ctx, "dev000001", client)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	incidents, err := Tickets("dev000001", servicenow.IncidentTable, 0)
+	require.NoError(t, err)
+	require.Len(t, incidents, 1)
+	assert.Equal(t, "p1", incidents[0].ProblemID)
+	assert.Equal(t, "c1", incidents[0].ChangeID)
+
+	problems, err := Tickets("dev000001", servicenow.ProblemTable, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"PRB001"}, numbers(problems))
+
+	stored, err := database.RetrieveTickets([]string{database.TicketID("dev000001", incidents[0].ProblemID)})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"PRB001"}, numbers(stored))
+
+	_, err = syncer.SyncTable(ctx, "dev000001", servicenow.KnowledgeTable, client)
+	assert.Error(t, err)
+}
+
+func TestSyncIfStale(t *testing.T) {
+	databasetest.UseBoltStore(t)
+	ctx := context.Background()
+	now := time.Date(2026, 10, 5, 12, 0, 0, 0, time.UTC)
+	syncer := newTestSyncer(&now)
+
+	inst := &instance{incidents: []models.Incident{incident("a1", "INC001", "Email is down", "2026-10-01 09:00:00")}}
+	client := inst.serve(t)
+
+	require.NoError(t, syncer.SyncIfStale(ctx, "dev000001", servicenow.IncidentTable, client))
+	require.NoError(t, syncer.SyncIfStale(ctx, "dev000001", servicenow.IncidentTable, client))
+	assert.Len(t, inst.queries, 1, "a fresh copy isn't synced again")
+
+	now = now.Add(DefaultInterval)
+	require.NoError(t, syncer.SyncIfStale(ctx, "dev000001", servicenow.IncidentTable, client))
+	assert.Len(t, inst.queries, 3, "a stale copy syncs incidents and deletions")
+}
@@ -0,0 +1,290 @@
+// Package vault keeps each instance's ServiceNow credentials encrypted at rest
+// so background jobs can call ServiceNow without a live user request.
+//
+// Credentials are sealed with AES-256-GCM, bound to their instance ID, and
+// tagged with the ID of the key that sealed them. To rotate, configure the new
+// key and list the old one in MIMIR_VAULT_PREVIOUS_KEYS; Rotate re-encrypts
+// every record under the new key, after which the old key can be dropped.
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davidulloa/mimir/database"
+	"github.com/davidulloa/mimir/servicenow"
+)
+
+const KeySize = 32
+
+var (
+	ErrNoKey      = errors.New("no vault key configured; set MIMIR_VAULT_KEY or MIMIR_VAULT_KEY_FILE")
+	ErrNotFound   = errors.New("no credentials stored for instance")
+	ErrUnknownKey = errors.New("credentials were sealed with an unknown key")
+)
+
+// Credentials are the ServiceNow login stored for an instance.
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// WebhookSecret verifies the instance's calls to the ServiceNow webhook;
+	// see the webhook package.
+	WebhookSecret string `json:"webhookSecret,omitempty"`
+}
+
+type Vault struct {
+	currentID string
+	keys      map[string]cipher.AEAD
+	now       func() time.Time
+}
+
+// KeyID identifies a key without revealing it.
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// New returns a vault that seals with current and can still open records
+// sealed with any of previous.
+func New(current []byte, previous ...[]byte) (*Vault, error) {
+	v := &Vault{keys: make(map[string]cipher.AEAD), now: time.Now}
+	for i, key := range append([][]byte{current}, previous...) {
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("vault keys must be %d bytes, got %d", KeySize, len(key))
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		id := KeyID(key)
+		v.keys[id] = aead
+		if i == 0 {
+			v.currentID = id
+		}
+	}
+	return v, nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("vault keys must be base64 encoded: %v", err)
+	}
+	return key, nil
+}
+
+// FromEnv reads the current key from MIMIR_VAULT_KEY, or from the file named
+// by MIMIR_VAULT_KEY_FILE, and retired keys from MIMIR_VAULT_PREVIOUS_KEYS
+// (comma separated). Keys are 32 random bytes, base64 encoded.
+func FromEnv() (*Vault, error) {
+	encoded := os.Getenv("MIMIR_VAULT_KEY")
+	if encoded == "" {
+		if path := os.Getenv("MIMIR_VAULT_KEY_FILE"); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("error reading vault key file: %v", err)
+			}
+			encoded = string(data)
+		}
+	}
+	if strings.TrimSpace(encoded) == "" {
+		return nil, ErrNoKey
+	}
+
+	current, err := decodeKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous [][]byte
+	for _, value := range strings.Split(os.Getenv("MIMIR_VAULT_PREVIOUS_KEYS"), ",") {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		key, err := decodeKey(value)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+
+	return New(current, previous...)
+}
+
+var (
+	defaultVault *Vault
+	defaultMu    sync.Mutex
+)
+
+// Default returns the process-wide vault, building it from the environment on
+// first use. It returns ErrNoKey when no key is configured.
+func Default() (*Vault, error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultVault != nil {
+		return defaultVault, nil
+	}
+
+	v, err := FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	defaultVault = v
+	return defaultVault, nil
+}
+
+// SetDefault replaces the process-wide vault. It is used by tests.
+func SetDefault(v *Vault) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultVault = v
+}
+
+func (v *Vault) seal(instanceID string, creds Credentials) (database.CredentialRecord, error) {
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return database.CredentialRecord{}, err
+	}
+
+	aead := v.keys[v.currentID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return database.CredentialRecord{}, err
+	}
+
+	// The instance ID is authenticated data, so a record can't be copied onto
+	// another instance.
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(instanceID))
+
+	return database.CredentialRecord{
+		InstanceID: instanceID,
+		KeyID:      v.currentID,
+		Ciphertext: base64.StdEncoding.EncodeToString(sealed),
+		UpdatedAt:  v.now(),
+	}, nil
+}
+
+func (v *Vault) open(record database.CredentialRecord) (*Credentials, error) {
+	aead, ok := v.keys[record.KeyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(record.Ciphertext)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, errors.New("malformed credential record")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(record.InstanceID))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting credentials: %v", err)
+	}
+
+	var creds Credentials
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return nil, err
+	}
+	return &creds, nil
+}
+
+// Put encrypts and stores the credentials for instanceID, replacing any
+// previous ones.
+func (v *Vault) Put(instanceID string, creds Credentials) error {
+	record, err := v.seal(instanceID, creds)
+	if err != nil {
+		return err
+	}
+
+	s, err := database.GetStore()
+	if err != nil {
+		return err
+	}
+	return s.SaveCredential(record)
+}
+
+// Get returns the stored credentials for instanceID, or ErrNotFound.
+func (v *Vault) Get(instanceID string) (*Credentials, error) {
+	s, err := database.GetStore()
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := s.FindCredential(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, ErrNotFound
+	}
+
+	return v.open(*record)
+}
+
+// Client returns a ServiceNow client logged in with the stored credentials.
+func (v *Vault) Client(instanceID string, opts ...servicenow.Option) (*servicenow.Client, error) {
+	creds, err := v.Get(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return servicenow.NewClient(instanceID, creds.Username, creds.Password, opts...)
+}
+
+// Rotate re-encrypts every record that isn't sealed with the current key and
+// returns how many were rewritten. Records sealed with a key the vault no
+// longer has are left alone and reported in the error.
+func (v *Vault) Rotate() (int, error) {
+	s, err := database.GetStore()
+	if err != nil {
+		return 0, err
+	}
+
+	records, err := s.ListCredentials()
+	if err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+	var errs []error
+	for _, record := range records {
+		if record.KeyID == v.currentID {
+			continue
+		}
+
+		creds, err := v.open(record)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("instance %s: %w", record.InstanceID, err))
+			continue
+		}
+
+		resealed, err := v.seal(record.InstanceID, *creds)
+		if err == nil {
+			err = s.SaveCredential(resealed)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("instance %s: %w", record.InstanceID, err))
+			continue
+		}
+		rotated++
+	}
+
+	return rotated, errors.Join(errs...)
+}
@@ -0,0 +1,102 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/davidulloa/mimir/database/databasetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+func TestPutAndGet(t *testing.T) {
+	s := databasetest.UseBoltStore(t)
+	v, err := New(testKey(1))
+	require.NoError(t, err)
+
+	require.NoError(t, v.Put("dev000001", Credentials{Username: "admin", Password: "hunter2"}))
+
+	creds, err := v.Get("dev000001")
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{Username: "admin", Password: "hunter2"}, *creds)
+
+	record, err := s.FindCredential("dev000001")
+	require.NoError(t, err)
+	assert.Equal(t, KeyID(testKey(1)), record.KeyID)
+	assert.NotContains(t, record.Ciphertext, "hunter2")
+
+	_, err = v.Get("dev000002")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRecordIsBoundToInstance(t *testing.T) {
+	s := databasetest.UseBoltStore(t)
+	v, err := New(testKey(1))
+	require.NoError(t, err)
+
+	require.NoError(t, v.Put("dev000001", Credentials{Username: "admin", Password: "hunter2"}))
+	record, err := s.FindCredential("dev000001")
+	require.NoError(t, err)
+
+	record.InstanceID = "dev000002"
+	require.NoError(t, s.SaveCredential(*record))
+
+	_, err = v.Get("dev000002")
+	assert.Error(t, err)
+}
+
+func TestRotate(t *testing.T) {
+	databasetest.UseBoltStore(t)
+	old, err := New(testKey(1))
+	require.NoError(t, err)
+	require.NoError(t, old.Put("dev000001", Credentials{Username: "admin", Password: "hunter2"}))
+	require.NoError(t, old.Put("dev000002", Credentials{Username: "admin", Password: "hunter3"}))
+
+	// Without the old key the records can't be opened.
+	fresh, err := New(testKey(2))
+	require.NoError(t, err)
+	_, err = fresh.Get("dev000001")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	rotating, err := New(testKey(2), testKey(1))
+	require.NoError(t, err)
+	rotated, err := rotating.Rotate()
+	require.NoError(t, err)
+	assert.Equal(t, 2, rotated)
+
+	creds, err := fresh.Get("dev000002")
+	require.NoError(t, err)
+	assert.Equal(t, "hunter3", creds.Password)
+
+	rotated, err = rotating.Rotate()
+	require.NoError(t, err)
+	assert.Equal(t, 0, rotated)
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("MIMIR_VAULT_KEY", "")
+	t.Setenv("MIMIR_VAULT_KEY_FILE", "")
+	_, err := FromEnv()
+	assert.ErrorIs(t, err, ErrNoKey)
+
+	path := filepath.Join(t.TempDir(), "vault.key")
+	require.NoError(t, os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(testKey(3))+"\n"), 0600))
+	t.Setenv("MIMIR_VAULT_KEY_FILE", path)
+	t.Setenv("MIMIR_VAULT_PREVIOUS_KEYS", base64.StdEncoding.EncodeToString(testKey(1)))
+
+	v, err := FromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, KeyID(testKey(3)), v.currentID)
+	assert.Len(t, v.keys, 2)
+
+	t.Setenv("MIMIR_VAULT_KEY", base64.StdEncoding.EncodeToString([]byte("short")))
+	_, err = FromEnv()
+	assert.Error(t, err)
+}
@@ -0,0 +1,81 @@
+// Package webhook defines the events ServiceNow posts to
+// /webhooks/servicenow/{instanceId} and how they are signed.
+//
+// A business rule on the incident table (after insert and update) sends the
+// record as an Event, signed with the instance's webhook secret:
+//
+//	var body = JSON.stringify({event: current.operation(), record: fields});
+//	var mac = new GlideCertificateEncryption().generateMac(
+//		gs.base64Encode(secret), "HmacSHA256", body);
+//	request.setRequestHeader("X-Mimir-Signature", "sha256=" + mac);
+//
+// The signature is the HMAC-SHA256 of the exact request body, hex or base64
+// encoded.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"github.com/davidulloa/mimir/models"
+)
+
+const SignatureHeader = "X-Mimir-Signature"
+
+const (
+	EventInsert = "insert"
+	EventUpdate = "update"
+	// EventClose is an update that closed the incident.
+	EventClose  = "close"
+	EventDelete = "delete"
+)
+
+// Event is one change to an incident. Record only needs sys_id for deletes;
+// otherwise it should carry the fields the incident sync reads.
+type Event struct {
+	Event string `json:"event"`
+	// Table defaults to incident, the only table events are accepted for.
+	Table  string          `json:"table,omitempty"`
+	Record models.Incident `json:"record"`
+}
+
+// NewSecret returns a random secret to configure on an instance.
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+func mac(secret string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Sign returns the SignatureHeader value for body.
+func Sign(secret string, body []byte) string {
+	return "sha256=" + hex.EncodeToString(mac(secret, body))
+}
+
+// Verify reports whether signature is a valid signature of body. The
+// "sha256=" prefix is optional.
+func Verify(secret string, body []byte, signature string) bool {
+	if secret == "" {
+		return false
+	}
+
+	signature = strings.TrimPrefix(strings.TrimSpace(signature), "sha256=")
+	expected := mac(secret, body)
+
+	if decoded, err := hex.DecodeString(signature); err == nil && hmac.Equal(decoded, expected) {
+		return true
+	}
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	return err == nil && hmac.Equal(decoded, expected)
+}
@@ -0,0 +1,28 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"event":"insert","record":{"sys_id":"a1"}}`)
+	signature := Sign("shared", body)
+
+	assert.True(t, Verify("shared", body, signature))
+	assert.True(t, Verify("shared", body, signature[len("sha256="):]))
+
+	// GlideCertificateEncryption.generateMac returns base64.
+	h := hmac.New(sha256.New, []byte("shared"))
+	h.Write(body)
+	assert.True(t, Verify("shared", body, base64.StdEncoding.EncodeToString(h.Sum(nil))))
+
+	assert.False(t, Verify("other", body, signature))
+	assert.False(t, Verify("shared", append(body, ' '), signature))
+	assert.False(t, Verify("shared", body, ""))
+	assert.False(t, Verify("", body, Sign("", body)), "an unset secret never verifies")
+}
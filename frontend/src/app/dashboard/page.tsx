@@ -11,6 +11,7 @@ import { Separator } from "@/components/ui/separator";
 import UserSection from "@/components/UserSection";
 import Link from "next/link";
 import { accelerators } from "@/data/accelerators";
+import { authFetch, getInstanceId } from "@/lib/auth";
 
 // Hardcoded variables for testing
 /* eslint-disable @typescript/es-line/no-unused-vars */
@@ -20,8 +21,6 @@ const SINGLE_TICKETS = 10;
 /* eslint-enable @typescript/es-line/no-unused-vars */
 const PREVIOUS_CHATS_COUNT = 15;
 const DOCUMENTATION_LINKS_COUNT = 5;
-const USERNAME = "admin";
-const PASSWORD = "r8RGnqYX=%m0";
 
 export default function Dashboard() {
   const [selectedItems, setSelectedItems] = useState<string[]>([]);
@@ -32,18 +31,14 @@ export default function Dashboard() {
   useEffect(() => {
     const fetchTicketsAndChats = async () => {
       try {
-        const auth = btoa(`${USERNAME}:${PASSWORD}`);
-        const ticketsResponse = await fetch(
-          `${process.env.NEXT_PUBLIC_BACKEND_IP}/tickets`,
-          {
-            method: "POST",
-            headers: {
-              "Content-Type": "application/json",
-              Authorization: `Basic ${auth}`,
-            },
-            body: JSON.stringify({ instanceId: "dev274800" }),
-          }
-        );
+        // ServiceNow credentials come from the vault, stored at login.
+        const ticketsResponse = await authFetch("/tickets", {
+          method: "POST",
+          headers: {
+            "Content-Type": "application/json",
+          },
+          body: JSON.stringify({ instanceId: getInstanceId() }),
+        });
 
         if (!ticketsResponse.ok) {
           throw new Error(`Error: ${ticketsResponse.statusText}`);
@@ -53,13 +48,12 @@ export default function Dashboard() {
         setTicketData(ticketData.clusters || []);
 
         // Fetch chat messages
-        const chatResponse = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_IP}`, {
+        const chatResponse = await authFetch("/chat", {
           method: "POST",
           headers: {
             "Content-Type": "application/json",
-            Authorization: `Basic ${auth}`,
           },
-          body: JSON.stringify({ instanceId: "dev274800" }),
+          body: JSON.stringify({ instanceId: getInstanceId() }),
         });
 
         if (!chatResponse.ok) {
@@ -15,6 +15,7 @@ import ThemeToggle from "@/components/ThemeToggle";
 import Link from "next/link";
 import { ArrowLeft, Send, Shell } from "lucide-react";
 import { motion, AnimatePresence, useAnimationControls } from "framer-motion";
+import { authFetch, getInstanceId } from "@/lib/auth";
 
 interface Message {
   content: string;
@@ -163,9 +164,6 @@ const ChatInterface: React.FC<ChatInterfaceProps> = ({
   const [threadTitle, setThreadTitle] = useState("Accelerator Agent");
   const [isAnimating, setIsAnimating] = useState(false);
 
-  const USERNAME = "admin";
-  const PASSWORD = "r8RGnqYX=%m0";
-
   const messagesRef = useRef<Message[]>(messages);
   useEffect(() => {
     messagesRef.current = messages;
@@ -174,14 +172,13 @@ const ChatInterface: React.FC<ChatInterfaceProps> = ({
   useEffect(() => {
     const fetchThread = async () => {
       try {
-        const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_IP}/chat`, {
+        const response = await authFetch("/chat", {
           method: "POST",
           headers: {
             "Content-Type": "application/json",
-            Authorization: "Basic " + btoa(`${USERNAME}:${PASSWORD}`),
           },
           body: JSON.stringify({
-            instanceId: "dev274800",
+            instanceId: getInstanceId(),
             threadId: threadId,
           }),
         });
@@ -233,14 +230,13 @@ const ChatInterface: React.FC<ChatInterfaceProps> = ({
       scrollToBottom();
       
       try {
-        const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_IP}/chat`, {
+        const response = await authFetch("/chat", {
           method: "POST",
           headers: {
             "Content-Type": "application/json",
-            Authorization: "Basic " + btoa(`${USERNAME}:${PASSWORD}`),
           },
           body: JSON.stringify({
-            instanceId: "dev274800",
+            instanceId: getInstanceId(),
             threadId: threadId,
             message: {
               content: userMessage.content,
@@ -314,14 +310,13 @@ const ChatInterface: React.FC<ChatInterfaceProps> = ({
 
     const pollThread = async () => {
       try {
-        const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_IP}/chat`, {
+        const response = await authFetch("/chat", {
           method: "POST",
           headers: {
             "Content-Type": "application/json",
-            Authorization: "Basic " + btoa(`${USERNAME}:${PASSWORD}`),
           },
           body: JSON.stringify({
-            instanceId: "dev274800",
+            instanceId: getInstanceId(),
             threadId: threadId,
           }),
         });
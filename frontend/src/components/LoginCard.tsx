@@ -6,6 +6,7 @@ import { Card, CardContent, CardDescription, CardHeader, CardTitle } from '@/com
 import { Input } from '@/components/ui/input'
 import { Button } from '@/components/ui/button'
 import { Eye, EyeOff } from 'lucide-react'
+import { saveTokens } from '@/lib/auth'
 
 export default function LoginCard() {
   const router = useRouter()
@@ -35,10 +36,9 @@ export default function LoginCard() {
     })
 
     if (response.ok) {
-      // Store credentials in local storage
-      localStorage.setItem('instanceId', instanceId)
+      // Keep the session tokens; the password is not stored
+      saveTokens(await response.json())
       localStorage.setItem('username', username)
-      localStorage.setItem('password', password)
 
       // Redirect or update state as needed
       router.push('/dashboard')
@@ -2,6 +2,7 @@
 
 import { useState, useEffect, useRef } from 'react'
 import { useRouter } from 'next/navigation'
+import { logout } from '@/lib/auth'
 import { Avatar, AvatarFallback, AvatarImage } from '@/components/ui/avatar'
 import { Button } from '@/components/ui/button'
 import {
@@ -52,9 +53,13 @@ export default function UserSection() {
     localStorage.removeItem("avatarImage");
   };
 
-  const handleSignOut = () => {
-    localStorage.clear()
-    router.push('/')
+  const handleSignOut = async () => {
+    try {
+      await logout()
+    } finally {
+      localStorage.clear()
+      router.push('/')
+    }
   }
 
   useEffect(() => {
@@ -18,6 +18,7 @@ import {
   TooltipTrigger,
 } from "@/components/ui/tooltip";
 import Link from "next/link";
+import { authFetch, getInstanceId } from "@/lib/auth";
 import { accelerators } from "@/data/accelerators";
 
 interface CarouselCardProps {
@@ -128,19 +129,14 @@ export default function Carousel() {
     const IconComponent = categoryIcons[category] || Lightbulb;
 
     const handleClick = async () => {
-      // Define your credentials
-      const username = "admin"; // Replace with your username
-      const password = "r8RGnqYX=%m0";
-
       // Create the thread
-      const response = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_IP}/chat`, {
+      const response = await authFetch("/chat", {
         method: "POST",
         headers: {
           "Content-Type": "application/json",
-          Authorization: "Basic " + btoa(`${username}:${password}`),
         },
         body: JSON.stringify({
-          instanceId: "dev274800",
+          instanceId: getInstanceId(),
           createThread: true,
           acceleratorId: acceleratorId,
         }),
@@ -0,0 +1,84 @@
+// Session tokens from /authorization. Requests carry the short-lived access
+// token; when the backend rejects it, the refresh token is exchanged for a
+// new pair and the request is sent again once.
+
+const BACKEND = process.env.NEXT_PUBLIC_BACKEND_IP
+
+export interface Tokens {
+  accessToken: string
+  refreshToken: string
+  instanceId: string
+}
+
+export function saveTokens(tokens: Tokens) {
+  localStorage.setItem("accessToken", tokens.accessToken)
+  localStorage.setItem("refreshToken", tokens.refreshToken)
+  localStorage.setItem("instanceId", tokens.instanceId)
+}
+
+// getInstanceId returns the instance the session was issued for. Request
+// bodies must name it.
+export function getInstanceId(): string {
+  return localStorage.getItem("instanceId") ?? ""
+}
+
+// Refreshing rotates the refresh token, so concurrent requests share one
+// refresh.
+let refreshing: Promise<boolean> | null = null
+
+function refresh(): Promise<boolean> {
+  if (!refreshing) {
+    refreshing = (async () => {
+      const refreshToken = localStorage.getItem("refreshToken")
+      if (!refreshToken) {
+        return false
+      }
+      const response = await fetch(`${BACKEND}/authorization/refresh`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ refreshToken }),
+      })
+      if (!response.ok) {
+        return false
+      }
+      saveTokens(await response.json())
+      return true
+    })().finally(() => {
+      refreshing = null
+    })
+  }
+  return refreshing
+}
+
+// authFetch calls a backend path with the session's access token.
+export async function authFetch(path: string, init: RequestInit = {}): Promise<Response> {
+  const send = () =>
+    fetch(`${BACKEND}${path}`, {
+      ...init,
+      headers: {
+        ...init.headers,
+        Authorization: `Bearer ${localStorage.getItem("accessToken") ?? ""}`,
+      },
+    })
+
+  const response = await send()
+  if (response.status === 401 && (await refresh())) {
+    return send()
+  }
+  return response
+}
+
+// logout revokes the session and forgets its tokens.
+export async function logout() {
+  const refreshToken = localStorage.getItem("refreshToken")
+  try {
+    await authFetch("/authorization/logout", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ refreshToken }),
+    })
+  } finally {
+    localStorage.removeItem("accessToken")
+    localStorage.removeItem("refreshToken")
+  }
+}